@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
@@ -14,7 +16,9 @@ import (
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/driver"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/handlers"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/helpers"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/invoices"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/payments"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/render"
 )
 
@@ -31,11 +35,21 @@ func main() {
 	}
 	defer db.SQL.Close()
 
+	if flag.Arg(0) == "migrate" {
+		err = runMigrate(db.SQL, flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	defer close(app.MailChan)
 
 	fmt.Println("Starting mail listener...")
 	listenForMail()
 
+	expirePendingPayments()
+
 	// msg := models.MailData{
 	// 	To:       "a@test.com",
 	// 	From:     "server@mail.com",
@@ -58,6 +72,19 @@ func main() {
 	log.Fatal(err)
 }
 
+// expirePendingPayments checks every minute for payments guests did not authenticate within the payment
+// timeout, and frees the rooms their reservations hold
+func expirePendingPayments() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			err := handlers.Repo.ExpirePendingPayments(context.Background(), time.Now().Add(-app.PaymentTimeout))
+			if err != nil {
+				errorLog.Println(err)
+			}
+		}
+	}()
+}
+
 func SetUpAppConfig() (*driver.DB, error) {
 	// put in the session
 	gob.Register(models.Reservation{})
@@ -75,19 +102,48 @@ func SetUpAppConfig() (*driver.DB, error) {
 	dbPass := flag.String("dbPass", "", "Database password")
 	dbPort := flag.String("dbPort", "5432", "Database port")
 	dbSSL := flag.String("dbSSL", "disable", "Database SSL settings (disable, prefer, require)")
+	dbTimeout := flag.Duration("dbTimeout", 3*time.Second, "Timeout for each database query")
+	sqlitePath := flag.String("sqlite", "", "Path to a SQLite database file, used instead of Postgres (build with -tags sqlite)")
+	uploadDir := flag.String("uploadDir", "./uploads", "Directory uploaded room photos are stored in")
+	maxUploadSize := flag.Int64("maxUploadSize", 10<<20, "Largest room photo upload accepted, in bytes")
+	paymentSecret := flag.String("paymentSecret", "", "Secret payment webhooks are signed with, none are accepted without it")
+	paymentTimeout := flag.Duration("paymentTimeout", 30*time.Minute, "How long guests have to authenticate a payment with their bank before their reservation expires")
+	invoiceName := flag.String("invoiceName", "Fort Smythe Bed and Breakfast", "Name invoices are issued under")
+	invoiceAddress := flag.String("invoiceAddress", "", "Address printed on invoices, with lines separated by commas")
+	invoiceEmails := flag.Bool("invoiceEmails", false, "Email guests their invoice when their reservation is checked out or cancelled with a fee")
 
 	flag.Parse()
 
-	if *dbName == "" || *dbUser == "" {
+	if *sqlitePath == "" && (*dbName == "" || *dbUser == "") {
 		fmt.Println("Missing required flags")
 		os.Exit(1)
 	}
 
+	if flag.Arg(0) == "migrate" && *sqlitePath != "" {
+		fmt.Println("SQLite databases are migrated automatically when the application starts")
+		os.Exit(1)
+	}
+
 	mailChan := make(chan models.MailData)
 	app.MailChan = mailChan
 	// if you want to change tmpl file and check easier, set app.UserCache = false
 	app.InProduction = *inProduction
 	app.UseCache = *useCache
+	app.DBTimeout = *dbTimeout
+	app.UploadDir = *uploadDir
+	app.MaxUploadSize = *maxUploadSize
+
+	// the fake provider is the only one so far, it takes test cards without charging anyone
+	app.Payments = payments.NewFake(*paymentSecret)
+	app.PaymentTimeout = *paymentTimeout
+
+	app.InvoiceIssuer = invoices.Issuer{Name: *invoiceName}
+	for _, line := range strings.Split(*invoiceAddress, ",") {
+		if line = strings.TrimSpace(line); line != "" {
+			app.InvoiceIssuer.Address = append(app.InvoiceIssuer.Address, line)
+		}
+	}
+	app.InvoiceEmails = *invoiceEmails
 
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -105,11 +161,17 @@ func SetUpAppConfig() (*driver.DB, error) {
 
 	// Connect to database
 	log.Println("Connecting to database...")
-	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s", *dbHost, *dbPort, *dbName, *dbUser, *dbPass, *dbSSL)
-	//db, err := driver.ConnectSQL("host=localhost port=5432 dbname=bookings user=postgres password=24072001do")
-	db, err := driver.ConnectSQL(connectionString)
+	var db *driver.DB
+	var err error
+	if *sqlitePath != "" {
+		db, err = driver.ConnectSQLite(*sqlitePath)
+	} else {
+		connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s", *dbHost, *dbPort, *dbName, *dbUser, *dbPass, *dbSSL)
+		//db, err := driver.ConnectSQL("host=localhost port=5432 dbname=bookings user=postgres password=24072001do")
+		db, err = driver.ConnectSQL(connectionString)
+	}
 	if err != nil {
-		log.Fatal("Cannot connect to database! Dying...")
+		log.Fatal("Cannot connect to database! Dying...", err)
 	}
 	log.Println("Connected to database!")
 
@@ -122,7 +184,16 @@ func SetUpAppConfig() (*driver.DB, error) {
 	app.TemplateCache = templateCache
 
 	// Send AppConfig to handlers
-	repo := handlers.NewRepo(&app, db)
+	var repo *handlers.Repository
+	if *sqlitePath != "" {
+		repo, err = handlers.NewSQLiteRepo(&app, db)
+		if err != nil {
+			log.Fatal("Cannot create SQLite schema", err)
+			return nil, err
+		}
+	} else {
+		repo = handlers.NewRepo(&app, db)
+	}
 	handlers.NewHandlers(repo)
 	render.NewRenderer(&app)
 	helpers.NewHelpers(&app)
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/helpers"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/payments"
 	"github.com/justinas/nosurf"
 )
 
@@ -18,6 +19,10 @@ func NoSurf(next http.Handler) http.Handler {
 		SameSite: http.SameSiteLaxMode,
 	})
 
+	// payment providers post webhooks and their own pages without our token, webhooks are signed instead
+	csrfHandler.ExemptPath("/payments/webhook")
+	csrfHandler.ExemptGlob(payments.FakeChallengePath + "*")
+
 	return csrfHandler
 }
 
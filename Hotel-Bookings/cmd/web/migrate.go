@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/migrate"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/migrations"
+)
+
+const migrateUsage = "usage: bookings [flags] migrate up | down [N] | status"
+
+// runMigrate handles the migrate subcommand, args being everything after "migrate"
+func runMigrate(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	m := migrate.New(db, migrations.Files)
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, mig := range applied {
+			fmt.Printf("applied  %s_%s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			var err error
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return errors.New(migrateUsage)
+			}
+		}
+
+		reverted, err := m.Down(ctx, n)
+		for _, mig := range reverted {
+			fmt.Printf("reverted %s_%s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%-8s %s_%s\n", state, s.Version, s.Name)
+		}
+
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/config"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/handlers"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/payments"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/photos"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 )
@@ -19,8 +21,9 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+	mux.Get("/generals-quarters", handlers.Repo.OldRoomPage)
+	mux.Get("/majors-suite", handlers.Repo.OldRoomPage)
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
@@ -33,6 +36,16 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/make-reservation", handlers.Repo.MakeReservation)
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+	mux.Get("/payment-return", handlers.Repo.PaymentReturn)
+	mux.Post("/payments/webhook", handlers.Repo.PaymentWebhook)
+	mux.Get("/cancel-reservation", handlers.Repo.CancelReservation)
+	mux.Post("/cancel-reservation", handlers.Repo.PostCancelReservation)
+
+	mux.Get("/manage-booking", handlers.Repo.ManageBooking)
+	mux.Post("/manage-booking", handlers.Repo.PostManageBooking)
+	mux.Get("/manage-booking/reservation", handlers.Repo.ShowManagedBooking)
+	mux.Post("/manage-booking/contact", handlers.Repo.PostManagedBookingContact)
+	mux.Post("/manage-booking/dates", handlers.Repo.PostManagedBookingDates)
 
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
@@ -40,18 +53,80 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Get("/favicon.ico", handlers.Repo.EmptyFunc)
 
+	// the fake payment provider stands in for the guest's bank on its own pages
+	if fake, ok := app.Payments.(*payments.Fake); ok {
+		mux.Handle(payments.FakeChallengePath+"*", fake)
+	}
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
+	// guests get the copies shown on pages, the originals as uploaded are for admins only
+	uploads := photos.New(app.UploadDir, "/uploads/", 0)
+	mux.Handle("/uploads/*", http.StripPrefix("/uploads", http.FileServer(uploads.Files(false))))
+
 	mux.Route("/admin", func(mux chi.Router) {
 		mux.Use(Auth)
+		mux.Handle("/uploads/*", http.StripPrefix("/admin/uploads", http.FileServer(uploads.Files(true))))
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
 
 		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+		mux.Get("/search", handlers.Repo.AdminSearchReservations)
 		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
 		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
-		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
+		mux.Get("/reservation-status/{src}/{id}/{status}/do", handlers.Repo.AdminUpdateReservationStatus)
+		mux.Post("/cancel-reservation/{src}/{id}", handlers.Repo.AdminCancelReservation)
+		mux.Post("/change-stay/{src}/{id}", handlers.Repo.AdminChangeReservationStay)
+		mux.Post("/capture-payment/{src}/{id}/{paymentID}", handlers.Repo.AdminCapturePayment)
+		mux.Post("/refund-payment/{src}/{id}/{paymentID}", handlers.Repo.AdminRefundPayment)
+		mux.Post("/issue-invoice/{src}/{id}", handlers.Repo.AdminIssueInvoice)
+		mux.Get("/create-reservation", handlers.Repo.AdminCreateReservation)
+		mux.Post("/create-reservation", handlers.Repo.AdminPostCreateReservation)
+
+		mux.Get("/rooms", handlers.Repo.AdminRooms)
+		mux.Get("/rooms/new", handlers.Repo.AdminNewRoom)
+		mux.Post("/rooms/new", handlers.Repo.AdminPostNewRoom)
+		mux.Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+		mux.Post("/rooms/{id}", handlers.Repo.AdminPostRoom)
+		mux.Post("/rooms/{id}/active", handlers.Repo.AdminSetRoomActive)
+		mux.Post("/rooms/{id}/move/{direction}", handlers.Repo.AdminMoveRoom)
+		mux.Post("/rooms/{id}/photos", handlers.Repo.AdminPostRoomPhoto)
+		mux.Post("/rooms/{id}/photos/upload", handlers.Repo.AdminUploadRoomPhoto)
+		mux.Post("/rooms/{id}/photos/{photoID}/delete", handlers.Repo.AdminDeleteRoomPhoto)
+		mux.Post("/rooms/{id}/photos/{photoID}/move/{direction}", handlers.Repo.AdminMoveRoomPhoto)
+
+		mux.Get("/rate-rules", handlers.Repo.AdminRateRules)
+		mux.Get("/rate-rules/new", handlers.Repo.AdminNewRateRule)
+		mux.Post("/rate-rules/new", handlers.Repo.AdminPostNewRateRule)
+		mux.Get("/rate-rules/{id}", handlers.Repo.AdminShowRateRule)
+		mux.Post("/rate-rules/{id}", handlers.Repo.AdminPostRateRule)
+		mux.Post("/rate-rules/{id}/delete", handlers.Repo.AdminDeleteRateRule)
+
+		mux.Get("/promo-codes", handlers.Repo.AdminPromoCodes)
+		mux.Get("/promo-codes/new", handlers.Repo.AdminNewPromoCode)
+		mux.Post("/promo-codes/new", handlers.Repo.AdminPostNewPromoCode)
+		mux.Get("/promo-codes/{id}", handlers.Repo.AdminShowPromoCode)
+		mux.Post("/promo-codes/{id}", handlers.Repo.AdminPostPromoCode)
+		mux.Post("/promo-codes/{id}/delete", handlers.Repo.AdminDeletePromoCode)
+
+		mux.Get("/charges", handlers.Repo.AdminCharges)
+		mux.Get("/charges/new", handlers.Repo.AdminNewCharge)
+		mux.Post("/charges/new", handlers.Repo.AdminPostNewCharge)
+		mux.Get("/charges/{id}", handlers.Repo.AdminShowCharge)
+		mux.Post("/charges/{id}", handlers.Repo.AdminPostCharge)
+		mux.Post("/charges/{id}/delete", handlers.Repo.AdminDeleteCharge)
+
+		mux.Get("/cancellation-policies", handlers.Repo.AdminCancellationPolicies)
+		mux.Get("/cancellation-policies/new", handlers.Repo.AdminNewCancellationPolicy)
+		mux.Post("/cancellation-policies/new", handlers.Repo.AdminPostNewCancellationPolicy)
+		mux.Get("/cancellation-policies/{id}", handlers.Repo.AdminShowCancellationPolicy)
+		mux.Post("/cancellation-policies/{id}", handlers.Repo.AdminPostCancellationPolicy)
+		mux.Post("/cancellation-policies/{id}/delete", handlers.Repo.AdminDeleteCancellationPolicy)
+
+		mux.Get("/invoices", handlers.Repo.AdminInvoices)
+		mux.Get("/invoices/{id}/pdf", handlers.Repo.AdminInvoicePDF)
+
 		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
 
 		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
@@ -3,9 +3,12 @@ package config
 import (
 	"html/template"
 	"log"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/invoices"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/payments"
 )
 
 // AppConfig holds the application config
@@ -17,4 +20,20 @@ type AppConfig struct {
 	InProduction  bool
 	Session       *scs.SessionManager
 	MailChan      chan models.MailData
+	DBTimeout     time.Duration
+
+	// UploadDir is where uploaded room photos are stored, served under /uploads/.
+	// MaxUploadSize is the largest photo accepted, in bytes.
+	UploadDir     string
+	MaxUploadSize int64
+
+	// Payments takes the card payments of online reservations. PaymentTimeout is how long guests have
+	// to authenticate a payment with their bank before the reservation it holds the room for expires.
+	Payments       payments.Provider
+	PaymentTimeout time.Duration
+
+	// InvoiceIssuer is who invoices are from, and InvoiceEmails whether guests are emailed their invoice
+	// when it is issued, at checkout or when they cancel with a fee
+	InvoiceIssuer invoices.Issuer
+	InvoiceEmails bool
 }
@@ -2,6 +2,7 @@ package driver
 
 import (
 	"database/sql"
+	"fmt"
 	"time"
 
 	_ "github.com/jackc/pgconn"
@@ -41,6 +42,28 @@ func ConnectSQL(dsn string) (*DB, error) {
 	return dbConn, nil
 }
 
+// ConnectSQLite opens the SQLite database file at path, creating it if needed.
+// The driver is registered by sqlite.go, which is only built with the "sqlite" build tag.
+func ConnectSQLite(path string) (*DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, so one connection avoids "database is locked" errors
+	db.SetMaxOpenConns(1)
+
+	err = testDB(db)
+	if err != nil {
+		return nil, err
+	}
+
+	dbConn.SQL = db
+
+	return dbConn, nil
+}
+
 // testDB tries to Ping the database
 func testDB(db *sql.DB) error {
 	err := db.Ping()
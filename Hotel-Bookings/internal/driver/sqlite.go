@@ -0,0 +1,6 @@
+//go:build sqlite
+
+package driver
+
+// the pure-Go SQLite driver is opt-in, so Postgres-only builds do not pull it in
+import _ "modernc.org/sqlite"
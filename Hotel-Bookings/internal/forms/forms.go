@@ -3,7 +3,10 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 )
@@ -63,3 +66,101 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address!")
 	}
 }
+
+// dateLayout is how date fields are posted, YYYY-MM-DD
+const dateLayout = "2006-01-02"
+
+// check that a field holds a date in YYYY-MM-DD form
+func (f *Form) IsDate(field string) bool {
+	if _, err := time.Parse(dateLayout, f.Get(field)); err != nil {
+		f.Errors.Add(field, "Invalid date!")
+		return false
+	}
+	return true
+}
+
+// check that a date field is later than another date field, both already checked with IsDate
+func (f *Form) DateAfter(field, other string) bool {
+	date, err1 := time.Parse(dateLayout, f.Get(field))
+	before, err2 := time.Parse(dateLayout, f.Get(other))
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	if !date.After(before) {
+		f.Errors.Add(field, "This date must be after the first date!")
+		return false
+	}
+	return true
+}
+
+// check that a date field is not earlier than another date field, both already checked with IsDate
+func (f *Form) DateNotBefore(field, other string) bool {
+	date, err1 := time.Parse(dateLayout, f.Get(field))
+	first, err2 := time.Parse(dateLayout, f.Get(other))
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	if date.Before(first) {
+		f.Errors.Add(field, "This date cannot be before the first date!")
+		return false
+	}
+	return true
+}
+
+// slugPattern matches lowercase words of letters and digits joined by single dashes
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// check that a field can be used in a URL, like "majors-suite"
+func (f *Form) IsSlug(field string) bool {
+	if !slugPattern.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Use lowercase letters, numbers and dashes only!")
+		return false
+	}
+	return true
+}
+
+// check that a field holds a whole number from min to max
+func (f *Form) IsIntBetween(field string, min, max int) bool {
+	n, err := strconv.Atoi(strings.TrimSpace(f.Get(field)))
+	if err != nil || n < min || n > max {
+		f.Errors.Add(field, fmt.Sprintf("Enter a whole number from %d to %d!", min, max))
+		return false
+	}
+	return true
+}
+
+var moneyPattern = regexp.MustCompile(`^\$?(\d+|\d{1,3}(,\d{3})+)(\.\d{1,2})?$`)
+
+// check that a field holds an amount of money, like 125, 99.50 or $1,250.00
+func (f *Form) IsMoney(field string) bool {
+	if !moneyPattern.MatchString(strings.TrimSpace(f.Get(field))) {
+		f.Errors.Add(field, "Enter an amount like 125 or 99.50!")
+		return false
+	}
+	return true
+}
+
+// promoCodePattern matches 3 to 20 letters, digits and dashes, starting with a letter or digit
+var promoCodePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]{2,19}$`)
+
+// check that a field looks like a promo code, like "SUMMER-10", in any case
+func (f *Form) IsPromoCode(field string) bool {
+	if !promoCodePattern.MatchString(strings.TrimSpace(f.Get(field))) {
+		f.Errors.Add(field, "Promo codes are 3 to 20 letters, numbers or dashes!")
+		return false
+	}
+	return true
+}
+
+var percentagePattern = regexp.MustCompile(`^\d{1,3}(\.\d{1,2})?%?$`)
+
+// check that a field holds a percentage from 0 to 100 with at most two decimals, like 10, 7.5 or 7.25%
+func (f *Form) IsPercentage(field string) bool {
+	value := strings.TrimSpace(f.Get(field))
+	n, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if !percentagePattern.MatchString(value) || err != nil || n > 100 {
+		f.Errors.Add(field, "Enter a percentage like 10 or 7.5, up to 100!")
+		return false
+	}
+	return true
+}
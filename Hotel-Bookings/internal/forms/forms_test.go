@@ -116,3 +116,201 @@ func TestIsEmail(t *testing.T) {
 		t.Error("Form shows valid email for invalid email")
 	}
 }
+
+func TestIsDate(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("a", "2050-01-02")
+	postedData.Add("b", "01/02/2050")
+	form := New(postedData)
+
+	if !form.IsDate("a") {
+		t.Error("Form shows invalid date for valid date")
+	}
+	if form.IsDate("b") {
+		t.Error("Form shows valid date for date in the wrong format")
+	}
+	if form.IsDate("c") {
+		t.Error("Form shows valid date for non-existent field")
+	}
+	if form.Errors.Get("b") == "" {
+		t.Error("should have an error, but did not get one")
+	}
+}
+
+func TestDateAfter(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("start", "2050-01-02")
+	postedData.Add("end", "2050-01-05")
+	postedData.Add("same", "2050-01-02")
+	form := New(postedData)
+
+	if !form.DateAfter("end", "start") {
+		t.Error("Form shows later date is not after earlier date")
+	}
+	if form.DateAfter("same", "start") {
+		t.Error("Form shows date is after the same date")
+	}
+	if form.DateAfter("start", "end") {
+		t.Error("Form shows earlier date is after later date")
+	}
+	if form.Errors.Get("start") == "" {
+		t.Error("should have an error, but did not get one")
+	}
+}
+
+func TestDateNotBefore(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("start", "2050-01-02")
+	postedData.Add("end", "2050-01-05")
+	postedData.Add("same", "2050-01-02")
+	form := New(postedData)
+
+	if !form.DateNotBefore("end", "start") {
+		t.Error("Form shows later date is before earlier date")
+	}
+	if !form.DateNotBefore("same", "start") {
+		t.Error("Form shows date is before the same date")
+	}
+	if form.DateNotBefore("start", "end") {
+		t.Error("Form shows earlier date is not before later date")
+	}
+	if form.Errors.Get("start") == "" {
+		t.Error("should have an error, but did not get one")
+	}
+}
+
+func TestIsSlug(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("a", "majors-suite")
+	postedData.Add("b", "Major's Suite")
+	postedData.Add("c", "majors--suite")
+	form := New(postedData)
+
+	if !form.IsSlug("a") {
+		t.Error("Form shows invalid slug for valid slug")
+	}
+	if form.IsSlug("b") {
+		t.Error("Form shows valid slug for a room name")
+	}
+	if form.IsSlug("c") {
+		t.Error("Form shows valid slug for a slug with a double dash")
+	}
+	if form.IsSlug("d") {
+		t.Error("Form shows valid slug for non-existent field")
+	}
+	if form.Errors.Get("b") == "" {
+		t.Error("should have an error, but did not get one")
+	}
+}
+
+func TestIsIntBetween(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("a", "4")
+	postedData.Add("b", "0")
+	postedData.Add("c", "two")
+	postedData.Add("d", "21")
+	form := New(postedData)
+
+	if !form.IsIntBetween("a", 1, 20) {
+		t.Error("Form shows invalid number for a number in range")
+	}
+	if form.IsIntBetween("b", 1, 20) {
+		t.Error("Form shows valid number for a number below the range")
+	}
+	if form.IsIntBetween("c", 1, 20) {
+		t.Error("Form shows valid number for a word")
+	}
+	if form.IsIntBetween("d", 1, 20) {
+		t.Error("Form shows valid number for a number above the range")
+	}
+	if form.Errors.Get("b") == "" {
+		t.Error("should have an error, but did not get one")
+	}
+}
+
+func TestIsMoney(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("a", "125")
+	postedData.Add("b", "$1,250.50")
+	postedData.Add("c", "12.505")
+	postedData.Add("d", "-5")
+	postedData.Add("e", "ten")
+	form := New(postedData)
+
+	if !form.IsMoney("a") {
+		t.Error("Form shows invalid amount for a whole number")
+	}
+	if !form.IsMoney("b") {
+		t.Error("Form shows invalid amount for a formatted amount")
+	}
+	if form.IsMoney("c") {
+		t.Error("Form shows valid amount for fractions of a cent")
+	}
+	if form.IsMoney("d") {
+		t.Error("Form shows valid amount for a negative number")
+	}
+	if form.IsMoney("e") {
+		t.Error("Form shows valid amount for a word")
+	}
+	if form.Errors.Get("c") == "" {
+		t.Error("should have an error, but did not get one")
+	}
+}
+
+func TestIsPromoCode(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("a", "SUMMER-10")
+	postedData.Add("b", " welcome ")
+	postedData.Add("c", "10% OFF")
+	postedData.Add("d", "AB")
+	postedData.Add("e", "-SUMMER")
+	form := New(postedData)
+
+	if !form.IsPromoCode("a") {
+		t.Error("Form shows invalid promo code for letters, digits and a dash")
+	}
+	if !form.IsPromoCode("b") {
+		t.Error("Form shows invalid promo code for lowercase letters with spaces around")
+	}
+	if form.IsPromoCode("c") {
+		t.Error("Form shows valid promo code for symbols and spaces")
+	}
+	if form.IsPromoCode("d") {
+		t.Error("Form shows valid promo code for a code that is too short")
+	}
+	if form.IsPromoCode("e") {
+		t.Error("Form shows valid promo code starting with a dash")
+	}
+	if form.Errors.Get("c") == "" {
+		t.Error("should have an error, but did not get one")
+	}
+}
+
+func TestIsPercentage(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("a", "10")
+	postedData.Add("b", " 7.25% ")
+	postedData.Add("c", "100.5")
+	postedData.Add("d", "7.125")
+	postedData.Add("e", "ten")
+	form := New(postedData)
+
+	if !form.IsPercentage("a") {
+		t.Error("Form shows invalid percentage for a whole number")
+	}
+	if !form.IsPercentage("b") {
+		t.Error("Form shows invalid percentage for decimals with a percent sign and spaces around")
+	}
+	if form.IsPercentage("c") {
+		t.Error("Form shows valid percentage for more than 100")
+	}
+	if form.IsPercentage("d") {
+		t.Error("Form shows valid percentage for three decimals")
+	}
+	if form.IsPercentage("e") {
+		t.Error("Form shows valid percentage for a word")
+	}
+	if form.Errors.Get("e") == "" {
+		t.Error("should have an error, but did not get one")
+	}
+}
@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -13,7 +19,11 @@ import (
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/driver"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/forms"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/helpers"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/invoices"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/payments"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/photos"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/pricing"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/render"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/repository"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/repository/dbrepo"
@@ -36,6 +46,19 @@ func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
 	}
 }
 
+// creates a new repository backed by SQLite
+func NewSQLiteRepo(a *config.AppConfig, db *driver.DB) (*Repository, error) {
+	repo, err := dbrepo.NewSQLiteRepo(db.SQL, a)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{
+		App: a,
+		DB:  repo,
+	}, nil
+}
+
 // creates a new repository
 func NewTestRepo(a *config.AppConfig) *Repository {
 	return &Repository{
@@ -47,6 +70,7 @@ func NewTestRepo(a *config.AppConfig) *Repository {
 // set the repository for the handlers
 func NewHandlers(r *Repository) {
 	Repo = r
+	render.SetNavRooms(r.DB.ActiveRooms)
 }
 
 func (m *Repository) Home(w http.ResponseWriter, r *http.Request) {
@@ -63,12 +87,30 @@ func (m *Repository) About(w http.ResponseWriter, r *http.Request) {
 	_ = render.Template(w, r, "about.page.tmpl", &models.TemplateData{})
 }
 
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	_ = render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+// Room shows the public page of an active room, /rooms/{slug}
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	_ = render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	_ = render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+// OldRoomPage sends the addresses room pages had before they came from the database,
+// like /majors-suite, to the room's page under /rooms
+func (m *Repository) OldRoomPage(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/rooms"+r.URL.Path, http.StatusMovedPermanently)
 }
 
 func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
@@ -102,7 +144,7 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Cannot connect to the database!")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -116,8 +158,33 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	rules, err := m.DB.RateRulesForStay(r.Context(), startDate, endDate)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot connect to the database!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	charges, err := m.DB.ActiveCharges(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot connect to the database!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// every room is quoted at today's rates, the total is fixed when the reservation is made
+	quotes := make(map[int]models.Money)
+	for _, room := range rooms {
+		quotes[room.ID] = pricing.Total(room, rules, startDate, endDate)
+	}
+
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
+	data["quotes"] = quotes
+	data["charges"] = charges
+
+	intMap := make(map[string]int)
+	intMap["nights"] = models.Nights(startDate, endDate)
 
 	res := models.Reservation{
 		StartDate: startDate,
@@ -127,7 +194,8 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	_ = render.Template(w, r, "choose-room.page.tmpl", &models.TemplateData{
-		Data: data,
+		Data:   data,
+		IntMap: intMap,
 	})
 }
 
@@ -164,7 +232,7 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 
 	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
 
-	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), startDate, endDate, roomID)
 
 	if err != nil {
 		resp := jsonResponse{
@@ -209,6 +277,18 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// only rooms guests can see may be chosen, whatever number is put in the URL
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res.Room.RoomName = room.RoomName
 	res.RoomID = roomID
 
 	m.App.Session.Put(r.Context(), "reservation", res)
@@ -226,14 +306,25 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	startDate, _ := time.Parse(layout, sd)
 	endDate, _ := time.Parse(layout, ed)
 
+	if models.Nights(startDate, endDate) < 1 {
+		m.App.Session.Put(r.Context(), "error", "Your stay must end after it starts. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
 	var res models.Reservation
 
-	room, err := m.DB.GetRoomByID(roomID)
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Cannot get room by ID")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	if !room.Active {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room can no longer be booked. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
 
 	res.Room.RoomName = room.RoomName
 	res.RoomID = roomID
@@ -257,28 +348,51 @@ func (m *Repository) MakeReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	if res.Guests < 1 {
+		res.Guests = 1
+	}
+
+	room, quote, err := m.priceStay(r.Context(), res.RoomID, res.StartDate, res.EndDate, res.Guests)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Cannot find room!")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
+	res.CancellationPolicy, err = m.cancellationPolicy(r.Context(), quote)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get cancellation policy from database!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// promo codes are applied from the form, so none is carried over from an earlier attempt
 	res.Room.RoomName = room.RoomName
+	res.TotalPrice = quote.Total
+	res.PromoCode = ""
+	res.Discount = 0
+	res.Charges = quote.ReservationCharges()
 	m.App.Session.Put(r.Context(), "reservation", res)
 
-	sd := res.StartDate.Format("2006-01-02")
-	ed := res.EndDate.Format("2006-01-02")
+	m.renderReservationForm(w, r, res, room, quote, forms.New(nil))
+}
 
+// renderReservationForm shows the guest the price of their stay and the form that books it
+func (m *Repository) renderReservationForm(w http.ResponseWriter, r *http.Request, res models.Reservation, room models.Room, quote pricing.Quote, form *forms.Form) {
 	stringMap := make(map[string]string)
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["room"] = room
+	data["quote"] = quote
+	if _, ok := m.App.Payments.(*payments.Fake); ok {
+		data["test_cards"] = payments.FakeCards
+	}
 
 	_ = render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-		Form:      forms.New(nil),
+		Form:      form,
 		Data:      data,
 		StringMap: stringMap,
 	})
@@ -299,81 +413,191 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// a stay of no nights costs nothing, so it would be booked without paying
+	if reservation.Nights() < 1 {
+		m.App.Session.Put(r.Context(), "error", "Your stay must end after it starts. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
 	reservation.FirstName = r.Form.Get("first_name")
 	reservation.LastName = r.Form.Get("last_name")
 	reservation.Phone = r.Form.Get("phone")
 	reservation.Email = r.Form.Get("email")
+	reservation.PromoCode = models.NormalizePromoCode(r.Form.Get("promo_code"))
+
+	room, err := m.DB.GetRoomByID(r.Context(), reservation.RoomID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot find room!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
 
 	form := forms.New(r.PostForm)
 
-	// check form if invalid
-	form.Required("first_name", "last_name", "email")
-	form.MinLength("first_name", 3)
-	form.IsEmail("email")
+	// the form always sends the number of guests, one is assumed when it does not
+	reservation.Guests = 1
+	if r.Form.Get("guests") != "" && form.IsIntBetween("guests", 1, room.MaxOccupancy) {
+		reservation.Guests, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get("guests")))
+	}
 
-	if !form.Valid() {
-		data := make(map[string]interface{})
-		data["reservation"] = reservation
+	// the total is quoted again at today's rates and stored with the reservation,
+	// so later rate or tax changes do not alter it
+	_, quote, err := m.priceStay(r.Context(), reservation.RoomID, reservation.StartDate, reservation.EndDate, reservation.Guests)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot find room!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
 
-		stringMap := make(map[string]string)
-		stringMap["start_date"] = reservation.StartDate.Format("2006-01-02")
-		stringMap["end_date"] = reservation.EndDate.Format("2006-01-02")
+	// the guest books under the cancellation terms in force now, which are kept with the reservation
+	reservation.CancellationPolicy, err = m.cancellationPolicy(r.Context(), quote)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get cancellation policy from database!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
 
-		w.WriteHeader(http.StatusSeeOther)
-		_ = render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-			Form:      form,
-			Data:      data,
-			StringMap: stringMap,
-		})
+	// the apply button only checks the promo code and shows the discounted price
+	applyOnly := r.Form.Get("apply") != ""
+
+	if !applyOnly {
+		// check form if invalid
+		form.Required("first_name", "last_name", "email")
+		form.MinLength("first_name", 3)
+		form.IsEmail("email")
+	}
+
+	if reservation.PromoCode != "" && form.IsPromoCode("promo_code") {
+		err = m.applyPromoCode(r.Context(), form, reservation, &quote)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Cannot get promo code from database!")
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
+	}
+	reservation.TotalPrice = quote.Total
+	reservation.Discount = quote.Discount
+	reservation.Charges = quote.ReservationCharges()
+
+	if !applyOnly && reservation.TotalPrice > 0 {
+		form.Required("card_number")
+	}
+
+	if !form.Valid() || applyOnly {
+		if !form.Valid() {
+			w.WriteHeader(http.StatusSeeOther)
+		}
+		m.renderReservationForm(w, r, reservation, room, quote, form)
 		return
 	}
 
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	// the card is authorized before anything is stored, so a declined card leaves no reservation behind
+	var auth payments.Authorization
+	if reservation.TotalPrice > 0 {
+		auth, err = m.App.Payments.Authorize(r.Context(), payments.AuthorizeRequest{
+			Amount: reservation.TotalPrice,
+			Description: fmt.Sprintf("%s from %s to %s", room.RoomName,
+				reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02")),
+			Card:      r.Form.Get("card_number"),
+			ReturnURL: "/payment-return",
+		})
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Cannot reach the payment provider!")
+			http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+			return
+		}
+		if auth.Status == models.PaymentDeclined {
+			form.Errors.Add("card_number", auth.DeclineReason)
+			w.WriteHeader(http.StatusSeeOther)
+			m.renderReservationForm(w, r, reservation, room, quote, form)
+			return
+		}
+	}
+
+	// the reservation and its room restriction are written together, so a failure
+	// never leaves a reservation behind that does not block the room
+	newReservationID, err := m.DB.InsertReservationWithRestriction(r.Context(), reservation)
+	if err != nil {
+		m.releasePayment(r.Context(), auth, reservation.TotalPrice)
+	}
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room just got taken for your dates. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, repository.ErrPromoCodeUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, that promo code can no longer be used. Please check your price and book again.")
+		http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Cannot insert reservation into database!")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
+	reservation.ID = newReservationID
+
+	if auth.Reference != "" {
+		_, err = m.DB.InsertPayment(r.Context(), models.Payment{
+			ReservationID: reservation.ID,
+			Provider:      m.App.Payments.Name(),
+			Reference:     auth.Reference,
+			Status:        auth.Status,
+			Amount:        reservation.TotalPrice,
+			CardLast4:     auth.CardLast4,
+		})
+		if err != nil {
+			// without its payment the reservation is unpaid, so the hold on the card is released
+			// and the room freed rather than kept for a booking nobody paid for
+			m.releasePayment(r.Context(), auth, reservation.TotalPrice)
+			cancelErr := m.DB.CancelReservation(r.Context(), reservation.ID, 0, "Payment could not be recorded", 0)
+			if cancelErr != nil {
+				m.App.ErrorLog.Println(cancelErr)
+			}
+			m.App.Session.Put(r.Context(), "error", "Cannot insert payment into database!")
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
 	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Cannot insert room restriction into database!")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	// the room is held while the guest authenticates the payment with their bank,
+	// the reservation is confirmed when they come back, see PaymentReturn
+	if auth.Status == models.PaymentPending {
+		m.App.Session.Put(r.Context(), "reservation", reservation)
+		http.Redirect(w, r, auth.RedirectURL, http.StatusSeeOther)
 		return
 	}
 
-	// send notification - first to guest
-	htmlMessage := fmt.Sprintf(`
-		<strong>Reservation Confirmation</strong><br>
-		Dear %s,<br>
-		This is confirm your reservation from %s to %s.
-	`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+	m.completeReservation(w, r, reservation)
+}
 
-	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "server@mail.com",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
+// completeReservation sends the guest their confirmation and the owner a notification of a new reservation,
+// then shows the guest its summary
+func (m *Repository) completeReservation(w http.ResponseWriter, r *http.Request, reservation models.Reservation) {
+	// the confirmation code is generated by the repository
+	saved, err := m.DB.GetReservationByID(r.Context(), reservation.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get reservation from database!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
 	}
+	reservation.ConfirmationCode = saved.ConfirmationCode
 
-	m.App.MailChan <- msg
+	// send notification - first to guest
+	m.sendConfirmationMail(reservation)
 
 	// send notification to property owner
-	htmlMessage = fmt.Sprintf(`
+	htmlMessage := fmt.Sprintf(`
 		<strong>Reservation Notification</strong><br>
-		A reservation has been made for %s from %s to %s.
-	`, reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+		A reservation has been made for %s from %s to %s for %d guest(s), confirmation code %s.<br>
+		%s
+		Total: %s
+	`, html.EscapeString(reservation.Room.RoomName), reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"),
+		reservation.Guests, reservation.ConfirmationCode, priceBreakdown(reservation), reservation.TotalPrice)
 
-	msg = models.MailData{
+	msg := models.MailData{
 		To:      "owner@mail.com",
 		From:    "server@mail.com",
 		Subject: "Reservation Notification",
@@ -387,191 +611,2670 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 }
 
-// ReservationSummary displays the reservation summary
-func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
-	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
-		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session!")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-
-		return
+// priceStay quotes a stay for guests in a room at its nightly rate and the rate rules that cover it,
+// with the taxes and fees in force
+func (m *Repository) priceStay(ctx context.Context, roomID int, start, end time.Time, guests int) (models.Room, pricing.Quote, error) {
+	room, err := m.DB.GetRoomByID(ctx, roomID)
+	if err != nil {
+		return room, pricing.Quote{}, err
 	}
 
-	m.App.Session.Remove(r.Context(), "reservation")
-
-	sd := reservation.StartDate.Format("2006-01-02")
-	ed := reservation.EndDate.Format("2006-01-02")
-
-	stringMap := make(map[string]string)
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
+	rules, err := m.DB.RateRulesForStay(ctx, start, end)
+	if err != nil {
+		return room, pricing.Quote{}, err
+	}
 
-	data := make(map[string]interface{})
-	data["reservation"] = reservation
+	charges, err := m.DB.ActiveCharges(ctx)
+	if err != nil {
+		return room, pricing.Quote{}, err
+	}
 
-	_ = render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
-		Data:      data,
-		StringMap: stringMap,
-	})
+	quote := pricing.Price(room, rules, start, end)
+	quote.AddCharges(charges, guests)
+	return room, quote, nil
 }
 
-// ShowLogin shows the login screen
-func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
-	_ = render.Template(w, r, "login.page.tmpl", &models.TemplateData{
-		Form: forms.New(nil),
-	})
+// cancellationPolicy returns the cancellation policy a stay priced at quote is booked under,
+// free cancellation when it has none
+func (m *Repository) cancellationPolicy(ctx context.Context, quote pricing.Quote) (models.CancellationPolicy, error) {
+	if quote.CancellationPolicyID == 0 {
+		return models.CancellationPolicy{}, nil
+	}
+	return m.DB.GetCancellationPolicyByID(ctx, quote.CancellationPolicyID)
 }
 
-// PostShowLogin handles logging the user in
-func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.RenewToken(r.Context())
+// cancellationCost works out the fee for cancelling res now under the terms it was booked under,
+// and how much of what the guest paid they would get back
+func (m *Repository) cancellationCost(ctx context.Context, res models.Reservation) (fee, refund models.Money, err error) {
+	fee = pricing.CancellationFee(res, time.Now())
 
-	err := r.ParseForm()
+	reservationPayments, err := m.DB.PaymentsForReservation(ctx, res.ID)
 	if err != nil {
-		log.Println(err)
+		return fee, 0, err
 	}
 
-	form := forms.New(r.PostForm)
-	form.Required("email", "password")
-	form.IsEmail("email")
-	if !form.Valid() {
-		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
-			Form: form,
-		})
-		return
+	return fee, payments.Refunded(payments.Settle(reservationPayments, fee)), nil
+}
+
+// paymentsHeld returns how much the payments hold for the reservation, authorized or captured and not refunded
+func paymentsHeld(reservationPayments []models.Payment) models.Money {
+	var held models.Money
+	for _, p := range reservationPayments {
+		held += p.Refundable()
 	}
+	return held
+}
 
-	email := r.Form.Get("email")
-	password := r.Form.Get("password")
-	id, _, err := m.DB.Authenticate(email, password)
+// settlePayments settles the payments of a cancelled reservation with the payment provider, so the fee
+// charged for cancelling is kept and everything else the guest paid is given back. It returns how much
+// was given back. Payments settled before an error are recorded, the others are left as they were.
+func (m *Repository) settlePayments(ctx context.Context, reservationID int, fee models.Money) (models.Money, error) {
+	reservationPayments, err := m.DB.PaymentsForReservation(ctx, reservationID)
 	if err != nil {
-		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
-		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return 0, err
 	}
 
-	m.App.Session.Put(r.Context(), "user_id", id)
-	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
-}
+	var settled []payments.Settlement
+	for _, s := range payments.Settle(reservationPayments, fee) {
+		p := s.Payment
 
-// Logout logs a user out
-func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.Destroy(r.Context())
-	_ = m.App.Session.RenewToken(r.Context())
+		if s.Capture > 0 {
+			err = m.App.Payments.Capture(ctx, p.Reference, s.Capture)
+			if err != nil {
+				return payments.Refunded(settled), err
+			}
+			p.Amount = s.Capture
+			p.Status = models.PaymentCaptured
+		}
 
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-}
+		if s.Refund > 0 {
+			err = m.App.Payments.Refund(ctx, p.Reference, s.Refund)
+			if err != nil {
+				return payments.Refunded(settled), err
+			}
+			p.Refunded += s.Refund
+			if p.Refunded == p.Amount {
+				p.Status = models.PaymentRefunded
+			}
+		}
 
-// AdminDashboard shows Admin Dashboard screen
-func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+		err = m.DB.UpdatePayment(ctx, p)
+		if err != nil {
+			return payments.Refunded(settled), err
+		}
+		settled = append(settled, s)
+	}
+
+	return payments.Refunded(settled), nil
 }
 
-// AdminNewReservations shows all new reservations in admin tool
-func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
+// cancellationNote tells the guest what cancelling cost them and what they get back, nothing when it was free
+func cancellationNote(fee, refunded models.Money) string {
+	var notes []string
+	if fee > 0 {
+		notes = append(notes, fmt.Sprintf("A cancellation fee of %s applies.", fee))
 	}
-	data := make(map[string]interface{})
-	data["reservations"] = reservations
-	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
-	})
+	if refunded > 0 {
+		notes = append(notes, fmt.Sprintf("%s is refunded to your card.", refunded))
+	}
+	return strings.Join(notes, " ")
 }
 
-// AdminAllReservations shows all reservations in admin tool
-func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
-	if err != nil {
-		helpers.ServerError(w, err)
+// releasePayment gives back the money a payment holds on the guest's card when the reservation it was
+// authorized for cannot be made. It does nothing if no payment was authorized.
+func (m *Repository) releasePayment(ctx context.Context, auth payments.Authorization, amount models.Money) {
+	if auth.Reference == "" {
 		return
 	}
-	data := make(map[string]interface{})
-	data["reservations"] = reservations
-	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
-	})
+	err := m.App.Payments.Refund(ctx, auth.Reference, amount)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
 }
 
-// AdminShowReservation shows a reservation in admin
-func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
-	exploded := strings.Split(r.RequestURI, "/")
+// PaymentReturn is where guests come back to after authenticating their payment with their bank.
+// The reservation is confirmed if the payment went through, or cancelled if it did not. The provider's
+// webhook may have recorded the outcome before the guest is back, see PaymentWebhook.
+func (m *Repository) PaymentReturn(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Cannot get reservation from session!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
 
-	id, err := strconv.Atoi(exploded[4])
-	if err != nil {
-		helpers.ServerError(w, err)
+	// the confirmation code is only put in the session once the guest was told about the reservation
+	if reservation.ConfirmationCode != "" {
+		http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 		return
 	}
 
-	src := exploded[3]
+	payment, err := m.DB.GetPaymentByReference(r.Context(), m.App.Payments.Name(), r.URL.Query().Get("reference"))
+	if err != nil || payment.ReservationID != reservation.ID {
+		m.App.Session.Put(r.Context(), "error", "Cannot find your payment!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
 
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
+	if payment.Status == models.PaymentPending {
+		auth, err := m.App.Payments.Lookup(r.Context(), payment.Reference)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Cannot reach the payment provider!")
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
-	stringMap["year"] = year
-	stringMap["month"] = month
+		// the guest left the bank's page without answering
+		if auth.Status == models.PaymentPending {
+			http.Redirect(w, r, auth.RedirectURL, http.StatusSeeOther)
+			return
+		}
+
+		payment.Status = auth.Status
+		payment.FailureReason = auth.DeclineReason
+		err = m.DB.UpdatePayment(r.Context(), payment)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Cannot update payment in database!")
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
+	switch payment.Status {
+	case models.PaymentAuthorized:
+		m.completeReservation(w, r, reservation)
+		return
+	case models.PaymentDeclined, models.PaymentRefunded:
+	default:
+		m.App.Session.Put(r.Context(), "error", "Cannot find your payment!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// a declined webhook cancels the reservation too, so it may be cancelled already
+	err = m.DB.CancelReservation(r.Context(), reservation.ID, 0, "Payment declined: "+declineReason(payment), 0)
+	if err != nil && !errors.Is(err, repository.ErrInvalidStatusTransition) {
+		m.App.Session.Put(r.Context(), "error", "Cannot cancel reservation in database!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// the guest can try again with another card, for the same stay
+	reservation.ID = 0
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+	m.App.Session.Put(r.Context(), "error", declineReason(payment)+". Please try another card.")
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// declineReason says why a payment did not go through, for guests and as the reason its reservation was cancelled
+func declineReason(payment models.Payment) string {
+	if payment.FailureReason == "" {
+		return "Your payment did not go through"
+	}
+	return payment.FailureReason
+}
+
+// ExpirePendingPayments gives up on payments the guest did not authenticate with their bank before cutoff.
+// The payment is released and declined, and its reservation cancelled so the room is free again.
+// It carries on past payments that fail and returns the last error.
+func (m *Repository) ExpirePendingPayments(ctx context.Context, cutoff time.Time) error {
+	pending, err := m.DB.PendingPayments(ctx)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, p := range pending {
+		if !p.CreatedAt.Before(cutoff) {
+			continue
+		}
+
+		// the provider may already have declined it, which leaves nothing to release
+		err = m.App.Payments.Refund(ctx, p.Reference, p.Amount)
+		if err != nil && !errors.Is(err, payments.ErrInvalidState) {
+			lastErr = err
+			continue
+		}
+
+		p.Status = models.PaymentDeclined
+		p.FailureReason = "The payment was not authenticated in time"
+		err = m.DB.UpdatePayment(ctx, p)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		err = m.DB.CancelReservation(ctx, p.ReservationID, 0, "Payment not authenticated in time", 0)
+		if err != nil && !errors.Is(err, repository.ErrInvalidStatusTransition) {
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+// PaymentWebhook records the changes to payments the payment provider notifies us of. Changes the payment's
+// status does not allow are refused with 409 Conflict.
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	event, err := m.App.Payments.VerifyWebhook(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payment, err := m.DB.GetPaymentByReference(r.Context(), m.App.Payments.Name(), event.Reference)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// notifications can come twice or out of order, so one that would move a payment back is refused,
+	// like a refunded payment being authorized again
+	if event.Status != payment.Status && !payment.Status.CanTransitionTo(event.Status) {
+		http.Error(w, fmt.Sprintf("payment is %s and cannot become %s", payment.Status, event.Status), http.StatusConflict)
+		return
+	}
+
+	wasPending := payment.Status == models.PaymentPending
+
+	// the event says nothing about amounts taken or why a payment was declined, the provider does
+	if event.Status != payment.Status && (event.Status == models.PaymentCaptured || event.Status == models.PaymentDeclined) {
+		auth, err := m.App.Payments.Lookup(r.Context(), payment.Reference)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if event.Status == models.PaymentCaptured && auth.Amount > 0 {
+			payment.Amount = auth.Amount
+		}
+		payment.FailureReason = auth.DeclineReason
+	}
 
-	reservation, err := m.DB.GetReservationByID(id)
+	payment.Status = event.Status
+	if event.Refunded > payment.Refunded {
+		payment.Refunded = min(event.Refunded, payment.Amount)
+	}
+	err = m.DB.UpdatePayment(r.Context(), payment)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
+
+	// a payment that never went through frees the room, whether or not the guest comes back from their bank
+	if wasPending && (payment.Status == models.PaymentDeclined || payment.Status == models.PaymentRefunded) {
+		err = m.DB.CancelReservation(r.Context(), payment.ReservationID, 0, "Payment declined: "+declineReason(payment), 0)
+		if err != nil && !errors.Is(err, repository.ErrInvalidStatusTransition) {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyPromoCode looks up the promo code of res and takes its discount off quote, or adds an error to form
+// saying why the code cannot be used for the stay. It returns an error only when the code cannot be looked up.
+func (m *Repository) applyPromoCode(ctx context.Context, form *forms.Form, res models.Reservation, quote *pricing.Quote) error {
+	promo, err := m.DB.GetPromoCodeByCode(ctx, res.PromoCode)
+	if errors.Is(err, sql.ErrNoRows) {
+		form.Errors.Add("promo_code", "This promo code does not exist!")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch promo.Check(res.RoomID, res.Nights(), time.Now()) {
+	case nil:
+		quote.ApplyPromo(promo)
+	case models.ErrPromoNotStarted:
+		form.Errors.Add("promo_code", "This promo code cannot be used yet!")
+	case models.ErrPromoExpired:
+		form.Errors.Add("promo_code", "This promo code has expired!")
+	case models.ErrPromoUsedUp:
+		form.Errors.Add("promo_code", "This promo code has been used up!")
+	case models.ErrPromoRoom:
+		form.Errors.Add("promo_code", "This promo code does not apply to this room!")
+	case models.ErrPromoMinNights:
+		form.Errors.Add("promo_code", fmt.Sprintf("This promo code needs a stay of at least %d nights!", promo.MinNights))
+	}
+
+	return nil
+}
+
+// sendConfirmationMail sends the guest the confirmation of a new reservation
+func (m *Repository) sendConfirmationMail(reservation models.Reservation) {
+	htmlMessage := fmt.Sprintf(`
+		<strong>Reservation Confirmation</strong><br>
+		Dear %s,<br>
+		This is confirm your reservation from %s to %s.<br>
+		Your confirmation code is <strong>%s</strong>.<br>
+		%s
+		The total for your stay is %s.
+	`, html.EscapeString(reservation.FirstName), reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"),
+		reservation.ConfirmationCode, priceBreakdown(reservation), reservation.TotalPrice)
+
+	msg := models.MailData{
+		To:       reservation.Email,
+		From:     "server@mail.com",
+		Subject:  "Reservation Confirmation",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+
+	m.App.MailChan <- msg
+}
+
+// issueInvoice returns the invoice of a reservation, issuing it first if the reservation has none.
+// It returns models.ErrReservationOpen for reservations that are not closed yet.
+func (m *Repository) issueInvoice(ctx context.Context, res models.Reservation) (models.Invoice, error) {
+	inv, err := m.DB.GetInvoiceForReservation(ctx, res.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return inv, err
+	}
+
+	inv, err = models.NewInvoice(res, time.Now())
+	if err != nil {
+		return inv, err
+	}
+
+	inv, err = m.DB.InsertInvoice(ctx, inv)
+	if errors.Is(err, repository.ErrInvoiceExists) {
+		// issued by another request in the meantime
+		return m.DB.GetInvoiceForReservation(ctx, res.ID)
+	}
+	return inv, err
+}
+
+// closingInvoice issues the invoice of a reservation that was just checked out or cancelled, and returns it
+// as attachments for the email that tells the guest. Nothing is attached when invoices are not emailed or
+// the reservation has nothing to invoice. The reservation is closed either way, so errors are only logged.
+func (m *Repository) closingInvoice(ctx context.Context, id int) []models.MailAttachment {
+	res, err := m.DB.GetReservationByID(ctx, id)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return nil
+	}
+
+	inv, err := m.issueInvoice(ctx, res)
+	if errors.Is(err, models.ErrNothingToInvoice) {
+		return nil
+	}
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return nil
+	}
+	if !m.App.InvoiceEmails {
+		return nil
+	}
+
+	attachment, err := m.invoiceAttachment(inv)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return nil
+	}
+	return []models.MailAttachment{attachment}
+}
+
+// invoiceAttachment returns an invoice as a PDF to attach to an email
+func (m *Repository) invoiceAttachment(inv models.Invoice) (models.MailAttachment, error) {
+	var buf bytes.Buffer
+	err := invoices.Write(&buf, m.App.InvoiceIssuer, inv)
+	if err != nil {
+		return models.MailAttachment{}, err
+	}
+
+	return models.MailAttachment{Name: inv.FileName(), ContentType: "application/pdf", Data: buf.Bytes()}, nil
+}
+
+// sendInvoiceMail sends the guest of a checked out reservation their invoice
+func (m *Repository) sendInvoiceMail(reservation models.Reservation, attachments []models.MailAttachment) {
+	htmlMessage := fmt.Sprintf(`
+		<strong>Thank You for Staying with Us</strong><br>
+		Dear %s,<br>
+		Please find attached the invoice for your stay in %s from %s to %s.
+	`, html.EscapeString(reservation.FirstName), html.EscapeString(reservation.Room.RoomName),
+		reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+
+	m.App.MailChan <- models.MailData{
+		To:          reservation.Email,
+		From:        "server@mail.com",
+		Subject:     "Your Invoice",
+		Content:     htmlMessage,
+		Template:    "basic.html",
+		Attachments: attachments,
+	}
+}
+
+// priceBreakdown itemizes the price of a reservation for emails: the room, the promo code discount and
+// every tax and fee, one per line, as HTML. It returns "" when the total is the room price alone.
+func priceBreakdown(res models.Reservation) string {
+	if res.PromoCode == "" && len(res.Charges) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Room: %s<br>", res.RoomPrice())
+	if res.PromoCode != "" {
+		fmt.Fprintf(&b, "Promo code %s: -%s<br>", html.EscapeString(res.PromoCode), res.Discount)
+	}
+	for _, c := range res.Charges {
+		fmt.Fprintf(&b, "%s: %s<br>", html.EscapeString(c.Name), c.Amount)
+	}
+	return b.String()
+}
+
+// ReservationSummary displays the reservation summary
+func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+
+		return
+	}
+
+	m.App.Session.Remove(r.Context(), "reservation")
+
+	reservationPayments, err := m.DB.PaymentsForReservation(r.Context(), reservation.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get payment from database!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	sd := reservation.StartDate.Format("2006-01-02")
+	ed := reservation.EndDate.Format("2006-01-02")
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = sd
+	stringMap["end_date"] = ed
+
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+	data["payments"] = reservationPayments
+
+	_ = render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// CancelReservation shows the form guests use to cancel their reservation
+func (m *Repository) CancelReservation(w http.ResponseWriter, r *http.Request) {
+	_ = render.Template(w, r, "cancel-reservation.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostCancelReservation cancels a guest's reservation once the confirmation code and email match
+func (m *Repository) PostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("confirmation_code", "email", "reason")
+	form.IsEmail("email")
+
+	var reservation models.Reservation
+	if form.Valid() {
+		// a wrong code and a wrong email get the same answer, so the form cannot be used to look up bookings
+		reservation, err = m.DB.GetReservationByCode(r.Context(), r.Form.Get("confirmation_code"))
+		if err != nil || !strings.EqualFold(reservation.Email, strings.TrimSpace(r.Form.Get("email"))) {
+			form.Errors.Add("confirmation_code", "We could not find a reservation with these details")
+		} else if !reservation.Status.CanTransitionTo(models.StatusCancelled) {
+			form.Errors.Add("confirmation_code", "This reservation can no longer be cancelled")
+		}
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		_ = render.Template(w, r, "cancel-reservation.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	reason := strings.TrimSpace(r.Form.Get("reason"))
+	fee := pricing.CancellationFee(reservation, time.Now())
+	err = m.DB.CancelReservation(r.Context(), reservation.ID, 0, reason, fee)
+	if errors.Is(err, repository.ErrInvalidStatusTransition) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled")
+		http.Redirect(w, r, "/cancel-reservation", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot cancel reservation!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// the reservation is cancelled either way, the owner settles any payment the provider did not
+	refunded, err := m.settlePayments(r.Context(), reservation.ID, fee)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+
+	m.sendCancellationMail(reservation, reason, fee, refunded, m.closingInvoice(r.Context(), reservation.ID))
+
+	flash := "Your reservation has been cancelled"
+	if note := cancellationNote(fee, refunded); note != "" {
+		flash += ". " + note
+	}
+	m.App.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// sendCancellationMail tells the guest and the property owner that a reservation was cancelled,
+// with attachments for the guest like the invoice of the cancellation fee
+func (m *Repository) sendCancellationMail(reservation models.Reservation, reason string, fee, refunded models.Money, attachments []models.MailAttachment) {
+	htmlMessage := fmt.Sprintf(`
+		<strong>Reservation Cancelled</strong><br>
+		Dear %s,<br>
+		Your reservation %s of %s from %s to %s has been cancelled. %s
+	`, html.EscapeString(reservation.FirstName), reservation.ConfirmationCode, html.EscapeString(reservation.Room.RoomName),
+		reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"), cancellationNote(fee, refunded))
+
+	m.App.MailChan <- models.MailData{
+		To:          reservation.Email,
+		From:        "server@mail.com",
+		Subject:     "Reservation Cancelled",
+		Content:     htmlMessage,
+		Template:    "basic.html",
+		Attachments: attachments,
+	}
+
+	htmlMessage = fmt.Sprintf(`
+		<strong>Cancellation Notification</strong><br>
+		The reservation of %s for %s %s from %s to %s has been cancelled.<br>
+		Reason: %s<br>
+		Cancellation fee: %s, refunded: %s
+	`, html.EscapeString(reservation.Room.RoomName), html.EscapeString(reservation.FirstName), html.EscapeString(reservation.LastName),
+		reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"), html.EscapeString(reason),
+		fee, refunded)
+
+	m.App.MailChan <- models.MailData{
+		To:      "owner@mail.com",
+		From:    "server@mail.com",
+		Subject: "Cancellation Notification",
+		Content: htmlMessage,
+	}
+}
+
+// ManageBooking shows the form guests use to find their reservation
+func (m *Repository) ManageBooking(w http.ResponseWriter, r *http.Request) {
+	_ = render.Template(w, r, "manage-booking.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostManageBooking finds a guest's reservation by confirmation code and email,
+// and lets this session manage it
+func (m *Repository) PostManageBooking(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form!")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("confirmation_code", "email")
+	form.IsEmail("email")
+
+	var reservation models.Reservation
+	if form.Valid() {
+		reservation, err = m.DB.GetReservationByCode(r.Context(), r.Form.Get("confirmation_code"))
+		if err != nil || !strings.EqualFold(reservation.Email, strings.TrimSpace(r.Form.Get("email"))) {
+			form.Errors.Add("confirmation_code", "We could not find a reservation with these details")
+		}
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		_ = render.Template(w, r, "manage-booking.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "managed_reservation_id", reservation.ID)
+
+	http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+}
+
+// managedReservation returns the reservation the guest looked up in this session
+func (m *Repository) managedReservation(r *http.Request) (models.Reservation, bool) {
+	id := m.App.Session.GetInt(r.Context(), "managed_reservation_id")
+	if id == 0 {
+		return models.Reservation{}, false
+	}
+
+	reservation, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		return reservation, false
+	}
+	return reservation, true
+}
+
+// renderManagedBooking shows a guest their reservation with the forms to change it
+func (m *Repository) renderManagedBooking(w http.ResponseWriter, r *http.Request, reservation models.Reservation, form *forms.Form) {
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
 
-	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
-		StringMap: stringMap,
-		Data:      data,
-		Form:      forms.New(nil),
-	})
-}
+	if reservation.Status.CanTransitionTo(models.StatusCancelled) {
+		fee, _, err := m.cancellationCost(r.Context(), reservation)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+		data["cancellation_fee"] = fee
+	}
+
+	_ = render.Template(w, r, "manage-reservation.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// ShowManagedBooking shows the reservation the guest looked up
+func (m *Repository) ShowManagedBooking(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.managedReservation(r)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Please look up your booking first")
+		http.Redirect(w, r, "/manage-booking", http.StatusSeeOther)
+		return
+	}
+
+	m.renderManagedBooking(w, r, reservation, forms.New(nil))
+}
+
+// PostManagedBookingContact updates the guest's contact details on the reservation they looked up
+func (m *Repository) PostManagedBookingContact(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.managedReservation(r)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Please look up your booking first")
+		http.Redirect(w, r, "/manage-booking", http.StatusSeeOther)
+		return
+	}
+	if !reservation.Status.Changeable() {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be changed")
+		http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form!")
+		http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+		return
+	}
+
+	reservation.FirstName = r.Form.Get("first_name")
+	reservation.LastName = r.Form.Get("last_name")
+	reservation.Email = r.Form.Get("email")
+	reservation.Phone = r.Form.Get("phone")
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		m.renderManagedBooking(w, r, reservation, form)
+		return
+	}
+
+	err = m.DB.UpdateReservation(r.Context(), reservation)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your contact details have been updated")
+	http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+}
+
+// PostManagedBookingDates records the dates a guest would like to move the reservation they looked up to.
+// The booking itself only moves once the property confirms the new dates.
+func (m *Repository) PostManagedBookingDates(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.managedReservation(r)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Please look up your booking first")
+		http.Redirect(w, r, "/manage-booking", http.StatusSeeOther)
+		return
+	}
+	if !reservation.Status.Changeable() {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be changed")
+		http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form!")
+		http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start_date", "end_date")
+	if form.IsDate("start_date") && form.IsDate("end_date") {
+		form.DateAfter("end_date", "start_date")
+	}
+
+	startDate, _ := time.Parse("2006-01-02", r.Form.Get("start_date"))
+	endDate, _ := time.Parse("2006-01-02", r.Form.Get("end_date"))
+	if form.Valid() && startDate.Before(time.Now().Truncate(24*time.Hour)) {
+		form.Errors.Add("start_date", "This date has already passed!")
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		m.renderManagedBooking(w, r, reservation, form)
+		return
+	}
+
+	err = m.DB.RequestDateChange(r.Context(), reservation.ID, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	htmlMessage := fmt.Sprintf(`
+		<strong>Date Change Request</strong><br>
+		%s %s asks to move reservation %s of %s from %s to %s, to %s to %s.
+	`, html.EscapeString(reservation.FirstName), html.EscapeString(reservation.LastName), reservation.ConfirmationCode,
+		html.EscapeString(reservation.Room.RoomName), reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"),
+		startDate.Format("2006-01-02"), endDate.Format("2006-01-02"))
+
+	m.App.MailChan <- models.MailData{
+		To:      "owner@mail.com",
+		From:    "server@mail.com",
+		Subject: "Date Change Request",
+		Content: htmlMessage,
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your request has been sent, we will be in touch to confirm the new dates")
+	http.Redirect(w, r, "/manage-booking/reservation", http.StatusSeeOther)
+}
+
+// ShowLogin shows the login screen
+func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	_ = render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostShowLogin handles logging the user in
+func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.RenewToken(r.Context())
+
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	email := r.Form.Get("email")
+	password := r.Form.Get("password")
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	}
+
+	m.App.Session.Put(r.Context(), "user_id", id)
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Logout logs a user out
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// AdminDashboard shows Admin Dashboard screen
+func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+}
+
+// searchResultLimit caps how many reservations the admin search shows
+const searchResultLimit = 50
+
+// AdminNewReservations shows all new reservations in admin tool
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	q := reservationQueryFromURL(r)
+	q.Status = models.StatusNew
+
+	m.renderReservationListing(w, r, "admin-new-reservations.page.tmpl", "new", q)
+}
+
+// AdminAllReservations shows all reservations in admin tool
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	m.renderReservationListing(w, r, "admin-all-reservations.page.tmpl", "all", reservationQueryFromURL(r))
+}
+
+// AdminSearchReservations finds reservations by guest name, email, phone or reservation ID
+func (m *Repository) AdminSearchReservations(w http.ResponseWriter, r *http.Request) {
+	term := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	var reservations []models.Reservation
+	if term != "" {
+		var err error
+		reservations, err = m.DB.SearchReservations(r.Context(), term, searchResultLimit)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+
+	stringMap := make(map[string]string)
+	stringMap["q"] = term
+
+	render.Template(w, r, "admin-search.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// renderReservationListing shows one page of reservations with its filters and page controls
+func (m *Repository) renderReservationListing(w http.ResponseWriter, r *http.Request, tmpl, src string, q models.ReservationQuery) {
+	page, err := m.DB.ListReservations(r.Context(), q)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["page"] = page
+	data["rooms"] = rooms
+	data["statuses"] = models.ReservationStatuses
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	render.Template(w, r, tmpl, &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// reservationQueryFromURL reads the page, sorting and filters of a reservation listing from the URL.
+// Values that cannot be parsed are ignored, the repository normalizes the rest.
+func reservationQueryFromURL(r *http.Request) models.ReservationQuery {
+	params := r.URL.Query()
+
+	var q models.ReservationQuery
+	q.Page, _ = strconv.Atoi(params.Get("page"))
+	q.PageSize, _ = strconv.Atoi(params.Get("size"))
+	q.Sort = params.Get("sort")
+	q.Desc = params.Get("dir") == "desc"
+	q.RoomID, _ = strconv.Atoi(params.Get("room"))
+	q.From, _ = time.Parse("2006-01-02", params.Get("from"))
+	q.To, _ = time.Parse("2006-01-02", params.Get("to"))
+	q.Search = strings.TrimSpace(params.Get("q"))
+	q.Status = models.ReservationStatus(params.Get("status"))
+
+	return q
+}
+
+// AdminShowReservation shows a reservation in admin
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := exploded[3]
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+	stringMap["year"] = year
+	stringMap["month"] = month
+
+	reservation, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	history, err := m.DB.GetReservationStatusHistory(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	reservationPayments, err := m.DB.PaymentsForReservation(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+	data["history"] = history
+	data["rooms"] = rooms
+	data["payments"] = reservationPayments
+
+	if reservation.Status.CanTransitionTo(models.StatusCancelled) {
+		fee, refund, err := m.cancellationCost(r.Context(), reservation)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["cancellation_fee"] = fee
+		data["cancellation_refund"] = refund
+	}
+
+	invoice, err := m.DB.GetInvoiceForReservation(r.Context(), id)
+	if err == nil {
+		data["invoice"] = invoice
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminShowReservation shows a reservation in admin
+func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	exploded := strings.Split(r.URL.String(), "/")
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := exploded[3]
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	reservation, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservation.FirstName = r.Form.Get("first_name")
+	reservation.LastName = r.Form.Get("last_name")
+	reservation.Email = r.Form.Get("email")
+	reservation.Phone = r.Form.Get("phone")
+
+	err = m.DB.UpdateReservation(r.Context(), reservation)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	month := r.Form.Get("month")
+	year := r.Form.Get("year")
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+}
+
+// AdminReservationsCalendar shows reservations calendar
+func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	// assume that there is no month/year specified
+	now := time.Now()
+	if r.URL.Query().Get("y") != "" {
+		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
+		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
+		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	data := make(map[string]interface{})
+	data["now"] = now
+
+	next := now.AddDate(0, 1, 0)
+	last := now.AddDate(0, -1, 0)
+
+	nextMonth := next.Format("01")
+	nextMonthYear := next.Format("2006")
+
+	lastMonth := last.Format("01")
+	lastMonthYear := last.Format("2006")
+
+	stringMap := make(map[string]string)
+	stringMap["next_month"] = nextMonth
+	stringMap["next_month_year"] = nextMonthYear
+	stringMap["last_month"] = lastMonth
+	stringMap["last_month_year"] = lastMonthYear
+
+	stringMap["this_month"] = now.Format("01")
+	stringMap["this_month_year"] = now.Format("2006")
+
+	// get the first & last days of the month
+	currentYear, currentMonth, _ := now.Date()
+	currentLocation := now.Location()
+	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
+	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+
+	intMap := make(map[string]int)
+	intMap["days_in_month"] = lastOfMonth.Day()
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data["rooms"] = rooms
+
+	for _, x := range rooms {
+		// create map
+		reservationMap := make(map[string]int)
+		blockMap := make(map[string]int)
+
+		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
+			reservationMap[d.Format("2006-01-02")] = 0
+			blockMap[d.Format("2006-01-02")] = 0
+		}
+
+		// get all the restrictions for the current rooms
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), x.ID, firstOfMonth, lastOfMonth)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		for _, y := range restrictions {
+			if y.ReservationID > 0 {
+				// it's a reservation
+				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
+					reservationMap[d.Format("2006-01-02")] = y.ReservationID
+				}
+			} else {
+				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
+					blockMap[d.Format("2006-01-02")] = y.ID
+				}
+			}
+		}
+		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
+		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+
+		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+	}
+
+	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		IntMap:    intMap,
+	})
+}
+
+// AdminPostReservationsCalendar handles post of reservations calendar
+func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	year, _ := strconv.Atoi(r.Form.Get("year"))
+	month, _ := strconv.Atoi(r.Form.Get("month"))
+
+	// process blocks
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	for _, room := range rooms {
+		// get the block map from the session.
+		// Loop through the entire map, if we have an entry in the map,
+		// that does not exist in our posted data, and if the restriction id > 0,
+		// then it's a block we need to remove
+		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", room.ID)).(map[string]int)
+		for name, value := range curMap {
+			// ok will be false if the value is not in the map
+			if val, ok := curMap[name]; ok {
+				// only pay attention to values > 0, and that are not in the form post
+				// the rest are just placeholders for days without blocks
+				if val > 0 {
+					if !form.Has(fmt.Sprintf("remove_block_%d_%s", room.ID, name)) {
+						// delete the restriction by ID
+						//log.Println("Would delete block ", value)
+						err := m.DB.DeleteBlockByID(r.Context(), value)
+						if err != nil {
+							log.Println(err)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// handle for new blocks
+	for name, _ := range r.PostForm {
+		if strings.HasPrefix(name, "add_block") {
+			exploded := strings.Split(name, "_")
+			roomID, _ := strconv.Atoi(exploded[2])
+			date, _ := time.Parse("2006-01-2", exploded[3])
+			// insert a new block
+			//log.Println("Would insert block for room id ", roomID, ", for date ", exploded[3])
+			err := m.DB.InsertBlockForRoom(r.Context(), roomID, date)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved!")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+}
+
+// AdminUpdateReservationStatus moves a reservation to the status in the URL, if its current status allows it
+func (m *Repository) AdminUpdateReservationStatus(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	src := chi.URLParam(r, "src")
+	status := models.ReservationStatus(chi.URLParam(r, "status"))
+
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	// cancelling charges the fee, settles the payments and tells the guest, which the cancellation form does
+	if status == models.StatusCancelled {
+		m.App.Session.Put(r.Context(), "error", "Please cancel the reservation with the cancellation form")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
+		return
+	}
+
+	err := m.DB.UpdateReservationStatus(r.Context(), id, status, m.App.Session.GetInt(r.Context(), "user_id"))
+	if errors.Is(err, repository.ErrInvalidStatusTransition) {
+		m.App.Session.Put(r.Context(), "error", "The reservation cannot be moved to that status")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the stay is paid for at checkout, which is when its invoice is issued
+	if status == models.StatusCheckedOut {
+		attachments := m.closingInvoice(r.Context(), id)
+		if len(attachments) > 0 {
+			reservation, err := m.DB.GetReservationByID(r.Context(), id)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			m.sendInvoiceMail(reservation, attachments)
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation marked as %s", strings.ToLower(status.Label())))
+
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+}
+
+// AdminRooms lists every room, active or not, in the order guests see them
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	_ = render.Template(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// renderRoomForm shows the form that creates a room, or edits it if it has an ID
+func (m *Repository) renderRoomForm(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	policies, err := m.DB.AllCancellationPolicies(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["policies"] = policies
+
+	_ = render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// maxRoomOccupancy is the most guests a room can be set to sleep
+const maxRoomOccupancy = 20
+
+// roomFromForm reads and checks the posted room name, slug, details and amenities.
+// A blank slug is made from the name.
+func roomFromForm(r *http.Request, room models.Room) (models.Room, *forms.Form) {
+	room.RoomName = strings.TrimSpace(r.Form.Get("room_name"))
+	room.Slug = strings.TrimSpace(r.Form.Get("slug"))
+	if room.Slug == "" {
+		room.Slug = models.Slugify(room.RoomName)
+		r.PostForm.Set("slug", room.Slug)
+	}
+	room.MaxOccupancy, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get("max_occupancy")))
+	room.BedConfiguration = strings.TrimSpace(r.Form.Get("bed_configuration"))
+	room.Description = strings.TrimSpace(r.Form.Get("description"))
+	room.Amenities = amenityList(r.Form.Get("amenities"))
+	room.CancellationPolicyID, _ = strconv.Atoi(r.Form.Get("cancellation_policy_id"))
+
+	form := forms.New(r.PostForm)
+	form.Required("room_name", "max_occupancy", "nightly_rate")
+	form.MinLength("room_name", 3)
+	if room.RoomName != "" {
+		form.IsSlug("slug")
+	}
+	if r.Form.Get("max_occupancy") != "" {
+		form.IsIntBetween("max_occupancy", 1, maxRoomOccupancy)
+	}
+	if r.Form.Get("nightly_rate") != "" && form.IsMoney("nightly_rate") {
+		room.NightlyRate, _ = models.ParseMoney(r.Form.Get("nightly_rate"))
+	}
+
+	return room, form
+}
+
+// amenityList reads amenities entered one per line, skipping blank lines and repeats
+func amenityList(text string) []string {
+	var amenities []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(text, "\n") {
+		name := strings.TrimSpace(line)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		amenities = append(amenities, name)
+	}
+	return amenities
+}
+
+// AdminNewRoom shows the form that creates a room
+func (m *Repository) AdminNewRoom(w http.ResponseWriter, r *http.Request) {
+	m.renderRoomForm(w, r, models.Room{Active: true, MaxOccupancy: 2}, forms.New(nil))
+}
+
+// AdminPostNewRoom creates a room, listed after the existing ones
+func (m *Repository) AdminPostNewRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, form := roomFromForm(r, models.Room{Active: true})
+
+	if form.Valid() {
+		room.ID, err = m.DB.InsertRoom(r.Context(), room)
+		if errors.Is(err, repository.ErrDuplicateSlug) {
+			form.Errors.Add("slug", "Another room already uses this slug")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		m.renderRoomForm(w, r, room, form)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room created")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminShowRoom shows the form that edits a room
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderRoomForm(w, r, room, forms.New(nil))
+}
+
+// AdminPostRoom saves the name, slug, details and amenities of a room
+func (m *Repository) AdminPostRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, form := roomFromForm(r, room)
+
+	if form.Valid() {
+		err = m.DB.UpdateRoom(r.Context(), room)
+		if errors.Is(err, repository.ErrDuplicateSlug) {
+			form.Errors.Add("slug", "Another room already uses this slug")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		m.renderRoomForm(w, r, room, form)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminPostRoomPhoto adds a photo to the end of a room's gallery
+func (m *Repository) AdminPostRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	backTo := fmt.Sprintf("/admin/rooms/%d", id)
+
+	path := strings.TrimSpace(r.Form.Get("path"))
+	if !strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "https://") && !strings.HasPrefix(path, "http://") {
+		m.App.Session.Put(r.Context(), "error", "Enter the photo's address, starting with / or http")
+		http.Redirect(w, r, backTo, http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.DB.AddRoomPhoto(r.Context(), models.RoomPhoto{
+		RoomID:  id,
+		Path:    path,
+		Caption: strings.TrimSpace(r.Form.Get("caption")),
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Photo added")
+	http.Redirect(w, r, backTo, http.StatusSeeOther)
+}
+
+// photoStore returns the store uploaded room photos are kept in
+func (m *Repository) photoStore() *photos.Store {
+	return photos.New(m.App.UploadDir, "/uploads/", m.App.MaxUploadSize)
+}
+
+// AdminUploadRoomPhoto stores an uploaded photo with its web-optimized copy and thumbnail,
+// and adds it to the end of a room's gallery
+func (m *Repository) AdminUploadRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	backTo := fmt.Sprintf("/admin/rooms/%d", id)
+
+	_, err := m.DB.GetRoomByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	store := m.photoStore()
+
+	// leave room for the other form fields
+	r.Body = http.MaxBytesReader(w, r.Body, store.MaxBytes+1<<20)
+	err = r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Choose a photo of up to %d MB to upload", store.MaxBytes>>20))
+		http.Redirect(w, r, backTo, http.StatusSeeOther)
+		return
+	}
+
+	file, _, err := r.FormFile("photo")
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Choose a photo to upload")
+		http.Redirect(w, r, backTo, http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+
+	saved, err := store.Save(file)
+	if errors.Is(err, photos.ErrTooLarge) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The photo is too large, the limit is %d MB", store.MaxBytes>>20))
+		http.Redirect(w, r, backTo, http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, photos.ErrUnsupportedType) {
+		m.App.Session.Put(r.Context(), "error", "Photos must be JPEG, PNG, GIF or WebP images")
+		http.Redirect(w, r, backTo, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	_, err = m.DB.AddRoomPhoto(r.Context(), models.RoomPhoto{
+		RoomID:        id,
+		Path:          saved.Web,
+		Caption:       strings.TrimSpace(r.Form.Get("caption")),
+		OriginalPath:  saved.Original,
+		ThumbnailPath: saved.Thumbnail,
+	})
+	if err != nil {
+		_ = store.Remove(saved.Original, saved.Web, saved.Thumbnail)
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Photo uploaded")
+	http.Redirect(w, r, backTo, http.StatusSeeOther)
+}
+
+// AdminDeleteRoomPhoto removes a photo from a room's gallery, with its files if it was uploaded
+func (m *Repository) AdminDeleteRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	photoID, _ := strconv.Atoi(chi.URLParam(r, "photoID"))
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var photo models.RoomPhoto
+	for _, p := range room.Photos {
+		if p.ID == photoID {
+			photo = p
+		}
+	}
+	if photo.ID == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = m.DB.DeleteRoomPhoto(r.Context(), id, photoID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the photo is already gone from the room, so a file that cannot be removed is only logged
+	err = m.photoStore().Remove(photo.OriginalPath, photo.Path, photo.ThumbnailPath)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Photo removed")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminMoveRoomPhoto moves a photo one place up or down a room's gallery.
+// The first photo is the one shown with the room in search results.
+func (m *Repository) AdminMoveRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	photoID, _ := strconv.Atoi(chi.URLParam(r, "photoID"))
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var ids []int
+	for _, photo := range room.Photos {
+		ids = append(ids, photo.ID)
+	}
+
+	for i := range ids {
+		if ids[i] != photoID {
+			continue
+		}
+		switch {
+		case chi.URLParam(r, "direction") == "up" && i > 0:
+			ids[i-1], ids[i] = ids[i], ids[i-1]
+		case chi.URLParam(r, "direction") == "down" && i < len(ids)-1:
+			ids[i+1], ids[i] = ids[i], ids[i+1]
+		}
+		break
+	}
+
+	err = m.DB.ReorderRoomPhotos(r.Context(), id, ids)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminSetRoomActive deactivates a room, hiding it from guests, or activates it again
+func (m *Repository) AdminSetRoomActive(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	active := r.Form.Get("active") == "1"
+
+	err = m.DB.SetRoomActive(r.Context(), id, active)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if active {
+		m.App.Session.Put(r.Context(), "flash", "Room activated")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Room deactivated, existing reservations are kept")
+	}
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminMoveRoom moves a room one place up or down the list
+func (m *Repository) AdminMoveRoom(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var ids []int
+	for _, room := range rooms {
+		ids = append(ids, room.ID)
+	}
+
+	for i := range ids {
+		if ids[i] != id {
+			continue
+		}
+		switch {
+		case chi.URLParam(r, "direction") == "up" && i > 0:
+			ids[i-1], ids[i] = ids[i], ids[i-1]
+		case chi.URLParam(r, "direction") == "down" && i < len(ids)-1:
+			ids[i+1], ids[i] = ids[i], ids[i+1]
+		}
+		break
+	}
+
+	err = m.DB.ReorderRooms(r.Context(), ids)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminRateRules lists the rate rules, the ones that win over others first
+func (m *Repository) AdminRateRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := m.DB.AllRateRules(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = rules
+
+	_ = render.Template(w, r, "admin-rate-rules.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// renderRateRuleForm shows the form that creates a rate rule, or edits it if it has an ID
+func (m *Repository) renderRateRuleForm(w http.ResponseWriter, r *http.Request, rule models.RateRule, form *forms.Form) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	policies, err := m.DB.AllCancellationPolicies(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rule"] = rule
+	data["rooms"] = rooms
+	data["weekdays"] = models.AllWeekdays
+	data["policies"] = policies
+
+	_ = render.Template(w, r, "admin-rate-rule.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// maxRateRulePriority is the highest priority a rate rule can be given
+const maxRateRulePriority = 100
+
+// rateRuleFromForm reads and checks the posted rate rule. Blank dates leave its date range open
+// and no days of the week makes it cover every day.
+func rateRuleFromForm(r *http.Request, rule models.RateRule) (models.RateRule, *forms.Form) {
+	rule.Name = strings.TrimSpace(r.Form.Get("name"))
+	rule.RoomID, _ = strconv.Atoi(r.Form.Get("room_id"))
+	rule.Priority, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get("priority")))
+	rule.CancellationPolicyID, _ = strconv.Atoi(r.Form.Get("cancellation_policy_id"))
+
+	var days []time.Weekday
+	for _, value := range r.Form["weekdays"] {
+		day, err := strconv.Atoi(value)
+		if err == nil {
+			days = append(days, time.Weekday(day))
+		}
+	}
+	rule.Weekdays = models.NewWeekdays(days...)
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "nightly_rate", "priority")
+	form.MinLength("name", 3)
+	if r.Form.Get("nightly_rate") != "" && form.IsMoney("nightly_rate") {
+		rule.NightlyRate, _ = models.ParseMoney(r.Form.Get("nightly_rate"))
+	}
+	if r.Form.Get("priority") != "" {
+		form.IsIntBetween("priority", 0, maxRateRulePriority)
+	}
+
+	rule.StartDate, rule.EndDate = time.Time{}, time.Time{}
+	if r.Form.Get("start_date") != "" && form.IsDate("start_date") {
+		rule.StartDate, _ = time.Parse("2006-01-02", r.Form.Get("start_date"))
+	}
+	if r.Form.Get("end_date") != "" && form.IsDate("end_date") {
+		rule.EndDate, _ = time.Parse("2006-01-02", r.Form.Get("end_date"))
+	}
+	if !rule.StartDate.IsZero() && !rule.EndDate.IsZero() {
+		form.DateNotBefore("end_date", "start_date")
+	}
+
+	return rule, form
+}
+
+// AdminNewRateRule shows the form that creates a rate rule
+func (m *Repository) AdminNewRateRule(w http.ResponseWriter, r *http.Request) {
+	m.renderRateRuleForm(w, r, models.RateRule{}, forms.New(nil))
+}
+
+// AdminPostNewRateRule creates a rate rule
+func (m *Repository) AdminPostNewRateRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rule, form := rateRuleFromForm(r, models.RateRule{})
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		m.renderRateRuleForm(w, r, rule, form)
+		return
+	}
+
+	_, err = m.DB.InsertRateRule(r.Context(), rule)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rate rule created")
+	http.Redirect(w, r, "/admin/rate-rules", http.StatusSeeOther)
+}
+
+// AdminShowRateRule shows the form that edits a rate rule
+func (m *Repository) AdminShowRateRule(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	rule, err := m.DB.GetRateRuleByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderRateRuleForm(w, r, rule, forms.New(nil))
+}
+
+// AdminPostRateRule saves a rate rule. Reservations already made keep their price.
+func (m *Repository) AdminPostRateRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	rule, err := m.DB.GetRateRuleByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rule, form := rateRuleFromForm(r, rule)
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		m.renderRateRuleForm(w, r, rule, form)
+		return
+	}
+
+	err = m.DB.UpdateRateRule(r.Context(), rule)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rate rule saved")
+	http.Redirect(w, r, "/admin/rate-rules", http.StatusSeeOther)
+}
+
+// AdminDeleteRateRule deletes a rate rule
+func (m *Repository) AdminDeleteRateRule(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeleteRateRule(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rate rule deleted")
+	http.Redirect(w, r, "/admin/rate-rules", http.StatusSeeOther)
+}
+
+// AdminPromoCodes lists the promo codes in alphabetical order
+func (m *Repository) AdminPromoCodes(w http.ResponseWriter, r *http.Request) {
+	codes, err := m.DB.AllPromoCodes(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["codes"] = codes
+	data["rooms"] = rooms
+
+	_ = render.Template(w, r, "admin-promo-codes.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// renderPromoCodeForm shows the form that creates a promo code, or edits it if it has an ID
+func (m *Repository) renderPromoCodeForm(w http.ResponseWriter, r *http.Request, promo models.PromoCode, form *forms.Form) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["promo"] = promo
+	data["rooms"] = rooms
+	data["kinds"] = models.PromoKinds
+
+	_ = render.Template(w, r, "admin-promo-code.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// limits of the numbers admins can give a promo code
+const (
+	maxPromoCodeUses      = 1000000
+	maxPromoCodeMinNights = 365
+)
+
+// promoCodeFromForm reads and checks the posted promo code. Blank dates leave its validity window open,
+// blank limits mean none and no rooms ticked makes it apply to every room.
+func promoCodeFromForm(r *http.Request, promo models.PromoCode) (models.PromoCode, *forms.Form) {
+	promo.Code = models.NormalizePromoCode(r.Form.Get("code"))
+	promo.Description = strings.TrimSpace(r.Form.Get("description"))
+	promo.Kind = models.PromoKind(r.Form.Get("kind"))
+
+	promo.RoomIDs = nil
+	for _, value := range r.Form["room_ids"] {
+		id, err := strconv.Atoi(value)
+		if err == nil {
+			promo.RoomIDs = append(promo.RoomIDs, id)
+		}
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code", "kind")
+	if r.Form.Get("code") != "" {
+		form.IsPromoCode("code")
+	}
+	if !promo.Kind.Valid() {
+		form.Errors.Add("kind", "Choose how the code discounts a stay")
+	}
+
+	promo.PercentOff, promo.AmountOff = 0, 0
+	switch promo.Kind {
+	case models.PromoPercent:
+		form.Required("percent_off")
+		if r.Form.Get("percent_off") != "" && form.IsIntBetween("percent_off", 1, 100) {
+			promo.PercentOff, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get("percent_off")))
+		}
+	case models.PromoFixed:
+		form.Required("amount_off")
+		if r.Form.Get("amount_off") != "" && form.IsMoney("amount_off") {
+			promo.AmountOff, _ = models.ParseMoney(r.Form.Get("amount_off"))
+		}
+	}
+
+	promo.MaxUses, promo.MinNights = 0, 0
+	if r.Form.Get("max_uses") != "" && form.IsIntBetween("max_uses", 0, maxPromoCodeUses) {
+		promo.MaxUses, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get("max_uses")))
+	}
+	if r.Form.Get("min_nights") != "" && form.IsIntBetween("min_nights", 0, maxPromoCodeMinNights) {
+		promo.MinNights, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get("min_nights")))
+	}
+
+	promo.ValidFrom, promo.ValidUntil = time.Time{}, time.Time{}
+	if r.Form.Get("valid_from") != "" && form.IsDate("valid_from") {
+		promo.ValidFrom, _ = time.Parse("2006-01-02", r.Form.Get("valid_from"))
+	}
+	if r.Form.Get("valid_until") != "" && form.IsDate("valid_until") {
+		promo.ValidUntil, _ = time.Parse("2006-01-02", r.Form.Get("valid_until"))
+	}
+	if !promo.ValidFrom.IsZero() && !promo.ValidUntil.IsZero() {
+		form.DateNotBefore("valid_until", "valid_from")
+	}
+
+	return promo, form
+}
+
+// AdminNewPromoCode shows the form that creates a promo code
+func (m *Repository) AdminNewPromoCode(w http.ResponseWriter, r *http.Request) {
+	m.renderPromoCodeForm(w, r, models.PromoCode{Kind: models.PromoPercent}, forms.New(nil))
+}
+
+// AdminPostNewPromoCode creates a promo code
+func (m *Repository) AdminPostNewPromoCode(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	promo, form := promoCodeFromForm(r, models.PromoCode{})
+
+	if form.Valid() {
+		_, err = m.DB.InsertPromoCode(r.Context(), promo)
+		if errors.Is(err, repository.ErrDuplicatePromoCode) {
+			form.Errors.Add("code", "Another promo code already uses this code")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		m.renderPromoCodeForm(w, r, promo, form)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code created")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// AdminShowPromoCode shows the form that edits a promo code
+func (m *Repository) AdminShowPromoCode(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	promo, err := m.DB.GetPromoCodeByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderPromoCodeForm(w, r, promo, forms.New(nil))
+}
+
+// AdminPostPromoCode saves a promo code. Reservations already made keep their discount.
+func (m *Repository) AdminPostPromoCode(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	promo, err := m.DB.GetPromoCodeByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	promo, form := promoCodeFromForm(r, promo)
+
+	if form.Valid() {
+		err = m.DB.UpdatePromoCode(r.Context(), promo)
+		if errors.Is(err, repository.ErrDuplicatePromoCode) {
+			form.Errors.Add("code", "Another promo code already uses this code")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		m.renderPromoCodeForm(w, r, promo, form)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code saved")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// AdminDeletePromoCode deletes a promo code. Reservations made with it keep their discount.
+func (m *Repository) AdminDeletePromoCode(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeletePromoCode(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code deleted")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// AdminCharges lists the taxes and fees added to reservation totals
+func (m *Repository) AdminCharges(w http.ResponseWriter, r *http.Request) {
+	charges, err := m.DB.AllCharges(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["charges"] = charges
+
+	_ = render.Template(w, r, "admin-charges.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// renderChargeForm shows the form that creates a tax or fee, or edits it if it has an ID
+func (m *Repository) renderChargeForm(w http.ResponseWriter, r *http.Request, charge models.Charge, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["charge"] = charge
+	data["methods"] = models.ChargeMethods
+	data["bases"] = models.ChargeBases
+
+	_ = render.Template(w, r, "admin-charge.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// chargeFromForm reads and checks the posted tax or fee. Percentages are of the room price after
+// discounts and are charged once per stay, flat amounts per stay, per night or per guest per night.
+func chargeFromForm(r *http.Request, charge models.Charge) (models.Charge, *forms.Form) {
+	charge.Name = strings.TrimSpace(r.Form.Get("name"))
+	charge.Method = models.ChargeMethod(r.Form.Get("method"))
+	charge.Per = models.ChargeBasis(r.Form.Get("per"))
+	charge.Active = r.Form.Get("active") != ""
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "method")
+	if !charge.Method.Valid() {
+		form.Errors.Add("method", "Choose how the charge is worked out")
+	}
+
+	charge.Rate, charge.Amount = 0, 0
+	switch charge.Method {
+	case models.ChargePercent:
+		charge.Per = models.PerStay
+		form.Required("rate")
+		if r.Form.Get("rate") != "" && form.IsPercentage("rate") {
+			charge.Rate, _ = models.ParsePercentage(r.Form.Get("rate"))
+		}
+	case models.ChargeFlat:
+		if !charge.Per.Valid() {
+			form.Errors.Add("per", "Choose what the amount is charged for")
+		}
+		form.Required("amount")
+		if r.Form.Get("amount") != "" && form.IsMoney("amount") {
+			charge.Amount, _ = models.ParseMoney(r.Form.Get("amount"))
+		}
+	}
+
+	return charge, form
+}
+
+// AdminNewCharge shows the form that creates a tax or fee
+func (m *Repository) AdminNewCharge(w http.ResponseWriter, r *http.Request) {
+	charge := models.Charge{Method: models.ChargePercent, Per: models.PerStay, Active: true}
+	m.renderChargeForm(w, r, charge, forms.New(nil))
+}
+
+// AdminPostNewCharge creates a tax or fee
+func (m *Repository) AdminPostNewCharge(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	charge, form := chargeFromForm(r, models.Charge{})
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		m.renderChargeForm(w, r, charge, form)
+		return
+	}
+
+	_, err = m.DB.InsertCharge(r.Context(), charge)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Charge created")
+	http.Redirect(w, r, "/admin/charges", http.StatusSeeOther)
+}
+
+// AdminShowCharge shows the form that edits a tax or fee
+func (m *Repository) AdminShowCharge(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	charge, err := m.DB.GetChargeByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderChargeForm(w, r, charge, forms.New(nil))
+}
+
+// AdminPostCharge saves a tax or fee. Reservations already made keep the charges they were quoted.
+func (m *Repository) AdminPostCharge(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	charge, err := m.DB.GetChargeByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	charge, form := chargeFromForm(r, charge)
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		m.renderChargeForm(w, r, charge, form)
+		return
+	}
+
+	err = m.DB.UpdateCharge(r.Context(), charge)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Charge saved")
+	http.Redirect(w, r, "/admin/charges", http.StatusSeeOther)
+}
+
+// AdminDeleteCharge deletes a tax or fee. Reservations already made keep the charges they were quoted.
+func (m *Repository) AdminDeleteCharge(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeleteCharge(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Charge deleted")
+	http.Redirect(w, r, "/admin/charges", http.StatusSeeOther)
+}
+
+// AdminCancellationPolicies lists the cancellation policies rooms and rates can be booked under
+func (m *Repository) AdminCancellationPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := m.DB.AllCancellationPolicies(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["policies"] = policies
+
+	_ = render.Template(w, r, "admin-cancellation-policies.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// renderCancellationPolicyForm shows the form that creates a cancellation policy, or edits it if it has an ID
+func (m *Repository) renderCancellationPolicyForm(w http.ResponseWriter, r *http.Request, policy models.CancellationPolicy, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["policy"] = policy
+
+	_ = render.Template(w, r, "admin-cancellation-policy.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// cancellationPolicyFromForm reads and checks the posted cancellation policy. No free days means
+// the penalty applies however early the guest cancels.
+func cancellationPolicyFromForm(r *http.Request, policy models.CancellationPolicy) (models.CancellationPolicy, *forms.Form) {
+	policy.Name = strings.TrimSpace(r.Form.Get("name"))
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "free_days", "penalty")
+
+	policy.FreeDays, policy.Penalty = 0, 0
+	if r.Form.Get("free_days") != "" && form.IsIntBetween("free_days", 0, 365) {
+		policy.FreeDays, _ = strconv.Atoi(r.Form.Get("free_days"))
+	}
+	if r.Form.Get("penalty") != "" && form.IsPercentage("penalty") {
+		policy.Penalty, _ = models.ParsePercentage(r.Form.Get("penalty"))
+	}
+
+	return policy, form
+}
+
+// AdminNewCancellationPolicy shows the form that creates a cancellation policy
+func (m *Repository) AdminNewCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	policy := models.CancellationPolicy{FreeDays: 7, Penalty: models.FullPenalty}
+	m.renderCancellationPolicyForm(w, r, policy, forms.New(nil))
+}
+
+// AdminPostNewCancellationPolicy creates a cancellation policy
+func (m *Repository) AdminPostNewCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	policy, form := cancellationPolicyFromForm(r, models.CancellationPolicy{})
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		m.renderCancellationPolicyForm(w, r, policy, form)
+		return
+	}
+
+	_, err = m.DB.InsertCancellationPolicy(r.Context(), policy)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Cancellation policy created")
+	http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// AdminShowCancellationPolicy shows the form that edits a cancellation policy
+func (m *Repository) AdminShowCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	policy, err := m.DB.GetCancellationPolicyByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderCancellationPolicyForm(w, r, policy, forms.New(nil))
+}
+
+// AdminPostCancellationPolicy saves a cancellation policy. Reservations already made keep the terms
+// they were booked under.
+func (m *Repository) AdminPostCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	policy, err := m.DB.GetCancellationPolicyByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	policy, form := cancellationPolicyFromForm(r, policy)
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		m.renderCancellationPolicyForm(w, r, policy, form)
+		return
+	}
+
+	err = m.DB.UpdateCancellationPolicy(r.Context(), policy)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Cancellation policy saved")
+	http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// AdminDeleteCancellationPolicy deletes a cancellation policy. Rooms and rates that used it are
+// cancelled for free from then on, reservations already made keep the terms they were booked under.
+func (m *Repository) AdminDeleteCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeleteCancellationPolicy(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Cancellation policy deleted")
+	http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// AdminCreateReservation shows the form admins use to record a phone or walk-in reservation
+func (m *Repository) AdminCreateReservation(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(url.Values{
+		"source":            {string(models.SourcePhone)},
+		"guests":            {"1"},
+		"send_confirmation": {"1"},
+	})
+	m.renderCreateReservation(w, r, form)
+}
+
+// renderCreateReservation shows the admin reservation form with the rooms to choose from
+func (m *Repository) renderCreateReservation(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["sources"] = models.AdminReservationSources
+
+	_ = render.Template(w, r, "admin-create-reservation.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostCreateReservation records a reservation taken by phone or at the desk,
+// if the room is free, and emails the guest a confirmation when asked to
+func (m *Repository) AdminPostCreateReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("source", "room_id", "start_date", "end_date", "first_name", "last_name")
+	form.MinLength("first_name", 3)
+	if form.IsDate("start_date") && form.IsDate("end_date") {
+		form.DateAfter("end_date", "start_date")
+	}
+	// walk-in guests may not give an email address, but a confirmation needs one
+	sendConfirmation := r.Form.Get("send_confirmation") != ""
+	if sendConfirmation {
+		form.Required("email")
+	}
+	if r.Form.Get("email") != "" {
+		form.IsEmail("email")
+	}
+
+	source := models.ReservationSource(r.Form.Get("source"))
+	if source != "" && (!source.Valid() || source == models.SourceOnline) {
+		form.Errors.Add("source", "Please choose how the reservation was made")
+	}
+
+	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+	startDate, _ := time.Parse("2006-01-02", r.Form.Get("start_date"))
+	endDate, _ := time.Parse("2006-01-02", r.Form.Get("end_date"))
+
+	// the number of guests is checked against the room once it is known to exist
+	guests := 1
+	if r.Form.Get("guests") != "" && form.IsIntBetween("guests", 1, 99) {
+		guests, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get("guests")))
+	}
+
+	reservation := models.Reservation{
+		FirstName: r.Form.Get("first_name"),
+		LastName:  r.Form.Get("last_name"),
+		Email:     r.Form.Get("email"),
+		Phone:     r.Form.Get("phone"),
+		StartDate: startDate,
+		EndDate:   endDate,
+		RoomID:    roomID,
+		Source:    source,
+		Guests:    guests,
+	}
+
+	if form.Valid() {
+		room, quote, err := m.priceStay(r.Context(), roomID, startDate, endDate, guests)
+		if errors.Is(err, sql.ErrNoRows) {
+			form.Errors.Add("room_id", "Please choose a room")
+			w.WriteHeader(http.StatusSeeOther)
+			m.renderCreateReservation(w, r, form)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		reservation.TotalPrice = quote.Total
+		reservation.Charges = quote.ReservationCharges()
+
+		reservation.CancellationPolicy, err = m.cancellationPolicy(r.Context(), quote)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		if guests > room.MaxOccupancy {
+			form.Errors.Add("guests", fmt.Sprintf("%s sleeps %d at most", room.RoomName, room.MaxOccupancy))
+		} else {
+			reservation.ID, err = m.DB.InsertReservationWithRestriction(r.Context(), reservation)
+		}
+		if errors.Is(err, repository.ErrRoomNotAvailable) {
+			form.Errors.Add("start_date", "The room is not available for these dates")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusSeeOther)
+		m.renderCreateReservation(w, r, form)
+		return
+	}
+
+	if sendConfirmation {
+		// the confirmation code is generated by the repository
+		saved, err := m.DB.GetReservationByID(r.Context(), reservation.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.sendConfirmationMail(saved)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation created")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/all/%d/show", reservation.ID), http.StatusSeeOther)
+}
+
+// AdminChangeReservationStay moves a reservation to other dates or another room,
+// if the room is free for the new dates
+func (m *Repository) AdminChangeReservationStay(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	src := chi.URLParam(r, "src")
+	showURL := fmt.Sprintf("/admin/reservations/%s/%d/show", src, id)
+
+	year := r.Form.Get("year")
+	month := r.Form.Get("month")
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "start_date", "end_date")
+	if form.IsDate("start_date") && form.IsDate("end_date") {
+		form.DateAfter("end_date", "start_date")
+	}
+	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
+	if !form.Valid() || err != nil {
+		m.App.Session.Put(r.Context(), "error", "Please choose a room and an arrival before the departure")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+
+	startDate, _ := time.Parse("2006-01-02", r.Form.Get("start_date"))
+	endDate, _ := time.Parse("2006-01-02", r.Form.Get("end_date"))
 
-// AdminShowReservation shows a reservation in admin
-func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+	res, err := m.DB.GetReservationByID(r.Context(), id)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
-	exploded := strings.Split(r.URL.String(), "/")
 
-	id, err := strconv.Atoi(exploded[4])
+	// the new stay is quoted at today's rates and taxes, less the discount given when it was booked
+	room, quote, err := m.priceStay(r.Context(), roomID, startDate, endDate, res.Guests)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	src := exploded[3]
+	if roomID != res.RoomID {
+		msg := ""
+		if !room.Active {
+			msg = fmt.Sprintf("%s is not open for booking", room.RoomName)
+		} else if res.Guests > room.MaxOccupancy {
+			msg = fmt.Sprintf("%s sleeps %d at most", room.RoomName, room.MaxOccupancy)
+		}
+		if msg != "" {
+			m.App.Session.Put(r.Context(), "error", msg)
+			http.Redirect(w, r, showURL, http.StatusSeeOther)
+			return
+		}
+	}
 
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
+	if res.PromoCode != "" {
+		// a deleted code leaves the discount with the reservation, as it does everywhere else
+		promo, err := m.DB.GetPromoCodeByCode(r.Context(), res.PromoCode)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, err)
+			return
+		}
+		if err == nil && len(promo.RoomIDs) > 0 && !promo.AppliesToRoom(roomID) {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The promo code %s does not apply to %s", promo.Code, room.RoomName))
+			http.Redirect(w, r, showURL, http.StatusSeeOther)
+			return
+		}
+		if err == nil && models.Nights(startDate, endDate) < promo.MinNights {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The promo code %s needs a stay of at least %d nights", promo.Code, promo.MinNights))
+			http.Redirect(w, r, showURL, http.StatusSeeOther)
+			return
+		}
+	}
+	quote.ApplyDiscount(res.Discount)
 
-	reservation, err := m.DB.GetReservationByID(id)
-	if err != nil {
-		helpers.ServerError(w, err)
+	err = m.DB.ChangeReservationStay(r.Context(), id, roomID, startDate, endDate, quote.Total, quote.ReservationCharges())
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "The room is not available for these dates")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, repository.ErrReservationClosed) {
+		m.App.Session.Put(r.Context(), "error", "The reservation can no longer be changed")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
 		return
 	}
-
-	reservation.FirstName = r.Form.Get("first_name")
-	reservation.LastName = r.Form.Get("last_name")
-	reservation.Email = r.Form.Get("email")
-	reservation.Phone = r.Form.Get("phone")
-
-	err = m.DB.UpdateReservation(reservation)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	month := r.Form.Get("month")
-	year := r.Form.Get("year")
+	m.App.Session.Put(r.Context(), "flash", "Stay changed")
+
+	// the guest's card cannot be charged again from here, so a new total is left for the staff to settle
+	if quote.Total != res.TotalPrice {
+		reservationPayments, err := m.DB.PaymentsForReservation(r.Context(), id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		held := paymentsHeld(reservationPayments)
+		if held > 0 && held != quote.Total {
+			m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("The new total is %s, but the guest's payments hold %s. Please settle the difference.", quote.Total, held))
+		}
+	}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved")
 	if year == "" {
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
 	} else {
@@ -579,171 +3282,246 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	}
 }
 
-// AdminReservationsCalendar shows reservations calendar
-func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	// assume that there is no month/year specified
-	now := time.Now()
-	if r.URL.Query().Get("y") != "" {
-		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
-		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
-		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	}
-
-	data := make(map[string]interface{})
-	data["now"] = now
+// reservationPayment finds the payment named in the URL of an admin payment action, together with the
+// address of the reservation page to go back to. It answers with a not found page and returns false
+// if the reservation has no such payment.
+func (m *Repository) reservationPayment(w http.ResponseWriter, r *http.Request) (models.Payment, string, bool) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	paymentID, _ := strconv.Atoi(chi.URLParam(r, "paymentID"))
+	showURL := fmt.Sprintf("/admin/reservations/%s/%d/show", chi.URLParam(r, "src"), id)
 
-	next := now.AddDate(0, 1, 0)
-	last := now.AddDate(0, -1, 0)
+	reservationPayments, err := m.DB.PaymentsForReservation(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return models.Payment{}, showURL, false
+	}
 
-	nextMonth := next.Format("01")
-	nextMonthYear := next.Format("2006")
+	for _, p := range reservationPayments {
+		if p.ID == paymentID {
+			return p, showURL, true
+		}
+	}
 
-	lastMonth := last.Format("01")
-	lastMonthYear := last.Format("2006")
+	http.NotFound(w, r)
+	return models.Payment{}, showURL, false
+}
 
-	stringMap := make(map[string]string)
-	stringMap["next_month"] = nextMonth
-	stringMap["next_month_year"] = nextMonthYear
-	stringMap["last_month"] = lastMonth
-	stringMap["last_month_year"] = lastMonthYear
+// AdminCapturePayment takes the money an authorized payment holds on the guest's card
+func (m *Repository) AdminCapturePayment(w http.ResponseWriter, r *http.Request) {
+	payment, showURL, ok := m.reservationPayment(w, r)
+	if !ok {
+		return
+	}
+	if !payment.Capturable() {
+		m.App.Session.Put(r.Context(), "error", "Only authorized payments can be captured")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
 
-	stringMap["this_month"] = now.Format("01")
-	stringMap["this_month_year"] = now.Format("2006")
+	err := m.App.Payments.Capture(r.Context(), payment.Reference, payment.Amount)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "The payment provider did not capture the payment: "+err.Error())
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
 
-	// get the first & last days of the month
-	currentYear, currentMonth, _ := now.Date()
-	currentLocation := now.Location()
-	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+	payment.Status = models.PaymentCaptured
+	err = m.DB.UpdatePayment(r.Context(), payment)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	intMap := make(map[string]int)
-	intMap["days_in_month"] = lastOfMonth.Day()
+	m.App.Session.Put(r.Context(), "flash", "Payment captured")
+	http.Redirect(w, r, showURL, http.StatusSeeOther)
+}
 
-	rooms, err := m.DB.AllRooms()
+// AdminRefundPayment gives the guest back the posted amount of a captured payment, everything that is left
+// when no amount is posted. Authorized payments are released in full.
+func (m *Repository) AdminRefundPayment(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	data["rooms"] = rooms
+	payment, showURL, ok := m.reservationPayment(w, r)
+	if !ok {
+		return
+	}
 
-	for _, x := range rooms {
-		// create map
-		reservationMap := make(map[string]int)
-		blockMap := make(map[string]int)
+	refundable := payment.Refundable()
+	if refundable == 0 {
+		m.App.Session.Put(r.Context(), "error", "There is nothing left to refund on this payment")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
 
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			reservationMap[d.Format("2006-01-02")] = 0
-			blockMap[d.Format("2006-01-02")] = 0
+	amount := refundable
+	if payment.Status == models.PaymentCaptured && r.Form.Get("amount") != "" {
+		form := forms.New(r.PostForm)
+		if form.IsMoney("amount") {
+			amount, _ = models.ParseMoney(r.Form.Get("amount"))
 		}
-
-		// get all the restrictions for the current rooms
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
-		if err != nil {
-			helpers.ServerError(w, err)
+		if !form.Valid() || amount <= 0 || amount > refundable {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Enter an amount to refund of up to %s", refundable))
+			http.Redirect(w, r, showURL, http.StatusSeeOther)
 			return
 		}
+	}
 
-		for _, y := range restrictions {
-			if y.ReservationID > 0 {
-				// it's a reservation
-				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
-					reservationMap[d.Format("2006-01-02")] = y.ReservationID
-				}
-			} else {
-				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
-					blockMap[d.Format("2006-01-02")] = y.ID
-				}
-			}
-		}
-		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
-		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+	err = m.App.Payments.Refund(r.Context(), payment.Reference, amount)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "The payment provider did not refund the payment: "+err.Error())
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
 
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+	payment.Refunded += amount
+	if payment.Refunded == payment.Amount {
+		payment.Status = models.PaymentRefunded
+	}
+	err = m.DB.UpdatePayment(r.Context(), payment)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
-		StringMap: stringMap,
-		Data:      data,
-		IntMap:    intMap,
-	})
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Refunded %s", amount))
+	http.Redirect(w, r, showURL, http.StatusSeeOther)
 }
 
-// AdminPostReservationsCalendar handles post of reservations calendar
-func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+// AdminIssueInvoice issues the invoice of a closed reservation that has none, like a no-show or one
+// closed before invoices were issued at checkout. A reservation has one invoice.
+func (m *Repository) AdminIssueInvoice(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	src := chi.URLParam(r, "src")
+	showURL := fmt.Sprintf("/admin/reservations/%s/%d/show", src, id)
+
+	reservation, err := m.DB.GetReservationByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	year, _ := strconv.Atoi(r.Form.Get("year"))
-	month, _ := strconv.Atoi(r.Form.Get("month"))
+	inv, err := m.issueInvoice(r.Context(), reservation)
+	if errors.Is(err, models.ErrReservationOpen) {
+		m.App.Session.Put(r.Context(), "error", "Invoices are issued once the reservation is checked out or cancelled")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, models.ErrNothingToInvoice) {
+		m.App.Session.Put(r.Context(), "error", "This reservation has nothing to invoice")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	// process blocks
-	rooms, err := m.DB.AllRooms()
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Invoice %s issued", inv.Code()))
+	http.Redirect(w, r, showURL, http.StatusSeeOther)
+}
+
+// AdminInvoices lists every invoice, the last issued first
+func (m *Repository) AdminInvoices(w http.ResponseWriter, r *http.Request) {
+	issued, err := m.DB.AllInvoices(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	form := forms.New(r.PostForm)
+	data := make(map[string]interface{})
+	data["invoices"] = issued
 
-	for _, room := range rooms {
-		// get the block map from the session.
-		// Loop through the entire map, if we have an entry in the map,
-		// that does not exist in our posted data, and if the restriction id > 0,
-		// then it's a block we need to remove
-		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", room.ID)).(map[string]int)
-		for name, value := range curMap {
-			// ok will be false if the value is not in the map
-			if val, ok := curMap[name]; ok {
-				// only pay attention to values > 0, and that are not in the form post
-				// the rest are just placeholders for days without blocks
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", room.ID, name)) {
-						// delete the restriction by ID
-						//log.Println("Would delete block ", value)
-						err := m.DB.DeleteBlockByID(value)
-						if err != nil {
-							log.Println(err)
-						}
-					}
-				}
-			}
-		}
+	_ = render.Template(w, r, "admin-invoices.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminInvoicePDF downloads an invoice as a PDF document
+func (m *Repository) AdminInvoicePDF(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	inv, err := m.DB.GetInvoiceByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	// handle for new blocks
-	for name, _ := range r.PostForm {
-		if strings.HasPrefix(name, "add_block") {
-			exploded := strings.Split(name, "_")
-			roomID, _ := strconv.Atoi(exploded[2])
-			date, _ := time.Parse("2006-01-2", exploded[3])
-			// insert a new block
-			//log.Println("Would insert block for room id ", roomID, ", for date ", exploded[3])
-			err := m.DB.InsertBlockForRoom(roomID, date)
-			if err != nil {
-				log.Println(err)
-			}
-		}
+	// the document is made before anything is written, so an error can still be reported
+	var buf bytes.Buffer
+	err = invoices.Write(&buf, m.App.InvoiceIssuer, inv)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved!")
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", inv.FileName()))
+	_, _ = w.Write(buf.Bytes())
 }
 
-// AdminProcessReservation marks a reservation as processed
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+// AdminCancelReservation cancels a reservation on behalf of the guest and frees its room
+func (m *Repository) AdminCancelReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
 
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
+	year := r.Form.Get("year")
+	month := r.Form.Get("month")
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	reason := strings.TrimSpace(r.Form.Get("reason"))
+	if reason == "" {
+		m.App.Session.Put(r.Context(), "error", "Please give a reason for the cancellation")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
+		return
+	}
+
+	reservation, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var fee models.Money
+	if r.Form.Get("waive_fee") == "" {
+		fee = pricing.CancellationFee(reservation, time.Now())
+	}
+
+	err = m.DB.CancelReservation(r.Context(), id, m.App.Session.GetInt(r.Context(), "user_id"), reason, fee)
+	if errors.Is(err, repository.ErrInvalidStatusTransition) {
+		m.App.Session.Put(r.Context(), "error", "The reservation can no longer be cancelled")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	refunded, err := m.settlePayments(r.Context(), id, fee)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Reservation cancelled, but the payment provider did not settle its payments: "+err.Error())
+	}
+
+	m.sendCancellationMail(reservation, reason, fee, refunded, m.closingInvoice(r.Context(), id))
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation cancelled with a fee of %s, %s refunded", fee, refunded))
 
 	if year == "" {
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
@@ -752,12 +3530,23 @@ func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Requ
 	}
 }
 
-// AdminDeleteReservation deletes a reservation
+// AdminDeleteReservation deletes a reservation, unless payments were taken for it
 func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
 
-	_ = m.DB.DeleteReservation(id)
+	// reservations that took money are cancelled instead, which settles their payments and keeps
+	// the record of them
+	err := m.DB.DeleteReservation(r.Context(), id)
+	if errors.Is(err, repository.ErrReservationHasPayments) {
+		m.App.Session.Put(r.Context(), "error", "This reservation has payments and cannot be deleted. Cancel it instead to settle them.")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
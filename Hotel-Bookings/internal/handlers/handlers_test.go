@@ -1,17 +1,27 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/payments"
 )
 
 type postData struct {
@@ -27,8 +37,10 @@ var theTests = []struct {
 }{
 	{"home", "/", "GET", http.StatusOK},
 	{"about", "/about", "GET", http.StatusOK},
-	{"generals-quarters", "/generals-quarters", "GET", http.StatusOK},
-	{"majors-suite", "/majors-suite", "GET", http.StatusOK},
+	{"generals-quarters", "/rooms/generals-quarters", "GET", http.StatusOK},
+	{"majors-suite", "/rooms/majors-suite", "GET", http.StatusOK},
+	{"old generals-quarters", "/generals-quarters", "GET", http.StatusOK},
+	{"non-existent room", "/rooms/no-such-room", "GET", http.StatusNotFound},
 	{"search-availability", "/search-availability", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
 	{"login", "/user/login", "GET", http.StatusOK},
@@ -36,11 +48,41 @@ var theTests = []struct {
 	{"dashboard", "/admin/dashboard", "GET", http.StatusOK},
 	{"new reservations", "/admin/reservations-new", "GET", http.StatusOK},
 	{"all reservations", "/admin/reservations-all", "GET", http.StatusOK},
+	{"all reservations filtered", "/admin/reservations-all?page=2&size=5&sort=last_name&dir=desc&room=1&from=2050-01-01&to=2050-02-01&status=new&q=smith", "GET", http.StatusOK},
+	{"cancel reservation", "/cancel-reservation", "GET", http.StatusOK},
+	{"manage booking", "/manage-booking", "GET", http.StatusOK},
+	{"search", "/admin/search", "GET", http.StatusOK},
+	{"create reservation", "/admin/create-reservation", "GET", http.StatusOK},
+	{"rooms", "/admin/rooms", "GET", http.StatusOK},
+	{"new room", "/admin/rooms/new", "GET", http.StatusOK},
+	{"show room", "/admin/rooms/1", "GET", http.StatusOK},
+	{"non-existent admin room", "/admin/rooms/99", "GET", http.StatusNotFound},
+	{"rate rules", "/admin/rate-rules", "GET", http.StatusOK},
+	{"new rate rule", "/admin/rate-rules/new", "GET", http.StatusOK},
+	{"show rate rule", "/admin/rate-rules/1", "GET", http.StatusOK},
+	{"non-existent rate rule", "/admin/rate-rules/99", "GET", http.StatusNotFound},
+	{"promo codes", "/admin/promo-codes", "GET", http.StatusOK},
+	{"new promo code", "/admin/promo-codes/new", "GET", http.StatusOK},
+	{"show promo code", "/admin/promo-codes/1", "GET", http.StatusOK},
+	{"non-existent promo code", "/admin/promo-codes/99", "GET", http.StatusNotFound},
+	{"charges", "/admin/charges", "GET", http.StatusOK},
+	{"new charge", "/admin/charges/new", "GET", http.StatusOK},
+	{"show charge", "/admin/charges/1", "GET", http.StatusOK},
+	{"non-existent charge", "/admin/charges/99", "GET", http.StatusNotFound},
+	{"cancellation policies", "/admin/cancellation-policies", "GET", http.StatusOK},
+	{"new cancellation policy", "/admin/cancellation-policies/new", "GET", http.StatusOK},
+	{"show cancellation policy", "/admin/cancellation-policies/1", "GET", http.StatusOK},
+	{"non-existent cancellation policy", "/admin/cancellation-policies/99", "GET", http.StatusNotFound},
+	{"invoices", "/admin/invoices", "GET", http.StatusOK},
+	{"non-existent invoice", "/admin/invoices/99/pdf", "GET", http.StatusNotFound},
+	{"search with term", "/admin/search?q=smith", "GET", http.StatusOK},
+	{"all reservations bad filters", "/admin/reservations-all?page=x&size=-1&sort=nope&room=x&from=x", "GET", http.StatusOK},
+	{"all reservations huge page", "/admin/reservations-all?page=9000000000000000000", "GET", http.StatusOK},
 	{"show reservations", "/admin/reservations/new/1/show", "GET", http.StatusOK},
 	{"non-existent", "/non/existent", "GET", http.StatusNotFound},
 	{"show reservations calendar", "/admin/reservations-calendar", "GET", http.StatusOK},
-	{"process reservation calendar", "/admin/process-reservation/calendar/1/do", "GET", http.StatusOK},
-	{"process reservation calendar with year", "/admin/process-reservation/calendar/1/do?y=2024&m=01", "GET", http.StatusOK},
+	{"confirm reservation calendar", "/admin/reservation-status/calendar/1/confirmed/do", "GET", http.StatusOK},
+	{"check in reservation calendar with year", "/admin/reservation-status/calendar/1/checked_in/do?y=2024&m=01", "GET", http.StatusOK},
 	{"delete reservation calendar", "/admin/delete-reservation/calendar/1/do", "GET", http.StatusOK},
 	{"delete reservation calendar with year", "/admin/delete-reservation/calendar/1/do?y=2024&m=01", "GET", http.StatusOK},
 	{"favicon", "/favicon.ico", "GET", http.StatusOK},
@@ -62,6 +104,13 @@ var theTests = []struct {
 }
 
 func TestHandlers(t *testing.T) {
+	resetDB()
+	bookRoom(t, 1, "2050-01-01", "2050-01-02")
+	_, _ = testDB.InsertRateRule(context.Background(), models.RateRule{Name: "Weekends", NightlyRate: 20000, Weekdays: models.NewWeekdays(time.Saturday)})
+	_, _ = testDB.InsertPromoCode(context.Background(), models.PromoCode{Code: "WELCOME", Kind: models.PromoPercent, PercentOff: 10, RoomIDs: []int{1}})
+	_, _ = testDB.InsertCharge(context.Background(), models.Charge{Name: "VAT", Method: models.ChargePercent, Rate: 750, Per: models.PerStay, Active: true})
+	_, _ = testDB.InsertCancellationPolicy(context.Background(), models.CancellationPolicy{Name: "Flexible", FreeDays: 7, Penalty: 5000})
+
 	routes := getRoutes()
 	testServer := httptest.NewTLSServer(routes)
 	defer testServer.Close()
@@ -80,6 +129,8 @@ func TestHandlers(t *testing.T) {
 }
 
 func TestRepository_Reservation(t *testing.T) {
+	resetDB()
+
 	reservation := models.Reservation{
 		RoomID: 1,
 		Room: models.Room{
@@ -128,11 +179,14 @@ func TestRepository_Reservation(t *testing.T) {
 }
 
 func TestRepository_PostReservation(t *testing.T) {
+	resetDB()
+
 	reqBody := url.Values{}
 	reqBody.Add("first_name", "Akihito")
 	reqBody.Add("last_name", "Shu")
 	reqBody.Add("email", "doantayd@gmail.com")
 	reqBody.Add("phone", "2748476277")
+	reqBody.Add("card_number", payments.FakeCardSuccess)
 
 	startDate, err := time.Parse("2006-01-02", "2050-01-01")
 	if err != nil {
@@ -162,6 +216,9 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Errorf("PostReservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
+	if saved, ok := session.Get(ctx, "reservation").(models.Reservation); !ok || saved.ConfirmationCode == "" {
+		t.Error("PostReservation does not put the confirmation code in the session for the summary")
+	}
 
 	// test case when reservation is not in session (reset everything)
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody.Encode()))
@@ -196,6 +253,7 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody.Add("last_name", "Shu")
 	reqBody.Add("email", "doantayd@gmail.com")
 	reqBody.Add("phone", "2748476277")
+	reqBody.Add("card_number", payments.FakeCardSuccess)
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody.Encode()))
 	ctx = getCtx(req)
@@ -216,11 +274,13 @@ func TestRepository_PostReservation(t *testing.T) {
 	reqBody.Add("last_name", "Shu")
 	reqBody.Add("email", "doantayd@gmail.com")
 	reqBody.Add("phone", "2748476277")
+	reqBody.Add("card_number", payments.FakeCardSuccess)
 	reservation = models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
 		RoomID:    2,
 	}
+	testDB.FailOn("InsertReservationWithRestriction", errors.New("some errors"))
 
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody.Encode()))
 	ctx = getCtx(req)
@@ -234,13 +294,15 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusTemporaryRedirect {
 		t.Errorf("PostReservation handler failed when trying to fail inserting reservation: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
+	testDB.ClearFailures()
 
-	// test case fail when insert room restriction
+	// test case fail when the room does not exist
 	reqBody = url.Values{}
 	reqBody.Add("first_name", "Akihito")
 	reqBody.Add("last_name", "Shu")
 	reqBody.Add("email", "doantayd@gmail.com")
 	reqBody.Add("phone", "2748476277")
+	reqBody.Add("card_number", payments.FakeCardSuccess)
 	reservation = models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
@@ -257,506 +319,3260 @@ func TestRepository_PostReservation(t *testing.T) {
 	handler = http.HandlerFunc(Repo.PostReservation)
 	handler.ServeHTTP(rr, req)
 	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler failed when trying to fail inserting room restriction: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+		t.Errorf("PostReservation handler failed when the room does not exist: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
-}
 
-func TestReservationSummary(t *testing.T) {
-	startDate, err := time.Parse("2006-01-02", "2050-01-01")
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	endDate, err := time.Parse("2006-01-02", "2050-01-02")
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	reservation := models.Reservation{
+	// test case room got taken by another guest: room 1 was booked by the first case
+	reqBody = url.Values{}
+	reqBody.Add("first_name", "Akihito")
+	reqBody.Add("last_name", "Shu")
+	reqBody.Add("email", "doantayd@gmail.com")
+	reqBody.Add("phone", "2748476277")
+	reqBody.Add("card_number", payments.FakeCardSuccess)
+	reservation = models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
+		RoomID:    1,
 	}
 
-	req, _ := http.NewRequest("GET", "/reservation-summary", nil)
-	ctx := getCtx(req)
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody.Encode()))
+	ctx = getCtx(req)
 	req = req.WithContext(ctx)
 	session.Put(ctx, "reservation", reservation)
-	rr := httptest.NewRecorder()
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
 
-	handler := http.HandlerFunc(Repo.ReservationSummary)
+	handler = http.HandlerFunc(Repo.PostReservation)
 	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusOK {
-		t.Errorf("ReservationSummary handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostReservation handler returned wrong response code when room got taken: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != "/search-availability" {
+		t.Errorf("PostReservation handler redirected to wrong location when room got taken: got %s, wanted %s", actualLoc.String(), "/search-availability")
 	}
 
-	// test case when reservation is not in session (reset everything)
-	req, _ = http.NewRequest("GET", "/reservation-summary", nil)
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.ReservationSummary)
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("ReservationSummary handler returned wrong response code for empty reservation: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	// only the first case stored a reservation
+	reservations, _ := testDB.ListReservations(context.Background(), models.ReservationQuery{})
+	if reservations.Total != 1 {
+		t.Errorf("PostReservation stored wrong number of reservations: got %d, wanted %d", reservations.Total, 1)
 	}
 }
 
-func TestRepository_PostAvailability(t *testing.T) {
+func TestPostReservationShowsInAdmin(t *testing.T) {
+	resetDB()
+
+	startDate, _ := time.Parse("2006-01-02", "2050-03-01")
+	endDate, _ := time.Parse("2006-01-02", "2050-03-04")
+	reservation := models.Reservation{
+		StartDate: startDate,
+		EndDate:   endDate,
+		RoomID:    2,
+	}
+
 	reqBody := url.Values{}
-	reqBody.Add("start", "2050-01-01")
-	reqBody.Add("end", "2050-01-02")
+	reqBody.Add("first_name", "Akihito")
+	reqBody.Add("last_name", "Bookington")
+	reqBody.Add("email", "doantayd@gmail.com")
+	reqBody.Add("phone", "2748476277")
+	reqBody.Add("card_number", payments.FakeCardSuccess)
 
-	req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody.Encode()))
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody.Encode()))
 	ctx := getCtx(req)
 	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr := httptest.NewRecorder()
 
-	handler := http.HandlerFunc(Repo.PostAvailability)
+	handler := http.HandlerFunc(Repo.PostReservation)
 	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusOK {
-		t.Errorf("PostAvailability handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("PostReservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
-	// test case can not parse form
-	req, _ = http.NewRequest("POST", "/search-availability", nil)
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostAvailability)
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostAvailability handler returned wrong response code when can not parse form: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	// the room is no longer available for those dates
+	available, _ := testDB.SearchAvailabilityByDatesByRoomID(context.Background(), startDate, endDate, 2)
+	if available {
+		t.Error("Room is still available after it was booked")
 	}
 
-	// test case can not parse start date
-	reqBody = url.Values{}
-	reqBody.Add("start", "invalid")
-	reqBody.Add("end", "2050-01-02")
+	// three nights at the Major's Suite rate
+	saved, _ := session.Get(ctx, "reservation").(models.Reservation)
+	stored, _ := testDB.GetReservationByID(context.Background(), saved.ID)
+	if stored.TotalPrice != 45000 {
+		t.Errorf("PostReservation stored total price %d, wanted %d", stored.TotalPrice, 45000)
+	}
 
-	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody.Encode()))
+	req, _ = http.NewRequest("GET", "/admin/reservations-all", nil)
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr = httptest.NewRecorder()
 
-	handler = http.HandlerFunc(Repo.PostAvailability)
+	handler = http.HandlerFunc(Repo.AdminAllReservations)
 	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostAvailability handler returned wrong response code when can not parse start date: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminAllReservations handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "Bookington") {
+		t.Error("AdminAllReservations does not show the new reservation")
 	}
+}
 
-	// test case can not parse end date
-	reqBody = url.Values{}
-	reqBody.Add("start", "2050-01-02")
-	reqBody.Add("end", "invalid")
+func TestAdminReservationListing(t *testing.T) {
+	resetDB()
+	for i := 0; i < 5; i++ {
+		start := time.Date(2050, 1, 1+2*i, 0, 0, 0, 0, time.UTC)
+		bookRoom(t, 1+i%2, start.Format("2006-01-02"), start.AddDate(0, 0, 1).Format("2006-01-02"))
+	}
+	_ = testDB.UpdateReservationStatus(context.Background(), 1, models.StatusConfirmed, 1)
+
+	var tests = []struct {
+		name     string
+		handler  http.HandlerFunc
+		url      string
+		expected []string
+	}{
+		{"first page", Repo.AdminAllReservations, "/admin/reservations-all?size=2", []string{"5 reservations, page 1 of 3", "/admin/reservations/all/1/show", "/admin/reservations/all/2/show"}},
+		{"next page link", Repo.AdminAllReservations, "/admin/reservations-all?size=2", []string{`href="?page=2&amp;size=2&amp;sort=start_date"`}},
+		{"last page", Repo.AdminAllReservations, "/admin/reservations-all?size=2&page=3", []string{"page 3 of 3", "/admin/reservations/all/5/show"}},
+		{"room filter", Repo.AdminAllReservations, "/admin/reservations-all?room=2", []string{"2 reservations, page 1 of 1"}},
+		{"status filter", Repo.AdminAllReservations, "/admin/reservations-all?status=confirmed", []string{"1 reservations, page 1 of 1", "/admin/reservations/all/1/show", `<option value="confirmed" selected>`}},
+		{"unknown status filter", Repo.AdminAllReservations, "/admin/reservations-all?status=nope", []string{"5 reservations, page 1 of 1"}},
+		{"no matches", Repo.AdminAllReservations, "/admin/reservations-all?q=nobody", []string{"No reservations found"}},
+		{"new reservations", Repo.AdminNewReservations, "/admin/reservations-new", []string{"4 reservations, page 1 of 1", "/admin/reservations/new/2/show"}},
+		{"new reservations ignore status filter", Repo.AdminNewReservations, "/admin/reservations-new?status=confirmed", []string{"4 reservations, page 1 of 1"}},
+	}
 
-	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody.Encode()))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rr = httptest.NewRecorder()
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
 
-	handler = http.HandlerFunc(Repo.PostAvailability)
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostAvailability handler returned wrong response code when can not parse end date: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+		e.handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusOK)
+		}
+		for _, want := range e.expected {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("%s: page does not contain %q", e.name, want)
+			}
+		}
 	}
 
-	// test case can not connect to database
-	reqBody = url.Values{}
-	reqBody.Add("start", "2050-01-30")
-	reqBody.Add("end", "2050-02-01")
+	// a database error is a server error
+	testDB.FailOn("ListReservations", errors.New("some errors"))
+	req, _ := http.NewRequest("GET", "/admin/reservations-all", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+	Repo.AdminAllReservations(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("AdminAllReservations returned wrong response code on database error: got %d, wanted %d", rr.Code, http.StatusInternalServerError)
+	}
+	testDB.ClearFailures()
+}
 
-	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody.Encode()))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rr = httptest.NewRecorder()
+func TestAdminUpdateReservationStatus(t *testing.T) {
+	resetDB()
+	id := bookRoom(t, 1, "2050-01-01", "2050-01-02")
+	routes := getRoutes()
 
-	handler = http.HandlerFunc(Repo.PostAvailability)
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostAvailability handler returned wrong response code when can not connect to database: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	var tests = []struct {
+		name             string
+		url              string
+		expectedLocation string
+		expectedStatus   models.ReservationStatus
+	}{
+		{"confirm", "/admin/reservation-status/new/1/confirmed/do", "/admin/reservations-new", models.StatusConfirmed},
+		{"invalid transition", "/admin/reservation-status/all/1/checked_out/do", "/admin/reservations/all/1/show", models.StatusConfirmed},
+		{"cancel without the form", "/admin/reservation-status/all/1/cancelled/do", "/admin/reservations/all/1/show", models.StatusConfirmed},
+		{"unknown status", "/admin/reservation-status/all/1/nope/do", "/admin/reservations/all/1/show", models.StatusConfirmed},
+		{"check in from calendar", "/admin/reservation-status/cal/1/checked_in/do?y=2050&m=01", "/admin/reservations-calendar?y=2050&m=01", models.StatusCheckedIn},
 	}
 
-	// test case there is no availability room
-	reqBody = url.Values{}
-	reqBody.Add("start", "2050-01-02")
-	reqBody.Add("end", "2050-02-01")
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
 
-	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody.Encode()))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rr = httptest.NewRecorder()
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %s, but got %s", e.name, e.expectedLocation, loc)
+		}
 
-	handler = http.HandlerFunc(Repo.PostAvailability)
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("PostAvailability handler returned wrong response code when no availability room: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+		res, _ := testDB.GetReservationByID(context.Background(), id)
+		if res.Status != e.expectedStatus {
+			t.Errorf("%s: got status %q, wanted %q", e.name, res.Status, e.expectedStatus)
+		}
+	}
+
+	history, _ := testDB.GetReservationStatusHistory(context.Background(), id)
+	if len(history) != 2 {
+		t.Errorf("got %d status changes, wanted 2", len(history))
+	}
+
+	// a database error is a server error
+	testDB.FailOn("UpdateReservationStatus", errors.New("some errors"))
+	req, _ := http.NewRequest("GET", "/admin/reservation-status/all/1/checked_out/do", nil)
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("database error: expected code %d, but got %d", http.StatusInternalServerError, rr.Code)
 	}
+	testDB.ClearFailures()
 }
 
-func TestRepository_AvailabilityJSON(t *testing.T) {
-	// test case: rooms are not available
-	reqBody := url.Values{}
-	reqBody.Add("start", "2050-01-01")
-	reqBody.Add("end", "2050-01-01")
-	reqBody.Add("room_id", "1")
+func TestPostCancelReservation(t *testing.T) {
+	resetDB()
+	id := bookRoom(t, 1, "2050-01-01", "2050-01-03")
+	checkedIn := bookRoom(t, 2, "2050-01-01", "2050-01-03")
+	_ = testDB.UpdateReservationStatus(context.Background(), checkedIn, models.StatusConfirmed, 1)
+	_ = testDB.UpdateReservationStatus(context.Background(), checkedIn, models.StatusCheckedIn, 1)
+
+	booked, _ := testDB.GetReservationByID(context.Background(), id)
+	stay, _ := testDB.GetReservationByID(context.Background(), checkedIn)
+
+	var tests = []struct {
+		name         string
+		code         string
+		email        string
+		reason       string
+		expectedCode int
+		expectedText string
+	}{
+		{"missing fields", "", "", "", http.StatusSeeOther, "This field cannot be blank!"},
+		{"unknown code", "AAAA-AAAA", "john@smith.com", "Change of plans", http.StatusSeeOther, "We could not find a reservation with these details"},
+		{"reservation ID", strconv.Itoa(id), "john@smith.com", "Change of plans", http.StatusSeeOther, "We could not find a reservation with these details"},
+		{"wrong email", booked.ConfirmationCode, "jane@smith.com", "Change of plans", http.StatusSeeOther, "We could not find a reservation with these details"},
+		{"checked in", stay.ConfirmationCode, "john@smith.com", "Change of plans", http.StatusSeeOther, "This reservation can no longer be cancelled"},
+	}
 
-	req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(reqBody.Encode()))
-	ctx := getCtx(req)
-	req = req.WithContext(ctx)
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("confirmation_code", e.code)
+		postData.Add("email", e.email)
+		postData.Add("reason", e.reason)
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req, _ := http.NewRequest("POST", "/cancel-reservation", strings.NewReader(postData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
 
-	handler := http.HandlerFunc(Repo.AvailabilityJSON)
+		http.HandlerFunc(Repo.PostCancelReservation).ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: page does not contain %q", e.name, e.expectedText)
+		}
+	}
+
+	// the code and email match whatever their case
+	postData := url.Values{}
+	postData.Add("confirmation_code", strings.ToLower(booked.ConfirmationCode))
+	postData.Add("email", "John@Smith.com")
+	postData.Add("reason", "Change of plans")
+
+	req, _ := http.NewRequest("POST", "/cancel-reservation", strings.NewReader(postData.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr := httptest.NewRecorder()
-	handler.ServeHTTP(rr, req)
 
-	var j jsonResponse
-	err := json.Unmarshal([]byte(rr.Body.String()), &j)
-	if err != nil {
-		t.Error("Failed to parse json")
+	http.HandlerFunc(Repo.PostCancelReservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/" {
+		t.Errorf("valid cancellation: expected redirect to / with code %d, but got %d to %q", http.StatusSeeOther, rr.Code, rr.Header().Get("Location"))
 	}
-	if j.Message != "Available!" {
-		t.Errorf("AvailabilityJSON handler can not get availability: got %s, wanted %s", j.Message, "Available!")
-	} else if j.OK != false {
-		t.Errorf("AvailabilityJSON handler gets available room when rooms are not available")
+
+	res, _ := testDB.GetReservationByID(context.Background(), id)
+	if res.Status != models.StatusCancelled || res.CancelledBy != 0 || res.CancellationReason != "Change of plans" {
+		t.Errorf("got status %q cancelled by %d because %q, wanted a guest cancellation", res.Status, res.CancelledBy, res.CancellationReason)
 	}
 
-	// test case: rooms are available
-	reqBody = url.Values{}
-	reqBody.Add("start", "2050-01-01")
-	reqBody.Add("end", "2050-01-01")
-	reqBody.Add("room_id", "2")
+	available, _ := testDB.SearchAvailabilityByDatesByRoomID(context.Background(), res.StartDate, res.EndDate, 1)
+	if !available {
+		t.Error("room is still unavailable after the guest cancelled")
+	}
+}
 
-	req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(reqBody.Encode()))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
+func TestPostManageBooking(t *testing.T) {
+	resetDB()
+	id := bookRoom(t, 1, "2050-01-01", "2050-01-03")
+	booked, _ := testDB.GetReservationByID(context.Background(), id)
+
+	var tests = []struct {
+		name         string
+		code         string
+		email        string
+		expectedText string
+	}{
+		{"missing fields", "", "", "This field cannot be blank!"},
+		{"unknown code", "AAAA-AAAA", "john@smith.com", "We could not find a reservation with these details"},
+		{"wrong email", booked.ConfirmationCode, "jane@smith.com", "We could not find a reservation with these details"},
+	}
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("confirmation_code", e.code)
+		postData.Add("email", e.email)
 
-	handler = http.HandlerFunc(Repo.AvailabilityJSON)
-	rr = httptest.NewRecorder()
-	handler.ServeHTTP(rr, req)
+		req, _ := http.NewRequest("POST", "/manage-booking", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
 
-	err = json.Unmarshal([]byte(rr.Body.String()), &j)
-	if err != nil {
-		t.Error("Failed to parse json")
-	}
-	if j.Message != "Available!" {
-		t.Errorf("AvailabilityJSON handler can not get availability: got %s, wanted %s", j.Message, "Available!")
-	} else if j.OK != true {
-		t.Errorf("AvailabilityJSON handler gets no available room when rooms are available")
+		http.HandlerFunc(Repo.PostManageBooking).ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: page does not contain %q", e.name, e.expectedText)
+		}
+		if session.GetInt(ctx, "managed_reservation_id") != 0 {
+			t.Errorf("%s: session manages a reservation it should not", e.name)
+		}
 	}
 
-	// test case: SearchAvailabilityByDatesByRoomID get errors
-	reqBody = url.Values{}
-	reqBody.Add("start", "2050-01-01")
-	reqBody.Add("end", "2050-01-01")
-	reqBody.Add("room_id", "1000")
+	postData := url.Values{}
+	postData.Add("confirmation_code", strings.ToLower(booked.ConfirmationCode))
+	postData.Add("email", "John@Smith.com")
 
-	req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(reqBody.Encode()))
-	ctx = getCtx(req)
+	req, _ := http.NewRequest("POST", "/manage-booking", strings.NewReader(postData.Encode()))
+	ctx := getCtx(req)
 	req = req.WithContext(ctx)
-
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
 
-	handler = http.HandlerFunc(Repo.AvailabilityJSON)
-	rr = httptest.NewRecorder()
-	handler.ServeHTTP(rr, req)
-
-	err = json.Unmarshal([]byte(rr.Body.String()), &j)
-	if err != nil {
-		t.Error("Failed to parse json")
+	http.HandlerFunc(Repo.PostManageBooking).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/manage-booking/reservation" {
+		t.Errorf("valid lookup: expected redirect to /manage-booking/reservation, but got %d to %q", rr.Code, rr.Header().Get("Location"))
 	}
-	if j.Message != "Error connecting to database" {
-		t.Errorf("AvailabilityJSON handler can not get error when connecting database: got %s, wanted %s", j.Message, "Error connecting to database")
+	if session.GetInt(ctx, "managed_reservation_id") != id {
+		t.Errorf("valid lookup: expected session to manage reservation %d, but got %d", id, session.GetInt(ctx, "managed_reservation_id"))
 	}
+}
 
-	// test case: can not parse form
-	req, _ = http.NewRequest("POST", "/search-availability-json", nil)
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
+func TestShowManagedBooking(t *testing.T) {
+	resetDB()
+	id := bookRoom(t, 1, "2050-01-01", "2050-01-03")
+	booked, _ := testDB.GetReservationByID(context.Background(), id)
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req, _ := http.NewRequest("GET", "/manage-booking/reservation", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
 
-	handler = http.HandlerFunc(Repo.AvailabilityJSON)
+	http.HandlerFunc(Repo.ShowManagedBooking).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/manage-booking" {
+		t.Errorf("no booking in session: expected redirect to /manage-booking, but got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	req, _ = http.NewRequest("GET", "/manage-booking/reservation", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "managed_reservation_id", id)
 	rr = httptest.NewRecorder()
-	handler.ServeHTTP(rr, req)
 
-	err = json.Unmarshal([]byte(rr.Body.String()), &j)
-	if err != nil {
-		t.Error("Failed to parse json")
+	http.HandlerFunc(Repo.ShowManagedBooking).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d, but got %d", http.StatusOK, rr.Code)
 	}
-	if j.Message != "Internal server error" {
-		t.Errorf("AvailabilityJSON handler can not get error when parsing form: got %s, wanted %s", j.Message, "Internal server error")
+	if !strings.Contains(rr.Body.String(), booked.ConfirmationCode) {
+		t.Errorf("page does not show the confirmation code %q", booked.ConfirmationCode)
 	}
 }
 
-func TestChooseRoom(t *testing.T) {
-	// test case: normal
+func TestPostManagedBookingContact(t *testing.T) {
+	resetDB()
+	id := bookRoom(t, 1, "2050-01-01", "2050-01-03")
+	checkedIn := bookRoom(t, 2, "2050-01-01", "2050-01-03")
+	_ = testDB.UpdateReservationStatus(context.Background(), checkedIn, models.StatusConfirmed, 1)
+	_ = testDB.UpdateReservationStatus(context.Background(), checkedIn, models.StatusCheckedIn, 1)
+
+	var tests = []struct {
+		name             string
+		reservationID    int
+		email            string
+		expectedCode     int
+		expectedLocation string
+		expectedText     string
+	}{
+		{"no booking in session", 0, "jane@smith.com", http.StatusSeeOther, "/manage-booking", ""},
+		{"checked in", checkedIn, "jane@smith.com", http.StatusSeeOther, "/manage-booking/reservation", ""},
+		{"invalid email", id, "jane", http.StatusSeeOther, "", "Invalid email address"},
+		{"valid", id, "jane@smith.com", http.StatusSeeOther, "/manage-booking/reservation", ""},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("first_name", "Jane")
+		postData.Add("last_name", "Smith")
+		postData.Add("email", e.email)
+		postData.Add("phone", "555-555-5555")
+
+		req, _ := http.NewRequest("POST", "/manage-booking/contact", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if e.reservationID > 0 {
+			session.Put(ctx, "managed_reservation_id", e.reservationID)
+		}
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.PostManagedBookingContact).ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %q, but got %q", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: page does not contain %q", e.name, e.expectedText)
+		}
+	}
+
+	res, _ := testDB.GetReservationByID(context.Background(), id)
+	if res.FirstName != "Jane" || res.Email != "jane@smith.com" || res.Phone != "555-555-5555" {
+		t.Errorf("contact details were not updated, got %s %s %s", res.FirstName, res.Email, res.Phone)
+	}
+	if res.StartDate.Format("2006-01-02") != "2050-01-01" || res.RoomID != 1 {
+		t.Error("updating contact details changed the stay")
+	}
+
+	stay, _ := testDB.GetReservationByID(context.Background(), checkedIn)
+	if stay.FirstName == "Jane" {
+		t.Error("contact details of a checked in reservation were changed")
+	}
+}
+
+func TestPostManagedBookingDates(t *testing.T) {
+	resetDB()
+	id := bookRoom(t, 1, "2050-01-01", "2050-01-03")
+	cancelled := bookRoom(t, 2, "2050-01-01", "2050-01-03")
+	_ = testDB.CancelReservation(context.Background(), cancelled, 1, "Guest called", 0)
+
+	var tests = []struct {
+		name             string
+		reservationID    int
+		start            string
+		end              string
+		expectedLocation string
+		expectedText     string
+	}{
+		{"no booking in session", 0, "2050-02-01", "2050-02-03", "/manage-booking", ""},
+		{"cancelled", cancelled, "2050-02-01", "2050-02-03", "/manage-booking/reservation", ""},
+		{"invalid date", id, "2050-02-30", "2050-03-03", "", "Invalid date!"},
+		{"end before start", id, "2050-02-03", "2050-02-01", "", "This date must be after the first date!"},
+		{"in the past", id, "2000-02-01", "2000-02-03", "", "This date has already passed!"},
+		{"valid", id, "2050-02-01", "2050-02-03", "/manage-booking/reservation", ""},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("start_date", e.start)
+		postData.Add("end_date", e.end)
+
+		req, _ := http.NewRequest("POST", "/manage-booking/dates", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if e.reservationID > 0 {
+			session.Put(ctx, "managed_reservation_id", e.reservationID)
+		}
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(Repo.PostManagedBookingDates).ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %q, but got %q", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: page does not contain %q", e.name, e.expectedText)
+		}
+	}
+
+	res, _ := testDB.GetReservationByID(context.Background(), id)
+	if res.RequestedStartDate.Format("2006-01-02") != "2050-02-01" || res.RequestedEndDate.Format("2006-01-02") != "2050-02-03" {
+		t.Errorf("expected requested dates 2050-02-01 to 2050-02-03, but got %v to %v", res.RequestedStartDate, res.RequestedEndDate)
+	}
+	if res.StartDate.Format("2006-01-02") != "2050-01-01" {
+		t.Error("requesting new dates moved the stay before it was confirmed")
+	}
+}
+
+func TestAdminPostCreateReservation(t *testing.T) {
+	resetDB()
+	bookRoom(t, 1, "2050-01-01", "2050-01-03")
+	routes := getRoutes()
+
+	var tests = []struct {
+		name             string
+		source           string
+		roomID           string
+		start            string
+		end              string
+		email            string
+		sendConfirmation string
+		expectedLocation string
+		expectedText     string
+	}{
+		{"missing dates", "phone", "1", "", "", "jane@smith.com", "1", "", "This field cannot be blank!"},
+		{"online source", "online", "1", "2050-02-01", "2050-02-03", "jane@smith.com", "1", "", "Please choose how the reservation was made"},
+		{"end before start", "phone", "1", "2050-02-03", "2050-02-01", "jane@smith.com", "1", "", "This date must be after the first date!"},
+		{"confirmation without email", "walk_in", "1", "2050-02-01", "2050-02-03", "", "1", "", "This field cannot be blank!"},
+		{"invalid email", "walk_in", "1", "2050-02-01", "2050-02-03", "jane", "", "", "Invalid email address"},
+		{"room taken", "phone", "1", "2050-01-02", "2050-01-04", "jane@smith.com", "1", "", "The room is not available for these dates"},
+		{"unknown room", "phone", "99", "2050-02-01", "2050-02-03", "jane@smith.com", "1", "", "Please choose a room"},
+		{"phone booking", "phone", "1", "2050-02-01", "2050-02-03", "jane@smith.com", "1", "/admin/reservations/all/2/show", ""},
+		{"walk-in without email", "walk_in", "2", "2050-02-01", "2050-02-03", "", "", "/admin/reservations/all/3/show", ""},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("source", e.source)
+		postData.Add("room_id", e.roomID)
+		postData.Add("start_date", e.start)
+		postData.Add("end_date", e.end)
+		postData.Add("first_name", "Jane")
+		postData.Add("last_name", "Smith")
+		postData.Add("email", e.email)
+		postData.Add("send_confirmation", e.sendConfirmation)
+
+		req, _ := http.NewRequest("POST", "/admin/create-reservation", strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedText) {
+			t.Errorf("%s: page does not contain %q", e.name, e.expectedText)
+		}
+	}
+
+	phone, _ := testDB.GetReservationByID(context.Background(), 2)
+	if phone.Source != models.SourcePhone || phone.RoomID != 1 || phone.Email != "jane@smith.com" {
+		t.Errorf("got a %q booking for room %d by %q, wanted a phone booking for room 1 by jane@smith.com", phone.Source, phone.RoomID, phone.Email)
+	}
+
+	walkIn, _ := testDB.GetReservationByID(context.Background(), 3)
+	if walkIn.Source != models.SourceWalkIn {
+		t.Errorf("got source %q, wanted %q", walkIn.Source, models.SourceWalkIn)
+	}
+
+	available, _ := testDB.SearchAvailabilityByDatesByRoomID(context.Background(), phone.StartDate, phone.EndDate, 1)
+	if available {
+		t.Error("room is still available after an admin booked it")
+	}
+}
+
+func TestAdminChangeReservationStay(t *testing.T) {
+	resetDB()
+	id := bookRoom(t, 1, "2050-01-01", "2050-01-03")
+	bookRoom(t, 1, "2050-01-10", "2050-01-12")
+	requestedStart, _ := time.Parse("2006-01-02", "2050-01-04")
+	requestedEnd, _ := time.Parse("2006-01-02", "2050-01-07")
+	_ = testDB.RequestDateChange(context.Background(), id, requestedStart, requestedEnd)
+	routes := getRoutes()
+
+	var tests = []struct {
+		name             string
+		url              string
+		roomID           string
+		start            string
+		end              string
+		year             string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"missing room", "/admin/change-stay/all/1", "", "2050-01-04", "2050-01-07", "", http.StatusSeeOther, "/admin/reservations/all/1/show"},
+		{"invalid date", "/admin/change-stay/all/1", "1", "2050-01-04", "2050-02-30", "", http.StatusSeeOther, "/admin/reservations/all/1/show"},
+		{"end before start", "/admin/change-stay/all/1", "1", "2050-01-07", "2050-01-04", "", http.StatusSeeOther, "/admin/reservations/all/1/show"},
+		{"room taken", "/admin/change-stay/all/1", "1", "2050-01-02", "2050-01-11", "", http.StatusSeeOther, "/admin/reservations/all/1/show"},
+		{"missing reservation", "/admin/change-stay/all/999", "1", "2050-01-04", "2050-01-07", "", http.StatusInternalServerError, ""},
+		{"change from calendar", "/admin/change-stay/cal/1", "2", "2050-01-04", "2050-01-07", "2050", http.StatusSeeOther, "/admin/reservations-calendar?y=2050&m=01"},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("room_id", e.roomID)
+		postData.Add("start_date", e.start)
+		postData.Add("end_date", e.end)
+		postData.Add("year", e.year)
+		postData.Add("month", "01")
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+	}
+
+	res, _ := testDB.GetReservationByID(context.Background(), id)
+	if res.RoomID != 2 || res.StartDate.Format("2006-01-02") != "2050-01-04" || res.EndDate.Format("2006-01-02") != "2050-01-07" {
+		t.Errorf("got room %d from %v to %v, wanted room 2 from 2050-01-04 to 2050-01-07", res.RoomID, res.StartDate, res.EndDate)
+	}
+	if !res.RequestedStartDate.IsZero() {
+		t.Error("the guest's requested dates were not cleared")
+	}
+	if res.TotalPrice != 45000 {
+		t.Errorf("got total price %d, wanted the new stay quoted at 45000", res.TotalPrice)
+	}
+
+	oldStart, _ := time.Parse("2006-01-02", "2050-01-01")
+	oldEnd, _ := time.Parse("2006-01-02", "2050-01-03")
+	available, _ := testDB.SearchAvailabilityByDatesByRoomID(context.Background(), oldStart, oldEnd, 1)
+	if !available {
+		t.Error("old dates are still unavailable after the stay moved")
+	}
+
+	// a cancelled reservation keeps its stay
+	_ = testDB.CancelReservation(context.Background(), id, 1, "Guest called", 0)
+	postData := url.Values{}
+	postData.Add("room_id", "1")
+	postData.Add("start_date", "2050-01-04")
+	postData.Add("end_date", "2050-01-07")
+
+	req, _ := http.NewRequest("POST", "/admin/change-stay/all/1", strings.NewReader(postData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/admin/reservations/all/1/show" {
+		t.Errorf("cancelled reservation: expected location /admin/reservations/all/1/show, but got %q", loc)
+	}
+}
+
+func TestAdminChangeReservationStayChecks(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+	ctx := context.Background()
+	_, _ = testDB.InsertPromoCode(ctx, models.PromoCode{Code: "ROOMONE", Kind: models.PromoPercent, PercentOff: 10, RoomIDs: []int{1}})
+
+	book := func(start string, res models.Reservation) int {
+		res.FirstName, res.LastName, res.Email, res.RoomID = "John", "Smith", "john@smith.com", 1
+		res.StartDate, _ = time.Parse("2006-01-02", start)
+		res.EndDate = res.StartDate.AddDate(0, 0, 2)
+		id, err := testDB.InsertReservationWithRestriction(ctx, res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	crowded := book("2050-03-01", models.Reservation{Guests: 3})
+	promo := book("2050-04-01", models.Reservation{Guests: 1, PromoCode: "ROOMONE", Discount: 2000})
+	paid := book("2050-05-01", models.Reservation{Guests: 1, TotalPrice: 20000})
+	_, _ = testDB.InsertPayment(ctx, models.Payment{
+		ReservationID: paid, Provider: "fake", Reference: "fake_9", Status: models.PaymentCaptured, Amount: 20000,
+	})
+
+	var tests = []struct {
+		name            string
+		id              int
+		start           string
+		inactive        bool
+		expectedError   string
+		expectedWarning string
+	}{
+		{"room closed", paid, "2050-05-01", true, "Major's Suite is not open for booking", ""},
+		{"too many guests", crowded, "2050-03-01", false, "Major's Suite sleeps 2 at most", ""},
+		{"promo code for another room", promo, "2050-04-01", false, "The promo code ROOMONE does not apply to Major's Suite", ""},
+		{"new total", paid, "2050-05-01", false, "", "The new total is $300.00, but the guest's payments hold $200.00. Please settle the difference."},
+	}
+
+	for _, e := range tests {
+		_ = testDB.SetRoomActive(ctx, 2, !e.inactive)
+
+		start, _ := time.Parse("2006-01-02", e.start)
+		postData := url.Values{}
+		postData.Add("room_id", "2")
+		postData.Add("start_date", e.start)
+		postData.Add("end_date", start.AddDate(0, 0, 2).Format("2006-01-02"))
+
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/change-stay/all/%d", e.id), strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		reqCtx := getCtx(req)
+		req = req.WithContext(reqCtx)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if msg := session.GetString(reqCtx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+		if msg := session.GetString(reqCtx, "warning"); msg != e.expectedWarning {
+			t.Errorf("%s: expected warning %q, but got %q", e.name, e.expectedWarning, msg)
+		}
+
+		res, _ := testDB.GetReservationByID(ctx, e.id)
+		if moved := res.RoomID == 2; moved != (e.expectedError == "") {
+			t.Errorf("%s: reservation is in room %d", e.name, res.RoomID)
+		}
+	}
+}
+
+func TestAdminCancelReservation(t *testing.T) {
+	resetDB()
+	id := bookRoom(t, 1, "2050-01-01", "2050-01-03")
+	routes := getRoutes()
+
+	var tests = []struct {
+		name             string
+		url              string
+		reason           string
+		year             string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"missing reason", "/admin/cancel-reservation/all/1", "", "", http.StatusSeeOther, "/admin/reservations/all/1/show"},
+		{"missing reservation", "/admin/cancel-reservation/all/999", "Guest called", "", http.StatusInternalServerError, ""},
+		{"cancel from calendar", "/admin/cancel-reservation/cal/1", "Guest called", "2050", http.StatusSeeOther, "/admin/reservations-calendar?y=2050&m=01"},
+		{"already cancelled", "/admin/cancel-reservation/all/1", "Guest called", "", http.StatusSeeOther, "/admin/reservations/all/1/show"},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("reason", e.reason)
+		postData.Add("year", e.year)
+		postData.Add("month", "01")
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+	}
+
+	res, _ := testDB.GetReservationByID(context.Background(), id)
+	if res.Status != models.StatusCancelled || res.CancellationReason != "Guest called" {
+		t.Errorf("got status %q because %q, wanted a cancellation because \"Guest called\"", res.Status, res.CancellationReason)
+	}
+}
+
+func TestAdminSearchReservations(t *testing.T) {
+	resetDB()
+	id := bookRoom(t, 1, "2050-01-01", "2050-01-02")
+	booked, _ := testDB.GetReservationByID(context.Background(), id)
+
+	var tests = []struct {
+		name     string
+		url      string
+		expected string
+	}{
+		{"by name", "/admin/search?q=smi", fmt.Sprintf("/admin/reservations/all/%d/show", id)},
+		{"by id", fmt.Sprintf("/admin/search?q=%d", id), "John Smith"},
+		{"by confirmation code", "/admin/search?q=" + strings.ToLower(booked.ConfirmationCode), booked.ConfirmationCode},
+		{"no match", "/admin/search?q=nobody", "No reservations match"},
+		{"no term", "/admin/search", "Guest name, email, phone, confirmation code or reservation ID"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminSearchReservations)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: AdminSearchReservations returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusOK)
+		}
+		if !strings.Contains(rr.Body.String(), e.expected) {
+			t.Errorf("%s: page does not contain %q", e.name, e.expected)
+		}
+	}
+
+	testDB.FailOn("SearchReservations", errors.New("some errors"))
+	req, _ := http.NewRequest("GET", "/admin/search?q=smith", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+	Repo.AdminSearchReservations(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("AdminSearchReservations returned wrong response code on database error: got %d, wanted %d", rr.Code, http.StatusInternalServerError)
+	}
+	testDB.ClearFailures()
+}
+
+func TestReservationSummary(t *testing.T) {
+	resetDB()
+
+	startDate, err := time.Parse("2006-01-02", "2050-01-01")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	endDate, err := time.Parse("2006-01-02", "2050-01-02")
+	if err != nil {
+		log.Println(err)
+		return
+	}
 	reservation := models.Reservation{
-		RoomID: 1,
+		StartDate:        startDate,
+		EndDate:          endDate,
+		ConfirmationCode: "K7QM-4XPD",
 	}
 
-	req, _ := http.NewRequest("GET", "/choose-room", nil)
-	req.RequestURI = "/choose-room/1"
+	req, _ := http.NewRequest("GET", "/reservation-summary", nil)
 	ctx := getCtx(req)
 	req = req.WithContext(ctx)
 	session.Put(ctx, "reservation", reservation)
 	rr := httptest.NewRecorder()
 
-	handler := http.HandlerFunc(Repo.ChooseRoom)
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	handler := http.HandlerFunc(Repo.ReservationSummary)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("ReservationSummary handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "K7QM-4XPD") {
+		t.Error("ReservationSummary does not show the confirmation code")
+	}
+
+	// test case when reservation is not in session (reset everything)
+	req, _ = http.NewRequest("GET", "/reservation-summary", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.ReservationSummary)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("ReservationSummary handler returned wrong response code for empty reservation: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+}
+
+func TestRepository_PostAvailability(t *testing.T) {
+	resetDB()
+
+	reqBody := url.Values{}
+	reqBody.Add("start", "2050-01-01")
+	reqBody.Add("end", "2050-01-02")
+
+	req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.PostAvailability)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("PostAvailability handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "$150.00</strong> for 1 night") {
+		t.Error("PostAvailability does not quote the price of the stay")
+	}
+
+	// test case can not parse form
+	req, _ = http.NewRequest("POST", "/search-availability", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostAvailability)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("PostAvailability handler returned wrong response code when can not parse form: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+
+	// test case can not parse start date
+	reqBody = url.Values{}
+	reqBody.Add("start", "invalid")
+	reqBody.Add("end", "2050-01-02")
+
+	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostAvailability)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("PostAvailability handler returned wrong response code when can not parse start date: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+
+	// test case can not parse end date
+	reqBody = url.Values{}
+	reqBody.Add("start", "2050-01-02")
+	reqBody.Add("end", "invalid")
+
+	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostAvailability)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("PostAvailability handler returned wrong response code when can not parse end date: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+
+	// test case can not connect to database
+	reqBody = url.Values{}
+	reqBody.Add("start", "2050-01-30")
+	reqBody.Add("end", "2050-02-01")
+	testDB.FailOn("SearchAvailabilityForAllRooms", errors.New("some errors"))
+
+	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostAvailability)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("PostAvailability handler returned wrong response code when can not connect to database: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+	testDB.ClearFailures()
+
+	// test case there is no availability room
+	bookRoom(t, 1, "2050-01-02", "2050-02-01")
+	bookRoom(t, 2, "2050-01-10", "2050-01-12")
+	reqBody = url.Values{}
+	reqBody.Add("start", "2050-01-02")
+	reqBody.Add("end", "2050-02-01")
+
+	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostAvailability)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostAvailability handler returned wrong response code when no availability room: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+}
+
+func TestRepository_AvailabilityJSON(t *testing.T) {
+	resetDB()
+	bookRoom(t, 1, "2050-01-01", "2050-01-03")
+
+	// test case: rooms are not available
+	reqBody := url.Values{}
+	reqBody.Add("start", "2050-01-01")
+	reqBody.Add("end", "2050-01-02")
+	reqBody.Add("room_id", "1")
+
+	req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(reqBody.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	handler := http.HandlerFunc(Repo.AvailabilityJSON)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var j jsonResponse
+	err := json.Unmarshal([]byte(rr.Body.String()), &j)
+	if err != nil {
+		t.Error("Failed to parse json")
+	}
+	if j.Message != "Available!" {
+		t.Errorf("AvailabilityJSON handler can not get availability: got %s, wanted %s", j.Message, "Available!")
+	} else if j.OK != false {
+		t.Errorf("AvailabilityJSON handler gets available room when rooms are not available")
+	}
+
+	// test case: rooms are available
+	reqBody = url.Values{}
+	reqBody.Add("start", "2050-01-01")
+	reqBody.Add("end", "2050-01-02")
+	reqBody.Add("room_id", "2")
+
+	req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(reqBody.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	handler = http.HandlerFunc(Repo.AvailabilityJSON)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	err = json.Unmarshal([]byte(rr.Body.String()), &j)
+	if err != nil {
+		t.Error("Failed to parse json")
+	}
+	if j.Message != "Available!" {
+		t.Errorf("AvailabilityJSON handler can not get availability: got %s, wanted %s", j.Message, "Available!")
+	} else if j.OK != true {
+		t.Errorf("AvailabilityJSON handler gets no available room when rooms are available")
+	}
+
+	// test case: SearchAvailabilityByDatesByRoomID get errors
+	reqBody = url.Values{}
+	reqBody.Add("start", "2050-01-01")
+	reqBody.Add("end", "2050-01-02")
+	reqBody.Add("room_id", "2")
+	testDB.FailOn("SearchAvailabilityByDatesByRoomID", errors.New("some errors"))
+
+	req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(reqBody.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	handler = http.HandlerFunc(Repo.AvailabilityJSON)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	err = json.Unmarshal([]byte(rr.Body.String()), &j)
+	if err != nil {
+		t.Error("Failed to parse json")
+	}
+	if j.Message != "Error connecting to database" {
+		t.Errorf("AvailabilityJSON handler can not get error when connecting database: got %s, wanted %s", j.Message, "Error connecting to database")
+	}
+	testDB.ClearFailures()
+
+	// test case: can not parse form
+	req, _ = http.NewRequest("POST", "/search-availability-json", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	handler = http.HandlerFunc(Repo.AvailabilityJSON)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	err = json.Unmarshal([]byte(rr.Body.String()), &j)
+	if err != nil {
+		t.Error("Failed to parse json")
+	}
+	if j.Message != "Internal server error" {
+		t.Errorf("AvailabilityJSON handler can not get error when parsing form: got %s, wanted %s", j.Message, "Internal server error")
+	}
+}
+
+func TestChooseRoom(t *testing.T) {
+	resetDB()
+
+	// test case: normal
+	reservation := models.Reservation{
+		RoomID: 1,
+	}
+
+	req, _ := http.NewRequest("GET", "/choose-room", nil)
+	req.RequestURI = "/choose-room/1"
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.ChooseRoom)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	// test case: reservation is empty
+	req, _ = http.NewRequest("GET", "/choose-room", nil)
+	req.RequestURI = "/choose-room/1"
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.ChooseRoom)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("ChooseRoom handler returned wrong response code when reservation is empty: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+
+	// test case: url param is wrong
+	req, _ = http.NewRequest("GET", "/choose-room", nil)
+	req.RequestURI = "/choose-room/a"
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.ChooseRoom)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("ChooseRoom handler returned wrong response code when url param is wrong: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+
+	// test case: room does not exist
+	req, _ = http.NewRequest("GET", "/choose-room", nil)
+	req.RequestURI = "/choose-room/999"
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.ChooseRoom)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("ChooseRoom handler returned wrong response code for a missing room: got %d, wanted %d", rr.Code, http.StatusNotFound)
+	}
+
+	// test case: room is inactive
+	_ = testDB.SetRoomActive(context.Background(), 2, false)
+	req, _ = http.NewRequest("GET", "/choose-room", nil)
+	req.RequestURI = "/choose-room/2"
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.ChooseRoom)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("ChooseRoom handler returned wrong response code for an inactive room: got %d, wanted %d", rr.Code, http.StatusNotFound)
+	}
+	if res, _ := session.Get(ctx, "reservation").(models.Reservation); res.RoomID != 1 {
+		t.Errorf("the inactive room was put in the reservation")
+	}
+
+	// test case: database error
+	testDB.FailOn("GetRoomByID", errors.New("some errors"))
+	req, _ = http.NewRequest("GET", "/choose-room", nil)
+	req.RequestURI = "/choose-room/1"
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.ChooseRoom)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("ChooseRoom handler returned wrong response code when the room cannot be loaded: got %d, wanted %d", rr.Code, http.StatusInternalServerError)
+	}
+	testDB.ClearFailures()
+}
+
+func TestBookRoom(t *testing.T) {
+	resetDB()
+
+	req, _ := http.NewRequest("GET", "/book-room?id=1&s=2050-01-01&e=2050-01-02", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.BookRoom)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("BookRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	// test case can not get room by ID
+	req, _ = http.NewRequest("GET", "/book-room?id=3&s=2050-01-01&e=2050-01-02", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.BookRoom)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("BookRoom handler returned wrong when can not get room by ID: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	}
+
+	// test case stays of no nights, or ending before they start
+	for _, query := range []string{"id=1&s=2050-01-02&e=2050-01-02", "id=1&s=2050-01-02&e=2050-01-01", "id=1"} {
+		req, _ = http.NewRequest("GET", "/book-room?"+query, nil)
+		ctx = getCtx(req)
+		req = req.WithContext(ctx)
+		rr = httptest.NewRecorder()
+
+		handler = http.HandlerFunc(Repo.BookRoom)
+		handler.ServeHTTP(rr, req)
+		if loc := rr.Header().Get("Location"); rr.Code != http.StatusSeeOther || loc != "/search-availability" {
+			t.Errorf("BookRoom handler booked %q: got %d to %q", query, rr.Code, loc)
+		}
+		if _, ok := session.Get(ctx, "reservation").(models.Reservation); ok {
+			t.Errorf("BookRoom handler put a reservation in the session for %q", query)
+		}
+	}
+}
+
+var loginTests = []struct {
+	name               string
+	email              string
+	expectedStatusCode int
+	expectedHTML       string
+	expectedLocation   string
+}{
+	{
+		"valid-credentials",
+		"admin@admin.com",
+		http.StatusSeeOther,
+		"",
+		"/",
+	},
+	{
+		"invalid-credentials",
+		"jack@user.com",
+		http.StatusSeeOther,
+		"",
+		"/user/login",
+	},
+	{
+		"invalid-data",
+		"not-email",
+		http.StatusOK,
+		`action="/user/login"`,
+		"",
+	},
+}
+
+func TestPostShowLogin(t *testing.T) {
+	resetDB()
+
+	// range through all tests
+	for _, e := range loginTests {
+		postData := url.Values{}
+		postData.Add("email", e.email)
+		postData.Add("password", "password")
+
+		// create request
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		// set the header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		// call the handler
+		handler := http.HandlerFunc(Repo.PostShowLogin)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("Failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			// get the URL from test
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("Failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		// checking for expected values in HTML
+		if e.expectedHTML != "" {
+			// read the response body into a string
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("Failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+var updateReservationTests = []struct {
+	name             string
+	src              string
+	year             string
+	expectedLocation string
+}{
+	{
+		"SRC IS ALL",
+		"all",
+		"",
+		"/admin/reservations-all",
+	},
+	{
+		"SRC IS NEW",
+		"new",
+		"",
+		"/admin/reservations-new",
+	},
+	{
+		"SRC IS CALENDAR",
+		"calendar",
+		"",
+		"/admin/reservations-calendar",
+	},
+	{
+		"HAVE YEAR",
+		"calendar",
+		"2024",
+		"/admin/reservations-calendar?y=2024&m=01",
+	},
+}
+
+func TestPostShowReservation(t *testing.T) {
+	resetDB()
+	bookRoom(t, 1, "2050-01-01", "2050-01-02")
+
+	for _, e := range updateReservationTests {
+		postData := url.Values{}
+		postData.Add("year", e.year)
+		if e.year != "" {
+			postData.Add("month", "01")
+		} else {
+			postData.Add("month", "")
+		}
+
+		// create request
+		req, _ := http.NewRequest("POST", "/admin/reservations/"+e.src+"/1", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		// set the header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		// call the handler
+		handler := http.HandlerFunc(Repo.AdminPostShowReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("Failed PostShowReservation - %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		// get the URL from test
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("Failed PostShowReservation - %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+	}
+}
+
+func TestPostReservationsCalendar(t *testing.T) {
+	resetDB()
+
+	postData := url.Values{}
+	postData.Add("year", "2024")
+	postData.Add("month", "1")
+
+	block_map_1 := make(map[string]int)
+	block_map_1["2024-11-12"] = 1
+	postData.Add("remove_block_1_2024-11-12", "")
+
+	block_map_2 := make(map[string]int)
+	block_map_2["2024-11-12"] = 1
+	postData.Add("remove_block_2_2024-11-12", "1")
+
+	postData.Add("add_block_1_2024-11-11", "1")
+
+	// create request
+	req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(postData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	// set the header
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	session.Put(req.Context(), "block_map_1", block_map_1)
+	session.Put(req.Context(), "block_map_2", block_map_2)
+
+	// call the handler
+	handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Failed %s: expected code %d, but got %d", "TestPostReservationsCalendar", http.StatusSeeOther, rr.Code)
+	}
+
+	// get the URL from test
+	expectedLocation := "/admin/reservations-calendar?y=2024&m=1"
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != expectedLocation {
+		t.Errorf("Failed %s: expected location %s, but got %s", "TestPostReservationsCalendar", expectedLocation, actualLoc.String())
+	}
+}
+
+func TestRoom(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+
+	req, _ := http.NewRequest("GET", "/rooms/majors-suite", nil)
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Major&#39;s Suite") {
+		t.Error("room page does not show the room name")
+	}
+	if !strings.Contains(rr.Body.String(), "/static/images/majors-suite.png") || !strings.Contains(rr.Body.String(), "Sleeps 2") {
+		t.Error("room page does not show the room's photo and details")
+	}
+
+	// the old address moves to the room's page
+	req, _ = http.NewRequest("GET", "/majors-suite", nil)
+	rr = httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/rooms/majors-suite" {
+		t.Errorf("expected a %d redirect to /rooms/majors-suite, but got %d to %q", http.StatusMovedPermanently, rr.Code, rr.Header().Get("Location"))
+	}
+
+	// an inactive room has no page
+	_ = testDB.SetRoomActive(context.Background(), 2, false)
+	req, _ = http.NewRequest("GET", "/rooms/majors-suite", nil)
+	rr = httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("inactive room: expected code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestBookRoomInactive(t *testing.T) {
+	resetDB()
+	_ = testDB.SetRoomActive(context.Background(), 1, false)
+
+	req, _ := http.NewRequest("GET", "/book-room?id=1&s=2050-01-01&e=2050-01-02", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.BookRoom)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("expected a %d redirect to /search-availability, but got %d to %q", http.StatusSeeOther, rr.Code, rr.Header().Get("Location"))
+	}
+	if session.GetString(ctx, "error") == "" {
+		t.Error("no error message for an inactive room")
+	}
+}
+
+func TestAdminPostNewRoom(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+
+	var tests = []struct {
+		name             string
+		roomName         string
+		slug             string
+		maxOccupancy     string
+		nightlyRate      string
+		expectedCode     int
+		expectedHTML     string
+		expectedLocation string
+	}{
+		{"missing name", "", "", "2", "120", http.StatusSeeOther, "This field cannot be blank", ""},
+		{"invalid slug", "Colonel's Room", "Colonel Room", "2", "120", http.StatusSeeOther, "Use lowercase letters, numbers and dashes only!", ""},
+		{"duplicate slug", "Another Suite", "majors-suite", "2", "120", http.StatusSeeOther, "Another room already uses this slug", ""},
+		{"missing occupancy", "Colonel's Room", "", "", "120", http.StatusSeeOther, "This field cannot be blank", ""},
+		{"invalid occupancy", "Colonel's Room", "", "0", "120", http.StatusSeeOther, "Enter a whole number from 1 to 20!", ""},
+		{"missing rate", "Colonel's Room", "", "4", "", http.StatusSeeOther, "This field cannot be blank", ""},
+		{"invalid rate", "Colonel's Room", "", "4", "12.345", http.StatusSeeOther, "Enter an amount like 125 or 99.50!", ""},
+		{"slug from name", "Colonel's Room", "", "4", "$1,120.50", http.StatusSeeOther, "", "/admin/rooms"},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("room_name", e.roomName)
+		postData.Add("slug", e.slug)
+		postData.Add("max_occupancy", e.maxOccupancy)
+		postData.Add("nightly_rate", e.nightlyRate)
+		postData.Add("bed_configuration", "2 queen beds")
+		postData.Add("amenities", "Wi-Fi\r\n\r\nFireplace\r\nwi-fi\r\n")
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/new", strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q in the page", e.name, e.expectedHTML)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+	}
+
+	room, err := testDB.GetRoomBySlug(context.Background(), "colonels-room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rooms, _ := testDB.AllRooms(context.Background())
+	if !room.Active || rooms[len(rooms)-1].ID != room.ID {
+		t.Errorf("new room should be active and listed last, got %+v", room)
+	}
+	if room.MaxOccupancy != 4 || room.BedConfiguration != "2 queen beds" || strings.Join(room.Amenities, ",") != "Wi-Fi,Fireplace" {
+		t.Errorf("got details %d, %q, %v, wanted 4, 2 queen beds, [Wi-Fi Fireplace]", room.MaxOccupancy, room.BedConfiguration, room.Amenities)
+	}
+	if room.NightlyRate != 112050 {
+		t.Errorf("got nightly rate %d, wanted 112050", room.NightlyRate)
+	}
+}
+
+func TestAdminPostRoom(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+
+	var tests = []struct {
+		name             string
+		url              string
+		roomName         string
+		slug             string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"duplicate slug", "/admin/rooms/1", "General's Quarters", "majors-suite", http.StatusSeeOther, ""},
+		{"missing room", "/admin/rooms/99", "Nowhere", "nowhere", http.StatusNotFound, ""},
+		{"rename", "/admin/rooms/1", "General's Lodge", "generals-lodge", http.StatusSeeOther, "/admin/rooms"},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("room_name", e.roomName)
+		postData.Add("slug", e.slug)
+		postData.Add("max_occupancy", "3")
+		postData.Add("nightly_rate", "95")
+		postData.Add("description", "Quiet and bright")
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+	}
+
+	room, _ := testDB.GetRoomByID(context.Background(), 1)
+	if room.RoomName != "General's Lodge" || room.Slug != "generals-lodge" {
+		t.Errorf("got room %q with slug %q, wanted General's Lodge with slug generals-lodge", room.RoomName, room.Slug)
+	}
+	if room.MaxOccupancy != 3 || room.NightlyRate != 9500 || room.Description != "Quiet and bright" || len(room.Photos) != 1 {
+		t.Errorf("got room %+v, wanted it to sleep 3 for $95.00, the new description and its photo kept", room)
+	}
+}
+
+func TestAdminRoomPhotos(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+
+	var tests = []struct {
+		name             string
+		url              string
+		path             string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"add", "/admin/rooms/1/photos", "/static/images/tray.png", http.StatusSeeOther, "/admin/rooms/1"},
+		{"add without address", "/admin/rooms/1/photos", "tray.png", http.StatusSeeOther, "/admin/rooms/1"},
+		{"move to the front", "/admin/rooms/1/photos/3/move/up", "", http.StatusSeeOther, "/admin/rooms/1"},
+		{"remove", "/admin/rooms/1/photos/1/delete", "", http.StatusSeeOther, "/admin/rooms/1"},
+		{"remove another room's photo", "/admin/rooms/1/photos/2/delete", "", http.StatusNotFound, ""},
+		{"move in a missing room", "/admin/rooms/99/photos/1/move/up", "", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("path", e.path)
+		postData.Add("caption", "Breakfast")
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+	}
+
+	room, _ := testDB.GetRoomByID(context.Background(), 1)
+	if len(room.Photos) != 1 || room.Photos[0].Path != "/static/images/tray.png" || room.Photos[0].Caption != "Breakfast" {
+		t.Errorf("got photos %+v, wanted only the added tray.png", room.Photos)
+	}
+}
+
+func TestAdminUploadRoomPhoto(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+
+	img := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	var photo bytes.Buffer
+	_ = png.Encode(&photo, img)
+
+	var tests = []struct {
+		name             string
+		url              string
+		file             []byte
+		expectedCode     int
+		expectedLocation string
+		expectedError    string
+	}{
+		{"upload", "/admin/rooms/1/photos/upload", photo.Bytes(), http.StatusSeeOther, "/admin/rooms/1", ""},
+		{"not an image", "/admin/rooms/1/photos/upload", []byte("just some text"), http.StatusSeeOther, "/admin/rooms/1", "Photos must be JPEG, PNG, GIF or WebP images"},
+		{"no file", "/admin/rooms/1/photos/upload", nil, http.StatusSeeOther, "/admin/rooms/1", "Choose a photo to upload"},
+		{"missing room", "/admin/rooms/99/photos/upload", photo.Bytes(), http.StatusNotFound, "", ""},
+	}
+
+	for _, e := range tests {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		_ = writer.WriteField("caption", "The view")
+		if e.file != nil {
+			part, _ := writer.CreateFormFile("photo", "room.png")
+			_, _ = part.Write(e.file)
+		}
+		_ = writer.Close()
+
+		req, _ := http.NewRequest("POST", e.url, &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q, but got %q", e.name, e.expectedError, msg)
+		}
+	}
+
+	room, _ := testDB.GetRoomByID(context.Background(), 1)
+	if len(room.Photos) != 2 {
+		t.Fatalf("got %d photos, wanted the seeded one and the upload", len(room.Photos))
+	}
+	uploaded := room.Photos[1]
+	if uploaded.Caption != "The view" || !strings.HasPrefix(uploaded.Path, "/uploads/") || !strings.HasPrefix(uploaded.ThumbnailPath, "/uploads/") {
+		t.Errorf("got photo %+v, wanted the upload's copies under /uploads/", uploaded)
+	}
+	files := []string{uploaded.OriginalPath, uploaded.Path, uploaded.ThumbnailPath}
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join(app.UploadDir, strings.TrimPrefix(file, "/uploads/"))); err != nil {
+			t.Errorf("file %s was not stored: %v", file, err)
+		}
+	}
+
+	// removing the photo removes its files
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/rooms/1/photos/%d/delete", uploaded.ID), nil)
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("delete: expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join(app.UploadDir, strings.TrimPrefix(file, "/uploads/"))); !os.IsNotExist(err) {
+			t.Errorf("file %s was not removed", file)
+		}
+	}
+}
+
+func TestUploads(t *testing.T) {
+	routes := getRoutes()
+
+	for _, name := range []string{"upload.png", "upload-web.jpg", "upload-thumb.jpg"} {
+		if err := os.WriteFile(filepath.Join(app.UploadDir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		for _, name := range []string{"upload.png", "upload-web.jpg", "upload-thumb.jpg"} {
+			_ = os.Remove(filepath.Join(app.UploadDir, name))
+		}
+	}()
+
+	var tests = []struct {
+		name         string
+		url          string
+		expectedCode int
+	}{
+		{"directory", "/uploads/", http.StatusNotFound},
+		{"web copy", "/uploads/upload-web.jpg", http.StatusOK},
+		{"thumbnail", "/uploads/upload-thumb.jpg", http.StatusOK},
+		{"original", "/uploads/upload.png", http.StatusNotFound},
+		{"admin directory", "/admin/uploads/", http.StatusNotFound},
+		{"admin original", "/admin/uploads/upload.png", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if strings.Contains(rr.Body.String(), "upload.png") && e.expectedCode != http.StatusOK {
+			t.Errorf("%s: the response gives away the stored files", e.name)
+		}
+	}
+}
+
+func TestAdminSetRoomActive(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+
+	for _, active := range []string{"0", "1"} {
+		postData := url.Values{}
+		postData.Add("active", active)
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/2/active", strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("active=%s: expected code %d, but got %d", active, http.StatusSeeOther, rr.Code)
+		}
+
+		room, _ := testDB.GetRoomByID(context.Background(), 2)
+		if room.Active != (active == "1") {
+			t.Errorf("active=%s: room active is %t", active, room.Active)
+		}
+
+		rooms, _ := testDB.ActiveRooms(context.Background())
+		expected := 1
+		if active == "1" {
+			expected = 2
+		}
+		if len(rooms) != expected {
+			t.Errorf("active=%s: got %d active rooms, wanted %d", active, len(rooms), expected)
+		}
+	}
+}
+
+func TestAdminMoveRoom(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+
+	var tests = []struct {
+		name     string
+		url      string
+		expected []int
+	}{
+		{"down", "/admin/rooms/1/move/down", []int{2, 1}},
+		{"down past the end", "/admin/rooms/1/move/down", []int{2, 1}},
+		{"up", "/admin/rooms/1/move/up", []int{1, 2}},
+		{"up past the start", "/admin/rooms/1/move/up", []int{1, 2}},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		rooms, _ := testDB.AllRooms(context.Background())
+		var ids []int
+		for _, room := range rooms {
+			ids = append(ids, room.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(e.expected) {
+			t.Errorf("%s: got order %v, wanted %v", e.name, ids, e.expected)
+		}
+	}
+}
+
+// bookRoom stores a reservation and its room restriction in the test repository and returns its ID
+func bookRoom(t *testing.T, roomID int, start, end string) int {
+	startDate, _ := time.Parse("2006-01-02", start)
+	endDate, _ := time.Parse("2006-01-02", end)
+
+	id, err := testDB.InsertReservationWithRestriction(context.Background(), models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: startDate,
+		EndDate:   endDate,
+		RoomID:    roomID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func getCtx(req *http.Request) context.Context {
+	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
+	if err != nil {
+		log.Println(err)
+	}
+	return ctx
+}
+
+func TestMakeReservationRateRules(t *testing.T) {
+	resetDB()
+	start, _ := time.Parse("2006-01-02", "2050-07-14")
+	_, _ = testDB.InsertRateRule(context.Background(), models.RateRule{
+		Name:        "Festival",
+		NightlyRate: 25000,
+		StartDate:   start,
+		EndDate:     start,
+	})
+
+	// 2050-07-14 to 2050-07-16 is a festival night and a normal night in Major's Suite
+	reservation := models.Reservation{
+		RoomID:    2,
+		StartDate: start,
+		EndDate:   start.AddDate(0, 0, 2),
+	}
+
+	req, _ := http.NewRequest("GET", "/make-reservation", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.MakeReservation)
+	handler.ServeHTTP(rr, req)
+
+	for _, expected := range []string{"Festival", "$250.00", "$150.00", "$400.00"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("MakeReservation does not show %q in the breakdown", expected)
+		}
+	}
+
+	reqBody := url.Values{}
+	reqBody.Add("first_name", "Akihito")
+	reqBody.Add("last_name", "Shu")
+	reqBody.Add("email", "doantayd@gmail.com")
+	reqBody.Add("card_number", payments.FakeCardSuccess)
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+
+	saved, _ := session.Get(ctx, "reservation").(models.Reservation)
+	stored, _ := testDB.GetReservationByID(context.Background(), saved.ID)
+	if stored.TotalPrice != 40000 {
+		t.Errorf("PostReservation stored total price %d, wanted %d", stored.TotalPrice, 40000)
+	}
+}
+
+func TestAdminPostRateRules(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+
+	var tests = []struct {
+		name             string
+		url              string
+		ruleName         string
+		nightlyRate      string
+		priority         string
+		start            string
+		end              string
+		expectedCode     int
+		expectedHTML     string
+		expectedLocation string
+	}{
+		{"missing name", "/admin/rate-rules/new", "", "200", "0", "", "", http.StatusSeeOther, "This field cannot be blank", ""},
+		{"invalid rate", "/admin/rate-rules/new", "Summer", "lots", "0", "", "", http.StatusSeeOther, "Enter an amount like 125 or 99.50!", ""},
+		{"invalid priority", "/admin/rate-rules/new", "Summer", "200", "101", "", "", http.StatusSeeOther, "Enter a whole number from 0 to 100!", ""},
+		{"invalid date", "/admin/rate-rules/new", "Summer", "200", "0", "2050-02-30", "", http.StatusSeeOther, "Invalid date!", ""},
+		{"end before start", "/admin/rate-rules/new", "Summer", "200", "0", "2050-08-31", "2050-07-01", http.StatusSeeOther, "This date cannot be before the first date!", ""},
+		{"create", "/admin/rate-rules/new", "Summer", "200", "1", "2050-07-01", "2050-08-31", http.StatusSeeOther, "", "/admin/rate-rules"},
+		{"missing rule", "/admin/rate-rules/99", "Summer", "200", "1", "", "", http.StatusNotFound, "", ""},
+		{"edit", "/admin/rate-rules/1", "Summer weekends", "250", "2", "2050-07-01", "", http.StatusSeeOther, "", "/admin/rate-rules"},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("name", e.ruleName)
+		postData.Add("room_id", "2")
+		postData.Add("nightly_rate", e.nightlyRate)
+		postData.Add("priority", e.priority)
+		postData.Add("start_date", e.start)
+		postData.Add("end_date", e.end)
+		postData.Add("weekdays", "5")
+		postData.Add("weekdays", "6")
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q in the page", e.name, e.expectedHTML)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+	}
+
+	rule, err := testDB.GetRateRuleByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Name != "Summer weekends" || rule.RoomID != 2 || rule.NightlyRate != 25000 || rule.Priority != 2 {
+		t.Errorf("got rule %+v, wanted Summer weekends for room 2 at 25000 with priority 2", rule)
+	}
+	if rule.StartDate.Format("2006-01-02") != "2050-07-01" || !rule.EndDate.IsZero() || rule.Weekdays.String() != "Fri, Sat" {
+		t.Errorf("got rule from %v to %v on %v, wanted from 2050-07-01 on Fri, Sat", rule.StartDate, rule.EndDate, rule.Weekdays)
+	}
+
+	for _, e := range []struct {
+		url          string
+		expectedCode int
+	}{
+		{"/admin/rate-rules/1/delete", http.StatusSeeOther},
+		{"/admin/rate-rules/1/delete", http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("deleting %s: expected code %d, but got %d", e.url, e.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestPostReservationPromoCode(t *testing.T) {
+	resetDB()
+	yesterday := time.Now().AddDate(0, 0, -1)
+	for _, p := range []models.PromoCode{
+		{Code: "TENOFF", Kind: models.PromoPercent, PercentOff: 10, MinNights: 2},
+		{Code: "SUITE50", Kind: models.PromoFixed, AmountOff: 5000, RoomIDs: []int{2}},
+		{Code: "OLD", Kind: models.PromoPercent, PercentOff: 50, ValidUntil: yesterday},
+		{Code: "ONCE", Kind: models.PromoPercent, PercentOff: 50, MaxUses: 1},
+	} {
+		if _, err := testDB.InsertPromoCode(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+
+	var tests = []struct {
+		name         string
+		code         string
+		apply        bool
+		nights       int
+		expectedCode int
+		expectedHTML string
+		expectedPaid models.Money
+	}{
+		{"malformed code", "10% off", false, 2, http.StatusSeeOther, "Promo codes are 3 to 20 letters, numbers or dashes!", 0},
+		{"unknown code", "NOPE", false, 2, http.StatusSeeOther, "This promo code does not exist!", 0},
+		{"expired code", "old", false, 2, http.StatusSeeOther, "This promo code has expired!", 0},
+		{"other room", "SUITE50", false, 2, http.StatusSeeOther, "This promo code does not apply to this room!", 0},
+		{"stay too short", "TENOFF", false, 1, http.StatusSeeOther, "This promo code needs a stay of at least 2 nights!", 0},
+		{"apply", "tenoff", true, 2, http.StatusOK, "-$20.00", 0},
+		{"book", "tenoff", false, 2, http.StatusSeeOther, "", 18000},
+		{"book the last use", "ONCE", false, 2, http.StatusSeeOther, "", 10000},
+		{"used up", "ONCE", false, 2, http.StatusSeeOther, "This promo code has been used up!", 0},
+	}
+
+	for i, e := range tests {
+		// every booking gets its own dates, so none is refused for the room being taken
+		reservation := models.Reservation{
+			RoomID:    1,
+			StartDate: start.AddDate(0, 0, 5*i),
+			EndDate:   start.AddDate(0, 0, 5*i+e.nights),
+			Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		}
+
+		reqBody := url.Values{}
+		reqBody.Add("first_name", "Akihito")
+		reqBody.Add("last_name", "Shu")
+		reqBody.Add("email", "doantayd@gmail.com")
+		reqBody.Add("promo_code", e.code)
+		reqBody.Add("card_number", payments.FakeCardSuccess)
+		if e.apply {
+			reqBody.Add("apply", "1")
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "reservation", reservation)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q in the page", e.name, e.expectedHTML)
+		}
+
+		saved, _ := session.Get(ctx, "reservation").(models.Reservation)
+		if e.expectedPaid == 0 {
+			if saved.ID != 0 {
+				t.Errorf("%s: a reservation was made", e.name)
+			}
+			continue
+		}
+
+		stored, _ := testDB.GetReservationByID(context.Background(), saved.ID)
+		if stored.TotalPrice != e.expectedPaid || stored.PromoCode != models.NormalizePromoCode(e.code) ||
+			stored.Discount != 20000-e.expectedPaid {
+			t.Errorf("%s: stored %s off with %q for a total of %s, wanted a total of %s", e.name, stored.Discount, stored.PromoCode,
+				stored.TotalPrice, e.expectedPaid)
+		}
+	}
+
+	p, _ := testDB.GetPromoCodeByCode(context.Background(), "TENOFF")
+	if p.Uses != 1 {
+		t.Errorf("got %d uses of TENOFF, wanted only the booking to count", p.Uses)
+	}
+}
+
+func TestAdminPostPromoCodes(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+
+	var tests = []struct {
+		name             string
+		url              string
+		code             string
+		kind             string
+		percentOff       string
+		amountOff        string
+		validFrom        string
+		validUntil       string
+		expectedCode     int
+		expectedHTML     string
+		expectedLocation string
+	}{
+		{"missing code", "/admin/promo-codes/new", "", "percent", "10", "", "", "", http.StatusSeeOther, "This field cannot be blank", ""},
+		{"invalid code", "/admin/promo-codes/new", "10% off", "percent", "10", "", "", "", http.StatusSeeOther, "Promo codes are 3 to 20 letters, numbers or dashes!", ""},
+		{"invalid kind", "/admin/promo-codes/new", "SUMMER", "free", "", "", "", "", http.StatusSeeOther, "Choose how the code discounts a stay", ""},
+		{"invalid percentage", "/admin/promo-codes/new", "SUMMER", "percent", "120", "", "", "", http.StatusSeeOther, "Enter a whole number from 1 to 100!", ""},
+		{"missing amount", "/admin/promo-codes/new", "SUMMER", "fixed", "10", "", "", "", http.StatusSeeOther, "This field cannot be blank", ""},
+		{"until before from", "/admin/promo-codes/new", "SUMMER", "percent", "10", "", "2050-08-31", "2050-07-01", http.StatusSeeOther, "This date cannot be before the first date!", ""},
+		{"create", "/admin/promo-codes/new", "summer", "percent", "10", "", "2050-07-01", "2050-08-31", http.StatusSeeOther, "", "/admin/promo-codes"},
+		{"duplicate", "/admin/promo-codes/new", "SUMMER", "fixed", "", "25", "", "", http.StatusSeeOther, "Another promo code already uses this code", ""},
+		{"missing promo code", "/admin/promo-codes/99", "SUMMER", "percent", "10", "", "", "", http.StatusNotFound, "", ""},
+		{"edit", "/admin/promo-codes/1", "SUMMER-25", "fixed", "", "25", "2050-07-01", "", http.StatusSeeOther, "", "/admin/promo-codes"},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("code", e.code)
+		postData.Add("kind", e.kind)
+		postData.Add("percent_off", e.percentOff)
+		postData.Add("amount_off", e.amountOff)
+		postData.Add("valid_from", e.validFrom)
+		postData.Add("valid_until", e.validUntil)
+		postData.Add("max_uses", "50")
+		postData.Add("min_nights", "2")
+		postData.Add("room_ids", "2")
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q in the page", e.name, e.expectedHTML)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+	}
+
+	p, err := testDB.GetPromoCodeByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Code != "SUMMER-25" || p.Kind != models.PromoFixed || p.AmountOff != 2500 || p.PercentOff != 0 || p.MaxUses != 50 || p.MinNights != 2 {
+		t.Errorf("got promo code %+v, wanted SUMMER-25 for $25.00 off", p)
+	}
+	if p.ValidFrom.Format("2006-01-02") != "2050-07-01" || !p.ValidUntil.IsZero() || len(p.RoomIDs) != 1 || p.RoomIDs[0] != 2 {
+		t.Errorf("got promo code valid from %v to %v for rooms %v, wanted from 2050-07-01 for room 2", p.ValidFrom, p.ValidUntil, p.RoomIDs)
+	}
+
+	for _, e := range []struct {
+		url          string
+		expectedCode int
+	}{
+		{"/admin/promo-codes/1/delete", http.StatusSeeOther},
+		{"/admin/promo-codes/1/delete", http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("deleting %s: expected code %d, but got %d", e.url, e.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestPostReservationCharges(t *testing.T) {
+	resetDB()
+	for _, c := range []models.Charge{
+		{Name: "VAT", Method: models.ChargePercent, Rate: 1000, Per: models.PerStay, Active: true},
+		{Name: "City tax", Method: models.ChargeFlat, Amount: 250, Per: models.PerGuestNight, Active: true},
+		{Name: "Cleaning fee", Method: models.ChargeFlat, Amount: 5000, Per: models.PerStay},
+	} {
+		if _, err := testDB.InsertCharge(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+
+	var tests = []struct {
+		name         string
+		guests       string
+		apply        bool
+		expectedCode int
+		expectedHTML string
+		expectedPaid models.Money
+	}{
+		{"too many guests", "3", false, http.StatusSeeOther, "Enter a whole number from 1 to 2!", 0},
+		{"apply", "2", true, http.StatusOK, "$230.00", 0},
+		{"book for two", "2", false, http.StatusSeeOther, "", 23000},
+		{"book without guests", "", false, http.StatusSeeOther, "", 22500},
+	}
+
+	for i, e := range tests {
+		reservation := models.Reservation{
+			RoomID:    1,
+			StartDate: start.AddDate(0, 0, 5*i),
+			EndDate:   start.AddDate(0, 0, 5*i+2),
+			Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		}
+
+		reqBody := url.Values{}
+		reqBody.Add("first_name", "Akihito")
+		reqBody.Add("last_name", "Shu")
+		reqBody.Add("email", "doantayd@gmail.com")
+		reqBody.Add("card_number", payments.FakeCardSuccess)
+		if e.guests != "" {
+			reqBody.Add("guests", e.guests)
+		}
+		if e.apply {
+			reqBody.Add("apply", "1")
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "reservation", reservation)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q in the page", e.name, e.expectedHTML)
+		}
+
+		saved, _ := session.Get(ctx, "reservation").(models.Reservation)
+		if e.expectedPaid == 0 {
+			if saved.ID != 0 {
+				t.Errorf("%s: a reservation was made", e.name)
+			}
+			continue
+		}
+
+		// the room is $200.00 for two nights, with 10% VAT and $2.50 city tax per guest per night
+		stored, _ := testDB.GetReservationByID(context.Background(), saved.ID)
+		if stored.TotalPrice != e.expectedPaid || len(stored.Charges) != 2 || stored.Charges[0].Name != "VAT" ||
+			stored.Charges[0].Amount != 2000 || stored.RoomPrice() != 20000 {
+			t.Errorf("%s: stored a total of %s with charges %+v, wanted %s", e.name, stored.TotalPrice, stored.Charges, e.expectedPaid)
+		}
+	}
+
+	// the reservation page reads the listing it came from off the request URI
+	req, _ := http.NewRequest("GET", "/admin/reservations/all/1/show", nil)
+	req.RequestURI = "/admin/reservations/all/1/show"
+	rr := httptest.NewRecorder()
+	getRoutes().ServeHTTP(rr, req)
+	for _, want := range []string{"City tax: </strong> $10.00", "Guests: </strong> 2"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected to find %q on the admin reservation page", want)
+		}
+	}
+}
+
+func TestAdminPostCharges(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+
+	var tests = []struct {
+		name             string
+		url              string
+		chargeName       string
+		method           string
+		rate             string
+		amount           string
+		per              string
+		expectedCode     int
+		expectedHTML     string
+		expectedLocation string
+	}{
+		{"missing name", "/admin/charges/new", "", "percent", "7.5", "", "", http.StatusSeeOther, "This field cannot be blank", ""},
+		{"invalid method", "/admin/charges/new", "VAT", "free", "", "", "", http.StatusSeeOther, "Choose how the charge is worked out", ""},
+		{"invalid percentage", "/admin/charges/new", "VAT", "percent", "120", "", "", http.StatusSeeOther, "Enter a percentage like 10 or 7.5, up to 100!", ""},
+		{"invalid basis", "/admin/charges/new", "City tax", "flat", "", "2.50", "week", http.StatusSeeOther, "Choose what the amount is charged for", ""},
+		{"invalid amount", "/admin/charges/new", "City tax", "flat", "", "two", "guest_night", http.StatusSeeOther, "Enter an amount like 125 or 99.50!", ""},
+		{"create", "/admin/charges/new", "VAT", "percent", "7.5%", "", "night", http.StatusSeeOther, "", "/admin/charges"},
+		{"missing charge", "/admin/charges/99", "VAT", "percent", "7.5", "", "", http.StatusNotFound, "", ""},
+		{"edit", "/admin/charges/1", "City tax", "flat", "", "2.50", "guest_night", http.StatusSeeOther, "", "/admin/charges"},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("name", e.chargeName)
+		postData.Add("method", e.method)
+		postData.Add("rate", e.rate)
+		postData.Add("amount", e.amount)
+		postData.Add("per", e.per)
+		postData.Add("active", "1")
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q in the page", e.name, e.expectedHTML)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+
+		// percentages are charged per stay, whatever basis is posted
+		if e.name == "create" {
+			c, _ := testDB.GetChargeByID(context.Background(), 1)
+			if c.Rate != 750 || c.Per != models.PerStay {
+				t.Errorf("got charge %+v, wanted 7.5%% per stay", c)
+			}
+		}
+	}
+
+	c, err := testDB.GetChargeByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "City tax" || c.Method != models.ChargeFlat || c.Amount != 250 || c.Rate != 0 || c.Per != models.PerGuestNight || !c.Active {
+		t.Errorf("got charge %+v, wanted a city tax of $2.50 per guest per night", c)
+	}
+
+	for _, e := range []struct {
+		url          string
+		expectedCode int
+	}{
+		{"/admin/charges/1/delete", http.StatusSeeOther},
+		{"/admin/charges/1/delete", http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("deleting %s: expected code %d, but got %d", e.url, e.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestPostReservationPayment(t *testing.T) {
+	resetDB()
+	fake := app.Payments.(*payments.Fake)
+
+	start, _ := time.Parse("2006-01-02", "2050-09-01")
+
+	var tests = []struct {
+		name             string
+		card             string
+		expectedCode     int
+		expectedHTML     string
+		expectedLocation string
+		expectedStatus   models.PaymentStatus
+	}{
+		{"missing card", "", http.StatusSeeOther, "This field cannot be blank", "", ""},
+		{"incorrect number", "1234567812345678", http.StatusSeeOther, "Your card number is incorrect", "", ""},
+		{"declined", payments.FakeCardDecline, http.StatusSeeOther, "Your card was declined", "", ""},
+		{"authorized", payments.FakeCardSuccess, http.StatusSeeOther, "", "/reservation-summary", models.PaymentAuthorized},
+		{"challenge", payments.FakeCardChallenge, http.StatusSeeOther, "", payments.FakeChallengePath, models.PaymentPending},
+	}
+
+	for i, e := range tests {
+		reservation := models.Reservation{
+			RoomID:    1,
+			StartDate: start.AddDate(0, 0, 5*i),
+			EndDate:   start.AddDate(0, 0, 5*i+2),
+		}
+
+		reqBody := url.Values{}
+		reqBody.Add("first_name", "Akihito")
+		reqBody.Add("last_name", "Shu")
+		reqBody.Add("email", "doantayd@gmail.com")
+		reqBody.Add("card_number", e.card)
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "reservation", reservation)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q in the page", e.name, e.expectedHTML)
+		}
+		if loc := rr.Header().Get("Location"); !strings.HasPrefix(loc, e.expectedLocation) || (loc == "") != (e.expectedLocation == "") {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+
+		saved, _ := session.Get(ctx, "reservation").(models.Reservation)
+		stored, _ := testDB.PaymentsForReservation(context.Background(), saved.ID)
+		if e.expectedStatus == "" {
+			if saved.ID != 0 {
+				t.Errorf("%s: a reservation was made", e.name)
+			}
+			continue
+		}
+		if len(stored) != 1 || stored[0].Status != e.expectedStatus || stored[0].Amount != 20000 || stored[0].CardLast4 != e.card[12:] {
+			t.Errorf("%s: stored payments %+v, wanted one %s payment of $200.00", e.name, stored, e.expectedStatus)
+		}
+	}
+
+	// a guest who authenticates with their bank comes back to their summary, one who fails
+	// gets to try another card and the reservation is cancelled, giving back its promo code
+	promoID, _ := testDB.InsertPromoCode(context.Background(), models.PromoCode{Code: "ONCE", Kind: models.PromoFixed, AmountOff: 1000, MaxUses: 1})
+	for i, outcome := range []string{"approve", "fail"} {
+		reservation := models.Reservation{
+			RoomID:    2,
+			StartDate: start.AddDate(0, 0, 5*i),
+			EndDate:   start.AddDate(0, 0, 5*i+1),
+		}
+
+		reqBody := url.Values{}
+		reqBody.Add("first_name", "Akihito")
+		reqBody.Add("last_name", "Shu")
+		reqBody.Add("email", "doantayd@gmail.com")
+		reqBody.Add("card_number", payments.FakeCardChallenge)
+		if outcome == "fail" {
+			reqBody.Add("promo_code", "ONCE")
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "reservation", reservation)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+		bankURL := rr.Header().Get("Location")
+		req, _ = http.NewRequest("POST", bankURL, strings.NewReader(url.Values{"outcome": {outcome}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr = httptest.NewRecorder()
+		fake.ServeHTTP(rr, req)
+
+		returnURL := rr.Header().Get("Location")
+		req, _ = http.NewRequest("GET", returnURL, nil)
+		req = req.WithContext(ctx)
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(Repo.PaymentReturn).ServeHTTP(rr, req)
+
+		id := session.Get(ctx, "reservation").(models.Reservation).ID
+		if outcome == "approve" {
+			stored, _ := testDB.GetReservationByID(context.Background(), id)
+			paid, _ := testDB.PaymentsForReservation(context.Background(), id)
+			if loc := rr.Header().Get("Location"); loc != "/reservation-summary" {
+				t.Errorf("approve: expected location %q, but got %q", "/reservation-summary", loc)
+			}
+			if stored.ConfirmationCode == "" || len(paid) != 1 || paid[0].Status != models.PaymentAuthorized {
+				t.Errorf("approve: got reservation %d with payments %+v", id, paid)
+			}
+			continue
+		}
+
+		if loc := rr.Header().Get("Location"); loc != "/make-reservation" {
+			t.Errorf("fail: expected location %q, but got %q", "/make-reservation", loc)
+		}
+		if id != 0 {
+			t.Errorf("fail: reservation %d is still in the session", id)
+		}
+		if msg := session.GetString(ctx, "error"); !strings.Contains(msg, "Your bank could not authenticate the payment") {
+			t.Errorf("fail: got error %q", msg)
+		}
+		if available, _ := testDB.SearchAvailabilityByDatesByRoomID(context.Background(), reservation.StartDate, reservation.EndDate, 2); !available {
+			t.Error("fail: the room is still held for a declined payment")
+		}
+		if promo, _ := testDB.GetPromoCodeByID(context.Background(), promoID); promo.Uses != 0 {
+			t.Errorf("fail: got %d uses of the promo code, wanted the declined booking's use given back", promo.Uses)
+		}
+	}
+}
+
+func TestPostReservationNoNights(t *testing.T) {
+	resetDB()
+
+	start, _ := time.Parse("2006-01-02", "2050-09-01")
+	for _, end := range []time.Time{start, start.AddDate(0, 0, -2)} {
+		reqBody := url.Values{}
+		reqBody.Add("first_name", "Akihito")
+		reqBody.Add("last_name", "Shu")
+		reqBody.Add("email", "doantayd@gmail.com")
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "reservation", models.Reservation{RoomID: 1, StartDate: start, EndDate: end})
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); rr.Code != http.StatusSeeOther || loc != "/search-availability" {
+			t.Errorf("ending %s: got %d to %q, wanted the guest sent back to search", end.Format("2006-01-02"), rr.Code, loc)
+		}
+	}
+
+	if page, _ := testDB.ListReservations(context.Background(), models.ReservationQuery{}); page.Total != 0 {
+		t.Errorf("got %d reservations, wanted none for stays of no nights", page.Total)
+	}
+}
+
+func TestExpirePendingPayments(t *testing.T) {
+	resetDB()
+	ctx := context.Background()
+	fake := app.Payments.(*payments.Fake)
+
+	id := bookRoom(t, 1, "2050-09-01", "2050-09-03")
+	auth, _ := fake.Authorize(ctx, payments.AuthorizeRequest{Amount: 20000, Card: payments.FakeCardChallenge})
+	_, _ = testDB.InsertPayment(ctx, models.Payment{
+		ReservationID: id, Provider: fake.Name(), Reference: auth.Reference, Status: models.PaymentPending, Amount: 20000,
+	})
+
+	// payments made after the cutoff are left to the guest
+	if err := Repo.ExpirePendingPayments(ctx, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := testDB.GetPaymentByReference(ctx, fake.Name(), auth.Reference); p.Status != models.PaymentPending {
+		t.Errorf("got status %s for a recent payment, wanted it still pending", p.Status)
+	}
+
+	if err := Repo.ExpirePendingPayments(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := testDB.GetPaymentByReference(ctx, fake.Name(), auth.Reference); p.Status != models.PaymentDeclined || p.FailureReason == "" {
+		t.Errorf("got status %s %q for an expired payment, wanted it declined", p.Status, p.FailureReason)
+	}
+	if held, _ := fake.Lookup(ctx, auth.Reference); held.Status != models.PaymentRefunded {
+		t.Errorf("got status %s at the provider, wanted the payment released", held.Status)
+	}
+	if res, _ := testDB.GetReservationByID(ctx, id); res.Status != models.StatusCancelled {
+		t.Errorf("got reservation status %s, wanted it cancelled", res.Status)
+	}
+	start, _ := time.Parse("2006-01-02", "2050-09-01")
+	if available, _ := testDB.SearchAvailabilityByDatesByRoomID(ctx, start, start.AddDate(0, 0, 2), 1); !available {
+		t.Error("the room is still held for an expired payment")
+	}
+
+	testDB.FailOn("PendingPayments", errors.New("some errors"))
+	if err := Repo.ExpirePendingPayments(ctx, time.Now()); err == nil {
+		t.Error("expected an error when pending payments cannot be listed")
+	}
+}
+
+func TestPostReservationPaymentNotRecorded(t *testing.T) {
+	resetDB()
+	fake := app.Payments.(*payments.Fake)
+
+	// the fake numbers its payments in order, so the next one is known
+	last, _ := fake.Authorize(context.Background(), payments.AuthorizeRequest{Amount: 100, Card: payments.FakeCardSuccess})
+	var n int
+	fmt.Sscanf(last.Reference, "fake_%d", &n)
+	next := fmt.Sprintf("fake_%d", n+1)
+
+	start, _ := time.Parse("2006-01-02", "2050-09-01")
+	reservation := models.Reservation{RoomID: 1, StartDate: start, EndDate: start.AddDate(0, 0, 2)}
+	testDB.FailOn("InsertPayment", errors.New("some errors"))
+
+	reqBody := url.Values{}
+	reqBody.Add("first_name", "Akihito")
+	reqBody.Add("last_name", "Shu")
+	reqBody.Add("email", "doantayd@gmail.com")
+	reqBody.Add("card_number", payments.FakeCardSuccess)
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("expected code %d, but got %d", http.StatusTemporaryRedirect, rr.Code)
+	}
+	if auth, _ := fake.Lookup(context.Background(), next); auth.Status != models.PaymentRefunded {
+		t.Errorf("got payment status %s, wanted the hold on the card released", auth.Status)
+	}
+	if available, _ := testDB.SearchAvailabilityByDatesByRoomID(context.Background(), reservation.StartDate, reservation.EndDate, 1); !available {
+		t.Error("the room is still held for a reservation without its payment")
+	}
+	page, _ := testDB.ListReservations(context.Background(), models.ReservationQuery{Status: models.StatusCancelled})
+	if page.Total != 1 {
+		t.Errorf("got %d cancelled reservations, wanted the unpaid one cancelled", page.Total)
+	}
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+	fake := app.Payments.(*payments.Fake)
+
+	start, _ := time.Parse("2006-01-02", "2050-10-01")
+	id, _ := testDB.InsertReservation(context.Background(), models.Reservation{
+		FirstName: "Akihito", LastName: "Shu", Email: "doantayd@gmail.com",
+		RoomID: 1, StartDate: start, EndDate: start.AddDate(0, 0, 1), TotalPrice: 10000,
+	})
+	_, _ = testDB.InsertPayment(context.Background(), models.Payment{
+		ReservationID: id, Provider: fake.Name(), Reference: "fake_9", Status: models.PaymentCaptured, Amount: 10000,
+	})
+
+	partial, partialSignature := fake.Webhook(payments.Event{Reference: "fake_9", Status: models.PaymentCaptured, Refunded: 4000})
+	body, signature := fake.Webhook(payments.Event{Reference: "fake_9", Status: models.PaymentRefunded, Refunded: 10000})
+	authorized, authorizedSignature := fake.Webhook(payments.Event{Reference: "fake_9", Status: models.PaymentAuthorized})
+	unknown, unknownSignature := fake.Webhook(payments.Event{Reference: "fake_99", Status: models.PaymentRefunded})
+
+	var tests = []struct {
+		name         string
+		body         []byte
+		signature    string
+		expectedCode int
+	}{
+		{"bad signature", body, "00", http.StatusBadRequest},
+		{"unknown payment", unknown, unknownSignature, http.StatusNotFound},
+		{"partly refunded", partial, partialSignature, http.StatusNoContent},
+		{"refunded", body, signature, http.StatusNoContent},
+		{"replayed", body, signature, http.StatusNoContent},
+		{"out of order", partial, partialSignature, http.StatusConflict},
+		{"authorized again", authorized, authorizedSignature, http.StatusConflict},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(string(e.body)))
+		req.Header.Set(payments.FakeSignatureHeader, e.signature)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+
+	p, _ := testDB.GetPaymentByReference(context.Background(), fake.Name(), "fake_9")
+	if p.Status != models.PaymentRefunded || p.Refunded != 10000 {
+		t.Errorf("got payment %+v, wanted it refunded in full", p)
+	}
+}
+
+func TestPaymentWebhookCapture(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+	fake := app.Payments.(*payments.Fake)
+	ctx := context.Background()
+
+	start, _ := time.Parse("2006-01-02", "2050-10-05")
+	id, _ := testDB.InsertReservation(ctx, models.Reservation{
+		FirstName: "Akihito", LastName: "Shu", Email: "doantayd@gmail.com",
+		RoomID: 1, StartDate: start, EndDate: start.AddDate(0, 0, 1), TotalPrice: 10000,
+	})
+	auth, _ := fake.Authorize(ctx, payments.AuthorizeRequest{Amount: 10000, Card: payments.FakeCardSuccess})
+	_, _ = testDB.InsertPayment(ctx, models.Payment{
+		ReservationID: id, Provider: fake.Name(), Reference: auth.Reference, Status: models.PaymentAuthorized, Amount: 10000,
+	})
+
+	// the provider took part of the payment, then reports more refunded than it took
+	_ = fake.Capture(ctx, auth.Reference, 6000)
+	for _, event := range []payments.Event{
+		{Reference: auth.Reference, Status: models.PaymentCaptured},
+		{Reference: auth.Reference, Status: models.PaymentRefunded, Refunded: 10000},
+	} {
+		body, signature := fake.Webhook(event)
+		req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(string(body)))
+		req.Header.Set(payments.FakeSignatureHeader, signature)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("%s: expected code %d, but got %d", event.Status, http.StatusNoContent, rr.Code)
+		}
+		if event.Status == models.PaymentCaptured {
+			p, _ := testDB.GetPaymentByReference(ctx, fake.Name(), auth.Reference)
+			if p.Amount != 6000 {
+				t.Errorf("got a captured amount of %d, wanted the 6000 the provider took", p.Amount)
+			}
+		}
+	}
+
+	p, _ := testDB.GetPaymentByReference(ctx, fake.Name(), auth.Reference)
+	if p.Status != models.PaymentRefunded || p.Amount != 6000 || p.Refunded != 6000 || p.Refundable() != 0 {
+		t.Errorf("got payment %+v, wanted 6000 taken and refunded", p)
+	}
+}
+
+// TestPaymentWebhookBeforeReturn covers the provider's webhook arriving before the guest is back from their bank
+func TestPaymentWebhookBeforeReturn(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+	fake := app.Payments.(*payments.Fake)
+
+	start, _ := time.Parse("2006-01-02", "2050-11-01")
+	for i, outcome := range []string{"approve", "fail"} {
+		reservation := models.Reservation{
+			RoomID:    2,
+			StartDate: start.AddDate(0, 0, 5*i),
+			EndDate:   start.AddDate(0, 0, 5*i+1),
+		}
+
+		reqBody := url.Values{}
+		reqBody.Add("first_name", "Akihito")
+		reqBody.Add("last_name", "Shu")
+		reqBody.Add("email", "doantayd@gmail.com")
+		reqBody.Add("card_number", payments.FakeCardChallenge)
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "reservation", reservation)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+		id := session.Get(ctx, "reservation").(models.Reservation).ID
+
+		bankURL := rr.Header().Get("Location")
+		req, _ = http.NewRequest("POST", bankURL, strings.NewReader(url.Values{"outcome": {outcome}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr = httptest.NewRecorder()
+		fake.ServeHTTP(rr, req)
+		returnURL := rr.Header().Get("Location")
+
+		reference := strings.TrimPrefix(bankURL, payments.FakeChallengePath)
+		auth, _ := fake.Lookup(context.Background(), reference)
+		body, signature := fake.Webhook(payments.Event{Reference: reference, Status: auth.Status})
+		req, _ = http.NewRequest("POST", "/payments/webhook", strings.NewReader(string(body)))
+		req.Header.Set(payments.FakeSignatureHeader, signature)
+		rr = httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("%s: webhook returned code %d", outcome, rr.Code)
+		}
+
+		available, _ := testDB.SearchAvailabilityByDatesByRoomID(context.Background(), reservation.StartDate, reservation.EndDate, 2)
+		if outcome == "fail" {
+			stored, _ := testDB.GetReservationByID(context.Background(), id)
+			if !available || stored.Status != models.StatusCancelled {
+				t.Errorf("fail: got reservation %s with the room available %v after a declined webhook", stored.Status, available)
+			}
+		} else if available {
+			t.Error("approve: the room was freed by an authorized webhook")
+		}
+
+		req, _ = http.NewRequest("GET", returnURL, nil)
+		req = req.WithContext(ctx)
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(Repo.PaymentReturn).ServeHTTP(rr, req)
+
+		if outcome == "approve" {
+			if loc := rr.Header().Get("Location"); loc != "/reservation-summary" {
+				t.Errorf("approve: expected location %q, but got %q", "/reservation-summary", loc)
+			}
+			if res := session.Get(ctx, "reservation").(models.Reservation); res.ConfirmationCode == "" {
+				t.Error("approve: the guest was not given their confirmation code")
+			}
+			continue
+		}
+
+		if loc := rr.Header().Get("Location"); loc != "/make-reservation" {
+			t.Errorf("fail: expected location %q, but got %q", "/make-reservation", loc)
+		}
+		if msg := session.GetString(ctx, "error"); !strings.Contains(msg, "Your bank could not authenticate the payment") {
+			t.Errorf("fail: got error %q", msg)
+		}
+	}
+}
+
+func TestAdminDeleteReservation(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+	ctx := context.Background()
+
+	start, _ := time.Parse("2006-01-02", "2050-10-01")
+	unpaid, _ := testDB.InsertReservation(ctx, models.Reservation{
+		FirstName: "Akihito", LastName: "Shu", Email: "doantayd@gmail.com",
+		RoomID: 1, StartDate: start, EndDate: start.AddDate(0, 0, 1),
+	})
+	paid, _ := testDB.InsertReservation(ctx, models.Reservation{
+		FirstName: "Akihito", LastName: "Shu", Email: "doantayd@gmail.com",
+		RoomID: 2, StartDate: start, EndDate: start.AddDate(0, 0, 1), TotalPrice: 10000,
+	})
+	_, _ = testDB.InsertPayment(ctx, models.Payment{
+		ReservationID: paid, Provider: "fake", Reference: "fake_9", Status: models.PaymentAuthorized, Amount: 10000,
+	})
+
+	var tests = []struct {
+		name             string
+		id               int
+		fail             bool
+		expectedCode     int
+		expectedLocation string
+		expectedDeleted  bool
+	}{
+		{"with payments", paid, false, http.StatusSeeOther, fmt.Sprintf("/admin/reservations/all/%d/show", paid), false},
+		{"database error", unpaid, true, http.StatusInternalServerError, "", false},
+		{"without payments", unpaid, false, http.StatusSeeOther, "/admin/reservations-all", true},
+	}
+
+	for _, e := range tests {
+		if e.fail {
+			testDB.FailOn("DeleteReservation", errors.New("some errors"))
+		}
+
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/delete-reservation/all/%d/do", e.id), nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		testDB.ClearFailures()
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+		if _, err := testDB.GetReservationByID(ctx, e.id); (err != nil) != e.expectedDeleted {
+			t.Errorf("%s: got error %v getting the reservation, wanted it deleted: %t", e.name, err, e.expectedDeleted)
+		}
+	}
+
+	if stored, _ := testDB.PaymentsForReservation(ctx, paid); len(stored) != 1 {
+		t.Errorf("got %d payments for the reservation, wanted 1", len(stored))
+	}
+}
+
+func TestAdminCaptureRefundPayment(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+	fake := app.Payments.(*payments.Fake)
+	ctx := context.Background()
+
+	start, _ := time.Parse("2006-01-02", "2050-11-01")
+	id, _ := testDB.InsertReservation(ctx, models.Reservation{
+		FirstName: "Akihito", LastName: "Shu", Email: "doantayd@gmail.com",
+		RoomID: 1, StartDate: start, EndDate: start.AddDate(0, 0, 1), TotalPrice: 10000,
+	})
+	auth, _ := fake.Authorize(ctx, payments.AuthorizeRequest{Amount: 10000, Card: payments.FakeCardSuccess})
+	paymentID, _ := testDB.InsertPayment(ctx, models.Payment{
+		ReservationID: id, Provider: fake.Name(), Reference: auth.Reference, Status: auth.Status, Amount: 10000,
+	})
+
+	base := fmt.Sprintf("/all/%d/%d", id, paymentID)
+	showURL := fmt.Sprintf("/admin/reservations/all/%d/show", id)
+
+	var tests = []struct {
+		name             string
+		url              string
+		amount           string
+		expectedCode     int
+		expectedLocation string
+		expectedStatus   models.PaymentStatus
+		expectedRefunded models.Money
+	}{
+		{"missing payment", fmt.Sprintf("/admin/capture-payment/all/%d/99", id), "", http.StatusNotFound, "", models.PaymentAuthorized, 0},
+		{"capture", "/admin/capture-payment" + base, "", http.StatusSeeOther, showURL, models.PaymentCaptured, 0},
+		{"capture twice", "/admin/capture-payment" + base, "", http.StatusSeeOther, showURL, models.PaymentCaptured, 0},
+		{"refund too much", "/admin/refund-payment" + base, "120", http.StatusSeeOther, showURL, models.PaymentCaptured, 0},
+		{"refund part", "/admin/refund-payment" + base, "30", http.StatusSeeOther, showURL, models.PaymentCaptured, 3000},
+		{"refund the rest", "/admin/refund-payment" + base, "", http.StatusSeeOther, showURL, models.PaymentRefunded, 10000},
+		{"nothing left", "/admin/refund-payment" + base, "", http.StatusSeeOther, showURL, models.PaymentRefunded, 10000},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("amount", e.amount)
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+
+		p, _ := testDB.GetPaymentByReference(ctx, fake.Name(), auth.Reference)
+		if p.Status != e.expectedStatus || p.Refunded != e.expectedRefunded {
+			t.Errorf("%s: got payment %s with %s refunded, wanted %s with %s", e.name, p.Status, p.Refunded, e.expectedStatus, e.expectedRefunded)
+		}
+	}
+
+	// the reservation page lists the payment
+	req, _ := http.NewRequest("GET", showURL, nil)
+	req.RequestURI = showURL
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), auth.Reference) || !strings.Contains(rr.Body.String(), "Refunded") {
+		t.Errorf("expected to find the refunded payment %s on the admin reservation page", auth.Reference)
+	}
+}
+
+func TestAdminPostCancellationPolicies(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+
+	var tests = []struct {
+		name             string
+		url              string
+		policyName       string
+		freeDays         string
+		penalty          string
+		expectedCode     int
+		expectedHTML     string
+		expectedLocation string
+	}{
+		{"missing name", "/admin/cancellation-policies/new", "", "7", "50", http.StatusSeeOther, "This field cannot be blank", ""},
+		{"invalid days", "/admin/cancellation-policies/new", "Flexible", "-1", "50", http.StatusSeeOther, "Enter a whole number from 0 to 365!", ""},
+		{"invalid penalty", "/admin/cancellation-policies/new", "Flexible", "7", "150", http.StatusSeeOther, "Enter a percentage like 10 or 7.5, up to 100!", ""},
+		{"create", "/admin/cancellation-policies/new", "Flexible", "7", "50%", http.StatusSeeOther, "", "/admin/cancellation-policies"},
+		{"missing policy", "/admin/cancellation-policies/99", "Flexible", "7", "50", http.StatusNotFound, "", ""},
+		{"edit", "/admin/cancellation-policies/1", "Non-refundable", "0", "100", http.StatusSeeOther, "", "/admin/cancellation-policies"},
+	}
+
+	for _, e := range tests {
+		postData := url.Values{}
+		postData.Add("name", e.policyName)
+		postData.Add("free_days", e.freeDays)
+		postData.Add("penalty", e.penalty)
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q in the page", e.name, e.expectedHTML)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+	}
+
+	p, err := testDB.GetCancellationPolicyByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Non-refundable" || p.FreeDays != 0 || p.Penalty != models.FullPenalty {
+		t.Errorf("got policy %+v, wanted a non-refundable one", p)
+	}
+
+	// rooms can be given the policy
+	room, _ := testDB.GetRoomByID(context.Background(), 1)
+	postData := url.Values{}
+	postData.Add("room_name", room.RoomName)
+	postData.Add("slug", room.Slug)
+	postData.Add("max_occupancy", "2")
+	postData.Add("nightly_rate", "100")
+	postData.Add("cancellation_policy_id", "1")
+
+	req, _ := http.NewRequest("POST", "/admin/rooms/1", strings.NewReader(postData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+
+	room, _ = testDB.GetRoomByID(context.Background(), 1)
+	if room.CancellationPolicyID != 1 {
+		t.Errorf("got room with cancellation policy %d, wanted 1", room.CancellationPolicyID)
 	}
 
-	// test case: reservation is empty
-	req, _ = http.NewRequest("GET", "/choose-room", nil)
-	req.RequestURI = "/choose-room/1"
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	rr = httptest.NewRecorder()
+	for _, e := range []struct {
+		url          string
+		expectedCode int
+	}{
+		{"/admin/cancellation-policies/1/delete", http.StatusSeeOther},
+		{"/admin/cancellation-policies/1/delete", http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
 
-	handler = http.HandlerFunc(Repo.ChooseRoom)
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("ChooseRoom handler returned wrong response code when reservation is empty: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+		if rr.Code != e.expectedCode {
+			t.Errorf("deleting %s: expected code %d, but got %d", e.url, e.expectedCode, rr.Code)
+		}
 	}
 
-	// test case: url param is wrong
-	req, _ = http.NewRequest("GET", "/choose-room", nil)
-	req.RequestURI = "/choose-room/a"
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.ChooseRoom)
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("ChooseRoom handler returned wrong response code when url param is wrong: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	room, _ = testDB.GetRoomByID(context.Background(), 1)
+	if room.CancellationPolicyID != 0 {
+		t.Errorf("room kept deleted cancellation policy %d", room.CancellationPolicyID)
 	}
 }
 
-func TestBookRoom(t *testing.T) {
-	req, _ := http.NewRequest("GET", "/book-room?id=1&s=2050-01-01&e=2050-01-02", nil)
-	ctx := getCtx(req)
-	req = req.WithContext(ctx)
-	rr := httptest.NewRecorder()
+func TestCancellationPolicyBooking(t *testing.T) {
+	resetDB()
+	ctx := context.Background()
 
-	handler := http.HandlerFunc(Repo.BookRoom)
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("BookRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	policyID, _ := testDB.InsertCancellationPolicy(ctx, models.CancellationPolicy{Name: "Moderate", FreeDays: 7, Penalty: 5000})
+	room, _ := testDB.GetRoomByID(ctx, 1)
+	room.CancellationPolicyID = policyID
+	_ = testDB.UpdateRoom(ctx, room)
+
+	// the guest sees the terms before booking, and books under them
+	start := time.Now().AddDate(0, 0, 3)
+	reservation := models.Reservation{
+		RoomID:    1,
+		StartDate: start,
+		EndDate:   start.AddDate(0, 0, 2),
 	}
 
-	// test case can not get room by ID
-	req, _ = http.NewRequest("GET", "/book-room?id=3&s=2050-01-01&e=2050-01-02", nil)
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	rr = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/make-reservation", nil)
+	reqCtx := getCtx(req)
+	req = req.WithContext(reqCtx)
+	session.Put(reqCtx, "reservation", reservation)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.MakeReservation).ServeHTTP(rr, req)
 
-	handler = http.HandlerFunc(Repo.BookRoom)
-	handler.ServeHTTP(rr, req)
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("BookRoom handler returned wrong when can not get room by ID: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	terms := "Free cancellation until " + start.AddDate(0, 0, -7).Format("2006-01-02") + ", then 50% of the total is charged"
+	if !strings.Contains(rr.Body.String(), terms) {
+		t.Errorf("expected to find %q on the reservation form", terms)
 	}
-}
 
-var loginTests = []struct {
-	name               string
-	email              string
-	expectedStatusCode int
-	expectedHTML       string
-	expectedLocation   string
-}{
-	{
-		"valid-credentials",
-		"admin@admin.com",
-		http.StatusSeeOther,
-		"",
-		"/",
-	},
-	{
-		"invalid-credentials",
-		"jack@user.com",
-		http.StatusSeeOther,
-		"",
-		"/user/login",
-	},
-	{
-		"invalid-data",
-		"not-email",
-		http.StatusOK,
-		`action="/user/login"`,
-		"",
-	},
-}
+	reqBody := url.Values{}
+	reqBody.Add("first_name", "Akihito")
+	reqBody.Add("last_name", "Shu")
+	reqBody.Add("email", "doantayd@gmail.com")
+	reqBody.Add("card_number", payments.FakeCardSuccess)
 
-func TestPostShowLogin(t *testing.T) {
-	// range through all tests
-	for _, e := range loginTests {
-		postData := url.Values{}
-		postData.Add("email", e.email)
-		postData.Add("password", "password")
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody.Encode()))
+	reqCtx = getCtx(req)
+	req = req.WithContext(reqCtx)
+	session.Put(reqCtx, "reservation", reservation)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
 
-		// create request
-		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postData.Encode()))
-		ctx := getCtx(req)
-		req = req.WithContext(ctx)
+	saved, _ := session.Get(reqCtx, "reservation").(models.Reservation)
+	stored, err := testDB.GetReservationByID(ctx, saved.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.CancellationPolicy.FreeDays != 7 || stored.CancellationPolicy.Penalty != 5000 {
+		t.Errorf("got cancellation policy %+v, wanted the room's", stored.CancellationPolicy)
+	}
 
-		// set the header
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		rr := httptest.NewRecorder()
+	// changing the policy later does not change the terms of the booking
+	_ = testDB.UpdateCancellationPolicy(ctx, models.CancellationPolicy{ID: policyID, Name: "Moderate", FreeDays: 1, Penalty: 1000})
 
-		// call the handler
-		handler := http.HandlerFunc(Repo.PostShowLogin)
-		handler.ServeHTTP(rr, req)
+	// cancelling inside the 7 days keeps half of the $200.00 and releases the rest of the hold
+	postData := url.Values{}
+	postData.Add("confirmation_code", stored.ConfirmationCode)
+	postData.Add("email", stored.Email)
+	postData.Add("reason", "Change of plans")
 
-		if rr.Code != e.expectedStatusCode {
-			t.Errorf("Failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
-		}
+	req, _ = http.NewRequest("POST", "/cancel-reservation", strings.NewReader(postData.Encode()))
+	reqCtx = getCtx(req)
+	req = req.WithContext(reqCtx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostCancelReservation).ServeHTTP(rr, req)
 
-		if e.expectedLocation != "" {
-			// get the URL from test
-			actualLoc, _ := rr.Result().Location()
-			if actualLoc.String() != e.expectedLocation {
-				t.Errorf("Failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
-			}
-		}
+	flash := session.GetString(reqCtx, "flash")
+	if flash != "Your reservation has been cancelled. A cancellation fee of $100.00 applies." {
+		t.Errorf("got flash %q", flash)
+	}
 
-		// checking for expected values in HTML
-		if e.expectedHTML != "" {
-			// read the response body into a string
-			html := rr.Body.String()
-			if !strings.Contains(html, e.expectedHTML) {
-				t.Errorf("Failed %s: expected to find %s but did not", e.name, e.expectedHTML)
-			}
-		}
+	res, _ := testDB.GetReservationByID(ctx, stored.ID)
+	if res.CancellationFee != 10000 {
+		t.Errorf("got cancellation fee %s, wanted $100.00", res.CancellationFee)
+	}
+	stays, _ := testDB.PaymentsForReservation(ctx, stored.ID)
+	if len(stays) != 1 || stays[0].Status != models.PaymentCaptured || stays[0].Amount != 10000 {
+		t.Errorf("got payments %+v, wanted $100.00 captured", stays)
 	}
 }
 
-var updateReservationTests = []struct {
-	name             string
-	src              string
-	year             string
-	expectedLocation string
-}{
-	{
-		"SRC IS ALL",
-		"all",
-		"",
-		"/admin/reservations-all",
-	},
-	{
-		"SRC IS NEW",
-		"new",
-		"",
-		"/admin/reservations-new",
-	},
-	{
-		"SRC IS CALENDAR",
-		"calendar",
-		"",
-		"/admin/reservations-calendar",
-	},
-	{
-		"HAVE YEAR",
-		"calendar",
-		"2024",
-		"/admin/reservations-calendar?y=2024&m=01",
-	},
-}
+func TestAdminCancelReservationFee(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+	fake := app.Payments.(*payments.Fake)
+	ctx := context.Background()
+
+	start := time.Now().AddDate(0, 0, 1)
+	policy := models.CancellationPolicy{Name: "Non-refundable", Penalty: models.FullPenalty}
+
+	var tests = []struct {
+		name             string
+		waive            string
+		expectedFee      models.Money
+		expectedStatus   models.PaymentStatus
+		expectedRefunded models.Money
+	}{
+		{"charged", "", 10000, models.PaymentCaptured, 0},
+		{"waived", "1", 0, models.PaymentRefunded, 10000},
+	}
 
-func TestPostShowReservation(t *testing.T) {
-	for _, e := range updateReservationTests {
-		postData := url.Values{}
-		postData.Add("year", e.year)
-		if e.year != "" {
-			postData.Add("month", "01")
-		} else {
-			postData.Add("month", "")
+	for i, e := range tests {
+		id, _ := testDB.InsertReservation(ctx, models.Reservation{
+			FirstName: "Akihito", LastName: "Shu", Email: "doantayd@gmail.com",
+			RoomID: 1, StartDate: start.AddDate(0, 0, 2*i), EndDate: start.AddDate(0, 0, 2*i+1), TotalPrice: 10000,
+			CancellationPolicy: policy,
+		})
+		auth, _ := fake.Authorize(ctx, payments.AuthorizeRequest{Amount: 10000, Card: payments.FakeCardSuccess})
+		_, _ = testDB.InsertPayment(ctx, models.Payment{
+			ReservationID: id, Provider: fake.Name(), Reference: auth.Reference, Status: auth.Status, Amount: 10000,
+		})
+
+		// the admin sees what cancelling costs before they do it
+		showURL := fmt.Sprintf("/admin/reservations/all/%d/show", id)
+		req, _ := http.NewRequest("GET", showURL, nil)
+		req.RequestURI = showURL
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		if !strings.Contains(rr.Body.String(), "Cancelling now charges a fee of $100.00") || !strings.Contains(rr.Body.String(), "Non-refundable") {
+			t.Errorf("%s: expected to find the cancellation fee and terms on the admin reservation page", e.name)
 		}
 
-		// create request
-		req, _ := http.NewRequest("POST", "/admin/reservations/"+e.src+"/1", strings.NewReader(postData.Encode()))
-		ctx := getCtx(req)
-		req = req.WithContext(ctx)
+		postData := url.Values{}
+		postData.Add("reason", "Guest called")
+		postData.Add("waive_fee", e.waive)
 
-		// set the header
+		req, _ = http.NewRequest("POST", fmt.Sprintf("/admin/cancel-reservation/all/%d", id), strings.NewReader(postData.Encode()))
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		rr := httptest.NewRecorder()
-
-		// call the handler
-		handler := http.HandlerFunc(Repo.AdminPostShowReservation)
-		handler.ServeHTTP(rr, req)
+		rr = httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusSeeOther {
-			t.Errorf("Failed PostShowReservation - %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		res, _ := testDB.GetReservationByID(ctx, id)
+		if res.Status != models.StatusCancelled || res.CancellationFee != e.expectedFee {
+			t.Errorf("%s: got status %s with a fee of %s, wanted cancelled with %s", e.name, res.Status, res.CancellationFee, e.expectedFee)
 		}
 
-		// get the URL from test
-		actualLoc, _ := rr.Result().Location()
-		if actualLoc.String() != e.expectedLocation {
-			t.Errorf("Failed PostShowReservation - %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+		p, _ := testDB.GetPaymentByReference(ctx, fake.Name(), auth.Reference)
+		if p.Status != e.expectedStatus || p.Refunded != e.expectedRefunded {
+			t.Errorf("%s: got payment %s with %s refunded, wanted %s with %s", e.name, p.Status, p.Refunded, e.expectedStatus, e.expectedRefunded)
 		}
 	}
 }
 
-func TestPostReservationsCalendar(t *testing.T) {
-	postData := url.Values{}
-	postData.Add("year", "2024")
-	postData.Add("month", "1")
-
-	block_map_1 := make(map[string]int)
-	block_map_1["2024-11-12"] = 1
-	postData.Add("remove_block_1_2024-11-12", "")
+func TestAdminIssueInvoice(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+	ctx := context.Background()
+
+	start, _ := time.Parse("2006-01-02", "2050-10-01")
+	id, _ := testDB.InsertReservation(ctx, models.Reservation{
+		FirstName: "Akihito", LastName: "Shu", Email: "doantayd@gmail.com",
+		RoomID: 1, StartDate: start, EndDate: start.AddDate(0, 0, 2), TotalPrice: 20000,
+	})
+	open, _ := testDB.InsertReservation(ctx, models.Reservation{
+		FirstName: "Akihito", LastName: "Shu", Email: "doantayd@gmail.com",
+		RoomID: 1, StartDate: start.AddDate(0, 0, 5), EndDate: start.AddDate(0, 0, 7), TotalPrice: 20000,
+	})
+	free, _ := testDB.InsertReservation(ctx, models.Reservation{
+		FirstName: "Akihito", LastName: "Shu", Email: "doantayd@gmail.com",
+		RoomID: 2, StartDate: start, EndDate: start.AddDate(0, 0, 2), TotalPrice: 30000,
+	})
+	for _, status := range []models.ReservationStatus{models.StatusConfirmed, models.StatusNoShow} {
+		_ = testDB.UpdateReservationStatus(ctx, id, status, 1)
+	}
+	_ = testDB.UpdateReservationStatus(ctx, open, models.StatusConfirmed, 1)
+	_ = testDB.CancelReservation(ctx, free, 1, "Guest called", 0)
+
+	showURL := fmt.Sprintf("/admin/reservations/all/%d/show", id)
+
+	var tests = []struct {
+		name             string
+		url              string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"open reservation", fmt.Sprintf("/admin/issue-invoice/all/%d", open), http.StatusSeeOther, fmt.Sprintf("/admin/reservations/all/%d/show", open)},
+		{"issue", fmt.Sprintf("/admin/issue-invoice/all/%d", id), http.StatusSeeOther, showURL},
+		{"issue again", fmt.Sprintf("/admin/issue-invoice/all/%d", id), http.StatusSeeOther, showURL},
+		{"cancelled for free", fmt.Sprintf("/admin/issue-invoice/all/%d", free), http.StatusSeeOther, fmt.Sprintf("/admin/reservations/all/%d/show", free)},
+		{"missing reservation", "/admin/issue-invoice/all/999", http.StatusNotFound, ""},
+	}
 
-	block_map_2 := make(map[string]int)
-	block_map_2["2024-11-12"] = 1
-	postData.Add("remove_block_2_2024-11-12", "1")
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
 
-	postData.Add("add_block_1_2024-11-11", "1")
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected location %q, but got %q", e.name, e.expectedLocation, loc)
+		}
+	}
 
-	// create request
-	req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(postData.Encode()))
-	ctx := getCtx(req)
-	req = req.WithContext(ctx)
+	// issuing again gives the same invoice, and nothing is issued for the open reservation or the free cancellation
+	issued, _ := testDB.AllInvoices(ctx)
+	if len(issued) != 1 || issued[0].Number != 1 || issued[0].ReservationID != id || issued[0].Total != 20000 {
+		t.Fatalf("got invoices %+v, wanted one of $200.00", issued)
+	}
 
-	// set the header
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req, _ := http.NewRequest("GET", showURL, nil)
+	req.RequestURI = showURL
 	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), "Invoice INV-000001") || !strings.Contains(rr.Body.String(), "/admin/invoices/1/pdf") {
+		t.Error("expected to find the invoice on the admin reservation page")
+	}
 
-	session.Put(req.Context(), "block_map_1", block_map_1)
-	session.Put(req.Context(), "block_map_2", block_map_2)
+	openURL := fmt.Sprintf("/admin/reservations/all/%d/show", open)
+	req, _ = http.NewRequest("GET", openURL, nil)
+	req.RequestURI = openURL
+	rr = httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if strings.Contains(rr.Body.String(), "Issue Invoice") {
+		t.Error("expected no way to invoice an open reservation")
+	}
 
-	// call the handler
-	handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
-	handler.ServeHTTP(rr, req)
+	req, _ = http.NewRequest("GET", "/admin/invoices/1/pdf", nil)
+	rr = httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/pdf" || !strings.HasPrefix(rr.Body.String(), "%PDF-") {
+		t.Errorf("got code %d with content type %q for the invoice, wanted a PDF", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if cd := rr.Header().Get("Content-Disposition"); !strings.Contains(cd, "INV-000001.pdf") {
+		t.Errorf("got content disposition %q, wanted the invoice number as file name", cd)
+	}
+}
 
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("Failed %s: expected code %d, but got %d", "TestPostReservationsCalendar", http.StatusSeeOther, rr.Code)
+func TestSendCancellationMailEscapes(t *testing.T) {
+	cfg := app
+	cfg.MailChan = make(chan models.MailData, 2)
+	repo := &Repository{App: &cfg, DB: testDB}
+
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+	res := models.Reservation{
+		FirstName: "<b>Akihito</b>",
+		LastName:  `Shu"><script>`,
+		StartDate: start,
+		EndDate:   start.AddDate(0, 0, 1),
 	}
+	repo.sendCancellationMail(res, "<i>Change of plans</i>", 0, 0, nil)
 
-	// get the URL from test
-	expectedLocation := "/admin/reservations-calendar?y=2024&m=1"
-	actualLoc, _ := rr.Result().Location()
-	if actualLoc.String() != expectedLocation {
-		t.Errorf("Failed %s: expected location %s, but got %s", "TestPostReservationsCalendar", expectedLocation, actualLoc.String())
+	for i := 0; i < 2; i++ {
+		msg := <-cfg.MailChan
+		if strings.Contains(msg.Content, "<b>") || strings.Contains(msg.Content, "<script>") || strings.Contains(msg.Content, "<i>") {
+			t.Errorf("%s: guest input is not escaped in %q", msg.Subject, msg.Content)
+		}
+		if !strings.Contains(msg.Content, "&lt;b&gt;Akihito&lt;/b&gt;") {
+			t.Errorf("%s: the guest's name is missing from %q", msg.Subject, msg.Content)
+		}
 	}
 }
 
-func getCtx(req *http.Request) context.Context {
-	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
+func TestClosingInvoice(t *testing.T) {
+	resetDB()
+	routes := getRoutes()
+	ctx := context.Background()
+
+	start, _ := time.Parse("2006-01-02", "2050-10-01")
+	stay, _ := testDB.InsertReservation(ctx, models.Reservation{
+		FirstName: "Akihito", LastName: "Shu", Email: "doantayd@gmail.com",
+		RoomID: 1, StartDate: start, EndDate: start.AddDate(0, 0, 1), TotalPrice: 10000,
+	})
+	cancelled, _ := testDB.InsertReservation(ctx, models.Reservation{
+		FirstName: "Akihito", LastName: "Shu", Email: "doantayd@gmail.com",
+		RoomID: 2, StartDate: start, EndDate: start.AddDate(0, 0, 1), TotalPrice: 10000,
+	})
+	for _, status := range []models.ReservationStatus{models.StatusConfirmed, models.StatusCheckedIn} {
+		_ = testDB.UpdateReservationStatus(ctx, stay, status, 1)
+	}
+
+	// the invoice is issued at checkout
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/reservation-status/all/%d/checked_out/do", stay), nil)
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("checkout: expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	inv, err := testDB.GetInvoiceForReservation(ctx, stay)
 	if err != nil {
-		log.Println(err)
+		t.Fatal(err)
+	}
+	if inv.Number != 1 || len(inv.Lines) != 1 || inv.Lines[0].Description != "General's Quarters from 2050-10-01 to 2050-10-02, 1 night" || inv.BilledTo != "Akihito Shu" {
+		t.Errorf("got invoice %+v, wanted one night in General's Quarters for Akihito Shu", inv)
+	}
+
+	// the guest is emailed the invoice already issued, only when invoices are emailed
+	if attachments := Repo.closingInvoice(ctx, stay); len(attachments) != 0 {
+		t.Errorf("got %d attachments when invoices are not emailed, wanted none", len(attachments))
+	}
+	app.InvoiceEmails = true
+	defer func() { app.InvoiceEmails = false }()
+
+	attachments := Repo.closingInvoice(ctx, stay)
+	if len(attachments) != 1 || attachments[0].Name != "INV-000001.pdf" || attachments[0].ContentType != "application/pdf" || !bytes.HasPrefix(attachments[0].Data, []byte("%PDF-")) {
+		t.Errorf("got attachments %+v, wanted the PDF of the first invoice", attachments)
+	}
+
+	// a cancellation is invoiced for its fee, and a failure leaves the cancellation without an invoice
+	_ = testDB.CancelReservation(ctx, cancelled, 1, "Guest called", 2500)
+	testDB.FailOn("InsertInvoice", errors.New("database is down"))
+	if attachments = Repo.closingInvoice(ctx, cancelled); len(attachments) != 0 {
+		t.Errorf("got %d attachments when the invoice cannot be issued, wanted none", len(attachments))
+	}
+	testDB.ClearFailures()
+
+	attachments = Repo.closingInvoice(ctx, cancelled)
+	inv, _ = testDB.GetInvoiceForReservation(ctx, cancelled)
+	if len(attachments) != 1 || inv.Number != 2 || inv.Total != 2500 {
+		t.Errorf("got invoice %+v with %d attachments, wanted the second invoice for the $25.00 fee", inv, len(attachments))
 	}
-	return ctx
 }
@@ -13,8 +13,12 @@ import (
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/config"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/helpers"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/payments"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/photos"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/render"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/repository/dbrepo"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/justinas/nosurf"
@@ -30,6 +34,9 @@ var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
 
+// testDB is the in-memory repository behind Repo, used to arrange data and inject errors
+var testDB *dbrepo.MemoryRepo
+
 func TestMain(m *testing.M) {
 	// put in the session
 	gob.Register(models.Reservation{})
@@ -39,6 +46,14 @@ func TestMain(m *testing.M) {
 	gob.Register(map[string]int{})
 	// if you want to change tmpl file and check easier, set app.UserCache = false
 	app.InProduction = true
+	app.DBTimeout = 3 * time.Second
+
+	uploadDir, err := os.MkdirTemp("", "uploads")
+	if err != nil {
+		log.Fatal("Cannot create upload directory", err)
+	}
+	app.UploadDir = uploadDir
+	app.Payments = payments.NewFake("secret")
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -72,9 +87,19 @@ func TestMain(m *testing.M) {
 	// Send AppConfig to handlers
 	repo := NewTestRepo(&app)
 	NewHandlers(repo)
+	testDB = repo.DB.(*dbrepo.MemoryRepo)
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
+
+	code := m.Run()
+	os.RemoveAll(uploadDir)
+	os.Exit(code)
+}
 
-	os.Exit(m.Run())
+// resetDB restores the seed data and removes injected errors, so each test starts from a known state
+func resetDB() {
+	testDB.Reset()
+	testDB.ClearFailures()
 }
 
 func listenForMail() {
@@ -95,8 +120,9 @@ func getRoutes() http.Handler {
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms/{slug}", Repo.Room)
+	mux.Get("/generals-quarters", Repo.OldRoomPage)
+	mux.Get("/majors-suite", Repo.OldRoomPage)
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
@@ -107,6 +133,16 @@ func getRoutes() http.Handler {
 	mux.Get("/make-reservation", Repo.MakeReservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
+	mux.Get("/payment-return", Repo.PaymentReturn)
+	mux.Post("/payments/webhook", Repo.PaymentWebhook)
+	mux.Get("/cancel-reservation", Repo.CancelReservation)
+	mux.Post("/cancel-reservation", Repo.PostCancelReservation)
+
+	mux.Get("/manage-booking", Repo.ManageBooking)
+	mux.Post("/manage-booking", Repo.PostManageBooking)
+	mux.Get("/manage-booking/reservation", Repo.ShowManagedBooking)
+	mux.Post("/manage-booking/contact", Repo.PostManagedBookingContact)
+	mux.Post("/manage-booking/dates", Repo.PostManagedBookingDates)
 
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostShowLogin)
@@ -114,18 +150,76 @@ func getRoutes() http.Handler {
 
 	mux.Get("/favicon.ico", Repo.EmptyFunc)
 
+	mux.Handle(payments.FakeChallengePath+"*", app.Payments.(*payments.Fake))
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
+	uploads := photos.New(app.UploadDir, "/uploads/", 0)
+	mux.Handle("/uploads/*", http.StripPrefix("/uploads", http.FileServer(uploads.Files(false))))
+
 	mux.Route("/admin", func(mux chi.Router) {
 		//mux.Use(Auth)
+		mux.Handle("/uploads/*", http.StripPrefix("/admin/uploads", http.FileServer(uploads.Files(true))))
 		mux.Get("/dashboard", Repo.AdminDashboard)
 
 		mux.Get("/reservations-new", Repo.AdminNewReservations)
 		mux.Get("/reservations-all", Repo.AdminAllReservations)
+		mux.Get("/search", Repo.AdminSearchReservations)
 		mux.Get("/reservations-calendar", Repo.AdminReservationsCalendar)
 		mux.Post("/reservations-calendar", Repo.AdminPostReservationsCalendar)
-		mux.Get("/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
+		mux.Get("/reservation-status/{src}/{id}/{status}/do", Repo.AdminUpdateReservationStatus)
+		mux.Post("/cancel-reservation/{src}/{id}", Repo.AdminCancelReservation)
+		mux.Post("/change-stay/{src}/{id}", Repo.AdminChangeReservationStay)
+		mux.Post("/capture-payment/{src}/{id}/{paymentID}", Repo.AdminCapturePayment)
+		mux.Post("/refund-payment/{src}/{id}/{paymentID}", Repo.AdminRefundPayment)
+		mux.Post("/issue-invoice/{src}/{id}", Repo.AdminIssueInvoice)
+		mux.Get("/create-reservation", Repo.AdminCreateReservation)
+		mux.Post("/create-reservation", Repo.AdminPostCreateReservation)
+
+		mux.Get("/rooms", Repo.AdminRooms)
+		mux.Get("/rooms/new", Repo.AdminNewRoom)
+		mux.Post("/rooms/new", Repo.AdminPostNewRoom)
+		mux.Get("/rooms/{id}", Repo.AdminShowRoom)
+		mux.Post("/rooms/{id}", Repo.AdminPostRoom)
+		mux.Post("/rooms/{id}/active", Repo.AdminSetRoomActive)
+		mux.Post("/rooms/{id}/move/{direction}", Repo.AdminMoveRoom)
+		mux.Post("/rooms/{id}/photos", Repo.AdminPostRoomPhoto)
+		mux.Post("/rooms/{id}/photos/upload", Repo.AdminUploadRoomPhoto)
+		mux.Post("/rooms/{id}/photos/{photoID}/delete", Repo.AdminDeleteRoomPhoto)
+		mux.Post("/rooms/{id}/photos/{photoID}/move/{direction}", Repo.AdminMoveRoomPhoto)
+
+		mux.Get("/rate-rules", Repo.AdminRateRules)
+		mux.Get("/rate-rules/new", Repo.AdminNewRateRule)
+		mux.Post("/rate-rules/new", Repo.AdminPostNewRateRule)
+		mux.Get("/rate-rules/{id}", Repo.AdminShowRateRule)
+		mux.Post("/rate-rules/{id}", Repo.AdminPostRateRule)
+		mux.Post("/rate-rules/{id}/delete", Repo.AdminDeleteRateRule)
+
+		mux.Get("/promo-codes", Repo.AdminPromoCodes)
+		mux.Get("/promo-codes/new", Repo.AdminNewPromoCode)
+		mux.Post("/promo-codes/new", Repo.AdminPostNewPromoCode)
+		mux.Get("/promo-codes/{id}", Repo.AdminShowPromoCode)
+		mux.Post("/promo-codes/{id}", Repo.AdminPostPromoCode)
+		mux.Post("/promo-codes/{id}/delete", Repo.AdminDeletePromoCode)
+
+		mux.Get("/charges", Repo.AdminCharges)
+		mux.Get("/charges/new", Repo.AdminNewCharge)
+		mux.Post("/charges/new", Repo.AdminPostNewCharge)
+		mux.Get("/charges/{id}", Repo.AdminShowCharge)
+		mux.Post("/charges/{id}", Repo.AdminPostCharge)
+		mux.Post("/charges/{id}/delete", Repo.AdminDeleteCharge)
+
+		mux.Get("/cancellation-policies", Repo.AdminCancellationPolicies)
+		mux.Get("/cancellation-policies/new", Repo.AdminNewCancellationPolicy)
+		mux.Post("/cancellation-policies/new", Repo.AdminPostNewCancellationPolicy)
+		mux.Get("/cancellation-policies/{id}", Repo.AdminShowCancellationPolicy)
+		mux.Post("/cancellation-policies/{id}", Repo.AdminPostCancellationPolicy)
+		mux.Post("/cancellation-policies/{id}/delete", Repo.AdminDeleteCancellationPolicy)
+
+		mux.Get("/invoices", Repo.AdminInvoices)
+		mux.Get("/invoices/{id}/pdf", Repo.AdminInvoicePDF)
+
 		mux.Get("/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
 
 		mux.Get("/reservations/{src}/{id}/show", Repo.AdminShowReservation)
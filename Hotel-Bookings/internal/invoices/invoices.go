@@ -0,0 +1,82 @@
+// Package invoices prints invoices as PDF documents, with a PDF library written in Go
+// so they are made without any outside service or program
+package invoices
+
+import (
+	"io"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Issuer is who the invoices are from, printed at the top of every invoice
+type Issuer struct {
+	Name string
+	// Address is printed under the name, one line each
+	Address []string
+}
+
+const (
+	// the page is A4 in millimeters, with the amount column this wide at the right margin
+	margin       = 20.0
+	pageWidth    = 210.0
+	amountWidth  = 40.0
+	contentWidth = pageWidth - 2*margin
+	lineHeight   = 7.0
+)
+
+// Write prints the invoice from issuer as a PDF to w
+func Write(w io.Writer, issuer Issuer, inv models.Invoice) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(margin, margin, margin)
+	pdf.SetTitle("Invoice "+inv.Code(), false)
+	pdf.SetAuthor(issuer.Name, true)
+	pdf.SetCreationDate(inv.IssuedAt)
+	pdf.AddPage()
+
+	// the core fonts are in the Windows-1252 code page, text is translated to it
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(contentWidth, 9, tr(issuer.Name), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	for _, line := range issuer.Address {
+		pdf.CellFormat(contentWidth, 5, tr(line), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(10)
+
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.CellFormat(contentWidth, 10, "Invoice", "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+
+	pdf.SetFont("Helvetica", "", 10)
+	details := [][2]string{
+		{"Invoice number", inv.Code()},
+		{"Date", inv.IssuedAt.Format("2006-01-02")},
+		{"Reservation", inv.ConfirmationCode},
+		{"Billed to", inv.BilledTo},
+		{"", inv.Email},
+	}
+	for _, d := range details {
+		pdf.CellFormat(35, 5, d[0], "", 0, "L", false, 0, "")
+		pdf.CellFormat(contentWidth-35, 5, tr(d[1]), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(8)
+
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFillColor(230, 230, 230)
+	pdf.CellFormat(contentWidth-amountWidth, lineHeight, "Description", "B", 0, "L", true, 0, "")
+	pdf.CellFormat(amountWidth, lineHeight, "Amount", "B", 1, "R", true, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	for _, line := range inv.Lines {
+		pdf.CellFormat(contentWidth-amountWidth, lineHeight, tr(line.Description), "", 0, "L", false, 0, "")
+		pdf.CellFormat(amountWidth, lineHeight, tr(line.Amount.String()), "", 1, "R", false, 0, "")
+	}
+
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.CellFormat(contentWidth-amountWidth, lineHeight, "Total", "T", 0, "L", false, 0, "")
+	pdf.CellFormat(amountWidth, lineHeight, tr(inv.Total.String()), "T", 1, "R", false, 0, "")
+
+	return pdf.Output(w)
+}
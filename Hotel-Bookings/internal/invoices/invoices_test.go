@@ -0,0 +1,114 @@
+package invoices
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+)
+
+func TestNewInvoice(t *testing.T) {
+	start, _ := time.Parse("2006-01-02", "2050-01-01")
+	issued := start.AddDate(0, 0, -30)
+
+	res := models.Reservation{
+		ID:               7,
+		FirstName:        "Akihito",
+		LastName:         "Shu",
+		Email:            "doantayd@gmail.com",
+		ConfirmationCode: "ABCD-EFGH",
+		StartDate:        start,
+		EndDate:          start.AddDate(0, 0, 2),
+		Room:             models.Room{RoomName: "General's Quarters"},
+		Status:           models.StatusCheckedOut,
+		TotalPrice:       19350,
+		PromoCode:        "WELCOME",
+		Discount:         2000,
+		Charges:          []models.ReservationCharge{{Name: "VAT", Amount: 1350}},
+	}
+
+	var tests = []struct {
+		name          string
+		status        models.ReservationStatus
+		fee           models.Money
+		expectedLines []models.InvoiceLine
+		expectedErr   error
+	}{
+		{"open", models.StatusConfirmed, 0, nil, models.ErrReservationOpen},
+		{"stay", models.StatusCheckedOut, 0, []models.InvoiceLine{
+			{Description: "General's Quarters from 2050-01-01 to 2050-01-03, 2 nights", Amount: 20000},
+			{Description: "Promo code WELCOME", Amount: -2000},
+			{Description: "VAT", Amount: 1350},
+		}, nil},
+		{"cancelled with a fee", models.StatusCancelled, 5000, []models.InvoiceLine{
+			{Description: "Cancellation fee, General's Quarters from 2050-01-01 to 2050-01-03", Amount: 5000},
+		}, nil},
+		{"cancelled for free", models.StatusCancelled, 0, nil, models.ErrNothingToInvoice},
+	}
+
+	for _, e := range tests {
+		res.Status = e.status
+		res.CancellationFee = e.fee
+
+		inv, err := models.NewInvoice(res, issued)
+		if !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: got error %v, wanted %v", e.name, err, e.expectedErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if len(inv.Lines) != len(e.expectedLines) {
+			t.Errorf("%s: got lines %+v, wanted %+v", e.name, inv.Lines, e.expectedLines)
+			continue
+		}
+		var total models.Money
+		for i, line := range inv.Lines {
+			if line != e.expectedLines[i] {
+				t.Errorf("%s: got line %+v, wanted %+v", e.name, line, e.expectedLines[i])
+			}
+			total += line.Amount
+		}
+		if inv.Total != total || inv.BilledTo != "Akihito Shu" || inv.ReservationID != 7 || inv.ConfirmationCode != "ABCD-EFGH" || !inv.IssuedAt.Equal(issued) {
+			t.Errorf("%s: got invoice %+v", e.name, inv)
+		}
+	}
+
+	// a stay that is free has nothing to invoice either
+	res.Status, res.TotalPrice, res.PromoCode, res.Charges = models.StatusCheckedOut, 0, "", nil
+	if _, err := models.NewInvoice(res, issued); !errors.Is(err, models.ErrNothingToInvoice) {
+		t.Errorf("free stay: got error %v, wanted %v", err, models.ErrNothingToInvoice)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	inv := models.Invoice{
+		Number:           42,
+		ConfirmationCode: "ABCD-EFGH",
+		BilledTo:         "Zoë Café",
+		Email:            "zoe@example.com",
+		Lines:            []models.InvoiceLine{{Description: "Major's Suite from 2050-01-01 to 2050-01-02, 1 night", Amount: 15000}},
+		Total:            15000,
+		IssuedAt:         time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	var buf bytes.Buffer
+	err := Write(&buf, Issuer{Name: "Fort Smythe Bed and Breakfast", Address: []string{"1 Main Street"}}, inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pdf := buf.Bytes()
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) || !bytes.Contains(pdf, []byte("%%EOF")) {
+		t.Errorf("got %d bytes that are not a PDF document", len(pdf))
+	}
+	if !bytes.Contains(pdf, []byte("Invoice INV-000042")) {
+		t.Error("expected the document to be titled with the invoice number")
+	}
+	if inv.FileName() != "INV-000042.pdf" {
+		t.Errorf("got file name %q", inv.FileName())
+	}
+}
@@ -0,0 +1,224 @@
+// Package migrate applies and rolls back the embedded SQL migrations.
+// Applied versions are kept in the schema_migration table used by soda,
+// so databases migrated with soda carry on where they left off.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Migration is one schema change with the SQL that applies and reverts it
+type Migration struct {
+	Version string
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status reports whether a migration has been applied
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Migrator runs the migrations in Files against DB
+type Migrator struct {
+	DB    *sql.DB
+	Files fs.FS
+}
+
+// migrationFile matches <version>_<name>.postgres.<up|down>.sql
+var migrationFile = regexp.MustCompile(`^(\d{14})_(\w+)\.postgres\.(up|down)\.sql$`)
+
+// New creates a Migrator
+func New(db *sql.DB, files fs.FS) *Migrator {
+	return &Migrator{
+		DB:    db,
+		Files: files,
+	}
+}
+
+// Migrations returns every migration in Files, oldest first
+func (m *Migrator) Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(m.Files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[string]*Migration)
+	for _, entry := range entries {
+		matches := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+		version, name, direction := matches[1], matches[2], matches[3]
+
+		content, err := fs.ReadFile(m.Files, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: name}
+			byVersion[version] = mig
+		} else if mig.Name != name {
+			return nil, fmt.Errorf("migration %s has two names: %s and %s", version, mig.Name, name)
+		}
+
+		if direction == "up" {
+			mig.Up = string(content)
+		} else {
+			mig.Down = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, mig := range byVersion {
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Up applies every pending migration in order and returns the ones it applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, s := range statuses {
+		if s.Applied {
+			continue
+		}
+
+		err = m.run(ctx, s.Version, s.Up, `insert into schema_migration (version) values ($1)`)
+		if err != nil {
+			return applied, fmt.Errorf("migration %s_%s: %w", s.Version, s.Name, err)
+		}
+		applied = append(applied, s.Migration)
+	}
+
+	return applied, nil
+}
+
+// Down reverts the n most recently applied migrations, newest first, and returns the ones it reverted
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := len(statuses) - 1; i >= 0 && len(reverted) < n; i-- {
+		s := statuses[i]
+		if !s.Applied {
+			continue
+		}
+
+		err = m.run(ctx, s.Version, s.Down, `delete from schema_migration where version = $1`)
+		if err != nil {
+			return reverted, fmt.Errorf("migration %s_%s: %w", s.Version, s.Name, err)
+		}
+		reverted = append(reverted, s.Migration)
+	}
+
+	return reverted, nil
+}
+
+// Status lists every migration, oldest first, with whether it has been applied.
+// A version recorded in the database without a matching file is an error,
+// since it cannot be rolled back and usually means the binary is out of date.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, mig := range migrations {
+		statuses = append(statuses, Status{Migration: mig, Applied: applied[mig.Version]})
+		delete(applied, mig.Version)
+	}
+
+	for version := range applied {
+		return nil, fmt.Errorf("database has migration %s applied, but this binary does not know it", version)
+	}
+
+	return statuses, nil
+}
+
+// appliedVersions creates the schema table if needed and returns the versions recorded in it
+func (m *Migrator) appliedVersions(ctx context.Context) (map[string]bool, error) {
+	_, err := m.DB.ExecContext(ctx, `create table if not exists schema_migration (version varchar(14) not null primary key)`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `select version from schema_migration`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// run executes the migration SQL and records the change in the schema table in one transaction
+func (m *Migrator) run(ctx context.Context, version, stmt, record string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if !isBlank(stmt) {
+		if _, err = tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, record, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// isBlank reports whether stmt holds nothing but whitespace and -- comments
+func isBlank(stmt string) bool {
+	for _, line := range strings.Split(stmt, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
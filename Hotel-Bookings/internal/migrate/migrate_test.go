@@ -0,0 +1,82 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/migrations"
+)
+
+func TestMigrations(t *testing.T) {
+	files := fstest.MapFS{
+		"20240102000000_second.postgres.up.sql":   {Data: []byte("create table b (id int);")},
+		"20240102000000_second.postgres.down.sql": {Data: []byte("drop table b;")},
+		"20240101000000_first.postgres.up.sql":    {Data: []byte("create table a (id int);")},
+		"20240101000000_first.postgres.down.sql":  {Data: []byte("drop table a;")},
+		"20240101000000_first.up.fizz":            {Data: []byte("ignored")},
+		"migrations.go":                           {Data: []byte("package migrations")},
+	}
+
+	m := New(nil, files)
+	got, err := m.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("got %d migrations, wanted 2", len(got))
+	}
+	if got[0].Version != "20240101000000" || got[0].Name != "first" || got[1].Name != "second" {
+		t.Errorf("migrations are not ordered by version: %+v", got)
+	}
+	if got[0].Up != "create table a (id int);" || got[0].Down != "drop table a;" {
+		t.Errorf("up and down SQL do not match: %+v", got[0])
+	}
+}
+
+func TestMigrationsNameMismatch(t *testing.T) {
+	files := fstest.MapFS{
+		"20240101000000_first.postgres.up.sql":   {Data: []byte("create table a (id int);")},
+		"20240101000000_other.postgres.down.sql": {Data: []byte("drop table a;")},
+	}
+
+	_, err := New(nil, files).Migrations()
+	if err == nil {
+		t.Error("two names for one version did not return an error")
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	got, err := New(nil, migrations.Files).Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) == 0 {
+		t.Fatal("no migrations are embedded")
+	}
+	for _, mig := range got {
+		if isBlank(mig.Up) {
+			t.Errorf("migration %s_%s has no up SQL", mig.Version, mig.Name)
+		}
+	}
+}
+
+func TestIsBlank(t *testing.T) {
+	var tests = []struct {
+		stmt  string
+		blank bool
+	}{
+		{"", true},
+		{"  \n\t\n", true},
+		{"-- nothing to undo\n", true},
+		{"-- drop it\ndrop table a;", false},
+		{"drop table a;", false},
+	}
+
+	for _, e := range tests {
+		if isBlank(e.stmt) != e.blank {
+			t.Errorf("isBlank(%q): got %v, wanted %v", e.stmt, !e.blank, e.blank)
+		}
+	}
+}
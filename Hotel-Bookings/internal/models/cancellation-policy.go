@@ -0,0 +1,49 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// FullPenalty is the penalty of a policy that charges the whole price
+const FullPenalty Percentage = 10000
+
+// Describe returns the terms of the policy as shown to people, like
+// "Free cancellation until 7 days before arrival, then 50% of the total is charged"
+func (p CancellationPolicy) Describe() string {
+	switch {
+	case p.Penalty == 0:
+		return "Free cancellation"
+	case p.FreeDays == 0 && p.Penalty >= FullPenalty:
+		return "Non-refundable"
+	case p.FreeDays == 0:
+		return fmt.Sprintf("%s of the total is charged on cancellation", p.Penalty)
+	}
+	return fmt.Sprintf("Free cancellation until %s before arrival, then %s of the total is charged", days(p.FreeDays), p.Penalty)
+}
+
+// FreeUntil returns the last day a stay arriving on arrival can be cancelled for free,
+// the zero time when it never can or always can
+func (p CancellationPolicy) FreeUntil(arrival time.Time) time.Time {
+	if p.FreeDays == 0 || p.Penalty == 0 {
+		return time.Time{}
+	}
+	return arrival.AddDate(0, 0, -p.FreeDays)
+}
+
+// CancellationTerms describes what cancelling the reservation costs, with the date free cancellation ends
+func (r Reservation) CancellationTerms() string {
+	p := r.CancellationPolicy
+	if until := p.FreeUntil(r.StartDate); !until.IsZero() {
+		return fmt.Sprintf("Free cancellation until %s, then %s of the total is charged", until.Format("2006-01-02"), p.Penalty)
+	}
+	return p.Describe()
+}
+
+// days returns n days, like "1 day" or "7 days"
+func days(n int) string {
+	if n == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", n)
+}
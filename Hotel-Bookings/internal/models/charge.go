@@ -0,0 +1,118 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ChargeMethod is how a tax or fee is worked out
+type ChargeMethod string
+
+const (
+	ChargePercent ChargeMethod = "percent"
+	ChargeFlat    ChargeMethod = "flat"
+)
+
+// ChargeMethods lists the methods in the order they are offered
+var ChargeMethods = []ChargeMethod{ChargePercent, ChargeFlat}
+
+var chargeMethodLabels = map[ChargeMethod]string{
+	ChargePercent: "Percentage of the room price",
+	ChargeFlat:    "Flat amount",
+}
+
+// Valid reports whether m is a known method
+func (m ChargeMethod) Valid() bool {
+	_, ok := chargeMethodLabels[m]
+	return ok
+}
+
+// Label returns the method as shown to people
+func (m ChargeMethod) Label() string {
+	if label, ok := chargeMethodLabels[m]; ok {
+		return label
+	}
+	return string(m)
+}
+
+// ChargeBasis is what a flat tax or fee is charged for
+type ChargeBasis string
+
+const (
+	PerStay       ChargeBasis = "stay"
+	PerNight      ChargeBasis = "night"
+	PerGuestNight ChargeBasis = "guest_night"
+)
+
+// ChargeBases lists the bases in the order they are offered
+var ChargeBases = []ChargeBasis{PerStay, PerNight, PerGuestNight}
+
+var chargeBasisLabels = map[ChargeBasis]string{
+	PerStay:       "Per stay",
+	PerNight:      "Per night",
+	PerGuestNight: "Per guest per night",
+}
+
+// Valid reports whether b is a known basis
+func (b ChargeBasis) Valid() bool {
+	_, ok := chargeBasisLabels[b]
+	return ok
+}
+
+// Label returns the basis as shown to people
+func (b ChargeBasis) Label() string {
+	if label, ok := chargeBasisLabels[b]; ok {
+		return label
+	}
+	return string(b)
+}
+
+// Percentage is a rate in hundredths of a percent, so 750 is 7.5%
+type Percentage int
+
+// ErrInvalidPercentage is returned by ParsePercentage for text that is not a percentage from 0 to 100
+// with at most two decimals
+var ErrInvalidPercentage = errors.New("invalid percentage")
+
+// String formats the rate as shown to people, like "7.5%"
+func (p Percentage) String() string {
+	s := strconv.Itoa(int(p) / 100)
+	if cents := int(p) % 100; cents != 0 {
+		s += strings.TrimRight("."+strconv.Itoa(100 + cents)[1:], "0")
+	}
+	return s + "%"
+}
+
+// Of returns the rate of amount, rounded to the nearest cent
+func (p Percentage) Of(amount Money) Money {
+	return (amount*Money(p) + 5000) / 10000
+}
+
+// ParsePercentage reads a rate typed by a person, like "10", "7.5" or "7.25%"
+func ParsePercentage(s string) (Percentage, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(s, "%")
+
+	// a percentage has the same form as an amount of money, hundredths included
+	n, err := ParseMoney(s)
+	if err != nil || strings.ContainsAny(s, "$,") || n > 10000 {
+		return 0, ErrInvalidPercentage
+	}
+	return Percentage(n), nil
+}
+
+// Describe returns the rate of the charge and what it is charged for, like "7.5%" or "$2.50 per guest per night"
+func (c Charge) Describe() string {
+	if c.Method == ChargePercent {
+		return c.Rate.String()
+	}
+
+	switch c.Per {
+	case PerNight:
+		return c.Amount.String() + " per night"
+	case PerGuestNight:
+		return c.Amount.String() + " per guest per night"
+	}
+	return c.Amount.String() + " per stay"
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// confirmationCodeAlphabet leaves out 0, 1, I and O, which are easy to misread
+const confirmationCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// confirmationCodeLength is the number of random characters in a code, 40 bits of randomness in all
+const confirmationCodeLength = 8
+
+// NewConfirmationCode returns a random confirmation code such as "K7QM-4XPD"
+func NewConfirmationCode() (string, error) {
+	b := make([]byte, confirmationCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	// the alphabet has 32 characters, so the low 5 bits of each byte pick one without bias
+	for i := range b {
+		b[i] = confirmationCodeAlphabet[b[i]&31]
+	}
+
+	return formatConfirmationCode(string(b)), nil
+}
+
+// NormalizeConfirmationCode turns a code as a guest typed it, in any case and with or
+// without the dash or spaces, into the form it is stored in
+func NormalizeConfirmationCode(s string) string {
+	s = strings.ToUpper(s)
+	s = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, s)
+
+	if len(s) != confirmationCodeLength {
+		return s
+	}
+	return formatConfirmationCode(s)
+}
+
+// formatConfirmationCode splits a code in two halves with a dash, so it is easier to read out
+func formatConfirmationCode(s string) string {
+	return s[:confirmationCodeLength/2] + "-" + s[confirmationCodeLength/2:]
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNothingToInvoice is returned when an invoice is made for a reservation that costs nothing,
+// like one cancelled for free
+var ErrNothingToInvoice = errors.New("reservation has nothing to invoice")
+
+// ErrReservationOpen is returned when an invoice is made for a reservation that is not closed yet,
+// whose cost can still change
+var ErrReservationOpen = errors.New("reservation is still open")
+
+// NewInvoice makes an unnumbered invoice for the reservation, issued at the given time. Only closed
+// reservations are invoiced, so the invoice bills what the reservation finally cost: a cancelled
+// reservation is invoiced for its cancellation fee, any other for the room, its discount and its charges.
+func NewInvoice(res Reservation, issuedAt time.Time) (Invoice, error) {
+	if !res.Status.Final() {
+		return Invoice{}, ErrReservationOpen
+	}
+
+	inv := Invoice{
+		ReservationID:    res.ID,
+		ConfirmationCode: res.ConfirmationCode,
+		BilledTo:         res.FirstName + " " + res.LastName,
+		Email:            res.Email,
+		IssuedAt:         issuedAt,
+	}
+
+	stay := fmt.Sprintf("%s from %s to %s", res.Room.RoomName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"))
+
+	if res.Status == StatusCancelled {
+		if res.CancellationFee > 0 {
+			inv.Lines = append(inv.Lines, InvoiceLine{"Cancellation fee, " + stay, res.CancellationFee})
+		}
+	} else if res.TotalPrice > 0 {
+		nights := res.Nights()
+		if nights == 1 {
+			stay += ", 1 night"
+		} else {
+			stay += fmt.Sprintf(", %d nights", nights)
+		}
+		inv.Lines = append(inv.Lines, InvoiceLine{stay, res.RoomPrice()})
+		if res.PromoCode != "" {
+			inv.Lines = append(inv.Lines, InvoiceLine{"Promo code " + res.PromoCode, -res.Discount})
+		}
+		for _, c := range res.Charges {
+			inv.Lines = append(inv.Lines, InvoiceLine{c.Name, c.Amount})
+		}
+	}
+
+	if len(inv.Lines) == 0 {
+		return Invoice{}, ErrNothingToInvoice
+	}
+
+	for _, line := range inv.Lines {
+		inv.Total += line.Amount
+	}
+	return inv, nil
+}
+
+// Code returns the invoice number as printed on the invoice, like "INV-000042"
+func (i Invoice) Code() string {
+	return fmt.Sprintf("INV-%06d", i.Number)
+}
+
+// FileName returns the name to download the PDF of the invoice as
+func (i Invoice) FileName() string {
+	return i.Code() + ".pdf"
+}
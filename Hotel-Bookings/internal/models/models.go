@@ -16,12 +16,45 @@ type User struct {
 	UpdatedAt   time.Time
 }
 
-// Room is the room model
+// Room is the room model. Slug names the room's public page, /rooms/{slug},
+// only active rooms are shown to guests and can be booked, and rooms are listed by SortOrder.
 type Room struct {
 	ID        int
 	RoomName  string
+	Slug      string
+	Active    bool
+	SortOrder int
 	CreatedAt time.Time
 	UpdatedAt time.Time
+
+	// MaxOccupancy is how many guests the room sleeps, BedConfiguration describes its beds, like "1 king bed"
+	MaxOccupancy     int
+	BedConfiguration string
+	Description      string
+	// Amenities and Photos are in display order, the first photo is the room's cover
+	Amenities []string
+	Photos    []RoomPhoto
+
+	// NightlyRate is the base price of one night in the room
+	NightlyRate Money
+
+	// CancellationPolicyID is the policy stays in the room are booked under, 0 for free cancellation
+	CancellationPolicyID int
+}
+
+// RoomPhoto is a photo in a room's gallery, served from Path
+type RoomPhoto struct {
+	ID        int
+	RoomID    int
+	Path      string
+	Caption   string
+	SortOrder int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// OriginalPath and ThumbnailPath are set for uploaded photos, whose Path is the web-optimized copy
+	OriginalPath  string
+	ThumbnailPath string
 }
 
 // Restriction is the restriction model
@@ -41,11 +74,63 @@ type Reservation struct {
 	Phone     string
 	StartDate time.Time
 	EndDate   time.Time
-	Processed int
+	Status    ReservationStatus
 	RoomID    int
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Room      Room
+
+	// ConfirmationCode is the code guests use to refer to the reservation
+	ConfirmationCode string
+
+	// RequestedStartDate and RequestedEndDate are the dates the guest asked to move to, zero when they have not asked
+	RequestedStartDate time.Time
+	RequestedEndDate   time.Time
+
+	CancelledAt time.Time
+	// CancelledBy is the admin who cancelled the reservation, 0 when the guest did
+	CancelledBy        int
+	CancellationReason string
+
+	// Source is how the reservation was made, online unless an admin recorded it
+	Source ReservationSource
+
+	// TotalPrice is the price quoted when the reservation was made or its stay changed,
+	// so later rate changes do not alter it
+	TotalPrice Money
+
+	// PromoCode is the code the guest booked with, if any, and Discount what it took off TotalPrice
+	PromoCode string
+	Discount  Money
+
+	// Guests is how many people stay, and Charges the taxes and fees included in TotalPrice
+	Guests  int
+	Charges []ReservationCharge
+
+	// CancellationPolicy holds the terms the reservation was booked under, copied so later changes to the
+	// policy do not alter them, and CancellationFee what was charged when it was cancelled
+	CancellationPolicy CancellationPolicy
+	CancellationFee    Money
+}
+
+// ReservationCharge is a tax or fee as it was charged on a reservation
+type ReservationCharge struct {
+	Name   string
+	Amount Money
+}
+
+// ChargesTotal returns the sum of the taxes and fees of the reservation
+func (r Reservation) ChargesTotal() Money {
+	var total Money
+	for _, c := range r.Charges {
+		total += c.Amount
+	}
+	return total
+}
+
+// RoomPrice returns the price of the nights before any discount, taxes and fees
+func (r Reservation) RoomPrice() Money {
+	return r.TotalPrice - r.ChargesTotal() + r.Discount
 }
 
 // RoomRestriction is the roomRestriction model
@@ -63,6 +148,124 @@ type RoomRestriction struct {
 	Restriction   Reservation
 }
 
+// RateRule sets the nightly rate of a room, or of every room, on the nights it covers.
+// Where rules overlap the one with the highest Priority wins.
+type RateRule struct {
+	ID          int
+	Name        string
+	NightlyRate Money
+	Priority    int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+
+	// RoomID is 0 for a rule that applies to every room
+	RoomID int
+	Room   Room
+	// StartDate and EndDate are the first and last nights covered, zero for no limit
+	StartDate time.Time
+	EndDate   time.Time
+	// Weekdays limits the rule to some days of the week, it covers every day when empty
+	Weekdays Weekdays
+
+	// CancellationPolicyID is the policy of stays that start on the rate, 0 to keep the room's policy
+	CancellationPolicyID int
+}
+
+// PromoCode is a code guests enter when booking to get a discount, a percentage
+// or a fixed amount off the price of their stay
+type PromoCode struct {
+	ID          int
+	Code        string
+	Description string
+	Kind        PromoKind
+	// PercentOff is used by percentage codes and AmountOff by fixed amount codes
+	PercentOff int
+	AmountOff  Money
+	// ValidFrom and ValidUntil are the first and last days the code can be used, zero for no limit
+	ValidFrom  time.Time
+	ValidUntil time.Time
+	// MaxUses is how many reservations can use the code, 0 for no limit, and Uses how many have
+	MaxUses int
+	Uses    int
+	// MinNights is the shortest stay the code applies to
+	MinNights int
+	// RoomIDs are the rooms the code applies to, every room when empty
+	RoomIDs   []int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Charge is a tax or fee added to the price of every stay, like VAT, a city tax or a cleaning fee
+type Charge struct {
+	ID     int
+	Name   string
+	Method ChargeMethod
+	// Rate is used by percentage charges, which apply to the room price after discounts,
+	// and Amount by flat charges, which are added once for every Per
+	Rate   Percentage
+	Amount Money
+	Per    ChargeBasis
+	// Active charges are added to new reservations
+	Active    bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// CancellationPolicy says what guests are charged for cancelling: nothing until FreeDays days before arrival,
+// then Penalty of the total price
+type CancellationPolicy struct {
+	ID   int
+	Name string
+	// FreeDays is 0 for a policy that never lets guests cancel for free
+	FreeDays  int
+	Penalty   Percentage
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Payment is a card payment taken through a payment provider for a reservation
+type Payment struct {
+	ID            int
+	ReservationID int
+	// Provider names the payment provider and Reference is its id for the payment
+	Provider  string
+	Reference string
+	Status    PaymentStatus
+	// Amount is what was authorized, or captured once it is, and Refunded how much of it has been given back
+	Amount    Money
+	Refunded  Money
+	CardLast4 string
+	// FailureReason says why the payment was declined, as the provider put it
+	FailureReason string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Invoice is a numbered bill for a reservation. Who it is made out to and what it charges for are copied
+// from the reservation when it is issued, so later changes to the reservation do not alter it.
+type Invoice struct {
+	ID int
+	// Number counts invoices up from 1 without gaps, in the order they were issued
+	Number int
+	// ReservationID is 0 once the reservation has been deleted, the invoice is kept for the books
+	ReservationID int
+	// ConfirmationCode is the code of the reservation invoiced
+	ConfirmationCode string
+	BilledTo         string
+	Email            string
+	Lines            []InvoiceLine
+	Total            Money
+	IssuedAt         time.Time
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// InvoiceLine is one thing an invoice charges for
+type InvoiceLine struct {
+	Description string
+	Amount      Money
+}
+
 // MailData holds an email message
 type MailData struct {
 	To       string
@@ -70,4 +273,13 @@ type MailData struct {
 	Subject  string
 	Content  string
 	Template string
+	// Attachments are files sent with the message
+	Attachments []MailAttachment
+}
+
+// MailAttachment is a file sent with an email
+type MailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
 }
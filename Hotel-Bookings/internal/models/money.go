@@ -0,0 +1,72 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in cents
+type Money int64
+
+// ErrInvalidMoney is returned by ParseMoney for text that is not a positive amount with at most two decimals
+var ErrInvalidMoney = errors.New("invalid amount of money")
+
+// String formats the amount as shown to people, like "$1,234.50"
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+
+	dollars := strconv.FormatInt(int64(m/100), 10)
+	var b strings.Builder
+	for i, c := range dollars {
+		if i > 0 && (len(dollars)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+
+	cents := strconv.FormatInt(int64(m%100), 10)
+	if len(cents) < 2 {
+		cents = "0" + cents
+	}
+	return sign + "$" + b.String() + "." + cents
+}
+
+// ParseMoney reads an amount typed by a person, like "125", "125.5" or "$1,250.00"
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "$")
+	s = strings.ReplaceAll(s, ",", "")
+
+	dollars, cents, hasCents := strings.Cut(s, ".")
+	if dollars == "" && cents == "" {
+		return 0, ErrInvalidMoney
+	}
+	if len(cents) > 2 || (hasCents && cents == "") {
+		return 0, ErrInvalidMoney
+	}
+	for len(cents) < 2 {
+		cents += "0"
+	}
+	if dollars == "" {
+		dollars = "0"
+	}
+
+	for _, c := range dollars + cents {
+		if c < '0' || c > '9' {
+			return 0, ErrInvalidMoney
+		}
+	}
+
+	d, err := strconv.ParseInt(dollars, 10, 64)
+	if err != nil || d > 1e12 {
+		return 0, ErrInvalidMoney
+	}
+	c, _ := strconv.ParseInt(cents, 10, 64)
+
+	return Money(d*100 + c), nil
+}
@@ -0,0 +1,68 @@
+package models
+
+// PaymentStatus is where a payment is in its life
+type PaymentStatus string
+
+const (
+	// PaymentPending payments wait for the guest to authenticate with their bank
+	PaymentPending    PaymentStatus = "pending"
+	PaymentAuthorized PaymentStatus = "authorized"
+	PaymentCaptured   PaymentStatus = "captured"
+	PaymentDeclined   PaymentStatus = "declined"
+	// PaymentRefunded payments were given back in full, or released before they were captured
+	PaymentRefunded PaymentStatus = "refunded"
+)
+
+// paymentTransitions decides which status can follow which. Declined and refunded payments are final.
+var paymentTransitions = map[PaymentStatus][]PaymentStatus{
+	PaymentPending:    {PaymentAuthorized, PaymentDeclined, PaymentRefunded},
+	PaymentAuthorized: {PaymentCaptured, PaymentRefunded},
+	PaymentCaptured:   {PaymentRefunded},
+}
+
+var paymentStatusLabels = map[PaymentStatus]string{
+	PaymentPending:    "Waiting for the guest",
+	PaymentAuthorized: "Authorized",
+	PaymentCaptured:   "Captured",
+	PaymentDeclined:   "Declined",
+	PaymentRefunded:   "Refunded",
+}
+
+// Valid reports whether s is a known status
+func (s PaymentStatus) Valid() bool {
+	_, ok := paymentStatusLabels[s]
+	return ok
+}
+
+// Label returns the status as shown to people
+func (s PaymentStatus) Label() string {
+	if label, ok := paymentStatusLabels[s]; ok {
+		return label
+	}
+	return string(s)
+}
+
+// CanTransitionTo reports whether a payment in status s can move to next
+func (s PaymentStatus) CanTransitionTo(next PaymentStatus) bool {
+	for _, allowed := range paymentTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Capturable reports whether the payment holds money that can still be captured
+func (p Payment) Capturable() bool {
+	return p.Status == PaymentAuthorized
+}
+
+// Refundable returns how much of the payment can still be refunded. An authorized payment
+// is released as a whole, a captured one can be refunded in parts.
+func (p Payment) Refundable() Money {
+	switch p.Status {
+	case PaymentAuthorized, PaymentCaptured:
+		return p.Amount - p.Refunded
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PromoKind is how a promo code takes money off a stay
+type PromoKind string
+
+const (
+	PromoPercent PromoKind = "percent"
+	PromoFixed   PromoKind = "fixed"
+)
+
+// PromoKinds lists the kinds of promo code in the order they are offered
+var PromoKinds = []PromoKind{PromoPercent, PromoFixed}
+
+var promoKindLabels = map[PromoKind]string{
+	PromoPercent: "Percentage",
+	PromoFixed:   "Fixed amount",
+}
+
+// Valid reports whether k is a known kind
+func (k PromoKind) Valid() bool {
+	_, ok := promoKindLabels[k]
+	return ok
+}
+
+// Label returns the kind as shown to people
+func (k PromoKind) Label() string {
+	if label, ok := promoKindLabels[k]; ok {
+		return label
+	}
+	return string(k)
+}
+
+// Errors returned by PromoCode.Check for codes that cannot be used for a stay
+var (
+	ErrPromoNotStarted = errors.New("promo code cannot be used yet")
+	ErrPromoExpired    = errors.New("promo code has expired")
+	ErrPromoUsedUp     = errors.New("promo code has been used up")
+	ErrPromoRoom       = errors.New("promo code does not apply to this room")
+	ErrPromoMinNights  = errors.New("stay is too short for promo code")
+)
+
+// NormalizePromoCode returns code the way promo codes are stored, trimmed and in capitals
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Check returns nil if the code can be used on today for a stay of nights in the room with roomID,
+// or the error saying why not
+func (p PromoCode) Check(roomID, nights int, today time.Time) error {
+	day := today.Format("2006-01-02")
+	if !p.ValidFrom.IsZero() && day < p.ValidFrom.Format("2006-01-02") {
+		return ErrPromoNotStarted
+	}
+	if !p.ValidUntil.IsZero() && day > p.ValidUntil.Format("2006-01-02") {
+		return ErrPromoExpired
+	}
+	if p.MaxUses > 0 && p.Uses >= p.MaxUses {
+		return ErrPromoUsedUp
+	}
+	if nights < p.MinNights {
+		return ErrPromoMinNights
+	}
+	if len(p.RoomIDs) > 0 && !p.AppliesToRoom(roomID) {
+		return ErrPromoRoom
+	}
+	return nil
+}
+
+// AppliesToRoom reports whether the code is limited to rooms including roomID
+func (p PromoCode) AppliesToRoom(roomID int) bool {
+	for _, id := range p.RoomIDs {
+		if id == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+// Discount returns how much the code takes off price, never more than price itself.
+// Percentages are rounded to the nearest cent.
+func (p PromoCode) Discount(price Money) Money {
+	var discount Money
+	switch p.Kind {
+	case PromoPercent:
+		discount = (price*Money(p.PercentOff) + 50) / 100
+	case PromoFixed:
+		discount = p.AmountOff
+	}
+
+	if discount > price {
+		return price
+	}
+	if discount < 0 {
+		return 0
+	}
+	return discount
+}
+
+// Offer describes the discount as shown to people, like "15% off" or "$20.00 off"
+func (p PromoCode) Offer() string {
+	if p.Kind == PromoPercent {
+		return fmt.Sprintf("%d%% off", p.PercentOff)
+	}
+	return p.AmountOff.String() + " off"
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultPageSize and MaxPageSize bound how many reservations a listing page shows
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// maxPage is the last page whose offset fits in an int at any page size
+const maxPage = math.MaxInt / MaxPageSize
+
+// ReservationSortColumns lists the columns a reservation listing can be sorted by
+var ReservationSortColumns = []string{"id", "first_name", "last_name", "room", "start_date", "end_date", "status", "created_at"}
+
+// ReservationQuery selects one page of reservations. Zero values mean "no filter".
+type ReservationQuery struct {
+	Page     int
+	PageSize int
+	Sort     string
+	Desc     bool
+	RoomID   int
+	// From and To keep reservations whose stay touches the range, both days included
+	From time.Time
+	To   time.Time
+	// Status keeps only reservations in that status when set
+	Status ReservationStatus
+	// Search matches part of the guest's name, email, phone or confirmation code
+	Search string
+}
+
+// Normalize fills in defaults and replaces values the repositories would reject
+func (q ReservationQuery) Normalize() ReservationQuery {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.Page > maxPage {
+		q.Page = maxPage
+	}
+	if q.PageSize < 1 {
+		q.PageSize = DefaultPageSize
+	}
+	if q.PageSize > MaxPageSize {
+		q.PageSize = MaxPageSize
+	}
+
+	valid := false
+	for _, col := range ReservationSortColumns {
+		if q.Sort == col {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		q.Sort = "start_date"
+	}
+
+	if q.Status != "" && !q.Status.Valid() {
+		q.Status = ""
+	}
+
+	return q
+}
+
+// Offset returns how many reservations come before the requested page
+func (q ReservationQuery) Offset() int {
+	return (q.Page - 1) * q.PageSize
+}
+
+// Values encodes the query as URL parameters, the way the admin listings read them back
+func (q ReservationQuery) Values() url.Values {
+	v := url.Values{}
+	if q.Page > 1 {
+		v.Set("page", strconv.Itoa(q.Page))
+	}
+	if q.PageSize > 0 && q.PageSize != DefaultPageSize {
+		v.Set("size", strconv.Itoa(q.PageSize))
+	}
+	if q.Sort != "" {
+		v.Set("sort", q.Sort)
+	}
+	if q.Desc {
+		v.Set("dir", "desc")
+	}
+	if q.RoomID > 0 {
+		v.Set("room", strconv.Itoa(q.RoomID))
+	}
+	if !q.From.IsZero() {
+		v.Set("from", q.From.Format("2006-01-02"))
+	}
+	if !q.To.IsZero() {
+		v.Set("to", q.To.Format("2006-01-02"))
+	}
+	if q.Status != "" {
+		v.Set("status", string(q.Status))
+	}
+	if q.Search != "" {
+		v.Set("q", q.Search)
+	}
+	return v
+}
+
+// PageURL returns the query string for page n with the same filters and sorting
+func (q ReservationQuery) PageURL(n int) string {
+	q.Page = n
+	return "?" + q.Values().Encode()
+}
+
+// SortURL returns the query string that sorts by col, reversing the direction
+// if the listing is already sorted by it. Sorting starts again from the first page.
+func (q ReservationQuery) SortURL(col string) string {
+	q.Desc = q.Sort == col && !q.Desc
+	q.Sort = col
+	q.Page = 1
+	return "?" + q.Values().Encode()
+}
+
+// SortIndicator returns an arrow showing the sort direction if the listing is sorted by col
+func (q ReservationQuery) SortIndicator(col string) string {
+	if q.Sort != col {
+		return ""
+	}
+	if q.Desc {
+		return "▼"
+	}
+	return "▲"
+}
+
+// ReservationPage is one page of a reservation listing
+type ReservationPage struct {
+	Reservations []Reservation
+	Query        ReservationQuery
+	// Total counts every reservation matching the query, across all pages
+	Total int
+}
+
+// TotalPages returns the number of pages, at least 1 so an empty listing still has a page
+func (p ReservationPage) TotalPages() int {
+	if p.Query.PageSize < 1 || p.Total == 0 {
+		return 1
+	}
+	return (p.Total + p.Query.PageSize - 1) / p.Query.PageSize
+}
+
+// HasPrev reports whether there is a page before this one
+func (p ReservationPage) HasPrev() bool {
+	return p.Query.Page > 1
+}
+
+// HasNext reports whether there is a page after this one
+func (p ReservationPage) HasNext() bool {
+	return p.Query.Page < p.TotalPages()
+}
@@ -0,0 +1,33 @@
+package models
+
+// ReservationSource is how a reservation was made
+type ReservationSource string
+
+const (
+	SourceOnline ReservationSource = "online"
+	SourcePhone  ReservationSource = "phone"
+	SourceWalkIn ReservationSource = "walk_in"
+)
+
+// AdminReservationSources lists the sources an admin can record when creating a reservation
+var AdminReservationSources = []ReservationSource{SourcePhone, SourceWalkIn}
+
+var sourceLabels = map[ReservationSource]string{
+	SourceOnline: "Online",
+	SourcePhone:  "Phone",
+	SourceWalkIn: "Walk-in",
+}
+
+// Valid reports whether s is a known source
+func (s ReservationSource) Valid() bool {
+	_, ok := sourceLabels[s]
+	return ok
+}
+
+// Label returns the source as shown to people
+func (s ReservationSource) Label() string {
+	if label, ok := sourceLabels[s]; ok {
+		return label
+	}
+	return string(s)
+}
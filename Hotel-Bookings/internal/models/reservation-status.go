@@ -0,0 +1,99 @@
+package models
+
+import "time"
+
+// ReservationStatus is where a reservation is in its lifecycle
+type ReservationStatus string
+
+const (
+	StatusNew        ReservationStatus = "new"
+	StatusConfirmed  ReservationStatus = "confirmed"
+	StatusCheckedIn  ReservationStatus = "checked_in"
+	StatusCheckedOut ReservationStatus = "checked_out"
+	StatusCancelled  ReservationStatus = "cancelled"
+	StatusNoShow     ReservationStatus = "no_show"
+)
+
+// ReservationStatuses lists every status in lifecycle order
+var ReservationStatuses = []ReservationStatus{StatusNew, StatusConfirmed, StatusCheckedIn, StatusCheckedOut, StatusCancelled, StatusNoShow}
+
+// statusTransitions is the one place that decides which status can follow which.
+// Checked out, cancelled and no-show reservations are final.
+var statusTransitions = map[ReservationStatus][]ReservationStatus{
+	StatusNew:       {StatusConfirmed, StatusCancelled},
+	StatusConfirmed: {StatusCheckedIn, StatusNoShow, StatusCancelled},
+	StatusCheckedIn: {StatusCheckedOut},
+}
+
+var statusLabels = map[ReservationStatus]string{
+	StatusNew:        "New",
+	StatusConfirmed:  "Confirmed",
+	StatusCheckedIn:  "Checked in",
+	StatusCheckedOut: "Checked out",
+	StatusCancelled:  "Cancelled",
+	StatusNoShow:     "No-show",
+}
+
+// statusActions names the admin action that moves a reservation into a status
+var statusActions = map[ReservationStatus]string{
+	StatusConfirmed:  "Confirm",
+	StatusCheckedIn:  "Check in",
+	StatusCheckedOut: "Check out",
+	StatusCancelled:  "Cancel",
+	StatusNoShow:     "Mark as no-show",
+}
+
+// Valid reports whether s is a known status
+func (s ReservationStatus) Valid() bool {
+	_, ok := statusLabels[s]
+	return ok
+}
+
+// Label returns the status as shown to people
+func (s ReservationStatus) Label() string {
+	if label, ok := statusLabels[s]; ok {
+		return label
+	}
+	return string(s)
+}
+
+// Action returns the name of the admin action that moves a reservation into s
+func (s ReservationStatus) Action() string {
+	return statusActions[s]
+}
+
+// Transitions returns the statuses a reservation in status s can move to
+func (s ReservationStatus) Transitions() []ReservationStatus {
+	return statusTransitions[s]
+}
+
+// CanTransitionTo reports whether a reservation in status s can move to next
+func (s ReservationStatus) CanTransitionTo(next ReservationStatus) bool {
+	for _, allowed := range statusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Final reports whether s ends the lifecycle, so nothing about the stay can change any more
+func (s ReservationStatus) Final() bool {
+	return len(statusTransitions[s]) == 0
+}
+
+// Changeable reports whether guests can still change or cancel a reservation in status s
+func (s ReservationStatus) Changeable() bool {
+	return s == StatusNew || s == StatusConfirmed
+}
+
+// ReservationStatusChange records one status transition of a reservation
+type ReservationStatusChange struct {
+	ID            int
+	ReservationID int
+	FromStatus    ReservationStatus
+	ToStatus      ReservationStatus
+	// UserID is the admin who made the change, 0 when it was not made by an admin
+	UserID    int
+	CreatedAt time.Time
+}
@@ -0,0 +1,9 @@
+package models
+
+// Thumbnail returns the address of the photo's thumbnail, or of the photo itself when it has none
+func (p RoomPhoto) Thumbnail() string {
+	if p.ThumbnailPath != "" {
+		return p.ThumbnailPath
+	}
+	return p.Path
+}
@@ -0,0 +1,24 @@
+package models
+
+import "strings"
+
+// Slugify turns a room name into a slug for its public page, "Major's Suite" becoming "majors-suite"
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			dash = false
+		case c == '\'':
+			// apostrophes are dropped rather than turned into a dash
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
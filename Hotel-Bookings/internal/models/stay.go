@@ -0,0 +1,20 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// Nights returns the number of nights between arrival and departure, 0 when departure is not after arrival
+func Nights(start, end time.Time) int {
+	nights := int(math.Round(end.Sub(start).Hours() / 24))
+	if nights < 0 {
+		return 0
+	}
+	return nights
+}
+
+// Nights returns the number of nights the reservation is for
+func (r Reservation) Nights() int {
+	return Nights(r.StartDate, r.EndDate)
+}
@@ -14,4 +14,6 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	// Rooms are the active rooms, listed in the site navigation
+	Rooms []Room
 }
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Weekdays is a set of days of the week, bit n standing for time.Weekday(n)
+type Weekdays uint8
+
+// AllWeekdays lists the days of the week in the order they are shown, starting on Monday
+var AllWeekdays = []time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+// NewWeekdays returns the set of the given days
+func NewWeekdays(days ...time.Weekday) Weekdays {
+	var w Weekdays
+	for _, day := range days {
+		if day >= time.Sunday && day <= time.Saturday {
+			w |= 1 << uint(day)
+		}
+	}
+	return w
+}
+
+// Has reports whether day is in the set
+func (w Weekdays) Has(day time.Weekday) bool {
+	return w&(1<<uint(day)) != 0
+}
+
+// Matches reports whether a rule limited to w covers day. An empty set covers every day.
+func (w Weekdays) Matches(day time.Weekday) bool {
+	return w == 0 || w.Has(day)
+}
+
+// Count returns how many days a rule limited to w covers
+func (w Weekdays) Count() int {
+	n := 0
+	for _, day := range AllWeekdays {
+		if w.Matches(day) {
+			n++
+		}
+	}
+	return n
+}
+
+// String lists the days as shown to people, like "Fri, Sat", or "Every day"
+func (w Weekdays) String() string {
+	if w.Count() == len(AllWeekdays) {
+		return "Every day"
+	}
+
+	var names []string
+	for _, day := range AllWeekdays {
+		if w.Has(day) {
+			names = append(names, day.String()[:3])
+		}
+	}
+	return strings.Join(names, ", ")
+}
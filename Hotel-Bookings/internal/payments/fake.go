@@ -0,0 +1,317 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+)
+
+// Card numbers the fake provider treats specially. Any other card number that passes the Luhn check
+// is authorized straight away.
+const (
+	FakeCardSuccess   = "4242424242424242"
+	FakeCardDecline   = "4000000000000002"
+	FakeCardChallenge = "4000000000003220"
+)
+
+// FakeCard is a card number to try the fake provider with and what it does
+type FakeCard struct {
+	Number  string
+	Outcome string
+}
+
+// FakeCards lists the cards to try the fake provider with, for payment forms to show
+var FakeCards = []FakeCard{
+	{FakeCardSuccess, "Authorized"},
+	{FakeCardDecline, "Declined"},
+	{FakeCardChallenge, "Asks the guest to authenticate"},
+}
+
+// FakeChallengePath is where the fake provider serves the page guests authenticate payments on,
+// followed by the payment's reference
+const FakeChallengePath = "/payments/fake/"
+
+// FakeSignatureHeader carries the signature of webhooks from the fake provider
+const FakeSignatureHeader = "Fake-Signature"
+
+// Fake is a payment provider that runs in the application, for development and tests. It keeps payments
+// in memory and stands in for the guest's bank on its authentication page, see ServeHTTP.
+type Fake struct {
+	secret []byte
+
+	mu       sync.Mutex
+	payments map[string]*fakePayment
+	lastID   int
+}
+
+type fakePayment struct {
+	amount    models.Money
+	refunded  models.Money
+	status    models.PaymentStatus
+	last4     string
+	reason    string
+	returnURL string
+}
+
+// NewFake creates a fake provider that signs webhooks with secret. Without a secret, no webhook verifies.
+func NewFake(secret string) *Fake {
+	return &Fake{
+		secret:   []byte(secret),
+		payments: make(map[string]*fakePayment),
+	}
+}
+
+// Name returns "fake"
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+// Authorize authorizes the payment, declines it or asks the guest to authenticate, depending on the card
+func (f *Fake) Authorize(ctx context.Context, req AuthorizeRequest) (Authorization, error) {
+	if req.Amount <= 0 {
+		return Authorization{}, ErrInvalidAmount
+	}
+
+	card := strings.NewReplacer(" ", "", "-", "").Replace(req.Card)
+	p := &fakePayment{
+		amount:    req.Amount,
+		status:    models.PaymentAuthorized,
+		returnURL: req.ReturnURL,
+	}
+	if len(card) >= 4 {
+		p.last4 = card[len(card)-4:]
+	}
+
+	switch {
+	case !luhnValid(card):
+		p.status = models.PaymentDeclined
+		p.reason = "Your card number is incorrect"
+	case card == FakeCardDecline:
+		p.status = models.PaymentDeclined
+		p.reason = "Your card was declined"
+	case card == FakeCardChallenge:
+		p.status = models.PaymentPending
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lastID++
+	reference := fmt.Sprintf("fake_%d", f.lastID)
+	f.payments[reference] = p
+
+	return p.authorization(reference), nil
+}
+
+// Lookup returns where a payment stands
+func (f *Fake) Lookup(ctx context.Context, reference string) (Authorization, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[reference]
+	if !ok {
+		return Authorization{}, ErrUnknownPayment
+	}
+	return p.authorization(reference), nil
+}
+
+// Capture takes amount of an authorized payment
+func (f *Fake) Capture(ctx context.Context, reference string, amount models.Money) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[reference]
+	if !ok {
+		return ErrUnknownPayment
+	}
+	if p.status != models.PaymentAuthorized {
+		return ErrInvalidState
+	}
+	if amount <= 0 || amount > p.amount {
+		return ErrInvalidAmount
+	}
+
+	p.amount = amount
+	p.status = models.PaymentCaptured
+	return nil
+}
+
+// Refund gives back amount of a captured payment, or releases an authorized or pending one in full
+func (f *Fake) Refund(ctx context.Context, reference string, amount models.Money) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[reference]
+	if !ok {
+		return ErrUnknownPayment
+	}
+
+	switch p.status {
+	case models.PaymentAuthorized, models.PaymentPending:
+		if amount != p.amount {
+			return ErrInvalidAmount
+		}
+	case models.PaymentCaptured:
+		if amount <= 0 || amount > p.amount-p.refunded {
+			return ErrInvalidAmount
+		}
+	default:
+		return ErrInvalidState
+	}
+
+	p.refunded += amount
+	if p.refunded == p.amount {
+		p.status = models.PaymentRefunded
+	}
+	return nil
+}
+
+// fakeWebhook is the body of a webhook from the fake provider
+type fakeWebhook struct {
+	Reference string               `json:"reference"`
+	Status    models.PaymentStatus `json:"status"`
+	Refunded  models.Money         `json:"refunded"`
+}
+
+// VerifyWebhook checks the body of r is signed with the provider's secret in the FakeSignatureHeader
+// and reads the event from it
+func (f *Fake) VerifyWebhook(r *http.Request) (Event, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return Event{}, err
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(FakeSignatureHeader))
+	if err != nil || len(f.secret) == 0 || !hmac.Equal(signature, f.sign(body)) {
+		return Event{}, ErrInvalidSignature
+	}
+
+	var hook fakeWebhook
+	err = json.Unmarshal(body, &hook)
+	if err != nil {
+		return Event{}, err
+	}
+	if hook.Reference == "" || !hook.Status.Valid() {
+		return Event{}, fmt.Errorf("webhook for payment %q has status %q", hook.Reference, hook.Status)
+	}
+
+	return Event{Reference: hook.Reference, Status: hook.Status, Refunded: hook.Refunded}, nil
+}
+
+// Webhook returns the body and signature of a webhook about a payment, like the fake provider would send
+func (f *Fake) Webhook(event Event) (body []byte, signature string) {
+	body, _ = json.Marshal(fakeWebhook{Reference: event.Reference, Status: event.Status, Refunded: event.Refunded})
+	return body, hex.EncodeToString(f.sign(body))
+}
+
+// sign returns the HMAC-SHA256 of body with the provider's secret
+func (f *Fake) sign(body []byte) []byte {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+var challengePage = template.Must(template.New("challenge").Parse(`<!doctype html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Fake Bank</title></head>
+<body style="font-family: sans-serif; max-width: 30rem; margin: 3rem auto;">
+    <h1>Fake Bank</h1>
+    <p>Please confirm the payment of <strong>{{.Amount}}</strong> with your card ending in {{.Last4}}.</p>
+    <form method="post">
+        <button type="submit" name="outcome" value="approve">Confirm</button>
+        <button type="submit" name="outcome" value="fail">Fail authentication</button>
+    </form>
+</body>
+</html>
+`))
+
+// ServeHTTP serves the page guests authenticate pending payments on, at FakeChallengePath followed by
+// the reference. Confirming authorizes the payment, failing declines it, and either sends the guest back
+// to the payment's return address.
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reference := strings.TrimPrefix(r.URL.Path, FakeChallengePath)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[reference]
+	if !ok || p.status != models.PaymentPending {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		_ = challengePage.Execute(w, struct {
+			Amount models.Money
+			Last4  string
+		}{p.amount, p.last4})
+		return
+	}
+
+	if r.PostFormValue("outcome") == "approve" {
+		p.status = models.PaymentAuthorized
+	} else {
+		p.status = models.PaymentDeclined
+		p.reason = "Your bank could not authenticate the payment"
+	}
+
+	returnURL, err := url.Parse(p.returnURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	query := returnURL.Query()
+	query.Set("reference", reference)
+	returnURL.RawQuery = query.Encode()
+
+	http.Redirect(w, r, returnURL.String(), http.StatusSeeOther)
+}
+
+// authorization describes the payment as Authorize and Lookup return it
+func (p *fakePayment) authorization(reference string) Authorization {
+	a := Authorization{
+		Reference:     reference,
+		Status:        p.status,
+		Amount:        p.amount,
+		CardLast4:     p.last4,
+		DeclineReason: p.reason,
+	}
+	if p.status == models.PaymentPending {
+		a.RedirectURL = FakeChallengePath + reference
+	}
+	return a
+}
+
+// luhnValid reports whether number is 12 to 19 digits with a valid Luhn check digit
+func luhnValid(number string) bool {
+	if len(number) < 12 || len(number) > 19 {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < len(number); i++ {
+		d := int(number[len(number)-1-i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,159 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+)
+
+func TestFakeAuthorize(t *testing.T) {
+	fake := NewFake("secret")
+
+	var tests = []struct {
+		name           string
+		card           string
+		expectedStatus models.PaymentStatus
+		expectedReason string
+	}{
+		{"success", "4242 4242 4242 4242", models.PaymentAuthorized, ""},
+		{"other valid card", "5555-5555-5555-4444", models.PaymentAuthorized, ""},
+		{"decline", FakeCardDecline, models.PaymentDeclined, "Your card was declined"},
+		{"challenge", FakeCardChallenge, models.PaymentPending, ""},
+		{"bad check digit", "4242424242424241", models.PaymentDeclined, "Your card number is incorrect"},
+		{"not a number", "four two", models.PaymentDeclined, "Your card number is incorrect"},
+	}
+
+	for _, e := range tests {
+		auth, err := fake.Authorize(context.Background(), AuthorizeRequest{Amount: 10000, Card: e.card, ReturnURL: "/payment-return"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth.Status != e.expectedStatus || auth.DeclineReason != e.expectedReason {
+			t.Errorf("%s: got %s %q, wanted %s %q", e.name, auth.Status, auth.DeclineReason, e.expectedStatus, e.expectedReason)
+		}
+		if (auth.RedirectURL != "") != (e.expectedStatus == models.PaymentPending) {
+			t.Errorf("%s: got redirect %q", e.name, auth.RedirectURL)
+		}
+	}
+
+	if _, err := fake.Authorize(context.Background(), AuthorizeRequest{Card: FakeCardSuccess}); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("authorizing nothing: got error %v, wanted %v", err, ErrInvalidAmount)
+	}
+}
+
+func TestFakeCaptureAndRefund(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake("secret")
+
+	auth, _ := fake.Authorize(ctx, AuthorizeRequest{Amount: 10000, Card: FakeCardSuccess})
+
+	if err := fake.Refund(ctx, auth.Reference, 5000); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("releasing part of an authorization: got error %v, wanted %v", err, ErrInvalidAmount)
+	}
+	if err := fake.Capture(ctx, auth.Reference, 12000); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("capturing more than authorized: got error %v, wanted %v", err, ErrInvalidAmount)
+	}
+	if err := fake.Capture(ctx, auth.Reference, 8000); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.Capture(ctx, auth.Reference, 8000); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("capturing twice: got error %v, wanted %v", err, ErrInvalidState)
+	}
+
+	if err := fake.Refund(ctx, auth.Reference, 3000); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.Refund(ctx, auth.Reference, 6000); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("refunding more than is left: got error %v, wanted %v", err, ErrInvalidAmount)
+	}
+	if err := fake.Refund(ctx, auth.Reference, 5000); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := fake.Lookup(ctx, auth.Reference); got.Status != models.PaymentRefunded {
+		t.Errorf("got status %s after refunding everything, wanted %s", got.Status, models.PaymentRefunded)
+	}
+
+	// authorizations are released in full
+	auth, _ = fake.Authorize(ctx, AuthorizeRequest{Amount: 10000, Card: FakeCardSuccess})
+	if err := fake.Refund(ctx, auth.Reference, 10000); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.Capture(ctx, auth.Reference, 10000); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("capturing a released payment: got error %v, wanted %v", err, ErrInvalidState)
+	}
+
+	if err := fake.Capture(ctx, "fake_99", 100); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("capturing an unknown payment: got error %v, wanted %v", err, ErrUnknownPayment)
+	}
+}
+
+func TestFakeChallenge(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake("secret")
+
+	for _, outcome := range []string{"approve", "fail"} {
+		auth, _ := fake.Authorize(ctx, AuthorizeRequest{Amount: 10000, Card: FakeCardChallenge, ReturnURL: "/payment-return"})
+
+		req := httptest.NewRequest("GET", auth.RedirectURL, nil)
+		rr := httptest.NewRecorder()
+		fake.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "$100.00") {
+			t.Errorf("%s: got code %d for the authentication page", outcome, rr.Code)
+		}
+
+		form := url.Values{"outcome": {outcome}}
+		req = httptest.NewRequest("POST", auth.RedirectURL, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr = httptest.NewRecorder()
+		fake.ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != "/payment-return?reference="+auth.Reference {
+			t.Errorf("%s: got redirected to %q", outcome, loc)
+		}
+
+		got, _ := fake.Lookup(ctx, auth.Reference)
+		if outcome == "approve" && got.Status != models.PaymentAuthorized {
+			t.Errorf("approve: got status %s", got.Status)
+		}
+		if outcome == "fail" && (got.Status != models.PaymentDeclined || got.DeclineReason == "") {
+			t.Errorf("fail: got status %s %q", got.Status, got.DeclineReason)
+		}
+
+		// the page is gone once the guest has answered
+		rr = httptest.NewRecorder()
+		fake.ServeHTTP(rr, httptest.NewRequest("GET", auth.RedirectURL, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s: got code %d for an answered authentication page", outcome, rr.Code)
+		}
+	}
+}
+
+func TestFakeVerifyWebhook(t *testing.T) {
+	fake := NewFake("secret")
+	body, signature := fake.Webhook(Event{Reference: "fake_1", Status: models.PaymentRefunded, Refunded: 10000})
+
+	req := httptest.NewRequest("POST", "/payments/webhook", bytes.NewReader(body))
+	req.Header.Set(FakeSignatureHeader, signature)
+	event, err := fake.VerifyWebhook(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Reference != "fake_1" || event.Status != models.PaymentRefunded || event.Refunded != 10000 {
+		t.Errorf("got event %+v", event)
+	}
+
+	for name, f := range map[string]*Fake{"other secret": NewFake("other"), "no secret": NewFake("")} {
+		req = httptest.NewRequest("POST", "/payments/webhook", bytes.NewReader(body))
+		req.Header.Set(FakeSignatureHeader, signature)
+		if _, err = f.VerifyWebhook(req); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: got error %v, wanted %v", name, err, ErrInvalidSignature)
+		}
+	}
+}
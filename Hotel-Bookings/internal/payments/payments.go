@@ -0,0 +1,128 @@
+// Package payments takes card payments for reservations through a payment provider
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+)
+
+// ErrUnknownPayment is returned for a reference the provider has no payment for
+var ErrUnknownPayment = errors.New("payment not found")
+
+// ErrInvalidState is returned when a payment is captured or refunded while its status does not allow it
+var ErrInvalidState = errors.New("payment cannot do that in its current state")
+
+// ErrInvalidAmount is returned for amounts of nothing, or more than a payment holds
+var ErrInvalidAmount = errors.New("invalid payment amount")
+
+// ErrInvalidSignature is returned for webhooks that were not signed by the provider
+var ErrInvalidSignature = errors.New("webhook signature is invalid")
+
+// Provider is a payment gateway. Payments are authorized when a reservation is made, which holds the money
+// on the guest's card, and captured later to take it.
+type Provider interface {
+	// Name identifies the provider on stored payments
+	Name() string
+	// Authorize holds an amount on a card. The payment comes back authorized, declined, or pending
+	// when the guest has to authenticate with their bank at the RedirectURL first.
+	Authorize(ctx context.Context, req AuthorizeRequest) (Authorization, error)
+	// Lookup returns where a payment stands, like after the guest comes back from authenticating
+	Lookup(ctx context.Context, reference string) (Authorization, error)
+	// Capture takes amount of an authorized payment and releases the rest
+	Capture(ctx context.Context, reference string, amount models.Money) error
+	// Refund gives back amount of a captured payment, or releases an authorized one in full
+	Refund(ctx context.Context, reference string, amount models.Money) error
+	// VerifyWebhook checks a notification the provider sent about a payment and returns what it says
+	VerifyWebhook(r *http.Request) (Event, error)
+}
+
+// AuthorizeRequest is a payment to authorize
+type AuthorizeRequest struct {
+	Amount      models.Money
+	Description string
+	// Card is what the payment form sent for the card, a card number for the fake provider
+	Card string
+	// ReturnURL is where guests who authenticate with their bank are sent back to,
+	// with the payment's reference added as the "reference" parameter
+	ReturnURL string
+}
+
+// Authorization is the outcome of authorizing a payment
+type Authorization struct {
+	Reference string
+	Status    models.PaymentStatus
+	// Amount is what the payment holds on the card, or what was taken once it is captured
+	Amount models.Money
+	// RedirectURL is where to send the guest to authenticate, for pending payments
+	RedirectURL   string
+	CardLast4     string
+	DeclineReason string
+}
+
+// Event is a change to a payment the provider notified us of
+type Event struct {
+	Reference string
+	Status    models.PaymentStatus
+	// Refunded is how much of the payment has been refunded so far
+	Refunded models.Money
+}
+
+// Settlement is what to do with a payment of a cancelled reservation: capture part of it,
+// give part of it back, or both left at 0 to leave it alone
+type Settlement struct {
+	Payment models.Payment
+	// Capture is how much of an authorized payment to take, the rest of it is released
+	Capture models.Money
+	// Refund is how much of a captured payment to give back, or the whole of an authorized or pending
+	// payment to release
+	Refund models.Money
+}
+
+// Settle works out how to settle the payments of a cancelled reservation so that fee is kept and
+// everything else is given back. Payments are used up in order. Those there is nothing to do with are
+// left out, so nothing is returned when no payment holds any money.
+func Settle(payments []models.Payment, fee models.Money) []Settlement {
+	var settlements []Settlement
+
+	for _, p := range payments {
+		s := Settlement{Payment: p}
+
+		switch p.Status {
+		case models.PaymentPending:
+			s.Refund = p.Amount
+		case models.PaymentAuthorized:
+			if fee > 0 {
+				s.Capture = min(fee, p.Amount)
+				fee -= s.Capture
+			} else {
+				s.Refund = p.Amount
+			}
+		case models.PaymentCaptured:
+			left := p.Amount - p.Refunded
+			kept := min(fee, left)
+			fee -= kept
+			s.Refund = left - kept
+		}
+
+		if s.Capture > 0 || s.Refund > 0 {
+			settlements = append(settlements, s)
+		}
+	}
+
+	return settlements
+}
+
+// Refunded returns how much settling gives back to the guest. Pending payments hold no money yet,
+// so releasing them gives nothing back.
+func Refunded(settlements []Settlement) models.Money {
+	var refunded models.Money
+	for _, s := range settlements {
+		if s.Payment.Status != models.PaymentPending {
+			refunded += s.Refund
+		}
+	}
+	return refunded
+}
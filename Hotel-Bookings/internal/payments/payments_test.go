@@ -0,0 +1,63 @@
+package payments
+
+import (
+	"testing"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+)
+
+func TestSettle(t *testing.T) {
+	authorized := models.Payment{ID: 1, Status: models.PaymentAuthorized, Amount: 20000}
+	captured := models.Payment{ID: 2, Status: models.PaymentCaptured, Amount: 20000, Refunded: 5000}
+	pending := models.Payment{ID: 3, Status: models.PaymentPending, Amount: 20000}
+	declined := models.Payment{ID: 4, Status: models.PaymentDeclined, Amount: 20000}
+
+	type settled struct {
+		id      int
+		capture models.Money
+		refund  models.Money
+	}
+
+	var tests = []struct {
+		name     string
+		payments []models.Payment
+		fee      models.Money
+		expected []settled
+	}{
+		{"release an authorization", []models.Payment{authorized}, 0, []settled{{1, 0, 20000}}},
+		{"capture the fee", []models.Payment{authorized}, 5000, []settled{{1, 5000, 0}}},
+		{"fee over the authorization", []models.Payment{authorized}, 30000, []settled{{1, 20000, 0}}},
+		{"refund what is left", []models.Payment{captured}, 0, []settled{{2, 0, 15000}}},
+		{"keep the fee of a capture", []models.Payment{captured}, 5000, []settled{{2, 0, 10000}}},
+		{"keep all of a capture", []models.Payment{captured}, 15000, nil},
+		{"release pending and skip declined", []models.Payment{pending, declined}, 5000, []settled{{3, 0, 20000}}},
+		{"fee across payments", []models.Payment{captured, authorized}, 25000, []settled{{1, 10000, 0}}},
+	}
+
+	for _, e := range tests {
+		got := Settle(e.payments, e.fee)
+		if len(got) != len(e.expected) {
+			t.Errorf("%s: got %d settlements, wanted %d", e.name, len(got), len(e.expected))
+			continue
+		}
+		for i, s := range got {
+			want := e.expected[i]
+			if s.Payment.ID != want.id || s.Capture != want.capture || s.Refund != want.refund {
+				t.Errorf("%s: got payment %d captured %s refunded %s, wanted payment %d captured %s refunded %s",
+					e.name, s.Payment.ID, s.Capture, s.Refund, want.id, want.capture, want.refund)
+			}
+		}
+	}
+}
+
+func TestRefunded(t *testing.T) {
+	settlements := Settle([]models.Payment{
+		{ID: 1, Status: models.PaymentPending, Amount: 20000},
+		{ID: 2, Status: models.PaymentAuthorized, Amount: 20000},
+		{ID: 3, Status: models.PaymentCaptured, Amount: 20000, Refunded: 5000},
+	}, 0)
+
+	if got := Refunded(settlements); got != 35000 {
+		t.Errorf("got %s refunded, wanted $350.00 without the pending payment", got)
+	}
+}
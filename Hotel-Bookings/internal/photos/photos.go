@@ -0,0 +1,242 @@
+// Package photos stores uploaded room photos in a local directory,
+// together with a web-optimized copy and a thumbnail of each
+package photos
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ErrTooLarge is returned when an upload is bigger than the store allows, in bytes or in pixels
+var ErrTooLarge = errors.New("photo is too large")
+
+// ErrUnsupportedType is returned when an upload is not a JPEG, PNG, GIF or WebP image
+var ErrUnsupportedType = errors.New("photo must be a JPEG, PNG, GIF or WebP image")
+
+// DefaultMaxBytes is the largest upload accepted when the store is not given a limit
+const DefaultMaxBytes = 10 << 20
+
+const (
+	// the web-optimized copy and the thumbnail fit within these sizes, keeping the photo's proportions
+	webWidth, webHeight     = 1600, 1200
+	thumbWidth, thumbHeight = 400, 300
+	jpegQuality             = 82
+
+	// maxPixels rejects images that are small files but decode to huge bitmaps
+	maxPixels = 50000000
+)
+
+// extensions maps the accepted content types to the extension originals are stored with
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Store keeps photos as files in Dir, served from addresses starting with URLPrefix
+type Store struct {
+	Dir       string
+	URLPrefix string
+	MaxBytes  int64
+}
+
+// New creates a store. maxBytes of 0 or less means DefaultMaxBytes.
+func New(dir, urlPrefix string, maxBytes int64) *Store {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	if !strings.HasSuffix(urlPrefix, "/") {
+		urlPrefix += "/"
+	}
+
+	return &Store{
+		Dir:       dir,
+		URLPrefix: urlPrefix,
+		MaxBytes:  maxBytes,
+	}
+}
+
+// Photo holds the addresses of a stored photo: the file as it was uploaded,
+// the copy shown on pages and its thumbnail
+type Photo struct {
+	Original  string
+	Web       string
+	Thumbnail string
+}
+
+// Save checks that r holds an image no larger than MaxBytes, then stores it with a web-optimized JPEG copy
+// and a JPEG thumbnail. It returns ErrTooLarge or ErrUnsupportedType for uploads it does not accept.
+func (s *Store) Save(r io.Reader) (Photo, error) {
+	data, err := io.ReadAll(io.LimitReader(r, s.MaxBytes+1))
+	if err != nil {
+		return Photo{}, err
+	}
+	if int64(len(data)) > s.MaxBytes {
+		return Photo{}, ErrTooLarge
+	}
+
+	ext, ok := extensions[http.DetectContentType(data)]
+	if !ok {
+		return Photo{}, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Photo{}, ErrUnsupportedType
+	}
+	if config.Width*config.Height > maxPixels {
+		return Photo{}, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Photo{}, ErrUnsupportedType
+	}
+
+	name, err := randomName()
+	if err != nil {
+		return Photo{}, err
+	}
+
+	err = os.MkdirAll(s.Dir, 0755)
+	if err != nil {
+		return Photo{}, err
+	}
+
+	files := []string{name + ext, name + "-web.jpg", name + "-thumb.jpg"}
+
+	err = os.WriteFile(filepath.Join(s.Dir, files[0]), data, 0644)
+	if err == nil {
+		err = s.writeJPEG(files[1], fit(img, webWidth, webHeight))
+	}
+	if err == nil {
+		err = s.writeJPEG(files[2], fit(img, thumbWidth, thumbHeight))
+	}
+	if err != nil {
+		for _, file := range files {
+			os.Remove(filepath.Join(s.Dir, file))
+		}
+		return Photo{}, err
+	}
+
+	return Photo{
+		Original:  s.URLPrefix + files[0],
+		Web:       s.URLPrefix + files[1],
+		Thumbnail: s.URLPrefix + files[2],
+	}, nil
+}
+
+// Remove deletes the stored files behind the given addresses. Addresses outside the store,
+// like photos under /static, are skipped, as are files that are already gone.
+func (s *Store) Remove(urls ...string) error {
+	for _, url := range urls {
+		name := strings.TrimPrefix(url, s.URLPrefix)
+		if name == url || name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		err := os.Remove(filepath.Join(s.Dir, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Files returns the stored files for an http.FileServer. Directories are never listed, so only someone
+// given an address can fetch a photo, and the originals as uploaded are left out unless originals is true.
+func (s *Store) Files(originals bool) http.FileSystem {
+	return storeFiles{dir: http.Dir(s.Dir), originals: originals}
+}
+
+// storeFiles serves the files of a store, see Store.Files
+type storeFiles struct {
+	dir       http.Dir
+	originals bool
+}
+
+// Open opens a stored file, and reports directories and files it does not serve as not existing
+func (f storeFiles) Open(name string) (http.File, error) {
+	if !f.originals && !strings.HasSuffix(name, "-web.jpg") && !strings.HasSuffix(name, "-thumb.jpg") {
+		return nil, fs.ErrNotExist
+	}
+
+	file, err := f.dir.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, fs.ErrNotExist
+	}
+	return file, nil
+}
+
+// writeJPEG stores img in the store's directory as a JPEG file
+func (s *Store) writeJPEG(name string, img image.Image) error {
+	f, err := os.Create(filepath.Join(s.Dir, name))
+	if err != nil {
+		return err
+	}
+
+	err = jpeg.Encode(f, img, &jpeg.Options{Quality: jpegQuality})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// fit scales img down to fit within width by height, keeping its proportions. Smaller images keep their size.
+// The result has a white background, so transparent areas do not turn black in a JPEG.
+func fit(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > width {
+		h = h * width / w
+		w = width
+	}
+	if h > height {
+		w = w * height / h
+		h = height
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// randomName returns a file name that cannot be guessed from the room or the upload
+func randomName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
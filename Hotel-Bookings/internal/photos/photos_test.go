@@ -0,0 +1,162 @@
+package photos
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testPNG returns a PNG image of the given size
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, height/2, color.NRGBA{R: 200, A: 255})
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// jpegSize decodes a stored JPEG and returns its size
+func jpegSize(t *testing.T, store *Store, url string) (int, int) {
+	f, err := os.Open(filepath.Join(store.Dir, strings.TrimPrefix(url, store.URLPrefix)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	config, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.Width, config.Height
+}
+
+func TestSave(t *testing.T) {
+	store := New(t.TempDir(), "/uploads", 0)
+
+	photo, err := store.Save(bytes.NewReader(testPNG(t, 2000, 1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(photo.Original, "/uploads/") || !strings.HasSuffix(photo.Original, ".png") {
+		t.Errorf("got original %q, wanted a .png under /uploads/", photo.Original)
+	}
+	if w, h := jpegSize(t, store, photo.Web); w != 1600 || h != 800 {
+		t.Errorf("got web copy of %dx%d, wanted 1600x800", w, h)
+	}
+	if w, h := jpegSize(t, store, photo.Thumbnail); w != 400 || h != 200 {
+		t.Errorf("got thumbnail of %dx%d, wanted 400x200", w, h)
+	}
+
+	// small photos are not enlarged
+	photo, err = store.Save(bytes.NewReader(testPNG(t, 300, 200)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := jpegSize(t, store, photo.Web); w != 300 || h != 200 {
+		t.Errorf("got web copy of %dx%d, wanted 300x200", w, h)
+	}
+}
+
+func TestSaveRejects(t *testing.T) {
+	var tests = []struct {
+		name     string
+		maxBytes int64
+		data     []byte
+		expected error
+	}{
+		{"text", 0, []byte("hello, this is not a photo"), ErrUnsupportedType},
+		{"html", 0, []byte("<html><body>not a photo</body></html>"), ErrUnsupportedType},
+		{"broken png", 0, testPNG(t, 10, 10)[:40], ErrUnsupportedType},
+		{"too many bytes", 100, testPNG(t, 100, 100), ErrTooLarge},
+	}
+
+	for _, e := range tests {
+		store := New(t.TempDir(), "/uploads/", e.maxBytes)
+
+		_, err := store.Save(bytes.NewReader(e.data))
+		if !errors.Is(err, e.expected) {
+			t.Errorf("%s: got error %v, wanted %v", e.name, err, e.expected)
+		}
+
+		files, _ := os.ReadDir(store.Dir)
+		if len(files) != 0 {
+			t.Errorf("%s: %d files were left behind", e.name, len(files))
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	store := New(t.TempDir(), "/uploads/", 0)
+
+	photo, err := store.Save(bytes.NewReader(testPNG(t, 50, 50)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Remove(photo.Original, photo.Web, photo.Thumbnail, "/static/images/tray.png", "/uploads/../secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ := os.ReadDir(store.Dir)
+	if len(files) != 0 {
+		t.Errorf("%d files are left after Remove", len(files))
+	}
+
+	// removing again is not an error
+	if err = store.Remove(photo.Original); err != nil {
+		t.Errorf("removing a missing file: got error %v", err)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	store := New(t.TempDir(), "/uploads/", 0)
+
+	photo, err := store.Save(bytes.NewReader(testPNG(t, 50, 50)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := func(url string) string {
+		return "/" + strings.TrimPrefix(url, store.URLPrefix)
+	}
+
+	var tests = []struct {
+		name      string
+		file      string
+		originals bool
+		served    bool
+	}{
+		{"web copy", name(photo.Web), false, true},
+		{"thumbnail", name(photo.Thumbnail), false, true},
+		{"original", name(photo.Original), false, false},
+		{"original for admins", name(photo.Original), true, true},
+		{"directory", "/", false, false},
+		{"directory for admins", "/", true, false},
+		{"missing", "/missing-web.jpg", false, false},
+	}
+
+	for _, e := range tests {
+		f, err := store.Files(e.originals).Open(e.file)
+		if f != nil {
+			f.Close()
+		}
+		if served := err == nil; served != e.served {
+			t.Errorf("%s: served %v, wanted %v (error %v)", e.name, served, e.served, err)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: got error %v, wanted it reported as not existing", e.name, err)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Package pricing works out what a stay costs
+package pricing
+
+import (
+	"time"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+)
+
+// Night is one night of a stay and what it costs
+type Night struct {
+	Date time.Time
+	Rate models.Money
+	// Rule is the rate rule that set the rate, nil when the room's base rate applies
+	Rule *models.RateRule
+}
+
+// ChargeLine is a tax or fee on a stay and what it comes to
+type ChargeLine struct {
+	Charge models.Charge
+	Amount models.Money
+}
+
+// Quote is the price of a stay, night by night
+type Quote struct {
+	Nights []Night
+	// Subtotal is the cost of the nights, and Total what there is to pay after Discount and with Charges
+	Subtotal models.Money
+	Discount models.Money
+	Charges  []ChargeLine
+	Total    models.Money
+	// Promo is the promo code the discount comes from, nil when there is none
+	Promo *models.PromoCode
+	// CancellationPolicyID is the policy the stay is booked under, 0 for free cancellation
+	CancellationPolicyID int
+
+	// charges and guests are what Charges are worked out from, again whenever the discount changes
+	charges []models.Charge
+	guests  int
+}
+
+// Price works out what staying in room from start to end costs. Every night is charged at the rate of the
+// most specific rule that covers it, or at the room's nightly rate when no rule does. Rules for other rooms
+// are ignored, so rules for every room can be passed in.
+func Price(room models.Room, rules []models.RateRule, start, end time.Time) Quote {
+	var quote Quote
+
+	for i := 0; i < models.Nights(start, end); i++ {
+		night := Night{
+			Date: start.AddDate(0, 0, i),
+			Rate: room.NightlyRate,
+		}
+
+		night.Rule = ruleFor(room, rules, night.Date)
+		if night.Rule != nil {
+			night.Rate = night.Rule.NightlyRate
+		}
+
+		quote.Nights = append(quote.Nights, night)
+		quote.Subtotal += night.Rate
+	}
+	quote.Total = quote.Subtotal
+
+	// a stay is booked under the policy of the rate it starts on, the room's policy when that rate has none
+	quote.CancellationPolicyID = room.CancellationPolicyID
+	if len(quote.Nights) > 0 && quote.Nights[0].Rule != nil && quote.Nights[0].Rule.CancellationPolicyID != 0 {
+		quote.CancellationPolicyID = quote.Nights[0].Rule.CancellationPolicyID
+	}
+
+	return quote
+}
+
+// ApplyPromo takes the discount of promo off the quote, replacing any discount applied before.
+// It does not check the code can be used for the stay, see models.PromoCode.Check.
+func (q *Quote) ApplyPromo(promo models.PromoCode) {
+	q.ApplyDiscount(promo.Discount(q.Subtotal))
+	q.Promo = &promo
+}
+
+// ApplyDiscount takes amount off the price of the nights, never more than the price itself,
+// replacing any discount applied before
+func (q *Quote) ApplyDiscount(amount models.Money) {
+	if amount > q.Subtotal {
+		amount = q.Subtotal
+	}
+	if amount < 0 {
+		amount = 0
+	}
+
+	q.Promo = nil
+	q.Discount = amount
+	q.update()
+}
+
+// AddCharges adds the taxes and fees of a stay for guests to the quote, replacing any added before.
+// Inactive charges are left out.
+func (q *Quote) AddCharges(charges []models.Charge, guests int) {
+	q.charges = charges
+	q.guests = guests
+	q.update()
+}
+
+// update works out the charges and the total again
+func (q *Quote) update() {
+	price := q.Subtotal - q.Discount
+
+	q.Charges = nil
+	q.Total = price
+	for _, c := range q.charges {
+		if !c.Active {
+			continue
+		}
+		line := ChargeLine{Charge: c, Amount: chargeFor(c, price, len(q.Nights), q.guests)}
+		q.Charges = append(q.Charges, line)
+		q.Total += line.Amount
+	}
+}
+
+// ReservationCharges returns the charges of the quote as they are recorded on a reservation
+func (q Quote) ReservationCharges() []models.ReservationCharge {
+	var charges []models.ReservationCharge
+	for _, line := range q.Charges {
+		charges = append(charges, models.ReservationCharge{Name: line.Charge.Name, Amount: line.Amount})
+	}
+	return charges
+}
+
+// chargeFor works out a charge on a stay of nights for guests, with price the room price after discounts
+func chargeFor(c models.Charge, price models.Money, nights, guests int) models.Money {
+	if c.Method == models.ChargePercent {
+		return c.Rate.Of(price)
+	}
+
+	switch c.Per {
+	case models.PerNight:
+		return c.Amount * models.Money(nights)
+	case models.PerGuestNight:
+		return c.Amount * models.Money(nights*guests)
+	}
+	return c.Amount
+}
+
+// CancellationFee works out what cancelling res on the day of at costs under the policy it was booked under.
+// Cancelling is free until the policy's free days before arrival, counted by day only, and charges the
+// penalty of the total price from then on.
+func CancellationFee(res models.Reservation, at time.Time) models.Money {
+	p := res.CancellationPolicy
+	if p.FreeDays > 0 && daysBetween(at, res.StartDate) >= p.FreeDays {
+		return 0
+	}
+	return p.Penalty.Of(res.TotalPrice)
+}
+
+// daysBetween returns how many days from the day of a to the day of b, negative when b is before a
+func daysBetween(a, b time.Time) int {
+	from := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	to := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// Total returns what staying in room from start to end costs, see Price
+func Total(room models.Room, rules []models.RateRule, start, end time.Time) models.Money {
+	return Price(room, rules, start, end).Total
+}
+
+// ruleFor returns the most specific rule that sets the room's rate on the night of date, or nil
+func ruleFor(room models.Room, rules []models.RateRule, date time.Time) *models.RateRule {
+	var best *models.RateRule
+	for i := range rules {
+		rule := &rules[i]
+		if !covers(rule, room, date) {
+			continue
+		}
+		if best == nil || moreSpecific(rule, best) {
+			best = rule
+		}
+	}
+	return best
+}
+
+// covers reports whether rule applies to the room on the night of date
+func covers(rule *models.RateRule, room models.Room, date time.Time) bool {
+	if rule.RoomID != 0 && rule.RoomID != room.ID {
+		return false
+	}
+
+	// dates are compared by day only, so time zones and times of day do not matter
+	day := date.Format("2006-01-02")
+	if !rule.StartDate.IsZero() && day < rule.StartDate.Format("2006-01-02") {
+		return false
+	}
+	if !rule.EndDate.IsZero() && day > rule.EndDate.Format("2006-01-02") {
+		return false
+	}
+
+	return rule.Weekdays.Matches(date.Weekday())
+}
+
+// moreSpecific reports whether rule a wins over rule b when both cover a night. The higher priority wins,
+// then a rule for the room over one for every room, a shorter date range, fewer days of the week,
+// and last the newer rule.
+func moreSpecific(a, b *models.RateRule) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if (a.RoomID != 0) != (b.RoomID != 0) {
+		return a.RoomID != 0
+	}
+	if da, db := rangeDays(a), rangeDays(b); da != db {
+		return da < db
+	}
+	if ca, cb := a.Weekdays.Count(), b.Weekdays.Count(); ca != cb {
+		return ca < cb
+	}
+	return a.ID > b.ID
+}
+
+// unlimited is the length given to date ranges without a start or an end
+const unlimited = int(^uint(0) >> 1)
+
+// rangeDays returns how many nights a rule's date range covers
+func rangeDays(rule *models.RateRule) int {
+	if rule.StartDate.IsZero() || rule.EndDate.IsZero() {
+		return unlimited
+	}
+	return models.Nights(rule.StartDate, rule.EndDate) + 1
+}
@@ -0,0 +1,268 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+)
+
+func TestTotal(t *testing.T) {
+	room := models.Room{NightlyRate: 12550}
+	layout := "2006-01-02"
+
+	var tests = []struct {
+		name     string
+		start    string
+		end      string
+		expected models.Money
+	}{
+		{"one night", "2050-01-01", "2050-01-02", 12550},
+		{"three nights", "2050-01-01", "2050-01-04", 37650},
+		{"over a month end", "2050-01-30", "2050-02-02", 37650},
+		{"same day", "2050-01-01", "2050-01-01", 0},
+		{"departure before arrival", "2050-01-05", "2050-01-01", 0},
+	}
+
+	for _, e := range tests {
+		start, _ := time.Parse(layout, e.start)
+		end, _ := time.Parse(layout, e.end)
+
+		got := Total(room, nil, start, end)
+		if got != e.expected {
+			t.Errorf("%s: got %d, wanted %d", e.name, got, e.expected)
+		}
+	}
+}
+
+func TestTotalAcrossDaylightSaving(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone data is not available")
+	}
+
+	// the night clocks go forward is 23 hours long
+	start := time.Date(2050, 3, 12, 0, 0, 0, 0, loc)
+	end := time.Date(2050, 3, 15, 0, 0, 0, 0, loc)
+
+	got := Total(models.Room{NightlyRate: 10000}, nil, start, end)
+	if got != 30000 {
+		t.Errorf("got %d, wanted 30000", got)
+	}
+}
+
+// date parses a YYYY-MM-DD date for the tests
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestPrice(t *testing.T) {
+	room := models.Room{ID: 1, NightlyRate: 10000}
+	rules := []models.RateRule{
+		{ID: 1, Name: "Weekends", NightlyRate: 13000, Weekdays: models.NewWeekdays(time.Friday, time.Saturday)},
+		{ID: 2, Name: "Summer", NightlyRate: 12000, StartDate: date("2050-07-01"), EndDate: date("2050-08-31")},
+		{ID: 3, Name: "Summer weekends", NightlyRate: 16000, StartDate: date("2050-07-01"), EndDate: date("2050-08-31"),
+			Weekdays: models.NewWeekdays(time.Friday, time.Saturday)},
+		{ID: 4, Name: "Festival", NightlyRate: 25000, StartDate: date("2050-07-14"), EndDate: date("2050-07-14"), Priority: 10},
+		{ID: 5, Name: "Other room", NightlyRate: 99900, RoomID: 2, Priority: 100},
+		{ID: 6, Name: "Suite midweek", NightlyRate: 11000, RoomID: 1, StartDate: date("2050-07-01"), EndDate: date("2050-08-31"),
+			Weekdays: models.NewWeekdays(time.Monday, time.Tuesday, time.Wednesday, time.Thursday)},
+	}
+
+	// 2050-07-13 is a Wednesday
+	quote := Price(room, rules, date("2050-07-12"), date("2050-07-17"))
+
+	var expected = []struct {
+		date string
+		rate models.Money
+		rule string
+	}{
+		{"2050-07-12", 11000, "Suite midweek"},
+		{"2050-07-13", 11000, "Suite midweek"},
+		{"2050-07-14", 25000, "Festival"},
+		{"2050-07-15", 16000, "Summer weekends"},
+		{"2050-07-16", 16000, "Summer weekends"},
+	}
+
+	if len(quote.Nights) != len(expected) {
+		t.Fatalf("got %d nights, wanted %d", len(quote.Nights), len(expected))
+	}
+	for i, e := range expected {
+		night := quote.Nights[i]
+		if night.Date.Format("2006-01-02") != e.date || night.Rate != e.rate || night.Rule == nil || night.Rule.Name != e.rule {
+			t.Errorf("night %d: got %s at %d, wanted %s at %d by %s", i, night.Date.Format("2006-01-02"), night.Rate, e.date, e.rate, e.rule)
+		}
+	}
+	if quote.Total != 79000 {
+		t.Errorf("got total %d, wanted 79000", quote.Total)
+	}
+
+	// outside every date range only the weekend rule applies
+	quote = Price(room, rules, date("2050-10-06"), date("2050-10-08"))
+	if quote.Nights[0].Rule != nil || quote.Nights[0].Rate != 10000 {
+		t.Errorf("got a Thursday at %d, wanted the base rate", quote.Nights[0].Rate)
+	}
+	if quote.Nights[1].Rule == nil || quote.Nights[1].Rule.Name != "Weekends" || quote.Total != 23000 {
+		t.Errorf("got a Friday at %d and a total of %d, wanted 13000 and 23000", quote.Nights[1].Rate, quote.Total)
+	}
+}
+
+func TestApplyPromo(t *testing.T) {
+	room := models.Room{ID: 1, NightlyRate: 12345}
+
+	var tests = []struct {
+		name     string
+		promo    models.PromoCode
+		discount models.Money
+	}{
+		{"percentage", models.PromoCode{Kind: models.PromoPercent, PercentOff: 10}, 3704},
+		{"fixed amount", models.PromoCode{Kind: models.PromoFixed, AmountOff: 5000}, 5000},
+		{"more than the stay", models.PromoCode{Kind: models.PromoFixed, AmountOff: 50000}, 37035},
+		{"whole stay", models.PromoCode{Kind: models.PromoPercent, PercentOff: 100}, 37035},
+	}
+
+	for _, e := range tests {
+		quote := Price(room, nil, date("2050-01-01"), date("2050-01-04"))
+		quote.ApplyPromo(e.promo)
+		if quote.Subtotal != 37035 || quote.Discount != e.discount || quote.Total != 37035-e.discount {
+			t.Errorf("%s: got %d - %d = %d, wanted 37035 - %d", e.name, quote.Subtotal, quote.Discount, quote.Total, e.discount)
+		}
+		if quote.Promo == nil || quote.Promo.Kind != e.promo.Kind {
+			t.Errorf("%s: quote does not record the promo code", e.name)
+		}
+	}
+}
+
+func TestPromoCheck(t *testing.T) {
+	today := date("2050-06-15")
+
+	var tests = []struct {
+		name     string
+		promo    models.PromoCode
+		roomID   int
+		nights   int
+		expected error
+	}{
+		{"no limits", models.PromoCode{}, 1, 1, nil},
+		{"within dates", models.PromoCode{ValidFrom: date("2050-06-15"), ValidUntil: date("2050-06-15")}, 1, 1, nil},
+		{"not started", models.PromoCode{ValidFrom: date("2050-06-16")}, 1, 1, models.ErrPromoNotStarted},
+		{"expired", models.PromoCode{ValidUntil: date("2050-06-14")}, 1, 1, models.ErrPromoExpired},
+		{"used up", models.PromoCode{MaxUses: 3, Uses: 3}, 1, 1, models.ErrPromoUsedUp},
+		{"uses left", models.PromoCode{MaxUses: 3, Uses: 2}, 1, 1, nil},
+		{"too short", models.PromoCode{MinNights: 3}, 1, 2, models.ErrPromoMinNights},
+		{"long enough", models.PromoCode{MinNights: 3}, 1, 3, nil},
+		{"other room", models.PromoCode{RoomIDs: []int{2, 3}}, 1, 1, models.ErrPromoRoom},
+		{"eligible room", models.PromoCode{RoomIDs: []int{2, 3}}, 3, 1, nil},
+	}
+
+	for _, e := range tests {
+		if got := e.promo.Check(e.roomID, e.nights, today); got != e.expected {
+			t.Errorf("%s: got %v, wanted %v", e.name, got, e.expected)
+		}
+	}
+}
+
+func TestAddCharges(t *testing.T) {
+	charges := []models.Charge{
+		{Name: "VAT", Method: models.ChargePercent, Rate: 750, Active: true},
+		{Name: "City tax", Method: models.ChargeFlat, Amount: 250, Per: models.PerGuestNight, Active: true},
+		{Name: "Cleaning fee", Method: models.ChargeFlat, Amount: 4000, Per: models.PerStay, Active: true},
+		{Name: "Towels", Method: models.ChargeFlat, Amount: 300, Per: models.PerNight, Active: true},
+		{Name: "Old fee", Method: models.ChargeFlat, Amount: 9900, Per: models.PerStay},
+	}
+
+	quote := Price(models.Room{NightlyRate: 10000}, nil, date("2050-01-01"), date("2050-01-04"))
+	quote.AddCharges(charges, 2)
+
+	expected := map[string]models.Money{"VAT": 2250, "City tax": 1500, "Cleaning fee": 4000, "Towels": 900}
+	if len(quote.Charges) != len(expected) {
+		t.Fatalf("got %d charges, wanted %d without the inactive one", len(quote.Charges), len(expected))
+	}
+	for _, line := range quote.Charges {
+		if line.Amount != expected[line.Charge.Name] {
+			t.Errorf("%s: got %d, wanted %d", line.Charge.Name, line.Amount, expected[line.Charge.Name])
+		}
+	}
+	if quote.Total != 38650 {
+		t.Errorf("got total %d, wanted 38650", quote.Total)
+	}
+
+	// percentages apply to the price after the discount, flat charges do not change
+	quote.ApplyPromo(models.PromoCode{Code: "TEN", Kind: models.PromoFixed, AmountOff: 10000})
+	if quote.Charges[0].Amount != 1500 || quote.Charges[1].Amount != 1500 || quote.Total != 27900 {
+		t.Errorf("got VAT %d, city tax %d and total %d after the discount, wanted 1500, 1500 and 27900",
+			quote.Charges[0].Amount, quote.Charges[1].Amount, quote.Total)
+	}
+
+	res := models.Reservation{TotalPrice: quote.Total, Discount: quote.Discount, Charges: quote.ReservationCharges()}
+	if res.ChargesTotal() != 7900 || res.RoomPrice() != 30000 {
+		t.Errorf("got charges of %d on a room price of %d, wanted 7900 on 30000", res.ChargesTotal(), res.RoomPrice())
+	}
+}
+
+func TestCancellationPolicyID(t *testing.T) {
+	room := models.Room{ID: 1, NightlyRate: 10000, CancellationPolicyID: 1}
+	rules := []models.RateRule{
+		{ID: 1, Name: "Non-refundable weekend", NightlyRate: 8000, Weekdays: models.NewWeekdays(time.Saturday), CancellationPolicyID: 2},
+		{ID: 2, Name: "Summer", NightlyRate: 12000, StartDate: date("2050-07-01"), EndDate: date("2050-07-31")},
+	}
+
+	var tests = []struct {
+		name     string
+		start    string
+		end      string
+		expected int
+	}{
+		{"base rate", "2050-01-04", "2050-01-06", 1},
+		{"starts on a rate with a policy", "2050-01-08", "2050-01-10", 2},
+		{"ends on a rate with a policy", "2050-01-06", "2050-01-09", 1},
+		{"starts on a rate without a policy", "2050-07-05", "2050-07-07", 1},
+	}
+
+	for _, e := range tests {
+		got := Price(room, rules, date(e.start), date(e.end)).CancellationPolicyID
+		if got != e.expected {
+			t.Errorf("%s: got policy %d, wanted %d", e.name, got, e.expected)
+		}
+	}
+}
+
+func TestCancellationFee(t *testing.T) {
+	flexible := models.CancellationPolicy{Name: "Flexible", FreeDays: 7, Penalty: 5000}
+	nonRefundable := models.CancellationPolicy{Name: "Non-refundable", Penalty: models.FullPenalty}
+
+	var tests = []struct {
+		name     string
+		policy   models.CancellationPolicy
+		at       time.Time
+		expected models.Money
+	}{
+		{"no policy", models.CancellationPolicy{}, date("2050-01-10"), 0},
+		{"well before arrival", flexible, date("2050-01-01"), 0},
+		{"on the last free day", flexible, date("2050-01-03").Add(23 * time.Hour), 0},
+		{"a day too late", flexible, date("2050-01-04").Add(time.Minute), 15000},
+		{"after arrival", flexible, date("2050-01-12"), 15000},
+		{"non-refundable", nonRefundable, date("2049-06-01"), 30000},
+	}
+
+	for _, e := range tests {
+		res := models.Reservation{
+			StartDate:          date("2050-01-10"),
+			EndDate:            date("2050-01-13"),
+			TotalPrice:         30000,
+			CancellationPolicy: e.policy,
+		}
+		if got := CancellationFee(res, e.at); got != e.expected {
+			t.Errorf("%s: got a fee of %s, wanted %s", e.name, got, e.expected)
+		}
+	}
+
+	res := models.Reservation{StartDate: date("2050-01-10"), CancellationPolicy: flexible}
+	if terms := res.CancellationTerms(); terms != "Free cancellation until 2050-01-03, then 50% of the total is charged" {
+		t.Errorf("got terms %q", terms)
+	}
+	if terms := nonRefundable.Describe(); terms != "Non-refundable" {
+		t.Errorf("got terms %q for a non-refundable policy", terms)
+	}
+}
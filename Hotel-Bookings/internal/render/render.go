@@ -2,6 +2,7 @@ package render
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
@@ -25,6 +26,9 @@ var functions = template.FuncMap{
 var app *config.AppConfig
 var pathToTemplates = "./templates"
 
+// navRooms loads the rooms listed in the site navigation, nil until SetNavRooms is called
+var navRooms func(ctx context.Context) ([]models.Room, error)
+
 // HumanDate returns time in YYYY-MM-DD
 func HumanDate(t time.Time) string {
 	return t.Format("2006-01-02")
@@ -54,6 +58,11 @@ func NewRenderer(a *config.AppConfig) {
 	app = a
 }
 
+// SetNavRooms sets where the rooms listed in the site navigation come from
+func SetNavRooms(f func(ctx context.Context) ([]models.Room, error)) {
+	navRooms = f
+}
+
 // AddDefaultData adds data for all templates
 func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
 	td.Flash = app.Session.PopString(r.Context(), "flash")
@@ -63,6 +72,14 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
 	}
+	if navRooms != nil {
+		// a page without the room menu is better than no page at all
+		rooms, err := navRooms(r.Context())
+		if err != nil {
+			app.ErrorLog.Println(err)
+		}
+		td.Rooms = rooms
+	}
 	return td
 }
 
@@ -1,9 +1,14 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/config"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/repository"
 )
 
@@ -12,7 +17,7 @@ type postgresDBRepo struct {
 	DB  *sql.DB
 }
 
-type testDBRepo struct {
+type sqliteDBRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
 }
@@ -24,8 +29,849 @@ func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo
 	}
 }
 
-func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
-	return &testDBRepo{
+// NewSQLiteRepo creates a SQLite backed repository, creating or upgrading its schema first
+func NewSQLiteRepo(conn *sql.DB, a *config.AppConfig) (repository.DatabaseRepo, error) {
+	err := migrateSQLite(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqliteDBRepo{
 		App: a,
+		DB:  conn,
+	}, nil
+}
+
+// NewTestingRepo creates an in-memory repository for tests
+func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
+	return NewMemoryRepo(a)
+}
+
+// defaultDBTimeout is used when the application config does not set a query timeout
+const defaultDBTimeout = 3 * time.Second
+
+// defaultSearchLimit caps SearchReservations when the caller does not give a limit
+const defaultSearchLimit = 50
+
+// queryContext derives the context for a single query from the caller's context,
+// bounded by the configured database timeout
+func queryContext(ctx context.Context, a *config.AppConfig) (context.Context, context.CancelFunc) {
+	timeout := defaultDBTimeout
+	if a != nil && a.DBTimeout > 0 {
+		timeout = a.DBTimeout
 	}
-}
\ No newline at end of file
+	return context.WithTimeout(ctx, timeout)
+}
+
+// sqlDialect holds what differs between the SQL databases when a query is built at runtime
+type sqlDialect struct {
+	// placeholder returns the bind parameter for the n-th argument, counting from 1
+	placeholder func(n int) string
+	// date converts a date argument to the form the database compares correctly
+	date func(t time.Time) interface{}
+	// like is the case-insensitive pattern match operator
+	like string
+}
+
+var postgresDialect = sqlDialect{
+	placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+	date:        func(t time.Time) interface{} { return t },
+	like:        "ilike",
+}
+
+var sqliteDialect = sqlDialect{
+	placeholder: func(n int) string { return "?" },
+	date:        func(t time.Time) interface{} { return sqliteDate(t) },
+	like:        "like",
+}
+
+// reservationSortColumns maps the sort keys of models.ReservationSortColumns to SQL expressions
+var reservationSortColumns = map[string]string{
+	"id":         "r.id",
+	"first_name": "lower(r.first_name)",
+	"last_name":  "lower(r.last_name)",
+	"room":       "rm.room_name",
+	"start_date": "r.start_date",
+	"end_date":   "r.end_date",
+	"status":     "r.status",
+	"created_at": "r.created_at",
+}
+
+// reservationListing builds the where and order by clauses for a reservation listing,
+// which select from reservations r joined to rooms rm. q must be normalized.
+func reservationListing(d sqlDialect, q models.ReservationQuery) (where string, orderBy string, args []interface{}) {
+	var conditions []string
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return d.placeholder(len(args))
+	}
+
+	if q.RoomID > 0 {
+		conditions = append(conditions, "r.room_id = "+arg(q.RoomID))
+	}
+	if !q.From.IsZero() {
+		conditions = append(conditions, "r.end_date >= "+arg(d.date(q.From)))
+	}
+	if !q.To.IsZero() {
+		conditions = append(conditions, "r.start_date <= "+arg(d.date(q.To)))
+	}
+	if q.Status != "" {
+		conditions = append(conditions, "r.status = "+arg(string(q.Status)))
+	}
+	if search := strings.TrimSpace(q.Search); search != "" {
+		pattern := containsPattern(search)
+		var matches []string
+		for _, col := range []string{"r.first_name", "r.last_name", "r.email", "r.phone", "r.confirmation_code"} {
+			matches = append(matches, fmt.Sprintf(`%s %s %s escape '\'`, col, d.like, arg(pattern)))
+		}
+		conditions = append(conditions, "("+strings.Join(matches, " or ")+")")
+	}
+
+	if len(conditions) > 0 {
+		where = "where " + strings.Join(conditions, " and ")
+	}
+
+	direction := "asc"
+	if q.Desc {
+		direction = "desc"
+	}
+	orderBy = fmt.Sprintf("order by %s %s, r.id %s", reservationSortColumns[q.Sort], direction, direction)
+
+	return where, orderBy, args
+}
+
+// escapeLike escapes the like wildcards in s with a backslash
+func escapeLike(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "%", `\%`)
+	return strings.ReplaceAll(s, "_", `\_`)
+}
+
+// containsPattern returns a like pattern matching s anywhere
+func containsPattern(s string) string {
+	return "%" + escapeLike(s) + "%"
+}
+
+// prefixPattern returns a like pattern matching values that start with s
+func prefixPattern(s string) string {
+	return escapeLike(s) + "%"
+}
+
+// roomColumns are the rooms columns read by scanRoom, in order
+const roomColumns = `id, room_name, slug, active, sort_order, created_at, updated_at,
+	max_occupancy, bed_configuration, description, nightly_rate, coalesce(cancellation_policy_id, 0)`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRoom reads a room selected with roomColumns
+func scanRoom(row rowScanner) (models.Room, error) {
+	var room models.Room
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Active,
+		&room.SortOrder,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+		&room.MaxOccupancy,
+		&room.BedConfiguration,
+		&room.Description,
+		&room.NightlyRate,
+		&room.CancellationPolicyID,
+	)
+	return room, err
+}
+
+// getRoom runs a query selecting roomColumns of a single room and returns it with its amenities and photos
+func getRoom(ctx context.Context, db *sql.DB, d sqlDialect, query string, args ...interface{}) (models.Room, error) {
+	room, err := scanRoom(db.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		return room, err
+	}
+
+	rooms := []models.Room{room}
+	err = loadRoomDetails(ctx, db, d, rooms)
+	return rooms[0], err
+}
+
+// listRooms runs a query selecting roomColumns and returns the rooms it finds with their amenities and photos
+func listRooms(ctx context.Context, db *sql.DB, d sqlDialect, query string, args ...interface{}) ([]models.Room, error) {
+	var rooms []models.Room
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	err = loadRoomDetails(ctx, db, d, rooms)
+	return rooms, err
+}
+
+// loadRoomDetails fills in the amenities and photos of rooms, in display order
+func loadRoomDetails(ctx context.Context, db *sql.DB, d sqlDialect, rooms []models.Room) error {
+	if len(rooms) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int)
+	var args []interface{}
+	var placeholders []string
+	for i, room := range rooms {
+		index[room.ID] = i
+		args = append(args, room.ID)
+		placeholders = append(placeholders, d.placeholder(len(args)))
+	}
+	in := strings.Join(placeholders, ", ")
+
+	rows, err := db.QueryContext(ctx, `select room_id, name from room_amenities
+		where room_id in (`+in+`) order by room_id, sort_order, id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roomID int
+		var name string
+		if err = rows.Scan(&roomID, &name); err != nil {
+			return err
+		}
+		i := index[roomID]
+		rooms[i].Amenities = append(rooms[i].Amenities, name)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	photos, err := db.QueryContext(ctx, `select id, room_id, path, caption, sort_order, created_at, updated_at,
+		original_path, thumbnail_path
+		from room_photos where room_id in (`+in+`) order by room_id, sort_order, id`, args...)
+	if err != nil {
+		return err
+	}
+	defer photos.Close()
+
+	for photos.Next() {
+		var p models.RoomPhoto
+		err = photos.Scan(&p.ID, &p.RoomID, &p.Path, &p.Caption, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt,
+			&p.OriginalPath, &p.ThumbnailPath)
+		if err != nil {
+			return err
+		}
+		i := index[p.RoomID]
+		rooms[i].Photos = append(rooms[i].Photos, p)
+	}
+
+	return photos.Err()
+}
+
+// saveAmenities replaces the amenities of a room with the given list, kept in its order
+func saveAmenities(ctx context.Context, tx *sql.Tx, d sqlDialect, roomID int, amenities []string) error {
+	_, err := tx.ExecContext(ctx, `delete from room_amenities where room_id = `+d.placeholder(1), roomID)
+	if err != nil {
+		return err
+	}
+
+	stmt := fmt.Sprintf(`insert into room_amenities (room_id, name, sort_order) values (%s, %s, %s)`,
+		d.placeholder(1), d.placeholder(2), d.placeholder(3))
+	for i, name := range amenities {
+		if _, err = tx.ExecContext(ctx, stmt, roomID, name, i+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reservationSource returns the source to store for res, online when none is set
+func reservationSource(res models.Reservation) models.ReservationSource {
+	if res.Source == "" {
+		return models.SourceOnline
+	}
+	return res.Source
+}
+
+// nullableID stores an optional foreign key, 0 meaning none, as null
+func nullableID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// nullableDate stores an optional date, the zero time meaning none, as null
+func nullableDate(d sqlDialect, t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return d.date(t)
+}
+
+// rateRuleColumns are the columns read by scanRateRule, from rate_rules r joined with rooms rm
+const rateRuleColumns = `r.id, r.name, coalesce(r.room_id, 0), r.start_date, r.end_date, r.weekdays,
+	r.nightly_rate, r.priority, r.created_at, r.updated_at, coalesce(rm.room_name, ''),
+	coalesce(r.cancellation_policy_id, 0)`
+
+// scanRateRule reads a rate rule selected with rateRuleColumns
+func scanRateRule(row rowScanner) (models.RateRule, error) {
+	var rule models.RateRule
+	var start, end sql.NullTime
+
+	err := row.Scan(
+		&rule.ID,
+		&rule.Name,
+		&rule.RoomID,
+		&start,
+		&end,
+		&rule.Weekdays,
+		&rule.NightlyRate,
+		&rule.Priority,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+		&rule.Room.RoomName,
+		&rule.CancellationPolicyID,
+	)
+
+	rule.Room.ID = rule.RoomID
+	rule.StartDate = start.Time
+	rule.EndDate = end.Time
+	return rule, err
+}
+
+// listRateRules runs a query selecting rateRuleColumns and returns the rules it finds
+func listRateRules(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]models.RateRule, error) {
+	var rules []models.RateRule
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rule, err := scanRateRule(rows)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// promoCodeColumns are the promo_codes columns read by scanPromoCode, in order
+const promoCodeColumns = `id, code, description, kind, percent_off, amount_off, valid_from, valid_until,
+	max_uses, uses, min_nights, created_at, updated_at`
+
+// scanPromoCode reads a promo code selected with promoCodeColumns, without its rooms
+func scanPromoCode(row rowScanner) (models.PromoCode, error) {
+	var p models.PromoCode
+	var from, until sql.NullTime
+
+	err := row.Scan(
+		&p.ID,
+		&p.Code,
+		&p.Description,
+		&p.Kind,
+		&p.PercentOff,
+		&p.AmountOff,
+		&from,
+		&until,
+		&p.MaxUses,
+		&p.Uses,
+		&p.MinNights,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+
+	p.ValidFrom = from.Time
+	p.ValidUntil = until.Time
+	return p, err
+}
+
+// getPromoCode runs a query selecting promoCodeColumns of a single promo code and returns it with its rooms
+func getPromoCode(ctx context.Context, db *sql.DB, d sqlDialect, query string, args ...interface{}) (models.PromoCode, error) {
+	p, err := scanPromoCode(db.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		return p, err
+	}
+
+	codes := []models.PromoCode{p}
+	err = loadPromoCodeRooms(ctx, db, d, codes)
+	return codes[0], err
+}
+
+// listPromoCodes runs a query selecting promoCodeColumns and returns the promo codes it finds with their rooms
+func listPromoCodes(ctx context.Context, db *sql.DB, d sqlDialect, query string, args ...interface{}) ([]models.PromoCode, error) {
+	var codes []models.PromoCode
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return codes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPromoCode(rows)
+		if err != nil {
+			return codes, err
+		}
+		codes = append(codes, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return codes, err
+	}
+
+	err = loadPromoCodeRooms(ctx, db, d, codes)
+	return codes, err
+}
+
+// loadPromoCodeRooms fills in the IDs of the rooms promo codes are limited to
+func loadPromoCodeRooms(ctx context.Context, db *sql.DB, d sqlDialect, codes []models.PromoCode) error {
+	if len(codes) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int)
+	var args []interface{}
+	var placeholders []string
+	for i, p := range codes {
+		index[p.ID] = i
+		args = append(args, p.ID)
+		placeholders = append(placeholders, d.placeholder(len(args)))
+	}
+
+	rows, err := db.QueryContext(ctx, `select promo_code_id, room_id from promo_code_rooms
+		where promo_code_id in (`+strings.Join(placeholders, ", ")+`) order by promo_code_id, room_id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var codeID, roomID int
+		if err = rows.Scan(&codeID, &roomID); err != nil {
+			return err
+		}
+		i := index[codeID]
+		codes[i].RoomIDs = append(codes[i].RoomIDs, roomID)
+	}
+
+	return rows.Err()
+}
+
+// savePromoCodeRooms replaces the rooms a promo code is limited to
+func savePromoCodeRooms(ctx context.Context, tx *sql.Tx, d sqlDialect, codeID int, roomIDs []int) error {
+	_, err := tx.ExecContext(ctx, `delete from promo_code_rooms where promo_code_id = `+d.placeholder(1), codeID)
+	if err != nil {
+		return err
+	}
+
+	stmt := fmt.Sprintf(`insert into promo_code_rooms (promo_code_id, room_id) values (%s, %s)`,
+		d.placeholder(1), d.placeholder(2))
+	for _, roomID := range roomIDs {
+		if _, err = tx.ExecContext(ctx, stmt, codeID, roomID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// redeemPromoCode counts one more use of code. It returns repository.ErrPromoCodeUnavailable
+// if the code does not exist or has been used as often as it allows.
+func redeemPromoCode(ctx context.Context, tx *sql.Tx, d sqlDialect, code string) error {
+	stmt := fmt.Sprintf(`update promo_codes set uses = uses + 1, updated_at = %s
+		where code = %s and (max_uses = 0 or uses < max_uses)`, d.placeholder(1), d.placeholder(2))
+
+	result, err := tx.ExecContext(ctx, stmt, time.Now(), code)
+	if err != nil {
+		return err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return repository.ErrPromoCodeUnavailable
+	}
+
+	return nil
+}
+
+// confirmationCodeAttempts is how often a reservation is inserted with a fresh confirmation code
+// before giving up, when the codes drawn belong to other reservations
+const confirmationCodeAttempts = 5
+
+// withConfirmationCode calls insert with a new random confirmation code, and again with another code
+// for as long as taken reports that the code belongs to another reservation
+func withConfirmationCode(insert func(code string) error, taken func(error) bool) error {
+	for attempt := 1; ; attempt++ {
+		code, err := models.NewConfirmationCode()
+		if err != nil {
+			return err
+		}
+
+		err = insert(code)
+		if attempt < confirmationCodeAttempts && taken(err) {
+			continue
+		}
+		return err
+	}
+}
+
+// returnPromoCode gives back the use of its promo code a reservation about to be cancelled took, if it was
+// never confirmed or paid for, so a booking that fell through does not use up the code
+func returnPromoCode(ctx context.Context, tx *sql.Tx, d sqlDialect, id int) error {
+	var status models.ReservationStatus
+	var code string
+	err := tx.QueryRowContext(ctx, `select status, promo_code from reservations where id = `+d.placeholder(1), id).
+		Scan(&status, &code)
+	if err != nil {
+		return err
+	}
+	if code == "" || status != models.StatusNew {
+		return nil
+	}
+
+	var captured int
+	query := fmt.Sprintf(`select count(id) from payments where reservation_id = %s and status = %s`,
+		d.placeholder(1), d.placeholder(2))
+	err = tx.QueryRowContext(ctx, query, id, string(models.PaymentCaptured)).Scan(&captured)
+	if err != nil || captured > 0 {
+		return err
+	}
+
+	stmt := fmt.Sprintf(`update promo_codes set uses = uses - 1, updated_at = %s where code = %s and uses > 0`,
+		d.placeholder(1), d.placeholder(2))
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), code)
+	return err
+}
+
+// deleteReservation deletes a reservation with its room restrictions and status history. It returns
+// repository.ErrReservationHasPayments, and deletes nothing, if payments were taken for the reservation.
+func deleteReservation(ctx context.Context, db *sql.DB, d sqlDialect, id int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var numRows int
+	err = tx.QueryRowContext(ctx, `select count(id) from payments where reservation_id = `+d.placeholder(1), id).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrReservationHasPayments
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from reservations where id = `+d.placeholder(1), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// reservationGuests returns the number of guests to store for res, at least one
+func reservationGuests(res models.Reservation) int {
+	if res.Guests < 1 {
+		return 1
+	}
+	return res.Guests
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// saveReservationCharges replaces the taxes and fees of a reservation with the given list, kept in its order
+func saveReservationCharges(ctx context.Context, db execer, d sqlDialect, reservationID int, charges []models.ReservationCharge) error {
+	_, err := db.ExecContext(ctx, `delete from reservation_charges where reservation_id = `+d.placeholder(1), reservationID)
+	if err != nil {
+		return err
+	}
+
+	stmt := fmt.Sprintf(`insert into reservation_charges (reservation_id, name, amount, sort_order) values (%s, %s, %s, %s)`,
+		d.placeholder(1), d.placeholder(2), d.placeholder(3), d.placeholder(4))
+	for i, c := range charges {
+		if _, err = db.ExecContext(ctx, stmt, reservationID, c.Name, c.Amount, i+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadReservationCharges returns the taxes and fees of a reservation in the order they were charged
+func loadReservationCharges(ctx context.Context, db *sql.DB, d sqlDialect, reservationID int) ([]models.ReservationCharge, error) {
+	var charges []models.ReservationCharge
+
+	rows, err := db.QueryContext(ctx, `select name, amount from reservation_charges
+		where reservation_id = `+d.placeholder(1)+` order by sort_order, id`, reservationID)
+	if err != nil {
+		return charges, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.ReservationCharge
+		if err = rows.Scan(&c.Name, &c.Amount); err != nil {
+			return charges, err
+		}
+		charges = append(charges, c)
+	}
+
+	return charges, rows.Err()
+}
+
+// chargeColumns are the charges columns read by scanCharge, in order
+const chargeColumns = `id, name, method, rate, amount, per, active, created_at, updated_at`
+
+// scanCharge reads a charge selected with chargeColumns
+func scanCharge(row rowScanner) (models.Charge, error) {
+	var c models.Charge
+	err := row.Scan(
+		&c.ID,
+		&c.Name,
+		&c.Method,
+		&c.Rate,
+		&c.Amount,
+		&c.Per,
+		&c.Active,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
+	return c, err
+}
+
+// listCharges runs a query selecting chargeColumns and returns the charges it finds
+func listCharges(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]models.Charge, error) {
+	var charges []models.Charge
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return charges, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanCharge(rows)
+		if err != nil {
+			return charges, err
+		}
+		charges = append(charges, c)
+	}
+
+	return charges, rows.Err()
+}
+
+// cancellationPolicyColumns are the cancellation_policies columns read by scanCancellationPolicy, in order
+const cancellationPolicyColumns = `id, name, free_days, penalty, created_at, updated_at`
+
+// scanCancellationPolicy reads a cancellation policy selected with cancellationPolicyColumns
+func scanCancellationPolicy(row rowScanner) (models.CancellationPolicy, error) {
+	var p models.CancellationPolicy
+	err := row.Scan(
+		&p.ID,
+		&p.Name,
+		&p.FreeDays,
+		&p.Penalty,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	return p, err
+}
+
+// listCancellationPolicies runs a query selecting cancellationPolicyColumns and returns the policies it finds
+func listCancellationPolicies(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]models.CancellationPolicy, error) {
+	var policies []models.CancellationPolicy
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return policies, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanCancellationPolicy(rows)
+		if err != nil {
+			return policies, err
+		}
+		policies = append(policies, p)
+	}
+
+	return policies, rows.Err()
+}
+
+// paymentColumns are the payments columns read by scanPayment, in order
+const paymentColumns = `id, reservation_id, provider, reference, status, amount, refunded, card_last4, failure_reason,
+	created_at, updated_at`
+
+// scanPayment reads a payment selected with paymentColumns
+func scanPayment(row rowScanner) (models.Payment, error) {
+	var p models.Payment
+	err := row.Scan(
+		&p.ID,
+		&p.ReservationID,
+		&p.Provider,
+		&p.Reference,
+		&p.Status,
+		&p.Amount,
+		&p.Refunded,
+		&p.CardLast4,
+		&p.FailureReason,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	return p, err
+}
+
+// listPayments runs a query selecting paymentColumns and returns the payments it finds
+func listPayments(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]models.Payment, error) {
+	var payments []models.Payment
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return payments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return payments, err
+		}
+		payments = append(payments, p)
+	}
+
+	return payments, rows.Err()
+}
+
+// invoiceColumns are the invoices columns read by scanInvoice, in order
+const invoiceColumns = `id, number, coalesce(reservation_id, 0), confirmation_code, billed_to, email, total, issued_at,
+	created_at, updated_at`
+
+// scanInvoice reads an invoice selected with invoiceColumns, without its lines
+func scanInvoice(row rowScanner) (models.Invoice, error) {
+	var inv models.Invoice
+	err := row.Scan(
+		&inv.ID,
+		&inv.Number,
+		&inv.ReservationID,
+		&inv.ConfirmationCode,
+		&inv.BilledTo,
+		&inv.Email,
+		&inv.Total,
+		&inv.IssuedAt,
+		&inv.CreatedAt,
+		&inv.UpdatedAt,
+	)
+	return inv, err
+}
+
+// listInvoices runs a query selecting invoiceColumns and returns the invoices it finds, without their lines
+func listInvoices(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]models.Invoice, error) {
+	var invoices []models.Invoice
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return invoices, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return invoices, err
+		}
+		invoices = append(invoices, inv)
+	}
+
+	return invoices, rows.Err()
+}
+
+// getInvoice reads the invoice a query selecting invoiceColumns finds, with its lines
+func getInvoice(ctx context.Context, db *sql.DB, d sqlDialect, query string, args ...interface{}) (models.Invoice, error) {
+	inv, err := scanInvoice(db.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		return inv, err
+	}
+
+	inv.Lines, err = loadInvoiceLines(ctx, db, d, inv.ID)
+	return inv, err
+}
+
+// saveInvoiceLines stores the lines of a new invoice, kept in their order
+func saveInvoiceLines(ctx context.Context, db execer, d sqlDialect, invoiceID int, lines []models.InvoiceLine) error {
+	stmt := fmt.Sprintf(`insert into invoice_lines (invoice_id, description, amount, sort_order) values (%s, %s, %s, %s)`,
+		d.placeholder(1), d.placeholder(2), d.placeholder(3), d.placeholder(4))
+	for i, line := range lines {
+		if _, err := db.ExecContext(ctx, stmt, invoiceID, line.Description, line.Amount, i+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadInvoiceLines returns the lines of an invoice in their order
+func loadInvoiceLines(ctx context.Context, db *sql.DB, d sqlDialect, invoiceID int) ([]models.InvoiceLine, error) {
+	var lines []models.InvoiceLine
+
+	rows, err := db.QueryContext(ctx, `select description, amount from invoice_lines
+		where invoice_id = `+d.placeholder(1)+` order by sort_order, id`, invoiceID)
+	if err != nil {
+		return lines, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line models.InvoiceLine
+		if err = rows.Scan(&line.Description, &line.Amount); err != nil {
+			return lines, err
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, rows.Err()
+}
+
+// invoiceNumber checks the reservation of inv has no invoice yet and returns the number the invoice is
+// issued under, one after the last. It returns repository.ErrInvoiceExists if the reservation has one.
+func invoiceNumber(ctx context.Context, tx *sql.Tx, d sqlDialect, inv models.Invoice) (int, error) {
+	var numRows int
+	err := tx.QueryRowContext(ctx, `select count(id) from invoices where reservation_id = `+d.placeholder(1),
+		inv.ReservationID).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, repository.ErrInvoiceExists
+	}
+
+	var last int
+	err = tx.QueryRowContext(ctx, `select coalesce(max(number), 0) from invoices`).Scan(&last)
+	return last + 1, err
+}
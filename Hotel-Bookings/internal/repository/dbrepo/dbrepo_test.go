@@ -0,0 +1,77 @@
+package dbrepo
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/config"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/driver"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/repository"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/repository/repositorytest"
+)
+
+func TestMemoryRepo(t *testing.T) {
+	repositorytest.RunConformanceTests(t, func(t *testing.T) repository.DatabaseRepo {
+		return NewMemoryRepo(&config.AppConfig{})
+	})
+}
+
+func TestWithConfirmationCode(t *testing.T) {
+	taken := errors.New("taken")
+	isTaken := func(err error) bool { return errors.Is(err, taken) }
+
+	var codes []string
+	err := withConfirmationCode(func(code string) error {
+		codes = append(codes, code)
+		if len(codes) < 3 {
+			return taken
+		}
+		return nil
+	}, isTaken)
+	if err != nil || len(codes) != 3 || codes[0] == codes[2] {
+		t.Errorf("got error %v after trying %v, wanted the third code to be used", err, codes)
+	}
+
+	attempts := 0
+	err = withConfirmationCode(func(code string) error {
+		attempts++
+		return taken
+	}, isTaken)
+	if !errors.Is(err, taken) || attempts != confirmationCodeAttempts {
+		t.Errorf("got error %v after %d attempts, wanted %v after %d", err, attempts, taken, confirmationCodeAttempts)
+	}
+
+	other := errors.New("some errors")
+	attempts = 0
+	err = withConfirmationCode(func(code string) error {
+		attempts++
+		return other
+	}, isTaken)
+	if err != other || attempts != 1 {
+		t.Errorf("got error %v after %d attempts, wanted %v at once", err, attempts, other)
+	}
+}
+
+// TestPostgresRepo runs against the database in BOOKINGS_TEST_DSN, which must be fully migrated.
+// Every reservation and room restriction in it is deleted, so never point it at real data.
+func TestPostgresRepo(t *testing.T) {
+	dsn := os.Getenv("BOOKINGS_TEST_DSN")
+	if dsn == "" {
+		t.Skip("BOOKINGS_TEST_DSN is not set")
+	}
+
+	db, err := driver.ConnectSQL(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SQL.Close()
+
+	repositorytest.RunConformanceTests(t, func(t *testing.T) repository.DatabaseRepo {
+		_, err := db.SQL.Exec(`truncate reservations, room_restrictions restart identity cascade`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewPostgresRepo(db.SQL, &config.AppConfig{})
+	})
+}
@@ -0,0 +1,1904 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/config"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MemoryRepo is a DatabaseRepo that keeps its data in memory. It behaves like the
+// database backed repositories, so tests can create data and read it back, and
+// FailOn lets a test make any method return an error.
+type MemoryRepo struct {
+	App *config.AppConfig
+
+	mu               sync.Mutex
+	users            map[int]models.User
+	rooms            map[int]models.Room
+	roomPhotos       map[int]models.RoomPhoto
+	rateRules        map[int]models.RateRule
+	promoCodes       map[int]models.PromoCode
+	charges          map[int]models.Charge
+	policies         map[int]models.CancellationPolicy
+	payments         map[int]models.Payment
+	invoices         map[int]models.Invoice
+	restrictions     map[int]models.Restriction
+	reservations     map[int]models.Reservation
+	roomRestrictions map[int]models.RoomRestriction
+	statusChanges    map[int]models.ReservationStatusChange
+	lastID           map[string]int
+	failures         map[string]error
+}
+
+// NewMemoryRepo creates an in-memory repository holding the same seed data as the migrations:
+// two rooms, the reservation and owner block restrictions, and admin@admin.com with password "password"
+func NewMemoryRepo(a *config.AppConfig) *MemoryRepo {
+	m := &MemoryRepo{
+		App:      a,
+		failures: make(map[string]error),
+	}
+	m.Reset()
+	return m
+}
+
+// Reset discards all data and restores the seed data
+func (m *MemoryRepo) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users = make(map[int]models.User)
+	m.rooms = make(map[int]models.Room)
+	m.roomPhotos = make(map[int]models.RoomPhoto)
+	m.rateRules = make(map[int]models.RateRule)
+	m.promoCodes = make(map[int]models.PromoCode)
+	m.charges = make(map[int]models.Charge)
+	m.policies = make(map[int]models.CancellationPolicy)
+	m.invoices = make(map[int]models.Invoice)
+	m.payments = make(map[int]models.Payment)
+	m.restrictions = make(map[int]models.Restriction)
+	m.reservations = make(map[int]models.Reservation)
+	m.roomRestrictions = make(map[int]models.RoomRestriction)
+	m.statusChanges = make(map[int]models.ReservationStatusChange)
+	m.lastID = make(map[string]int)
+
+	now := time.Now()
+	nightlyRates := []models.Money{10000, 15000}
+	for i, name := range []string{"General's Quarters", "Major's Suite"} {
+		id := m.nextID("rooms")
+		m.rooms[id] = models.Room{
+			ID:           id,
+			RoomName:     name,
+			Slug:         models.Slugify(name),
+			Active:       true,
+			SortOrder:    id,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+			MaxOccupancy: 2,
+			Description:  "Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.",
+			NightlyRate:  nightlyRates[i],
+		}
+
+		photoID := m.nextID("room_photos")
+		m.roomPhotos[photoID] = models.RoomPhoto{
+			ID:        photoID,
+			RoomID:    id,
+			Path:      "/static/images/" + models.Slugify(name) + ".png",
+			SortOrder: 1,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+	}
+
+	for _, name := range []string{"Reservation", "Owner Block"} {
+		id := m.nextID("restrictions")
+		m.restrictions[id] = models.Restriction{ID: id, RestrictionName: name, CreatedAt: now, UpdatedAt: now}
+	}
+
+	// the minimum cost keeps tests that log in fast
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	id := m.nextID("users")
+	m.users[id] = models.User{
+		ID:          id,
+		FirstName:   "Shu",
+		LastName:    "Akihito",
+		Email:       "admin@admin.com",
+		Password:    string(hash),
+		AccessLevel: 3,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// FailOn makes the named method return err until ClearFailures is called
+func (m *MemoryRepo) FailOn(method string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.failures[method] = err
+}
+
+// ClearFailures removes every error registered with FailOn
+func (m *MemoryRepo) ClearFailures() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.failures = make(map[string]error)
+}
+
+// fail returns the error registered for method, if any. The caller must hold m.mu.
+func (m *MemoryRepo) fail(method string) error {
+	return m.failures[method]
+}
+
+// nextID returns the next primary key for table. The caller must hold m.mu.
+func (m *MemoryRepo) nextID(table string) int {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+// overlaps reports whether a restriction on roomID already covers part of start to end,
+// ignoring the restriction with id skipID. The caller must hold m.mu.
+func (m *MemoryRepo) overlaps(roomID int, start, end time.Time, skipID int) bool {
+	for _, rr := range m.roomRestrictions {
+		if rr.ID == skipID || rr.RoomID != roomID {
+			continue
+		}
+		if start.Before(rr.EndDate) && end.After(rr.StartDate) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedRooms returns every room in the order they are listed in. The caller must hold m.mu.
+func (m *MemoryRepo) sortedRooms() []models.Room {
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		rooms = append(rooms, m.withDetails(room))
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].SortOrder != rooms[j].SortOrder {
+			return rooms[i].SortOrder < rooms[j].SortOrder
+		}
+		return rooms[i].ID < rooms[j].ID
+	})
+	return rooms
+}
+
+// withDetails returns room with a copy of its amenities and its photos in display order.
+// The caller must hold m.mu.
+func (m *MemoryRepo) withDetails(room models.Room) models.Room {
+	room.Amenities = append([]string(nil), room.Amenities...)
+
+	room.Photos = nil
+	for _, photo := range m.roomPhotos {
+		if photo.RoomID == room.ID {
+			room.Photos = append(room.Photos, photo)
+		}
+	}
+	sort.Slice(room.Photos, func(i, j int) bool {
+		if room.Photos[i].SortOrder != room.Photos[j].SortOrder {
+			return room.Photos[i].SortOrder < room.Photos[j].SortOrder
+		}
+		return room.Photos[i].ID < room.Photos[j].ID
+	})
+
+	return room
+}
+
+// withRoom fills in the joined room of a reservation. The caller must hold m.mu.
+func (m *MemoryRepo) withRoom(res models.Reservation) models.Reservation {
+	room := m.rooms[res.RoomID]
+	res.Room.ID = room.ID
+	res.Room.RoomName = room.RoomName
+	return res
+}
+
+// errConfirmationCodeTaken is returned when every confirmation code drawn for a new reservation was taken
+var errConfirmationCodeTaken = errors.New("confirmation code is taken")
+
+// insertReservation stores a new reservation. The caller must hold m.mu.
+func (m *MemoryRepo) insertReservation(res models.Reservation) (int, error) {
+	if _, ok := m.rooms[res.RoomID]; !ok {
+		return 0, errors.New("room does not exist")
+	}
+
+	var code string
+	err := withConfirmationCode(func(c string) error {
+		for _, other := range m.reservations {
+			if other.ConfirmationCode == c {
+				return errConfirmationCodeTaken
+			}
+		}
+		code = c
+		return nil
+	}, func(err error) bool { return err == errConfirmationCodeTaken })
+	if err != nil {
+		return 0, err
+	}
+
+	res.ID = m.nextID("reservations")
+	res.ConfirmationCode = code
+	res.Status = models.StatusNew
+	res.Source = reservationSource(res)
+	res.Guests = reservationGuests(res)
+	res.Charges = append([]models.ReservationCharge(nil), res.Charges...)
+	// only the terms of the policy are kept, like the database repositories do
+	res.CancellationPolicy = models.CancellationPolicy{
+		Name:     res.CancellationPolicy.Name,
+		FreeDays: res.CancellationPolicy.FreeDays,
+		Penalty:  res.CancellationPolicy.Penalty,
+	}
+	res.CancellationFee = 0
+	res.CreatedAt = time.Now()
+	res.UpdatedAt = time.Now()
+	res.Room = models.Room{}
+	m.reservations[res.ID] = res
+
+	return res.ID, nil
+}
+
+// insertRoomRestriction stores a new room restriction, rejecting overlaps. The caller must hold m.mu.
+func (m *MemoryRepo) insertRoomRestriction(r models.RoomRestriction) error {
+	if _, ok := m.rooms[r.RoomID]; !ok {
+		return errors.New("room does not exist")
+	}
+	if m.overlaps(r.RoomID, r.StartDate, r.EndDate, 0) {
+		return repository.ErrRoomNotAvailable
+	}
+
+	r.ID = m.nextID("room_restrictions")
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+	m.roomRestrictions[r.ID] = r
+
+	return nil
+}
+
+func (m *MemoryRepo) AllUser(ctx context.Context) bool {
+	return true
+}
+
+// InsertReservation inserts a reservation into the database
+func (m *MemoryRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertReservation"); err != nil {
+		return 0, err
+	}
+
+	return m.insertReservation(res)
+}
+
+// InsertRoomRestriction inserts a room restriction into the database
+func (m *MemoryRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertRoomRestriction"); err != nil {
+		return err
+	}
+
+	return m.insertRoomRestriction(r)
+}
+
+// InsertReservationWithRestriction inserts a reservation and its room restriction in one transaction
+func (m *MemoryRepo) InsertReservationWithRestriction(ctx context.Context, res models.Reservation) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertReservationWithRestriction"); err != nil {
+		return 0, err
+	}
+
+	if _, ok := m.rooms[res.RoomID]; !ok {
+		return 0, errors.New("room does not exist")
+	}
+	if m.overlaps(res.RoomID, res.StartDate, res.EndDate, 0) {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	var promo models.PromoCode
+	if res.PromoCode != "" {
+		var ok bool
+		promo, ok = m.promoCodeByCode(res.PromoCode)
+		if !ok || (promo.MaxUses > 0 && promo.Uses >= promo.MaxUses) {
+			return 0, repository.ErrPromoCodeUnavailable
+		}
+	}
+
+	newID, err := m.insertReservation(res)
+	if err != nil {
+		return 0, err
+	}
+
+	err = m.insertRoomRestriction(models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomID:        res.RoomID,
+		ReservationID: newID,
+		RestrictionID: 1,
+	})
+	if err != nil {
+		delete(m.reservations, newID)
+		return 0, err
+	}
+
+	if promo.ID != 0 {
+		promo.Uses++
+		promo.UpdatedAt = time.Now()
+		m.promoCodes[promo.ID] = promo
+	}
+
+	return newID, nil
+}
+
+// SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID, and false if no availability exists for roomID
+func (m *MemoryRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("SearchAvailabilityByDatesByRoomID"); err != nil {
+		return false, err
+	}
+
+	return !m.overlaps(roomID, start, end, 0), nil
+}
+
+// SearchAvailabilityForAllRooms returns a slice of available room, if any, for give date range
+func (m *MemoryRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rooms []models.Room
+
+	if err := m.fail("SearchAvailabilityForAllRooms"); err != nil {
+		return rooms, err
+	}
+
+	for _, room := range m.sortedRooms() {
+		if room.Active && !m.overlaps(room.ID, start, end, 0) {
+			rooms = append(rooms, room)
+		}
+	}
+
+	return rooms, nil
+}
+
+// GetRoomByID gets a room by ID
+func (m *MemoryRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetRoomByID"); err != nil {
+		return models.Room{}, err
+	}
+
+	room, ok := m.rooms[id]
+	if !ok {
+		return room, sql.ErrNoRows
+	}
+	return m.withDetails(room), nil
+}
+
+// GetUserByID returns a user by ID
+func (m *MemoryRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetUserByID"); err != nil {
+		return models.User{}, err
+	}
+
+	user, ok := m.users[id]
+	if !ok {
+		return user, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+// UpdateUser updates user in database
+func (m *MemoryRepo) UpdateUser(ctx context.Context, user models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("UpdateUser"); err != nil {
+		return err
+	}
+
+	existing, ok := m.users[user.ID]
+	if !ok {
+		return nil
+	}
+
+	existing.FirstName = user.FirstName
+	existing.LastName = user.LastName
+	existing.Email = user.Email
+	existing.AccessLevel = user.AccessLevel
+	existing.UpdatedAt = time.Now()
+	m.users[user.ID] = existing
+
+	return nil
+}
+
+// Authenticate authenticates a user
+func (m *MemoryRepo) Authenticate(ctx context.Context, email, password string) (int, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("Authenticate"); err != nil {
+		return 0, "", err
+	}
+
+	for _, user := range m.users {
+		if user.Email != email {
+			continue
+		}
+
+		err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return 0, "", errors.New("incorrect password")
+		} else if err != nil {
+			return 0, "", err
+		}
+		return user.ID, user.Password, nil
+	}
+
+	return 0, "", sql.ErrNoRows
+}
+
+// matchesQuery reports whether res passes the filters of q
+func matchesQuery(res models.Reservation, q models.ReservationQuery) bool {
+	if q.RoomID > 0 && res.RoomID != q.RoomID {
+		return false
+	}
+	if !q.From.IsZero() && res.EndDate.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && res.StartDate.After(q.To) {
+		return false
+	}
+	if q.Status != "" && res.Status != q.Status {
+		return false
+	}
+	if search := strings.ToLower(strings.TrimSpace(q.Search)); search != "" {
+		found := false
+		for _, field := range []string{res.FirstName, res.LastName, res.Email, res.Phone, res.ConfirmationCode} {
+			if strings.Contains(strings.ToLower(field), search) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// lessReservation orders reservations by the sort column of q, then by ID
+func lessReservation(a, b models.Reservation, sortBy string) bool {
+	var cmp int
+	switch sortBy {
+	case "first_name":
+		cmp = strings.Compare(strings.ToLower(a.FirstName), strings.ToLower(b.FirstName))
+	case "last_name":
+		cmp = strings.Compare(strings.ToLower(a.LastName), strings.ToLower(b.LastName))
+	case "room":
+		cmp = strings.Compare(a.Room.RoomName, b.Room.RoomName)
+	case "start_date":
+		cmp = a.StartDate.Compare(b.StartDate)
+	case "end_date":
+		cmp = a.EndDate.Compare(b.EndDate)
+	case "status":
+		cmp = strings.Compare(string(a.Status), string(b.Status))
+	case "created_at":
+		cmp = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if cmp != 0 {
+		return cmp < 0
+	}
+	return a.ID < b.ID
+}
+
+// ListReservations returns one page of the reservations matching q, with the total number of matches
+func (m *MemoryRepo) ListReservations(ctx context.Context, q models.ReservationQuery) (models.ReservationPage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q = q.Normalize()
+	page := models.ReservationPage{Query: q}
+
+	if err := m.fail("ListReservations"); err != nil {
+		return page, err
+	}
+
+	var matches []models.Reservation
+	for _, res := range m.reservations {
+		res = m.withRoom(res)
+		if matchesQuery(res, q) {
+			matches = append(matches, res)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if q.Desc {
+			return lessReservation(matches[j], matches[i], q.Sort)
+		}
+		return lessReservation(matches[i], matches[j], q.Sort)
+	})
+
+	page.Total = len(matches)
+	if q.Offset() < len(matches) {
+		end := q.Offset() + q.PageSize
+		if end > len(matches) {
+			end = len(matches)
+		}
+		page.Reservations = matches[q.Offset():end]
+	}
+
+	return page, nil
+}
+
+// searchRank orders a reservation for SearchReservations, lower is better, or returns -1 if it does not match
+func searchRank(res models.Reservation, term string, id int, code string) int {
+	term = strings.ToLower(term)
+	first := strings.ToLower(res.FirstName)
+	last := strings.ToLower(res.LastName)
+	name := first + " " + last
+	email := strings.ToLower(res.Email)
+	phone := strings.ToLower(res.Phone)
+
+	switch {
+	case res.ID == id || res.ConfirmationCode == code:
+		return 0
+	case !strings.Contains(name, term) && !strings.Contains(email, term) && !strings.Contains(phone, term):
+		return -1
+	case email == term:
+		return 1
+	case name == term || first == term || last == term:
+		return 2
+	case strings.HasPrefix(first, term) || strings.HasPrefix(last, term) || strings.HasPrefix(email, term) || strings.HasPrefix(phone, term):
+		return 3
+	}
+	return 4
+}
+
+// SearchReservations finds reservations by reservation ID, confirmation code or part of the guest's name, email or phone, best matches first
+func (m *MemoryRepo) SearchReservations(ctx context.Context, term string, limit int) ([]models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var reservations []models.Reservation
+
+	if err := m.fail("SearchReservations"); err != nil {
+		return reservations, err
+	}
+
+	term = strings.TrimSpace(term)
+	if term == "" {
+		return reservations, nil
+	}
+	id, _ := strconv.Atoi(term)
+	code := models.NormalizeConfirmationCode(term)
+	if limit < 1 {
+		limit = defaultSearchLimit
+	}
+
+	ranks := make(map[int]int)
+	for _, res := range m.reservations {
+		if rank := searchRank(res, term, id, code); rank >= 0 {
+			ranks[res.ID] = rank
+			reservations = append(reservations, m.withRoom(res))
+		}
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		a, b := reservations[i], reservations[j]
+		if ranks[a.ID] != ranks[b.ID] {
+			return ranks[a.ID] < ranks[b.ID]
+		}
+		if !a.StartDate.Equal(b.StartDate) {
+			return a.StartDate.After(b.StartDate)
+		}
+		return a.ID > b.ID
+	})
+
+	if len(reservations) > limit {
+		reservations = reservations[:limit]
+	}
+
+	return reservations, nil
+}
+
+// GetReservationByID returns a reservation by ID
+func (m *MemoryRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetReservationByID"); err != nil {
+		return models.Reservation{}, err
+	}
+
+	res, ok := m.reservations[id]
+	if !ok {
+		return res, sql.ErrNoRows
+	}
+	return m.withRoom(res), nil
+}
+
+// GetReservationByCode returns a reservation by its confirmation code, however the guest typed it
+func (m *MemoryRepo) GetReservationByCode(ctx context.Context, code string) (models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetReservationByCode"); err != nil {
+		return models.Reservation{}, err
+	}
+
+	code = models.NormalizeConfirmationCode(code)
+	for _, res := range m.reservations {
+		if res.ConfirmationCode == code {
+			return m.withRoom(res), nil
+		}
+	}
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+// UpdateReservation updates reservation in database
+func (m *MemoryRepo) UpdateReservation(ctx context.Context, res models.Reservation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("UpdateReservation"); err != nil {
+		return err
+	}
+
+	existing, ok := m.reservations[res.ID]
+	if !ok {
+		return nil
+	}
+
+	existing.FirstName = res.FirstName
+	existing.LastName = res.LastName
+	existing.Email = res.Email
+	existing.Phone = res.Phone
+	existing.UpdatedAt = time.Now()
+	m.reservations[res.ID] = existing
+
+	return nil
+}
+
+// RequestDateChange records the dates a guest would like to move their reservation to
+func (m *MemoryRepo) RequestDateChange(ctx context.Context, id int, start, end time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("RequestDateChange"); err != nil {
+		return err
+	}
+
+	res, ok := m.reservations[id]
+	if !ok {
+		return nil
+	}
+
+	res.RequestedStartDate = start
+	res.RequestedEndDate = end
+	res.UpdatedAt = time.Now()
+	m.reservations[id] = res
+
+	return nil
+}
+
+// ChangeReservationStay moves a reservation to new dates and room, together with its room restriction,
+// and stores total and charges as its new price.
+// Availability is checked again without the reservation's own restriction, so a stay can be shortened,
+// extended or moved onto dates it partly covers already. Any dates the guest asked for are cleared.
+// It returns repository.ErrRoomNotAvailable if the room is taken and repository.ErrReservationClosed
+// if the reservation is in a final status.
+func (m *MemoryRepo) ChangeReservationStay(ctx context.Context, id, roomID int, start, end time.Time, total models.Money, charges []models.ReservationCharge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("ChangeReservationStay"); err != nil {
+		return err
+	}
+
+	res, ok := m.reservations[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if res.Status.Final() {
+		return repository.ErrReservationClosed
+	}
+	if _, ok := m.rooms[roomID]; !ok {
+		return sql.ErrNoRows
+	}
+
+	own := 0
+	for rrID, rr := range m.roomRestrictions {
+		if rr.ReservationID == id {
+			own = rrID
+		}
+	}
+	if m.overlaps(roomID, start, end, own) {
+		return repository.ErrRoomNotAvailable
+	}
+
+	res.StartDate = start
+	res.EndDate = end
+	res.RoomID = roomID
+	res.TotalPrice = total
+	res.Charges = append([]models.ReservationCharge(nil), charges...)
+	res.RequestedStartDate = time.Time{}
+	res.RequestedEndDate = time.Time{}
+	res.UpdatedAt = time.Now()
+	m.reservations[id] = res
+
+	if rr, ok := m.roomRestrictions[own]; ok {
+		rr.StartDate = start
+		rr.EndDate = end
+		rr.RoomID = roomID
+		rr.UpdatedAt = time.Now()
+		m.roomRestrictions[own] = rr
+	}
+
+	return nil
+}
+
+// DeleteReservation deletes a reservation by ID, together with its room restrictions
+func (m *MemoryRepo) DeleteReservation(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("DeleteReservation"); err != nil {
+		return err
+	}
+
+	for _, p := range m.payments {
+		if p.ReservationID == id {
+			return repository.ErrReservationHasPayments
+		}
+	}
+
+	delete(m.reservations, id)
+	for rrID, rr := range m.roomRestrictions {
+		if rr.ReservationID == id {
+			delete(m.roomRestrictions, rrID)
+		}
+	}
+	for changeID, c := range m.statusChanges {
+		if c.ReservationID == id {
+			delete(m.statusChanges, changeID)
+		}
+	}
+	for invoiceID, inv := range m.invoices {
+		if inv.ReservationID == id {
+			inv.ReservationID = 0
+			m.invoices[invoiceID] = inv
+		}
+	}
+
+	return nil
+}
+
+// changeStatus moves a reservation to status and records the change in its history.
+// It returns repository.ErrInvalidStatusTransition if the current status cannot move to status.
+// The caller must hold m.mu.
+func (m *MemoryRepo) changeStatus(id int, status models.ReservationStatus, userID int) (models.Reservation, error) {
+	res, ok := m.reservations[id]
+	if !ok {
+		return res, sql.ErrNoRows
+	}
+	if !res.Status.CanTransitionTo(status) {
+		return res, repository.ErrInvalidStatusTransition
+	}
+
+	changeID := m.nextID("reservation_status_history")
+	m.statusChanges[changeID] = models.ReservationStatusChange{
+		ID:            changeID,
+		ReservationID: id,
+		FromStatus:    res.Status,
+		ToStatus:      status,
+		UserID:        userID,
+		CreatedAt:     time.Now(),
+	}
+
+	res.Status = status
+	res.UpdatedAt = time.Now()
+	m.reservations[id] = res
+
+	return res, nil
+}
+
+// UpdateReservationStatus moves a reservation to status and records the change in its history.
+// It returns repository.ErrInvalidStatusTransition if the current status cannot move to status.
+// Reservations are cancelled with CancelReservation only, which frees the room and records why and for what fee,
+// so status cancelled returns repository.ErrInvalidStatusTransition here.
+func (m *MemoryRepo) UpdateReservationStatus(ctx context.Context, id int, status models.ReservationStatus, userID int) error {
+	if status == models.StatusCancelled {
+		return repository.ErrInvalidStatusTransition
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("UpdateReservationStatus"); err != nil {
+		return err
+	}
+
+	_, err := m.changeStatus(id, status, userID)
+	return err
+}
+
+// CancelReservation cancels a reservation, records who cancelled it, why and the fee charged for it,
+// and deletes its room restriction so the dates can be booked again. A reservation that was never confirmed
+// or paid for gives back the use of its promo code. userID is the admin who cancelled, 0 when the guest did.
+func (m *MemoryRepo) CancelReservation(ctx context.Context, id int, userID int, reason string, fee models.Money) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("CancelReservation"); err != nil {
+		return err
+	}
+
+	wasNew := m.reservations[id].Status == models.StatusNew
+
+	res, err := m.changeStatus(id, models.StatusCancelled, userID)
+	if err != nil {
+		return err
+	}
+
+	if wasNew && res.PromoCode != "" && !m.captured(id) {
+		if promo, ok := m.promoCodeByCode(res.PromoCode); ok && promo.Uses > 0 {
+			promo.Uses--
+			promo.UpdatedAt = time.Now()
+			m.promoCodes[promo.ID] = promo
+		}
+	}
+
+	res.CancelledAt = time.Now()
+	res.CancelledBy = userID
+	res.CancellationReason = reason
+	res.CancellationFee = fee
+	m.reservations[id] = res
+
+	for rrID, rr := range m.roomRestrictions {
+		if rr.ReservationID == id {
+			delete(m.roomRestrictions, rrID)
+		}
+	}
+
+	return nil
+}
+
+// captured reports whether any payment for the reservation with id was captured. The caller must hold m.mu.
+func (m *MemoryRepo) captured(id int) bool {
+	for _, p := range m.payments {
+		if p.ReservationID == id && p.Status == models.PaymentCaptured {
+			return true
+		}
+	}
+	return false
+}
+
+// GetReservationStatusHistory returns the status changes of a reservation, oldest first
+func (m *MemoryRepo) GetReservationStatusHistory(ctx context.Context, id int) ([]models.ReservationStatusChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var changes []models.ReservationStatusChange
+
+	if err := m.fail("GetReservationStatusHistory"); err != nil {
+		return changes, err
+	}
+
+	for _, c := range m.statusChanges {
+		if c.ReservationID == id {
+			changes = append(changes, c)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].ID < changes[j].ID })
+
+	return changes, nil
+}
+
+// AllRooms returns all rooms, active or not, in the order they are listed in
+func (m *MemoryRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rooms []models.Room
+
+	if err := m.fail("AllRooms"); err != nil {
+		return rooms, err
+	}
+
+	rooms = m.sortedRooms()
+
+	return rooms, nil
+}
+
+// ActiveRooms returns the rooms guests can see and book, in the order they are listed in
+func (m *MemoryRepo) ActiveRooms(ctx context.Context) ([]models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rooms []models.Room
+
+	if err := m.fail("ActiveRooms"); err != nil {
+		return rooms, err
+	}
+
+	for _, room := range m.sortedRooms() {
+		if room.Active {
+			rooms = append(rooms, room)
+		}
+	}
+
+	return rooms, nil
+}
+
+// GetRoomBySlug gets the room whose public page is /rooms/{slug}
+func (m *MemoryRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetRoomBySlug"); err != nil {
+		return models.Room{}, err
+	}
+
+	for _, room := range m.rooms {
+		if room.Slug == slug {
+			return m.withDetails(room), nil
+		}
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+// slugTaken reports whether a room other than id already uses slug. The caller must hold m.mu.
+func (m *MemoryRepo) slugTaken(slug string, id int) bool {
+	for _, room := range m.rooms {
+		if room.Slug == slug && room.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// InsertRoom adds a room with its amenities at the end of the list and returns its ID.
+// It returns repository.ErrDuplicateSlug if another room uses the same slug.
+func (m *MemoryRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertRoom"); err != nil {
+		return 0, err
+	}
+	if m.slugTaken(room.Slug, 0) {
+		return 0, repository.ErrDuplicateSlug
+	}
+
+	room.SortOrder = 1
+	for _, other := range m.rooms {
+		if other.SortOrder >= room.SortOrder {
+			room.SortOrder = other.SortOrder + 1
+		}
+	}
+
+	room.ID = m.nextID("rooms")
+	room.Amenities = append([]string(nil), room.Amenities...)
+	room.Photos = nil
+	room.CreatedAt = time.Now()
+	room.UpdatedAt = time.Now()
+	m.rooms[room.ID] = room
+
+	return room.ID, nil
+}
+
+// UpdateRoom updates the name, slug, details and amenities of a room. Its photos are changed separately.
+// It returns repository.ErrDuplicateSlug if another room uses the same slug.
+func (m *MemoryRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("UpdateRoom"); err != nil {
+		return err
+	}
+	if m.slugTaken(room.Slug, room.ID) {
+		return repository.ErrDuplicateSlug
+	}
+
+	existing, ok := m.rooms[room.ID]
+	if !ok {
+		return nil
+	}
+
+	existing.RoomName = room.RoomName
+	existing.Slug = room.Slug
+	existing.MaxOccupancy = room.MaxOccupancy
+	existing.BedConfiguration = room.BedConfiguration
+	existing.Description = room.Description
+	existing.NightlyRate = room.NightlyRate
+	existing.CancellationPolicyID = room.CancellationPolicyID
+	existing.Amenities = append([]string(nil), room.Amenities...)
+	existing.UpdatedAt = time.Now()
+	m.rooms[room.ID] = existing
+
+	return nil
+}
+
+// SetRoomActive shows a room to guests again, or hides it so it can no longer be booked.
+// Existing reservations for the room are kept.
+func (m *MemoryRepo) SetRoomActive(ctx context.Context, id int, active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("SetRoomActive"); err != nil {
+		return err
+	}
+
+	room, ok := m.rooms[id]
+	if !ok {
+		return nil
+	}
+
+	room.Active = active
+	room.UpdatedAt = time.Now()
+	m.rooms[id] = room
+
+	return nil
+}
+
+// ReorderRooms lists the rooms in the order of ids. Rooms missing from ids keep their position number.
+func (m *MemoryRepo) ReorderRooms(ctx context.Context, ids []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("ReorderRooms"); err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		room, ok := m.rooms[id]
+		if !ok {
+			continue
+		}
+		room.SortOrder = i + 1
+		room.UpdatedAt = time.Now()
+		m.rooms[id] = room
+	}
+
+	return nil
+}
+
+// AddRoomPhoto adds a photo at the end of a room's gallery and returns its ID
+func (m *MemoryRepo) AddRoomPhoto(ctx context.Context, photo models.RoomPhoto) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("AddRoomPhoto"); err != nil {
+		return 0, err
+	}
+	if _, ok := m.rooms[photo.RoomID]; !ok {
+		return 0, errors.New("room does not exist")
+	}
+
+	photo.SortOrder = 1
+	for _, other := range m.roomPhotos {
+		if other.RoomID == photo.RoomID && other.SortOrder >= photo.SortOrder {
+			photo.SortOrder = other.SortOrder + 1
+		}
+	}
+
+	photo.ID = m.nextID("room_photos")
+	photo.CreatedAt = time.Now()
+	photo.UpdatedAt = time.Now()
+	m.roomPhotos[photo.ID] = photo
+
+	return photo.ID, nil
+}
+
+// DeleteRoomPhoto removes a photo from a room's gallery. It returns sql.ErrNoRows if the room has no such photo.
+func (m *MemoryRepo) DeleteRoomPhoto(ctx context.Context, roomID, photoID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("DeleteRoomPhoto"); err != nil {
+		return err
+	}
+
+	photo, ok := m.roomPhotos[photoID]
+	if !ok || photo.RoomID != roomID {
+		return sql.ErrNoRows
+	}
+	delete(m.roomPhotos, photoID)
+
+	return nil
+}
+
+// ReorderRoomPhotos puts a room's gallery in the order of ids. Photos missing from ids keep their position number.
+func (m *MemoryRepo) ReorderRoomPhotos(ctx context.Context, roomID int, ids []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("ReorderRoomPhotos"); err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		photo, ok := m.roomPhotos[id]
+		if !ok || photo.RoomID != roomID {
+			continue
+		}
+		photo.SortOrder = i + 1
+		photo.UpdatedAt = time.Now()
+		m.roomPhotos[id] = photo
+	}
+
+	return nil
+}
+
+// withRoomName fills in the name of the room a rate rule is for
+func (m *MemoryRepo) withRoomName(rule models.RateRule) models.RateRule {
+	rule.Room = models.Room{ID: rule.RoomID, RoomName: m.rooms[rule.RoomID].RoomName}
+	return rule
+}
+
+// AllRateRules returns every rate rule, the ones that win over others first
+func (m *MemoryRepo) AllRateRules(ctx context.Context) ([]models.RateRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rules []models.RateRule
+
+	if err := m.fail("AllRateRules"); err != nil {
+		return rules, err
+	}
+
+	for _, rule := range m.rateRules {
+		rules = append(rules, m.withRoomName(rule))
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority > rules[j].Priority
+		}
+		if rules[i].Name != rules[j].Name {
+			return rules[i].Name < rules[j].Name
+		}
+		return rules[i].ID < rules[j].ID
+	})
+
+	return rules, nil
+}
+
+// RateRulesForStay returns the rate rules, for any room, that may cover a night from start to end
+func (m *MemoryRepo) RateRulesForStay(ctx context.Context, start, end time.Time) ([]models.RateRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rules []models.RateRule
+
+	if err := m.fail("RateRulesForStay"); err != nil {
+		return rules, err
+	}
+
+	for _, rule := range m.rateRules {
+		if (rule.StartDate.IsZero() || rule.StartDate.Before(end)) && (rule.EndDate.IsZero() || !rule.EndDate.Before(start)) {
+			rules = append(rules, m.withRoomName(rule))
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	return rules, nil
+}
+
+// GetRateRuleByID gets a rate rule by ID
+func (m *MemoryRepo) GetRateRuleByID(ctx context.Context, id int) (models.RateRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetRateRuleByID"); err != nil {
+		return models.RateRule{}, err
+	}
+
+	rule, ok := m.rateRules[id]
+	if !ok {
+		return models.RateRule{}, sql.ErrNoRows
+	}
+	return m.withRoomName(rule), nil
+}
+
+// InsertRateRule adds a rate rule and returns its ID
+func (m *MemoryRepo) InsertRateRule(ctx context.Context, rule models.RateRule) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertRateRule"); err != nil {
+		return 0, err
+	}
+	if _, ok := m.rooms[rule.RoomID]; rule.RoomID != 0 && !ok {
+		return 0, errors.New("room does not exist")
+	}
+
+	rule.ID = m.nextID("rate_rules")
+	rule.Room = models.Room{}
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+	m.rateRules[rule.ID] = rule
+
+	return rule.ID, nil
+}
+
+// UpdateRateRule saves every field of a rate rule
+func (m *MemoryRepo) UpdateRateRule(ctx context.Context, rule models.RateRule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("UpdateRateRule"); err != nil {
+		return err
+	}
+	if _, ok := m.rooms[rule.RoomID]; rule.RoomID != 0 && !ok {
+		return errors.New("room does not exist")
+	}
+
+	existing, ok := m.rateRules[rule.ID]
+	if !ok {
+		return nil
+	}
+
+	rule.Room = models.Room{}
+	rule.CreatedAt = existing.CreatedAt
+	rule.UpdatedAt = time.Now()
+	m.rateRules[rule.ID] = rule
+
+	return nil
+}
+
+// DeleteRateRule deletes a rate rule. It returns sql.ErrNoRows if there is no such rule.
+func (m *MemoryRepo) DeleteRateRule(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("DeleteRateRule"); err != nil {
+		return err
+	}
+
+	if _, ok := m.rateRules[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.rateRules, id)
+
+	return nil
+}
+
+// promoCodeByCode finds a promo code by the code guests enter, in any case. The caller must hold m.mu.
+func (m *MemoryRepo) promoCodeByCode(code string) (models.PromoCode, bool) {
+	code = models.NormalizePromoCode(code)
+	for _, p := range m.promoCodes {
+		if p.Code == code {
+			return p, true
+		}
+	}
+	return models.PromoCode{}, false
+}
+
+// promoCodeTaken reports whether a promo code other than id already uses code. The caller must hold m.mu.
+func (m *MemoryRepo) promoCodeTaken(code string, id int) bool {
+	p, ok := m.promoCodeByCode(code)
+	return ok && p.ID != id
+}
+
+// withRooms returns a copy of a promo code that does not share its room IDs with the stored one
+func withRooms(p models.PromoCode) models.PromoCode {
+	p.RoomIDs = append([]int(nil), p.RoomIDs...)
+	if len(p.RoomIDs) == 0 {
+		p.RoomIDs = nil
+	}
+	return p
+}
+
+// AllPromoCodes returns every promo code in alphabetical order
+func (m *MemoryRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var codes []models.PromoCode
+
+	if err := m.fail("AllPromoCodes"); err != nil {
+		return codes, err
+	}
+
+	for _, p := range m.promoCodes {
+		codes = append(codes, withRooms(p))
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i].Code < codes[j].Code })
+
+	return codes, nil
+}
+
+// GetPromoCodeByID gets a promo code by ID
+func (m *MemoryRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetPromoCodeByID"); err != nil {
+		return models.PromoCode{}, err
+	}
+
+	p, ok := m.promoCodes[id]
+	if !ok {
+		return models.PromoCode{}, sql.ErrNoRows
+	}
+	return withRooms(p), nil
+}
+
+// GetPromoCodeByCode gets a promo code by the code guests enter, in any case
+func (m *MemoryRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetPromoCodeByCode"); err != nil {
+		return models.PromoCode{}, err
+	}
+
+	p, ok := m.promoCodeByCode(code)
+	if !ok {
+		return models.PromoCode{}, sql.ErrNoRows
+	}
+	return withRooms(p), nil
+}
+
+// InsertPromoCode adds a promo code with the rooms it is limited to and returns its ID.
+// It returns repository.ErrDuplicatePromoCode if another promo code uses the same code.
+func (m *MemoryRepo) InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertPromoCode"); err != nil {
+		return 0, err
+	}
+
+	p.Code = models.NormalizePromoCode(p.Code)
+	if m.promoCodeTaken(p.Code, 0) {
+		return 0, repository.ErrDuplicatePromoCode
+	}
+	for _, roomID := range p.RoomIDs {
+		if _, ok := m.rooms[roomID]; !ok {
+			return 0, errors.New("room does not exist")
+		}
+	}
+
+	p = withRooms(p)
+	p.ID = m.nextID("promo_codes")
+	p.Uses = 0
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	m.promoCodes[p.ID] = p
+
+	return p.ID, nil
+}
+
+// UpdatePromoCode saves every field of a promo code but its uses, and the rooms it is limited to.
+// It returns repository.ErrDuplicatePromoCode if another promo code uses the same code.
+func (m *MemoryRepo) UpdatePromoCode(ctx context.Context, p models.PromoCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("UpdatePromoCode"); err != nil {
+		return err
+	}
+
+	p.Code = models.NormalizePromoCode(p.Code)
+	if m.promoCodeTaken(p.Code, p.ID) {
+		return repository.ErrDuplicatePromoCode
+	}
+	for _, roomID := range p.RoomIDs {
+		if _, ok := m.rooms[roomID]; !ok {
+			return errors.New("room does not exist")
+		}
+	}
+
+	existing, ok := m.promoCodes[p.ID]
+	if !ok {
+		return nil
+	}
+
+	p = withRooms(p)
+	p.Uses = existing.Uses
+	p.CreatedAt = existing.CreatedAt
+	p.UpdatedAt = time.Now()
+	m.promoCodes[p.ID] = p
+
+	return nil
+}
+
+// DeletePromoCode deletes a promo code. Reservations booked with it keep its code and discount.
+// It returns sql.ErrNoRows if there is no such promo code.
+func (m *MemoryRepo) DeletePromoCode(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("DeletePromoCode"); err != nil {
+		return err
+	}
+
+	if _, ok := m.promoCodes[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.promoCodes, id)
+
+	return nil
+}
+
+// AllCharges returns every tax and fee, active or not, in the order they are added to stays
+func (m *MemoryRepo) AllCharges(ctx context.Context) ([]models.Charge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var charges []models.Charge
+
+	if err := m.fail("AllCharges"); err != nil {
+		return charges, err
+	}
+
+	return m.sortedCharges(false), nil
+}
+
+// ActiveCharges returns the taxes and fees added to new reservations, in the order they are added
+func (m *MemoryRepo) ActiveCharges(ctx context.Context) ([]models.Charge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var charges []models.Charge
+
+	if err := m.fail("ActiveCharges"); err != nil {
+		return charges, err
+	}
+
+	return m.sortedCharges(true), nil
+}
+
+// sortedCharges returns the charges, only the active ones if asked, by ID. The caller must hold m.mu.
+func (m *MemoryRepo) sortedCharges(activeOnly bool) []models.Charge {
+	var charges []models.Charge
+	for _, c := range m.charges {
+		if c.Active || !activeOnly {
+			charges = append(charges, c)
+		}
+	}
+	sort.Slice(charges, func(i, j int) bool { return charges[i].ID < charges[j].ID })
+	return charges
+}
+
+// GetChargeByID gets a tax or fee by ID
+func (m *MemoryRepo) GetChargeByID(ctx context.Context, id int) (models.Charge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetChargeByID"); err != nil {
+		return models.Charge{}, err
+	}
+
+	c, ok := m.charges[id]
+	if !ok {
+		return models.Charge{}, sql.ErrNoRows
+	}
+	return c, nil
+}
+
+// InsertCharge adds a tax or fee and returns its ID
+func (m *MemoryRepo) InsertCharge(ctx context.Context, c models.Charge) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertCharge"); err != nil {
+		return 0, err
+	}
+
+	c.ID = m.nextID("charges")
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = time.Now()
+	m.charges[c.ID] = c
+
+	return c.ID, nil
+}
+
+// UpdateCharge saves every field of a tax or fee. Reservations already made keep their charges.
+func (m *MemoryRepo) UpdateCharge(ctx context.Context, c models.Charge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("UpdateCharge"); err != nil {
+		return err
+	}
+
+	existing, ok := m.charges[c.ID]
+	if !ok {
+		return nil
+	}
+
+	c.CreatedAt = existing.CreatedAt
+	c.UpdatedAt = time.Now()
+	m.charges[c.ID] = c
+
+	return nil
+}
+
+// DeleteCharge deletes a tax or fee. Reservations already made keep their charges.
+// It returns sql.ErrNoRows if there is no such charge.
+func (m *MemoryRepo) DeleteCharge(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("DeleteCharge"); err != nil {
+		return err
+	}
+
+	if _, ok := m.charges[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.charges, id)
+
+	return nil
+}
+
+// AllCancellationPolicies returns every cancellation policy, by name
+func (m *MemoryRepo) AllCancellationPolicies(ctx context.Context) ([]models.CancellationPolicy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var policies []models.CancellationPolicy
+
+	if err := m.fail("AllCancellationPolicies"); err != nil {
+		return policies, err
+	}
+
+	for _, p := range m.policies {
+		policies = append(policies, p)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Name != policies[j].Name {
+			return policies[i].Name < policies[j].Name
+		}
+		return policies[i].ID < policies[j].ID
+	})
+
+	return policies, nil
+}
+
+// GetCancellationPolicyByID gets a cancellation policy by ID
+func (m *MemoryRepo) GetCancellationPolicyByID(ctx context.Context, id int) (models.CancellationPolicy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetCancellationPolicyByID"); err != nil {
+		return models.CancellationPolicy{}, err
+	}
+
+	p, ok := m.policies[id]
+	if !ok {
+		return models.CancellationPolicy{}, sql.ErrNoRows
+	}
+	return p, nil
+}
+
+// InsertCancellationPolicy adds a cancellation policy and returns its ID
+func (m *MemoryRepo) InsertCancellationPolicy(ctx context.Context, p models.CancellationPolicy) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertCancellationPolicy"); err != nil {
+		return 0, err
+	}
+
+	p.ID = m.nextID("cancellation_policies")
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	m.policies[p.ID] = p
+
+	return p.ID, nil
+}
+
+// UpdateCancellationPolicy saves every field of a cancellation policy. Reservations already made keep their terms.
+func (m *MemoryRepo) UpdateCancellationPolicy(ctx context.Context, p models.CancellationPolicy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("UpdateCancellationPolicy"); err != nil {
+		return err
+	}
+
+	existing, ok := m.policies[p.ID]
+	if !ok {
+		return nil
+	}
+
+	p.CreatedAt = existing.CreatedAt
+	p.UpdatedAt = time.Now()
+	m.policies[p.ID] = p
+
+	return nil
+}
+
+// DeleteCancellationPolicy deletes a cancellation policy. Rooms and rate rules using it go back to free
+// cancellation and to their room's policy, and reservations already made keep their terms.
+// It returns sql.ErrNoRows if there is no such policy.
+func (m *MemoryRepo) DeleteCancellationPolicy(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("DeleteCancellationPolicy"); err != nil {
+		return err
+	}
+
+	if _, ok := m.policies[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.policies, id)
+
+	for roomID, room := range m.rooms {
+		if room.CancellationPolicyID == id {
+			room.CancellationPolicyID = 0
+			m.rooms[roomID] = room
+		}
+	}
+	for ruleID, rule := range m.rateRules {
+		if rule.CancellationPolicyID == id {
+			rule.CancellationPolicyID = 0
+			m.rateRules[ruleID] = rule
+		}
+	}
+
+	return nil
+}
+
+// InsertPayment records a payment taken for a reservation and returns its ID
+func (m *MemoryRepo) InsertPayment(ctx context.Context, p models.Payment) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertPayment"); err != nil {
+		return 0, err
+	}
+
+	p.ID = m.nextID("payments")
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	m.payments[p.ID] = p
+
+	return p.ID, nil
+}
+
+// UpdatePayment saves the status, amounts and failure reason of a payment
+func (m *MemoryRepo) UpdatePayment(ctx context.Context, p models.Payment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("UpdatePayment"); err != nil {
+		return err
+	}
+
+	existing, ok := m.payments[p.ID]
+	if !ok {
+		return nil
+	}
+
+	existing.Status = p.Status
+	existing.Amount = p.Amount
+	existing.Refunded = p.Refunded
+	existing.FailureReason = p.FailureReason
+	existing.UpdatedAt = time.Now()
+	m.payments[p.ID] = existing
+
+	return nil
+}
+
+// GetPaymentByReference gets a payment by the reference its provider gave it
+func (m *MemoryRepo) GetPaymentByReference(ctx context.Context, provider, reference string) (models.Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetPaymentByReference"); err != nil {
+		return models.Payment{}, err
+	}
+
+	for _, p := range m.payments {
+		if p.Provider == provider && p.Reference == reference {
+			return p, nil
+		}
+	}
+	return models.Payment{}, sql.ErrNoRows
+}
+
+// PaymentsForReservation returns the payments taken for a reservation, oldest first
+func (m *MemoryRepo) PaymentsForReservation(ctx context.Context, reservationID int) ([]models.Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("PaymentsForReservation"); err != nil {
+		return nil, err
+	}
+
+	var payments []models.Payment
+	for _, p := range m.payments {
+		if p.ReservationID == reservationID {
+			payments = append(payments, p)
+		}
+	}
+	sort.Slice(payments, func(i, j int) bool { return payments[i].ID < payments[j].ID })
+
+	return payments, nil
+}
+
+// PendingPayments returns the payments still waiting for the guest to authenticate them, oldest first
+func (m *MemoryRepo) PendingPayments(ctx context.Context) ([]models.Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("PendingPayments"); err != nil {
+		return nil, err
+	}
+
+	var payments []models.Payment
+	for _, p := range m.payments {
+		if p.Status == models.PaymentPending {
+			payments = append(payments, p)
+		}
+	}
+	sort.Slice(payments, func(i, j int) bool { return payments[i].ID < payments[j].ID })
+
+	return payments, nil
+}
+
+// InsertInvoice issues an invoice for a reservation under the next invoice number and returns it with
+// its ID and number. It returns repository.ErrInvoiceExists if the reservation already has an invoice.
+func (m *MemoryRepo) InsertInvoice(ctx context.Context, inv models.Invoice) (models.Invoice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertInvoice"); err != nil {
+		return inv, err
+	}
+
+	inv.Number = 1
+	for _, existing := range m.invoices {
+		if inv.ReservationID != 0 && existing.ReservationID == inv.ReservationID {
+			return inv, repository.ErrInvoiceExists
+		}
+		if existing.Number >= inv.Number {
+			inv.Number = existing.Number + 1
+		}
+	}
+
+	inv.ID = m.nextID("invoices")
+	inv.Lines = append([]models.InvoiceLine(nil), inv.Lines...)
+	inv.CreatedAt = time.Now()
+	inv.UpdatedAt = time.Now()
+	m.invoices[inv.ID] = inv
+
+	return inv, nil
+}
+
+// GetInvoiceByID gets an invoice with its lines
+func (m *MemoryRepo) GetInvoiceByID(ctx context.Context, id int) (models.Invoice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetInvoiceByID"); err != nil {
+		return models.Invoice{}, err
+	}
+
+	inv, ok := m.invoices[id]
+	if !ok {
+		return models.Invoice{}, sql.ErrNoRows
+	}
+	inv.Lines = append([]models.InvoiceLine(nil), inv.Lines...)
+	return inv, nil
+}
+
+// GetInvoiceForReservation gets the invoice issued for a reservation with its lines
+func (m *MemoryRepo) GetInvoiceForReservation(ctx context.Context, reservationID int) (models.Invoice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("GetInvoiceForReservation"); err != nil {
+		return models.Invoice{}, err
+	}
+
+	for _, inv := range m.invoices {
+		if reservationID != 0 && inv.ReservationID == reservationID {
+			inv.Lines = append([]models.InvoiceLine(nil), inv.Lines...)
+			return inv, nil
+		}
+	}
+	return models.Invoice{}, sql.ErrNoRows
+}
+
+// AllInvoices returns every invoice without its lines, the last issued first
+func (m *MemoryRepo) AllInvoices(ctx context.Context) ([]models.Invoice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("AllInvoices"); err != nil {
+		return nil, err
+	}
+
+	var invoices []models.Invoice
+	for _, inv := range m.invoices {
+		inv.Lines = nil
+		invoices = append(invoices, inv)
+	}
+	sort.Slice(invoices, func(i, j int) bool { return invoices[i].Number > invoices[j].Number })
+
+	return invoices, nil
+}
+
+// GetRestrictionsForRoomByDate returns all restrictions for a room by date range
+func (m *MemoryRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var restrictions []models.RoomRestriction
+
+	if err := m.fail("GetRestrictionsForRoomByDate"); err != nil {
+		return restrictions, err
+	}
+
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID == roomID && start.Before(rr.EndDate) && !end.Before(rr.StartDate) {
+			restrictions = append(restrictions, models.RoomRestriction{
+				ID:            rr.ID,
+				ReservationID: rr.ReservationID,
+				RestrictionID: rr.RestrictionID,
+				RoomID:        rr.RoomID,
+				StartDate:     rr.StartDate,
+				EndDate:       rr.EndDate,
+			})
+		}
+	}
+	sort.Slice(restrictions, func(i, j int) bool { return restrictions[i].ID < restrictions[j].ID })
+
+	return restrictions, nil
+}
+
+// InsertBlockForRoom inserts a room restriction
+func (m *MemoryRepo) InsertBlockForRoom(ctx context.Context, id int, date time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("InsertBlockForRoom"); err != nil {
+		return err
+	}
+
+	return m.insertRoomRestriction(models.RoomRestriction{
+		StartDate:     date,
+		EndDate:       date.AddDate(0, 0, 1),
+		RoomID:        id,
+		RestrictionID: 2,
+	})
+}
+
+// DeleteBlockByID deletes a room restriction by ID
+func (m *MemoryRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fail("DeleteBlockByID"); err != nil {
+		return err
+	}
+
+	delete(m.roomRestrictions, id)
+
+	return nil
+}
@@ -2,41 +2,82 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/repository"
+	"github.com/jackc/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *postgresDBRepo) AllUser() bool {
+// Postgres error codes for violated constraints
+const (
+	pgUniqueViolation    = "23505"
+	pgExclusionViolation = "23P01"
+)
+
+// isOverlapError reports whether err was raised by the room_restrictions_no_overlap constraint
+func isOverlapError(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgExclusionViolation
+}
+
+// isConfirmationCodeTaken reports whether err was raised because another reservation has the same confirmation code
+func isConfirmationCodeTaken(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation && pgErr.ConstraintName == "reservations_confirmation_code_idx"
+}
+
+func (m *postgresDBRepo) AllUser(ctx context.Context) bool {
 	return true
 }
 
 // InsertReservation inserts a reservation into the database
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	var newID int
 
 	stmt := `insert into reservations (first_name, last_name, email, phone, start_date,
-			end_date, room_id, created_at, updated_at)
-			values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+			end_date, room_id, created_at, updated_at, confirmation_code, source, total_price, promo_code, discount, guests,
+			cancellation_policy, cancellation_free_days, cancellation_penalty)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18) returning id`
+
+	err := withConfirmationCode(func(code string) error {
+		return m.DB.QueryRowContext(ctx, stmt,
+			res.FirstName,
+			res.LastName,
+			res.Email,
+			res.Phone,
+			res.StartDate,
+			res.EndDate,
+			res.RoomID,
+			time.Now(),
+			time.Now(),
+			code,
+			string(reservationSource(res)),
+			res.TotalPrice,
+			res.PromoCode,
+			res.Discount,
+			reservationGuests(res),
+			res.CancellationPolicy.Name,
+			res.CancellationPolicy.FreeDays,
+			res.CancellationPolicy.Penalty,
+		).Scan(&newID)
+	}, isConfirmationCodeTaken)
 
-	err := m.DB.QueryRowContext(ctx, stmt,
-		res.FirstName,
-		res.LastName,
-		res.Email,
-		res.Phone,
-		res.StartDate,
-		res.EndDate,
-		res.RoomID,
-		time.Now(),
-		time.Now(),
-	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
 
+	err = saveReservationCharges(ctx, m.DB, postgresDialect, newID, res.Charges)
 	if err != nil {
 		return 0, err
 	}
@@ -45,8 +86,8 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 }
 
 // InsertRoomRestriction inserts a room restriction into the database
-func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	stmt := `insert into room_restrictions
@@ -62,15 +103,137 @@ func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 		time.Now(),
 		r.RestrictionID,
 	)
+	if isOverlapError(err) {
+		return repository.ErrRoomNotAvailable
+	}
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// InsertReservationWithRestriction inserts a reservation and its room restriction in one transaction,
+// so either both rows are written or neither is
+func (m *postgresDBRepo) InsertReservationWithRestriction(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	// rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// lock the room row so concurrent bookings for the same room are serialized,
+	// then re-check availability inside the transaction
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select id from rooms where id = $1 for update`, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	var numRows int
+	query := `select count(id)
+			  from room_restrictions
+			  where
+			  room_id = $1 and
+			  	$2 < end_date and $3 > start_date;`
+
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	// the promo code is redeemed in the same transaction, so it is never used more often than it allows
+	if res.PromoCode != "" {
+		if err = redeemPromoCode(ctx, tx, postgresDialect, res.PromoCode); err != nil {
+			return 0, err
+		}
+	}
+
+	var newID int
+
+	stmt := `insert into reservations (first_name, last_name, email, phone, start_date,
+			end_date, room_id, created_at, updated_at, confirmation_code, source, total_price, promo_code, discount, guests,
+			cancellation_policy, cancellation_free_days, cancellation_penalty)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18) returning id`
+
+	// a taken code fails the insert only, the transaction rolls back to the savepoint and tries another
+	err = withConfirmationCode(func(code string) error {
+		if _, err := tx.ExecContext(ctx, `savepoint new_reservation`); err != nil {
+			return err
+		}
+		err := tx.QueryRowContext(ctx, stmt,
+			res.FirstName,
+			res.LastName,
+			res.Email,
+			res.Phone,
+			res.StartDate,
+			res.EndDate,
+			res.RoomID,
+			time.Now(),
+			time.Now(),
+			code,
+			string(reservationSource(res)),
+			res.TotalPrice,
+			res.PromoCode,
+			res.Discount,
+			reservationGuests(res),
+			res.CancellationPolicy.Name,
+			res.CancellationPolicy.FreeDays,
+			res.CancellationPolicy.Penalty,
+		).Scan(&newID)
+		if isConfirmationCodeTaken(err) {
+			if _, rollbackErr := tx.ExecContext(ctx, `rollback to savepoint new_reservation`); rollbackErr != nil {
+				return rollbackErr
+			}
+		}
+		return err
+	}, isConfirmationCodeTaken)
+	if err != nil {
+		return 0, err
+	}
+
+	err = saveReservationCharges(ctx, tx, postgresDialect, newID, res.Charges)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `insert into room_restrictions
+			(start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
+			values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		newID,
+		time.Now(),
+		time.Now(),
+		1,
+	)
+	// the exclusion constraint is the last line of defence against overlapping bookings
+	if isOverlapError(err) {
+		return 0, repository.ErrRoomNotAvailable
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
 // SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID, and false if no availability exists for roomID
-func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	query := `select count(id)
@@ -93,71 +256,43 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time,
 }
 
 // SearchAvailabilityForAllRooms returns a slice of available room, if any, for give date range
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	query := `select r.id, r.room_name
-			  from rooms r
-			  where r.id not in
+	query := `select ` + roomColumns + `
+			  from rooms
+			  where active and id not in
 			  		(select room_id from room_restrictions rr
-					where $1 < rr.end_date and $2 > rr.start_date);`
-
-	var rooms []models.Room
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
-	if err != nil {
-		return rooms, err
-	}
+					where $1 < rr.end_date and $2 > rr.start_date)
+			  order by sort_order, id`
 
-	for rows.Next() {
-		var room models.Room
-		err = rows.Scan(
-			&room.ID,
-			&room.RoomName,
-		)
-
-		if err != nil {
-			return rooms, err
-		}
-
-		rooms = append(rooms, room)
-	}
-
-	if err = rows.Err(); err != nil {
-		return rooms, err
-	}
-
-	return rooms, nil
+	return listRooms(ctx, m.DB, postgresDialect, query, start, end)
 }
 
 // GetRoomByID gets a room by ID
-func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	var room models.Room
+	query := `select ` + roomColumns + ` from rooms where id = $1`
 
-	query := `select id, room_name, created_at, updated_at
-			  from rooms where id = $1;`
+	return getRoom(ctx, m.DB, postgresDialect, query, id)
+}
 
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&room.ID,
-		&room.RoomName,
-		&room.CreatedAt,
-		&room.UpdatedAt,
-	)
+// GetRoomBySlug gets the room whose public page is /rooms/{slug}
+func (m *postgresDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
 
-	if err != nil {
-		return room, err
-	}
+	query := `select ` + roomColumns + ` from rooms where slug = $1`
 
-	return room, nil
+	return getRoom(ctx, m.DB, postgresDialect, query, slug)
 }
 
 // GetUserByID returns a user by ID
-func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at
@@ -185,8 +320,8 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 }
 
 // UpdateUser updates user in database
-func (m *postgresDBRepo) UpdateUser(user models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) UpdateUser(ctx context.Context, user models.User) error {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	query := `update users set first_name = $1, last_name = $2, email = $3, access_level = $4, updated_at = $5 where id = $6`
@@ -201,8 +336,8 @@ func (m *postgresDBRepo) UpdateUser(user models.User) error {
 }
 
 // Authenticate authenticates a user
-func (m *postgresDBRepo) Authenticate(email, password string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) Authenticate(ctx context.Context, email, password string) (int, string, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	var id int
@@ -223,25 +358,36 @@ func (m *postgresDBRepo) Authenticate(email, password string) (int, string, erro
 	return id, hashedPassword, nil
 }
 
-// AllReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// ListReservations returns one page of the reservations matching q, with the total number of matches
+func (m *postgresDBRepo) ListReservations(ctx context.Context, q models.ReservationQuery) (models.ReservationPage, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	var reservations []models.Reservation
+	q = q.Normalize()
+	page := models.ReservationPage{Query: q}
 
-	query := `
-	select r.id, r.first_name, r.last_name, r.email, r.phone,
-	r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
+	where, orderBy, args := reservationListing(postgresDialect, q)
+
+	query := `select count(*) from reservations r left join rooms rm on (r.room_id = rm.id) ` + where
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&page.Total)
+	if err != nil {
+		return page, err
+	}
+
+	query = fmt.Sprintf(`
+	select r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone,
+	r.start_date, r.end_date, r.status, r.room_id, r.created_at, r.updated_at,
 	rm.id, rm.room_name
 	from reservations r
 	left join rooms rm on (r.room_id = rm.id)
-	order by r.start_date asc
-	`
+	%s
+	%s
+	limit %d offset %d
+	`, where, orderBy, q.PageSize, q.Offset())
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return reservations, err
+		return page, err
 	}
 
 	defer rows.Close()
@@ -250,12 +396,14 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 		var i models.Reservation
 		err = rows.Scan(
 			&i.ID,
+			&i.ConfirmationCode,
 			&i.FirstName,
 			&i.LastName,
 			&i.Email,
 			&i.Phone,
 			&i.StartDate,
 			&i.EndDate,
+			&i.Status,
 			&i.RoomID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
@@ -264,37 +412,63 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 		)
 
 		if err != nil {
-			return reservations, err
+			return page, err
 		}
-		// log.Println(i.ID, i.FirstName, i.LastName, i.StartDate, i.RoomID)
-		reservations = append(reservations, i)
+		page.Reservations = append(page.Reservations, i)
 	}
 
 	if err = rows.Err(); err != nil {
-		return reservations, err
+		return page, err
 	}
 
-	return reservations, nil
+	return page, nil
 }
 
-// AllNewReservations returns a slice of all new reservations
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// SearchReservations finds reservations by reservation ID, confirmation code or part of the guest's name, email or phone,
+// best matches first. Names also match with small typos, using the pg_trgm indexes.
+func (m *postgresDBRepo) SearchReservations(ctx context.Context, term string, limit int) ([]models.Reservation, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	var reservations []models.Reservation
 
+	term = strings.TrimSpace(term)
+	if term == "" {
+		return reservations, nil
+	}
+	id, _ := strconv.Atoi(term)
+	if limit < 1 {
+		limit = defaultSearchLimit
+	}
+
+	// $1 term, $2 reservation ID, $3 contains pattern, $4 prefix pattern, $5 limit, $6 confirmation code
 	query := `
-	select r.id, r.first_name, r.last_name, r.email, r.phone,
-	r.start_date, r.end_date, r.processed, r.room_id, r.created_at, r.updated_at,
+	select r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone,
+	r.start_date, r.end_date, r.status, r.room_id, r.created_at, r.updated_at,
 	rm.id, rm.room_name
 	from reservations r
 	left join rooms rm on (r.room_id = rm.id)
-	where processed = 0
-	order by r.start_date asc
+	where r.id = $2 or r.confirmation_code = $6
+	or (r.first_name || ' ' || r.last_name) ilike $3 escape '\'
+	or r.email ilike $3 escape '\'
+	or r.phone ilike $3 escape '\'
+	or (r.first_name || ' ' || r.last_name) % $1
+	order by
+		case
+			when r.id = $2 or r.confirmation_code = $6 then 0
+			when lower(r.email) = lower($1) then 1
+			when lower(r.first_name || ' ' || r.last_name) = lower($1)
+				or lower(r.first_name) = lower($1) or lower(r.last_name) = lower($1) then 2
+			when r.first_name ilike $4 escape '\' or r.last_name ilike $4 escape '\'
+				or r.email ilike $4 escape '\' or r.phone ilike $4 escape '\' then 3
+			else 4
+		end,
+		similarity(r.first_name || ' ' || r.last_name, $1) desc,
+		r.start_date desc, r.id desc
+	limit $5
 	`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, term, id, containsPattern(term), prefixPattern(term), limit, models.NormalizeConfirmationCode(term))
 	if err != nil {
 		return reservations, err
 	}
@@ -305,13 +479,14 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 		var i models.Reservation
 		err = rows.Scan(
 			&i.ID,
+			&i.ConfirmationCode,
 			&i.FirstName,
 			&i.LastName,
 			&i.Email,
 			&i.Phone,
 			&i.StartDate,
 			&i.EndDate,
-			&i.Processed,
+			&i.Status,
 			&i.RoomID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
@@ -322,7 +497,6 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 		if err != nil {
 			return reservations, err
 		}
-		// log.Println(i.ID, i.FirstName, i.LastName, i.StartDate, i.RoomID)
 		reservations = append(reservations, i)
 	}
 
@@ -334,23 +508,39 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 }
 
 // GetReservationByID returns a reservation by ID
-func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	return m.getReservation(ctx, "r.id", id)
+}
+
+// GetReservationByCode returns a reservation by its confirmation code, however the guest typed it
+func (m *postgresDBRepo) GetReservationByCode(ctx context.Context, code string) (models.Reservation, error) {
+	return m.getReservation(ctx, "r.confirmation_code", models.NormalizeConfirmationCode(code))
+}
+
+// getReservation returns the reservation whose column equals value
+func (m *postgresDBRepo) getReservation(ctx context.Context, column string, value interface{}) (models.Reservation, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	var res models.Reservation
 
 	query := `
-	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
-	r.created_at, r.updated_at, r.processed, rm.id, rm.room_name
+	select r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
+	r.created_at, r.updated_at, r.status, r.cancelled_at, coalesce(r.cancelled_by, 0), r.cancellation_reason,
+	r.requested_start_date, r.requested_end_date, r.source, r.total_price, r.promo_code, r.discount, r.guests,
+	r.cancellation_policy, r.cancellation_free_days, r.cancellation_penalty, r.cancellation_fee,
+	rm.id, rm.room_name
 	from reservations r
 	left join rooms rm on (r.room_id = rm.id)
-	where r.id = $1
+	where ` + column + ` = $1
 	`
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+	var cancelledAt, requestedStart, requestedEnd sql.NullTime
+
+	row := m.DB.QueryRowContext(ctx, query, value)
 	err := row.Scan(
 		&res.ID,
+		&res.ConfirmationCode,
 		&res.FirstName,
 		&res.LastName,
 		&res.Email,
@@ -360,7 +550,21 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 		&res.RoomID,
 		&res.CreatedAt,
 		&res.UpdatedAt,
-		&res.Processed,
+		&res.Status,
+		&cancelledAt,
+		&res.CancelledBy,
+		&res.CancellationReason,
+		&requestedStart,
+		&requestedEnd,
+		&res.Source,
+		&res.TotalPrice,
+		&res.PromoCode,
+		&res.Discount,
+		&res.Guests,
+		&res.CancellationPolicy.Name,
+		&res.CancellationPolicy.FreeDays,
+		&res.CancellationPolicy.Penalty,
+		&res.CancellationFee,
 		&res.Room.ID,
 		&res.Room.RoomName,
 	)
@@ -369,12 +573,17 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 		return res, err
 	}
 
-	return res, nil
+	res.CancelledAt = cancelledAt.Time
+	res.RequestedStartDate = requestedStart.Time
+	res.RequestedEndDate = requestedEnd.Time
+
+	res.Charges, err = loadReservationCharges(ctx, m.DB, postgresDialect, res.ID)
+	return res, err
 }
 
 // UpdateReservation updates reservation in database
-func (m *postgresDBRepo) UpdateReservation(res models.Reservation) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) UpdateReservation(ctx context.Context, res models.Reservation) error {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	query := `update reservations set
@@ -391,15 +600,15 @@ func (m *postgresDBRepo) UpdateReservation(res models.Reservation) error {
 	return nil
 }
 
-// DeleteReservation deletes a reservation by ID
-func (m *postgresDBRepo) DeleteReservation(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// RequestDateChange records the dates a guest would like to move their reservation to
+func (m *postgresDBRepo) RequestDateChange(ctx context.Context, id int, start, end time.Time) error {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	query := `delete from reservations where id = $1`
-
-	_, err := m.DB.ExecContext(ctx, query, id)
+	query := `update reservations set requested_start_date = $1, requested_end_date = $2, updated_at = $3
+	where id = $4`
 
+	_, err := m.DB.ExecContext(ctx, query, start, end, time.Now(), id)
 	if err != nil {
 		return err
 	}
@@ -407,110 +616,1127 @@ func (m *postgresDBRepo) DeleteReservation(id int) error {
 	return nil
 }
 
-// UpdateProcessedForReservation updates processed of reservation by ID
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// ChangeReservationStay moves a reservation to new dates and room, together with its room restriction,
+// and stores total and charges as its new price.
+// Availability is checked again without the reservation's own restriction, so a stay can be shortened,
+// extended or moved onto dates it partly covers already. Any dates the guest asked for are cleared.
+// It returns repository.ErrRoomNotAvailable if the room is taken and repository.ErrReservationClosed
+// if the reservation is in a final status.
+func (m *postgresDBRepo) ChangeReservationStay(ctx context.Context, id, roomID int, start, end time.Time, total models.Money, charges []models.ReservationCharge) error {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	query := `update reservations set processed = $1 where id = $2`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status models.ReservationStatus
+	err = tx.QueryRowContext(ctx, `select status from reservations where id = $1 for update`, id).Scan(&status)
+	if err != nil {
+		return err
+	}
+	if status.Final() {
+		return repository.ErrReservationClosed
+	}
+
+	// lock the room row like InsertReservationWithRestriction does, so bookings for it are serialized
+	var lockedID int
+	err = tx.QueryRowContext(ctx, `select id from rooms where id = $1 for update`, roomID).Scan(&lockedID)
+	if err != nil {
+		return err
+	}
 
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	var numRows int
+	query := `select count(id)
+			  from room_restrictions
+			  where
+			  room_id = $1 and
+			  	$2 < end_date and $3 > start_date and
+			  	(reservation_id is null or reservation_id <> $4);`
 
+	err = tx.QueryRowContext(ctx, query, roomID, start, end, id).Scan(&numRows)
 	if err != nil {
 		return err
 	}
+	if numRows > 0 {
+		return repository.ErrRoomNotAvailable
+	}
 
-	return nil
+	stmt := `update reservations set start_date = $1, end_date = $2, room_id = $3, total_price = $4,
+			requested_start_date = null, requested_end_date = null, updated_at = $5
+			where id = $6`
+
+	_, err = tx.ExecContext(ctx, stmt, start, end, roomID, total, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update room_restrictions set start_date = $1, end_date = $2, room_id = $3, updated_at = $4
+			where reservation_id = $5`
+
+	_, err = tx.ExecContext(ctx, stmt, start, end, roomID, time.Now(), id)
+	if isOverlapError(err) {
+		return repository.ErrRoomNotAvailable
+	}
+	if err != nil {
+		return err
+	}
+
+	err = saveReservationCharges(ctx, tx, postgresDialect, id, charges)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// AllRooms returns all rooms
-func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// DeleteReservation deletes a reservation by ID
+func (m *postgresDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	var rooms []models.Room
+	return deleteReservation(ctx, m.DB, postgresDialect, id)
+}
+
+// changeStatus moves a reservation to status inside tx and records the change in its history.
+// It returns repository.ErrInvalidStatusTransition if the current status cannot move to status.
+func (m *postgresDBRepo) changeStatus(ctx context.Context, tx *sql.Tx, id int, status models.ReservationStatus, userID int) error {
+	var current models.ReservationStatus
+	err := tx.QueryRowContext(ctx, `select status from reservations where id = $1 for update`, id).Scan(&current)
+	if err != nil {
+		return err
+	}
 
-	query := `select id, room_name, created_at, updated_at from rooms order by room_name`
+	if !current.CanTransitionTo(status) {
+		return repository.ErrInvalidStatusTransition
+	}
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	_, err = tx.ExecContext(ctx, `update reservations set status = $1, updated_at = $2 where id = $3`, string(status), time.Now(), id)
 	if err != nil {
-		return rooms, err
+		return err
 	}
 
-	defer rows.Close()
+	stmt := `insert into reservation_status_history (reservation_id, from_status, to_status, user_id, created_at)
+			values ($1, $2, $3, $4, $5)`
 
-	for rows.Next() {
-		var room models.Room
-		err := rows.Scan(
-			&room.ID,
-			&room.RoomName,
-			&room.CreatedAt,
-			&room.UpdatedAt,
-		)
-		if err != nil {
-			return rooms, err
-		}
-		rooms = append(rooms, room)
+	_, err = tx.ExecContext(ctx, stmt, id, string(current), string(status), nullableID(userID), time.Now())
+	return err
+}
+
+// UpdateReservationStatus moves a reservation to status and records the change in its history.
+// It returns repository.ErrInvalidStatusTransition if the current status cannot move to status.
+// Reservations are cancelled with CancelReservation only, which frees the room and records why and for what fee,
+// so status cancelled returns repository.ErrInvalidStatusTransition here.
+func (m *postgresDBRepo) UpdateReservationStatus(ctx context.Context, id int, status models.ReservationStatus, userID int) error {
+	if status == models.StatusCancelled {
+		return repository.ErrInvalidStatusTransition
 	}
 
-	if err = rows.Err(); err != nil {
-		return rooms, err
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = m.changeStatus(ctx, tx, id, status, userID)
+	if err != nil {
+		return err
 	}
-	return rooms, nil
+
+	return tx.Commit()
 }
 
-// GetRestrictionsForRoomByDate returns all restrictions for a room by date range
-func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// CancelReservation cancels a reservation, records who cancelled it, why and the fee charged for it,
+// and deletes its room restriction so the dates can be booked again. A reservation that was never confirmed
+// or paid for gives back the use of its promo code. userID is the admin who cancelled, 0 when the guest did.
+func (m *postgresDBRepo) CancelReservation(ctx context.Context, id int, userID int, reason string, fee models.Money) error {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	var restrictions []models.RoomRestriction
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	query := `select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
-	from room_restrictions where $1 < end_date and $2 >= start_date
-	and room_id = $3`
+	err = returnPromoCode(ctx, tx, postgresDialect, id)
+	if err != nil {
+		return err
+	}
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	err = m.changeStatus(ctx, tx, id, models.StatusCancelled, userID)
+	if err != nil {
+		return err
+	}
 
+	stmt := `update reservations set cancelled_at = $1, cancelled_by = $2, cancellation_reason = $3, cancellation_fee = $4
+			where id = $5`
+
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), nullableID(userID), reason, fee, id)
 	if err != nil {
-		return restrictions, err
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetReservationStatusHistory returns the status changes of a reservation, oldest first
+func (m *postgresDBRepo) GetReservationStatusHistory(ctx context.Context, id int) ([]models.ReservationStatusChange, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var changes []models.ReservationStatusChange
+
+	query := `select id, reservation_id, from_status, to_status, coalesce(user_id, 0), created_at
+	from reservation_status_history where reservation_id = $1
+	order by created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return changes, err
 	}
 
 	defer rows.Close()
 
 	for rows.Next() {
-		var restriction models.RoomRestriction
-		err := rows.Scan(
-			&restriction.ID,
-			&restriction.ReservationID,
-			&restriction.RestrictionID,
-			&restriction.RoomID,
-			&restriction.StartDate,
-			&restriction.EndDate,
+		var c models.ReservationStatusChange
+		err = rows.Scan(
+			&c.ID,
+			&c.ReservationID,
+			&c.FromStatus,
+			&c.ToStatus,
+			&c.UserID,
+			&c.CreatedAt,
 		)
 		if err != nil {
-			return restrictions, err
+			return changes, err
 		}
-		restrictions = append(restrictions, restriction)
+		changes = append(changes, c)
 	}
 
 	if err = rows.Err(); err != nil {
-		return restrictions, err
+		return changes, err
 	}
 
-	return restrictions, nil
+	return changes, nil
 }
 
-// InsertBlockForRoom inserts a room restriction, return rooms_restrictions_id
-func (m *postgresDBRepo) InsertBlockForRoom(id int, date time.Time) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// AllRooms returns all rooms, active or not, in the order they are listed in
+func (m *postgresDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
-	query := `insert into room_restrictions 
-	(start_date, end_date, room_id, restriction_id, created_at, updated_at)
-	values ($1, $2, $3, $4, $5, $6)`
+	return listRooms(ctx, m.DB, postgresDialect, `select `+roomColumns+` from rooms order by sort_order, id`)
+}
 
-	_, err := m.DB.ExecContext(ctx, query, date, date.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
+// ActiveRooms returns the rooms guests can see and book, in the order they are listed in
+func (m *postgresDBRepo) ActiveRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listRooms(ctx, m.DB, postgresDialect, `select `+roomColumns+` from rooms where active order by sort_order, id`)
+}
+
+// slugTaken reports whether a room other than id already uses slug
+func (m *postgresDBRepo) slugTaken(ctx context.Context, tx *sql.Tx, slug string, id int) (bool, error) {
+	var numRows int
+	err := tx.QueryRowContext(ctx, `select count(id) from rooms where slug = $1 and id <> $2`, slug, id).Scan(&numRows)
+	return numRows > 0, err
+}
+
+// InsertRoom adds a room with its amenities at the end of the list and returns its ID.
+// It returns repository.ErrDuplicateSlug if another room uses the same slug.
+func (m *postgresDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	taken, err := m.slugTaken(ctx, tx, room.Slug, 0)
+	if err != nil {
+		return 0, err
+	}
+	if taken {
+		return 0, repository.ErrDuplicateSlug
+	}
+
+	var sortOrder int
+	err = tx.QueryRowContext(ctx, `select coalesce(max(sort_order), 0) + 1 from rooms`).Scan(&sortOrder)
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+	stmt := `insert into rooms (room_name, slug, active, sort_order, max_occupancy, bed_configuration, description,
+			nightly_rate, cancellation_policy_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Active,
+		sortOrder,
+		room.MaxOccupancy,
+		room.BedConfiguration,
+		room.Description,
+		room.NightlyRate,
+		nullableID(room.CancellationPolicyID),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = saveAmenities(ctx, tx, postgresDialect, newID, room.Amenities)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRoom updates the name, slug, details and amenities of a room. Its photos are changed separately.
+// It returns repository.ErrDuplicateSlug if another room uses the same slug.
+func (m *postgresDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	taken, err := m.slugTaken(ctx, tx, room.Slug, room.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return repository.ErrDuplicateSlug
+	}
+
+	stmt := `update rooms set room_name = $1, slug = $2, max_occupancy = $3, bed_configuration = $4,
+			description = $5, nightly_rate = $6, cancellation_policy_id = $7, updated_at = $8
+			where id = $9`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.MaxOccupancy,
+		room.BedConfiguration,
+		room.Description,
+		room.NightlyRate,
+		nullableID(room.CancellationPolicyID),
+		time.Now(),
+		room.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = saveAmenities(ctx, tx, postgresDialect, room.ID, room.Amenities)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetRoomActive shows a room to guests again, or hides it so it can no longer be booked.
+// Existing reservations for the room are kept.
+func (m *postgresDBRepo) SetRoomActive(ctx context.Context, id int, active bool) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update rooms set active = $1, updated_at = $2 where id = $3`, active, time.Now(), id)
+	return err
+}
+
+// ReorderRooms lists the rooms in the order of ids. Rooms missing from ids keep their position number.
+func (m *postgresDBRepo) ReorderRooms(ctx context.Context, ids []int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, id := range ids {
+		_, err = tx.ExecContext(ctx, `update rooms set sort_order = $1, updated_at = $2 where id = $3`, i+1, time.Now(), id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AddRoomPhoto adds a photo at the end of a room's gallery and returns its ID
+func (m *postgresDBRepo) AddRoomPhoto(ctx context.Context, photo models.RoomPhoto) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var sortOrder int
+	err = tx.QueryRowContext(ctx, `select coalesce(max(sort_order), 0) + 1 from room_photos where room_id = $1`, photo.RoomID).Scan(&sortOrder)
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+	stmt := `insert into room_photos (room_id, path, caption, sort_order, original_path, thumbnail_path,
+			created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		photo.RoomID,
+		photo.Path,
+		photo.Caption,
+		sortOrder,
+		photo.OriginalPath,
+		photo.ThumbnailPath,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteRoomPhoto removes a photo from a room's gallery. It returns sql.ErrNoRows if the room has no such photo.
+func (m *postgresDBRepo) DeleteRoomPhoto(ctx context.Context, roomID, photoID int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from room_photos where id = $1 and room_id = $2`, photoID, roomID)
+	if err != nil {
+		return err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ReorderRoomPhotos puts a room's gallery in the order of ids. Photos missing from ids keep their position number.
+func (m *postgresDBRepo) ReorderRoomPhotos(ctx context.Context, roomID int, ids []int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update room_photos set sort_order = $1, updated_at = $2 where id = $3 and room_id = $4`
+	for i, id := range ids {
+		_, err = tx.ExecContext(ctx, stmt, i+1, time.Now(), id, roomID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AllRateRules returns every rate rule, the ones that win over others first
+func (m *postgresDBRepo) AllRateRules(ctx context.Context) ([]models.RateRule, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listRateRules(ctx, m.DB, `select `+rateRuleColumns+`
+		from rate_rules r left join rooms rm on (r.room_id = rm.id)
+		order by r.priority desc, r.name, r.id`)
+}
+
+// RateRulesForStay returns the rate rules, for any room, that may cover a night from start to end
+func (m *postgresDBRepo) RateRulesForStay(ctx context.Context, start, end time.Time) ([]models.RateRule, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listRateRules(ctx, m.DB, `select `+rateRuleColumns+`
+		from rate_rules r left join rooms rm on (r.room_id = rm.id)
+		where (r.start_date is null or r.start_date < $1) and (r.end_date is null or r.end_date >= $2)
+		order by r.id`, end, start)
+}
+
+// GetRateRuleByID gets a rate rule by ID
+func (m *postgresDBRepo) GetRateRuleByID(ctx context.Context, id int) (models.RateRule, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return scanRateRule(m.DB.QueryRowContext(ctx, `select `+rateRuleColumns+`
+		from rate_rules r left join rooms rm on (r.room_id = rm.id)
+		where r.id = $1`, id))
+}
+
+// InsertRateRule adds a rate rule and returns its ID
+func (m *postgresDBRepo) InsertRateRule(ctx context.Context, rule models.RateRule) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `insert into rate_rules (name, room_id, start_date, end_date, weekdays, nightly_rate, priority,
+			cancellation_policy_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	var newID int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		rule.Name,
+		nullableID(rule.RoomID),
+		nullableDate(postgresDialect, rule.StartDate),
+		nullableDate(postgresDialect, rule.EndDate),
+		rule.Weekdays,
+		rule.NightlyRate,
+		rule.Priority,
+		nullableID(rule.CancellationPolicyID),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRateRule saves every field of a rate rule
+func (m *postgresDBRepo) UpdateRateRule(ctx context.Context, rule models.RateRule) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `update rate_rules set name = $1, room_id = $2, start_date = $3, end_date = $4, weekdays = $5,
+			nightly_rate = $6, priority = $7, cancellation_policy_id = $8, updated_at = $9
+			where id = $10`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		rule.Name,
+		nullableID(rule.RoomID),
+		nullableDate(postgresDialect, rule.StartDate),
+		nullableDate(postgresDialect, rule.EndDate),
+		rule.Weekdays,
+		rule.NightlyRate,
+		rule.Priority,
+		nullableID(rule.CancellationPolicyID),
+		time.Now(),
+		rule.ID,
+	)
+	return err
+}
+
+// DeleteRateRule deletes a rate rule. It returns sql.ErrNoRows if there is no such rule.
+func (m *postgresDBRepo) DeleteRateRule(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from rate_rules where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// promoCodeTaken reports whether a promo code other than id already uses code
+func (m *postgresDBRepo) promoCodeTaken(ctx context.Context, tx *sql.Tx, code string, id int) (bool, error) {
+	var numRows int
+	err := tx.QueryRowContext(ctx, `select count(id) from promo_codes where code = $1 and id <> $2`, code, id).Scan(&numRows)
+	return numRows > 0, err
+}
+
+// AllPromoCodes returns every promo code in alphabetical order
+func (m *postgresDBRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listPromoCodes(ctx, m.DB, postgresDialect, `select `+promoCodeColumns+` from promo_codes order by code`)
+}
+
+// GetPromoCodeByID gets a promo code by ID
+func (m *postgresDBRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return getPromoCode(ctx, m.DB, postgresDialect, `select `+promoCodeColumns+` from promo_codes where id = $1`, id)
+}
+
+// GetPromoCodeByCode gets a promo code by the code guests enter, in any case
+func (m *postgresDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return getPromoCode(ctx, m.DB, postgresDialect, `select `+promoCodeColumns+` from promo_codes where code = $1`,
+		models.NormalizePromoCode(code))
+}
+
+// InsertPromoCode adds a promo code with the rooms it is limited to and returns its ID.
+// It returns repository.ErrDuplicatePromoCode if another promo code uses the same code.
+func (m *postgresDBRepo) InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	code := models.NormalizePromoCode(p.Code)
+	taken, err := m.promoCodeTaken(ctx, tx, code, 0)
+	if err != nil {
+		return 0, err
+	}
+	if taken {
+		return 0, repository.ErrDuplicatePromoCode
+	}
+
+	stmt := `insert into promo_codes (code, description, kind, percent_off, amount_off, valid_from, valid_until,
+			max_uses, min_nights, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
+
+	var newID int
+	err = tx.QueryRowContext(ctx, stmt,
+		code,
+		p.Description,
+		string(p.Kind),
+		p.PercentOff,
+		p.AmountOff,
+		nullableDate(postgresDialect, p.ValidFrom),
+		nullableDate(postgresDialect, p.ValidUntil),
+		p.MaxUses,
+		p.MinNights,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = savePromoCodeRooms(ctx, tx, postgresDialect, newID, p.RoomIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdatePromoCode saves every field of a promo code but its uses, and the rooms it is limited to.
+// It returns repository.ErrDuplicatePromoCode if another promo code uses the same code.
+func (m *postgresDBRepo) UpdatePromoCode(ctx context.Context, p models.PromoCode) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	code := models.NormalizePromoCode(p.Code)
+	taken, err := m.promoCodeTaken(ctx, tx, code, p.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return repository.ErrDuplicatePromoCode
+	}
+
+	stmt := `update promo_codes set code = $1, description = $2, kind = $3, percent_off = $4, amount_off = $5,
+			valid_from = $6, valid_until = $7, max_uses = $8, min_nights = $9, updated_at = $10
+			where id = $11`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		code,
+		p.Description,
+		string(p.Kind),
+		p.PercentOff,
+		p.AmountOff,
+		nullableDate(postgresDialect, p.ValidFrom),
+		nullableDate(postgresDialect, p.ValidUntil),
+		p.MaxUses,
+		p.MinNights,
+		time.Now(),
+		p.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = savePromoCodeRooms(ctx, tx, postgresDialect, p.ID, p.RoomIDs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeletePromoCode deletes a promo code. Reservations booked with it keep its code and discount.
+// It returns sql.ErrNoRows if there is no such promo code.
+func (m *postgresDBRepo) DeletePromoCode(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from promo_codes where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// AllCharges returns every tax and fee, active or not, in the order they are added to stays
+func (m *postgresDBRepo) AllCharges(ctx context.Context) ([]models.Charge, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listCharges(ctx, m.DB, `select `+chargeColumns+` from charges order by id`)
+}
+
+// ActiveCharges returns the taxes and fees added to new reservations, in the order they are added
+func (m *postgresDBRepo) ActiveCharges(ctx context.Context) ([]models.Charge, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listCharges(ctx, m.DB, `select `+chargeColumns+` from charges where active order by id`)
+}
+
+// GetChargeByID gets a tax or fee by ID
+func (m *postgresDBRepo) GetChargeByID(ctx context.Context, id int) (models.Charge, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return scanCharge(m.DB.QueryRowContext(ctx, `select `+chargeColumns+` from charges where id = $1`, id))
+}
+
+// InsertCharge adds a tax or fee and returns its ID
+func (m *postgresDBRepo) InsertCharge(ctx context.Context, c models.Charge) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `insert into charges (name, method, rate, amount, per, active, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	var newID int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		c.Name,
+		string(c.Method),
+		c.Rate,
+		c.Amount,
+		string(c.Per),
+		c.Active,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateCharge saves every field of a tax or fee. Reservations already made keep their charges.
+func (m *postgresDBRepo) UpdateCharge(ctx context.Context, c models.Charge) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `update charges set name = $1, method = $2, rate = $3, amount = $4, per = $5, active = $6, updated_at = $7
+			where id = $8`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		c.Name,
+		string(c.Method),
+		c.Rate,
+		c.Amount,
+		string(c.Per),
+		c.Active,
+		time.Now(),
+		c.ID,
+	)
+	return err
+}
+
+// DeleteCharge deletes a tax or fee. Reservations already made keep their charges.
+// It returns sql.ErrNoRows if there is no such charge.
+func (m *postgresDBRepo) DeleteCharge(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from charges where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// AllCancellationPolicies returns every cancellation policy, by name
+func (m *postgresDBRepo) AllCancellationPolicies(ctx context.Context) ([]models.CancellationPolicy, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listCancellationPolicies(ctx, m.DB, `select `+cancellationPolicyColumns+` from cancellation_policies order by name, id`)
+}
+
+// GetCancellationPolicyByID gets a cancellation policy by ID
+func (m *postgresDBRepo) GetCancellationPolicyByID(ctx context.Context, id int) (models.CancellationPolicy, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return scanCancellationPolicy(m.DB.QueryRowContext(ctx, `select `+cancellationPolicyColumns+` from cancellation_policies where id = $1`, id))
+}
+
+// InsertCancellationPolicy adds a cancellation policy and returns its ID
+func (m *postgresDBRepo) InsertCancellationPolicy(ctx context.Context, p models.CancellationPolicy) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `insert into cancellation_policies (name, free_days, penalty, created_at, updated_at)
+			values ($1, $2, $3, $4, $5) returning id`
+
+	var newID int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.Name,
+		p.FreeDays,
+		p.Penalty,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateCancellationPolicy saves every field of a cancellation policy. Reservations already made keep their terms.
+func (m *postgresDBRepo) UpdateCancellationPolicy(ctx context.Context, p models.CancellationPolicy) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `update cancellation_policies set name = $1, free_days = $2, penalty = $3, updated_at = $4
+			where id = $5`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		p.Name,
+		p.FreeDays,
+		p.Penalty,
+		time.Now(),
+		p.ID,
+	)
+	return err
+}
+
+// DeleteCancellationPolicy deletes a cancellation policy. Rooms and rate rules using it go back to free
+// cancellation and to their room's policy, and reservations already made keep their terms.
+// It returns sql.ErrNoRows if there is no such policy.
+func (m *postgresDBRepo) DeleteCancellationPolicy(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update rooms set cancellation_policy_id = null where cancellation_policy_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update rate_rules set cancellation_policy_id = null where cancellation_policy_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `delete from cancellation_policies where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+// InsertPayment records a payment taken for a reservation and returns its ID
+func (m *postgresDBRepo) InsertPayment(ctx context.Context, p models.Payment) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `insert into payments (reservation_id, provider, reference, status, amount, refunded, card_last4,
+			failure_reason, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	var newID int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.ReservationID,
+		p.Provider,
+		p.Reference,
+		string(p.Status),
+		p.Amount,
+		p.Refunded,
+		p.CardLast4,
+		p.FailureReason,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdatePayment saves the status, amounts and failure reason of a payment
+func (m *postgresDBRepo) UpdatePayment(ctx context.Context, p models.Payment) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `update payments set status = $1, amount = $2, refunded = $3, failure_reason = $4, updated_at = $5
+			where id = $6`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		string(p.Status),
+		p.Amount,
+		p.Refunded,
+		p.FailureReason,
+		time.Now(),
+		p.ID,
+	)
+	return err
+}
+
+// GetPaymentByReference gets a payment by the reference its provider gave it
+func (m *postgresDBRepo) GetPaymentByReference(ctx context.Context, provider, reference string) (models.Payment, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return scanPayment(m.DB.QueryRowContext(ctx, `select `+paymentColumns+` from payments
+		where provider = $1 and reference = $2`, provider, reference))
+}
+
+// PaymentsForReservation returns the payments taken for a reservation, oldest first
+func (m *postgresDBRepo) PaymentsForReservation(ctx context.Context, reservationID int) ([]models.Payment, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listPayments(ctx, m.DB, `select `+paymentColumns+` from payments
+		where reservation_id = $1 order by id`, reservationID)
+}
+
+// PendingPayments returns the payments still waiting for the guest to authenticate them, oldest first
+func (m *postgresDBRepo) PendingPayments(ctx context.Context) ([]models.Payment, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listPayments(ctx, m.DB, `select `+paymentColumns+` from payments
+		where status = $1 order by id`, string(models.PaymentPending))
+}
+
+// InsertInvoice issues an invoice for a reservation under the next invoice number and returns it with
+// its ID and number. It returns repository.ErrInvoiceExists if the reservation already has an invoice.
+func (m *postgresDBRepo) InsertInvoice(ctx context.Context, inv models.Invoice) (models.Invoice, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return inv, err
+	}
+	defer tx.Rollback()
+
+	// invoices are numbered one at a time, so no two get the same number and none is skipped
+	_, err = tx.ExecContext(ctx, `lock table invoices in share row exclusive mode`)
+	if err != nil {
+		return inv, err
+	}
+
+	inv.Number, err = invoiceNumber(ctx, tx, postgresDialect, inv)
+	if err != nil {
+		return inv, err
+	}
+	inv.CreatedAt = time.Now()
+	inv.UpdatedAt = time.Now()
+
+	stmt := `insert into invoices (number, reservation_id, confirmation_code, billed_to, email, total, issued_at,
+			created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		inv.Number,
+		nullableID(inv.ReservationID),
+		inv.ConfirmationCode,
+		inv.BilledTo,
+		inv.Email,
+		inv.Total,
+		inv.IssuedAt,
+		inv.CreatedAt,
+		inv.UpdatedAt,
+	).Scan(&inv.ID)
+	if err != nil {
+		return inv, err
+	}
+
+	err = saveInvoiceLines(ctx, tx, postgresDialect, inv.ID, inv.Lines)
+	if err != nil {
+		return inv, err
+	}
+
+	return inv, tx.Commit()
+}
+
+// GetInvoiceByID gets an invoice with its lines
+func (m *postgresDBRepo) GetInvoiceByID(ctx context.Context, id int) (models.Invoice, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return getInvoice(ctx, m.DB, postgresDialect, `select `+invoiceColumns+` from invoices where id = $1`, id)
+}
+
+// GetInvoiceForReservation gets the invoice issued for a reservation with its lines
+func (m *postgresDBRepo) GetInvoiceForReservation(ctx context.Context, reservationID int) (models.Invoice, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return getInvoice(ctx, m.DB, postgresDialect, `select `+invoiceColumns+` from invoices where reservation_id = $1`, reservationID)
+}
+
+// AllInvoices returns every invoice without its lines, the last issued first
+func (m *postgresDBRepo) AllInvoices(ctx context.Context) ([]models.Invoice, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listInvoices(ctx, m.DB, `select `+invoiceColumns+` from invoices order by number desc`)
+}
+
+// GetRestrictionsForRoomByDate returns all restrictions for a room by date range
+func (m *postgresDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
+	from room_restrictions where $1 < end_date and $2 >= start_date
+	and room_id = $3`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+
+	if err != nil {
+		return restrictions, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var restriction models.RoomRestriction
+		err := rows.Scan(
+			&restriction.ID,
+			&restriction.ReservationID,
+			&restriction.RestrictionID,
+			&restriction.RoomID,
+			&restriction.StartDate,
+			&restriction.EndDate,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, restriction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// InsertBlockForRoom inserts a room restriction, return rooms_restrictions_id
+func (m *postgresDBRepo) InsertBlockForRoom(ctx context.Context, id int, date time.Time) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `insert into room_restrictions 
+	(start_date, end_date, room_id, restriction_id, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6)`
+
+	_, err := m.DB.ExecContext(ctx, query, date, date.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
+	if isOverlapError(err) {
+		return repository.ErrRoomNotAvailable
+	}
 	if err != nil {
 		log.Println(err)
 		return err
@@ -520,8 +1746,8 @@ func (m *postgresDBRepo) InsertBlockForRoom(id int, date time.Time) error {
 }
 
 // DeleteBlockByID deletes a room restriction by ID
-func (m *postgresDBRepo) DeleteBlockByID(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
 	defer cancel()
 
 	query := `delete from room_restrictions where id = $1`
@@ -0,0 +1,365 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// sqliteCodeChar picks one random character of a confirmation code, see models.NewConfirmationCode
+const sqliteCodeChar = `substr('ABCDEFGHJKLMNPQRSTUVWXYZ23456789', abs(random() % 32) + 1, 1)`
+
+// sqliteSchema holds the statements that build the SQLite schema, one entry per version.
+// The database's user_version records how many entries have been applied,
+// so new entries must only ever be appended to the end of the list.
+var sqliteSchema = []string{
+	`
+	create table users (
+		id integer primary key autoincrement,
+		first_name varchar(255) not null default '',
+		last_name varchar(255) not null default '',
+		email varchar(255) not null,
+		password varchar(60) not null,
+		access_level integer not null default 1,
+		created_at timestamp not null,
+		updated_at timestamp not null
+	);
+	create unique index users_email_idx on users (email);
+
+	create table rooms (
+		id integer primary key autoincrement,
+		room_name varchar(255) not null default '',
+		created_at timestamp not null,
+		updated_at timestamp not null
+	);
+
+	create table restrictions (
+		id integer primary key autoincrement,
+		restriction_name varchar(255) not null default '',
+		created_at timestamp not null,
+		updated_at timestamp not null
+	);
+
+	create table reservations (
+		id integer primary key autoincrement,
+		first_name varchar(255) not null default '',
+		last_name varchar(255) not null default '',
+		email varchar(255) not null,
+		phone varchar(255) not null default '',
+		start_date date not null,
+		end_date date not null,
+		room_id integer not null references rooms (id) on delete cascade on update cascade,
+		processed integer not null default 0,
+		created_at timestamp not null,
+		updated_at timestamp not null
+	);
+	create index reservations_email_idx on reservations (email);
+	create index reservations_last_name_idx on reservations (last_name);
+
+	create table room_restrictions (
+		id integer primary key autoincrement,
+		start_date date not null,
+		end_date date not null,
+		room_id integer not null references rooms (id) on delete cascade on update cascade,
+		reservation_id integer references reservations (id) on delete cascade on update cascade,
+		restriction_id integer not null references restrictions (id) on delete cascade on update cascade,
+		created_at timestamp not null,
+		updated_at timestamp not null
+	);
+	create index room_restrictions_start_date_end_date_idx on room_restrictions (start_date, end_date);
+	create index room_restrictions_room_id_idx on room_restrictions (room_id);
+	create index room_restrictions_reservation_id_idx on room_restrictions (reservation_id);
+
+	-- SQLite has no exclusion constraints, so overlapping restrictions are rejected by triggers
+	create trigger room_restrictions_no_overlap_insert
+	before insert on room_restrictions
+	when exists (
+		select 1 from room_restrictions
+		where room_id = new.room_id and new.start_date < end_date and new.end_date > start_date
+	)
+	begin
+		select raise(abort, 'room_restrictions_no_overlap');
+	end;
+
+	create trigger room_restrictions_no_overlap_update
+	before update of start_date, end_date, room_id on room_restrictions
+	when exists (
+		select 1 from room_restrictions
+		where id <> new.id and room_id = new.room_id and new.start_date < end_date and new.end_date > start_date
+	)
+	begin
+		select raise(abort, 'room_restrictions_no_overlap');
+	end;
+
+	insert into rooms (room_name, created_at, updated_at) values
+		('General''s Quarters', '2024-05-20 00:00:00', '2024-05-20 00:00:00'),
+		('Major''s Suite', '2024-05-28 00:00:00', '2024-05-28 00:00:00');
+
+	insert into restrictions (restriction_name, created_at, updated_at) values
+		('Reservation', '2024-05-25 00:00:00', '2024-05-25 00:00:00'),
+		('Owner Block', '2024-05-28 00:00:00', '2024-05-28 00:00:00');
+
+	insert into users (first_name, last_name, email, password, access_level, created_at, updated_at) values
+		('Shu', 'Akihito', 'admin@admin.com', '$2a$12$JFkjE3cAZxNwye2xssHlM.Yzz89rvwMu.62WkChHgvPrYmbvyISvS', 3,
+		'2024-09-23 00:00:00', '2024-09-23 00:00:00');
+	`,
+	`
+	create index reservations_start_date_idx on reservations (start_date);
+	create index reservations_room_id_idx on reservations (room_id);
+	`,
+	`
+	alter table reservations add column status varchar(20) not null default 'new'
+		check (status in ('new', 'confirmed', 'checked_in', 'checked_out', 'cancelled', 'no_show'));
+	update reservations set status = 'confirmed' where processed = 1;
+	alter table reservations drop column processed;
+	create index reservations_status_idx on reservations (status);
+
+	create table reservation_status_history (
+		id integer primary key autoincrement,
+		reservation_id integer not null references reservations (id) on delete cascade on update cascade,
+		from_status varchar(20) not null,
+		to_status varchar(20) not null,
+		user_id integer references users (id) on delete set null on update cascade,
+		created_at timestamp not null
+	);
+	create index reservation_status_history_reservation_id_idx on reservation_status_history (reservation_id);
+	`,
+	`
+	alter table reservations add column cancelled_at timestamp;
+	alter table reservations add column cancelled_by integer references users (id) on delete set null on update cascade;
+	alter table reservations add column cancellation_reason text not null default '';
+
+	delete from room_restrictions
+	where reservation_id in (select id from reservations where status = 'cancelled');
+	`,
+	`
+	-- SQLite cannot add a not null column without a default, so existing reservations are given a random code
+	-- from the application's alphabet. Should two draw the same code, creating the index fails and rolls
+	-- the version back, and the next start draws them again.
+	alter table reservations add column confirmation_code varchar(20) not null default '';
+	update reservations set confirmation_code =
+		` + sqliteCodeChar + ` || ` + sqliteCodeChar + ` || ` + sqliteCodeChar + ` || ` + sqliteCodeChar + ` || '-' ||
+		` + sqliteCodeChar + ` || ` + sqliteCodeChar + ` || ` + sqliteCodeChar + ` || ` + sqliteCodeChar + `;
+	create unique index reservations_confirmation_code_idx on reservations (confirmation_code);
+	`,
+	`
+	alter table reservations add column requested_start_date date;
+	alter table reservations add column requested_end_date date;
+	`,
+	`
+	alter table reservations add column source varchar(20) not null default 'online';
+	`,
+	`
+	alter table rooms add column slug varchar(255) not null default '';
+	alter table rooms add column active boolean not null default 1;
+	alter table rooms add column sort_order integer not null default 0;
+
+	update rooms set slug = 'generals-quarters' where room_name = 'General''s Quarters';
+	update rooms set slug = 'majors-suite' where room_name = 'Major''s Suite';
+	update rooms set slug = 'room-' || id where slug = '';
+	update rooms set sort_order = id;
+
+	create unique index rooms_slug_idx on rooms (slug);
+	`,
+	`
+	alter table rooms add column max_occupancy integer not null default 2;
+	alter table rooms add column bed_configuration varchar(255) not null default '';
+	alter table rooms add column description text not null default '';
+
+	create table room_amenities (
+		id integer primary key autoincrement,
+		room_id integer not null references rooms (id) on delete cascade on update cascade,
+		name varchar(255) not null,
+		sort_order integer not null default 0
+	);
+	create index room_amenities_room_id_idx on room_amenities (room_id);
+
+	create table room_photos (
+		id integer primary key autoincrement,
+		room_id integer not null references rooms (id) on delete cascade on update cascade,
+		path varchar(255) not null,
+		caption varchar(255) not null default '',
+		sort_order integer not null default 0,
+		created_at timestamp not null,
+		updated_at timestamp not null
+	);
+	create index room_photos_room_id_idx on room_photos (room_id);
+
+	update rooms set description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.'
+	where slug in ('generals-quarters', 'majors-suite');
+	insert into room_photos (room_id, path, sort_order, created_at, updated_at)
+	select id, '/static/images/' || slug || '.png', 1, current_timestamp, current_timestamp
+	from rooms where slug in ('generals-quarters', 'majors-suite');
+	`,
+	`
+	alter table room_photos add column original_path varchar(255) not null default '';
+	alter table room_photos add column thumbnail_path varchar(255) not null default '';
+	`,
+	`
+	alter table rooms add column nightly_rate integer not null default 0;
+	alter table reservations add column total_price integer not null default 0;
+
+	update rooms set nightly_rate = 10000 where slug = 'generals-quarters';
+	update rooms set nightly_rate = 15000 where slug = 'majors-suite';
+	`,
+	`
+	create table rate_rules (
+		id integer primary key autoincrement,
+		name varchar(255) not null,
+		room_id integer references rooms (id) on delete cascade on update cascade,
+		start_date date,
+		end_date date,
+		weekdays integer not null default 0,
+		nightly_rate integer not null,
+		priority integer not null default 0,
+		created_at timestamp not null,
+		updated_at timestamp not null
+	);
+	create index rate_rules_start_date_end_date_idx on rate_rules (start_date, end_date);
+	`,
+	`
+	create table promo_codes (
+		id integer primary key autoincrement,
+		code varchar(50) not null,
+		description varchar(255) not null default '',
+		kind varchar(20) not null,
+		percent_off integer not null default 0,
+		amount_off integer not null default 0,
+		valid_from date,
+		valid_until date,
+		max_uses integer not null default 0,
+		uses integer not null default 0,
+		min_nights integer not null default 0,
+		created_at timestamp not null,
+		updated_at timestamp not null
+	);
+	create unique index promo_codes_code_idx on promo_codes (code);
+
+	create table promo_code_rooms (
+		promo_code_id integer not null references promo_codes (id) on delete cascade on update cascade,
+		room_id integer not null references rooms (id) on delete cascade on update cascade,
+		primary key (promo_code_id, room_id)
+	);
+
+	alter table reservations add column promo_code varchar(50) not null default '';
+	alter table reservations add column discount integer not null default 0;
+	`,
+	`
+	create table charges (
+		id integer primary key autoincrement,
+		name varchar(255) not null,
+		method varchar(20) not null,
+		rate integer not null default 0,
+		amount integer not null default 0,
+		per varchar(20) not null default 'stay',
+		active boolean not null default true,
+		created_at timestamp not null,
+		updated_at timestamp not null
+	);
+
+	create table reservation_charges (
+		id integer primary key autoincrement,
+		reservation_id integer not null references reservations (id) on delete cascade on update cascade,
+		name varchar(255) not null,
+		amount integer not null,
+		sort_order integer not null default 0
+	);
+	create index reservation_charges_reservation_id_idx on reservation_charges (reservation_id);
+
+	alter table reservations add column guests integer not null default 1;
+	`,
+	`
+	create table payments (
+		id integer primary key autoincrement,
+		reservation_id integer not null references reservations (id) on delete restrict on update cascade,
+		provider varchar(50) not null,
+		reference varchar(255) not null,
+		status varchar(20) not null,
+		amount integer not null,
+		refunded integer not null default 0,
+		card_last4 varchar(4) not null default '',
+		failure_reason varchar(255) not null default '',
+		created_at timestamp not null,
+		updated_at timestamp not null
+	);
+	create unique index payments_provider_reference_idx on payments (provider, reference);
+	create index payments_reservation_id_idx on payments (reservation_id);
+	`,
+	`
+	create table cancellation_policies (
+		id integer primary key autoincrement,
+		name varchar(255) not null,
+		free_days integer not null default 0,
+		penalty integer not null default 0,
+		created_at timestamp not null,
+		updated_at timestamp not null
+	);
+
+	alter table rooms add column cancellation_policy_id integer
+		references cancellation_policies (id) on delete set null on update cascade;
+	alter table rate_rules add column cancellation_policy_id integer
+		references cancellation_policies (id) on delete set null on update cascade;
+
+	alter table reservations add column cancellation_policy varchar(255) not null default '';
+	alter table reservations add column cancellation_free_days integer not null default 0;
+	alter table reservations add column cancellation_penalty integer not null default 0;
+	alter table reservations add column cancellation_fee integer not null default 0;
+	`,
+	`
+	create table invoices (
+		id integer primary key autoincrement,
+		number integer not null,
+		reservation_id integer references reservations (id) on delete set null on update cascade,
+		confirmation_code varchar(20) not null default '',
+		billed_to varchar(255) not null,
+		email varchar(255) not null default '',
+		total integer not null,
+		issued_at timestamp not null,
+		created_at timestamp not null,
+		updated_at timestamp not null
+	);
+	create unique index invoices_number_idx on invoices (number);
+	create unique index invoices_reservation_id_idx on invoices (reservation_id);
+
+	create table invoice_lines (
+		id integer primary key autoincrement,
+		invoice_id integer not null references invoices (id) on delete cascade on update cascade,
+		description varchar(255) not null,
+		amount integer not null,
+		sort_order integer not null default 0
+	);
+	create index invoice_lines_invoice_id_idx on invoice_lines (invoice_id);
+	`,
+}
+
+// migrateSQLite applies every schema version the database has not seen yet
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	err := db.QueryRow("pragma user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(sqliteSchema); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(sqliteSchema[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("sqlite schema version %d: %w", i+1, err)
+		}
+
+		// pragma statements do not accept bound parameters
+		if _, err = tx.Exec(fmt.Sprintf("pragma user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,1767 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sqliteDate formats a date the way it is stored in SQLite, so dates compare correctly as text
+func sqliteDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// isSQLiteOverlapError reports whether err was raised by the room_restrictions_no_overlap triggers
+func isSQLiteOverlapError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "room_restrictions_no_overlap")
+}
+
+// isSQLiteConfirmationCodeTaken reports whether err was raised because another reservation has the same confirmation code
+func isSQLiteConfirmationCodeTaken(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "reservations.confirmation_code")
+}
+
+func (m *sqliteDBRepo) AllUser(ctx context.Context) bool {
+	return true
+}
+
+// InsertReservation inserts a reservation into the database
+func (m *sqliteDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `insert into reservations (first_name, last_name, email, phone, start_date,
+			end_date, room_id, created_at, updated_at, confirmation_code, source, total_price, promo_code, discount, guests,
+			cancellation_policy, cancellation_free_days, cancellation_penalty)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	var result sql.Result
+	err := withConfirmationCode(func(code string) error {
+		var err error
+		result, err = m.DB.ExecContext(ctx, stmt,
+			res.FirstName,
+			res.LastName,
+			res.Email,
+			res.Phone,
+			sqliteDate(res.StartDate),
+			sqliteDate(res.EndDate),
+			res.RoomID,
+			time.Now(),
+			time.Now(),
+			code,
+			string(reservationSource(res)),
+			res.TotalPrice,
+			res.PromoCode,
+			res.Discount,
+			reservationGuests(res),
+			res.CancellationPolicy.Name,
+			res.CancellationPolicy.FreeDays,
+			res.CancellationPolicy.Penalty,
+		)
+		return err
+	}, isSQLiteConfirmationCodeTaken)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	err = saveReservationCharges(ctx, m.DB, sqliteDialect, int(newID), res.Charges)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
+
+// InsertRoomRestriction inserts a room restriction into the database
+func (m *sqliteDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `insert into room_restrictions
+			(start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
+			values (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		sqliteDate(r.StartDate),
+		sqliteDate(r.EndDate),
+		r.RoomID,
+		r.ReservationID,
+		time.Now(),
+		time.Now(),
+		r.RestrictionID,
+	)
+	if isSQLiteOverlapError(err) {
+		return repository.ErrRoomNotAvailable
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// InsertReservationWithRestriction inserts a reservation and its room restriction in one transaction,
+// so either both rows are written or neither is
+func (m *sqliteDBRepo) InsertReservationWithRestriction(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	// rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	var numRows int
+	query := `select count(id)
+			  from room_restrictions
+			  where
+			  room_id = ? and
+			  	? < end_date and ? > start_date;`
+
+	err = tx.QueryRowContext(ctx, query, res.RoomID, sqliteDate(res.StartDate), sqliteDate(res.EndDate)).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	// the promo code is redeemed in the same transaction, so it is never used more often than it allows
+	if res.PromoCode != "" {
+		if err = redeemPromoCode(ctx, tx, sqliteDialect, res.PromoCode); err != nil {
+			return 0, err
+		}
+	}
+
+	stmt := `insert into reservations (first_name, last_name, email, phone, start_date,
+			end_date, room_id, created_at, updated_at, confirmation_code, source, total_price, promo_code, discount, guests,
+			cancellation_policy, cancellation_free_days, cancellation_penalty)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	var result sql.Result
+	err = withConfirmationCode(func(code string) error {
+		var err error
+		result, err = tx.ExecContext(ctx, stmt,
+			res.FirstName,
+			res.LastName,
+			res.Email,
+			res.Phone,
+			sqliteDate(res.StartDate),
+			sqliteDate(res.EndDate),
+			res.RoomID,
+			time.Now(),
+			time.Now(),
+			code,
+			string(reservationSource(res)),
+			res.TotalPrice,
+			res.PromoCode,
+			res.Discount,
+			reservationGuests(res),
+			res.CancellationPolicy.Name,
+			res.CancellationPolicy.FreeDays,
+			res.CancellationPolicy.Penalty,
+		)
+		return err
+	}, isSQLiteConfirmationCodeTaken)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	err = saveReservationCharges(ctx, tx, sqliteDialect, int(newID), res.Charges)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `insert into room_restrictions
+			(start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
+			values (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		sqliteDate(res.StartDate),
+		sqliteDate(res.EndDate),
+		res.RoomID,
+		newID,
+		time.Now(),
+		time.Now(),
+		1,
+	)
+	if isSQLiteOverlapError(err) {
+		return 0, repository.ErrRoomNotAvailable
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
+
+// SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID, and false if no availability exists for roomID
+func (m *sqliteDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select count(id)
+			  from room_restrictions
+			  where
+			  room_id = ? and
+			  	? < end_date and ? > start_date;`
+
+	var numRows int
+	row := m.DB.QueryRowContext(ctx, query, roomID, sqliteDate(start), sqliteDate(end))
+	err := row.Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+
+	if numRows == 0 {
+		return true, nil
+	}
+	return false, nil
+}
+
+// SearchAvailabilityForAllRooms returns a slice of available room, if any, for give date range
+func (m *sqliteDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select ` + roomColumns + `
+			  from rooms
+			  where active and id not in
+			  		(select room_id from room_restrictions rr
+					where ? < rr.end_date and ? > rr.start_date)
+			  order by sort_order, id`
+
+	return listRooms(ctx, m.DB, sqliteDialect, query, sqliteDate(start), sqliteDate(end))
+}
+
+// GetRoomByID gets a room by ID
+func (m *sqliteDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms where id = ?`
+
+	return getRoom(ctx, m.DB, sqliteDialect, query, id)
+}
+
+// GetRoomBySlug gets the room whose public page is /rooms/{slug}
+func (m *sqliteDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms where slug = ?`
+
+	return getRoom(ctx, m.DB, sqliteDialect, query, slug)
+}
+
+// GetUserByID returns a user by ID
+func (m *sqliteDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at
+			  from users where id = ?;`
+
+	var user models.User
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Password,
+		&user.AccessLevel,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// UpdateUser updates user in database
+func (m *sqliteDBRepo) UpdateUser(ctx context.Context, user models.User) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `update users set first_name = ?, last_name = ?, email = ?, access_level = ?, updated_at = ? where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, query, user.FirstName, user.LastName, user.Email, user.AccessLevel, time.Now(), user.ID)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Authenticate authenticates a user
+func (m *sqliteDBRepo) Authenticate(ctx context.Context, email, password string) (int, string, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+
+	row := m.DB.QueryRowContext(ctx, `select id, password from users where email = ?`, email)
+	err := row.Scan(&id, &hashedPassword)
+	if err != nil {
+		return id, "", err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("incorrect password")
+	} else if err != nil {
+		return 0, "", err
+	}
+	return id, hashedPassword, nil
+}
+
+// ListReservations returns one page of the reservations matching q, with the total number of matches
+func (m *sqliteDBRepo) ListReservations(ctx context.Context, q models.ReservationQuery) (models.ReservationPage, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	q = q.Normalize()
+	page := models.ReservationPage{Query: q}
+
+	where, orderBy, args := reservationListing(sqliteDialect, q)
+
+	query := `select count(*) from reservations r left join rooms rm on (r.room_id = rm.id) ` + where
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&page.Total)
+	if err != nil {
+		return page, err
+	}
+
+	query = fmt.Sprintf(`
+	select r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone,
+	r.start_date, r.end_date, r.status, r.room_id, r.created_at, r.updated_at,
+	rm.id, rm.room_name
+	from reservations r
+	left join rooms rm on (r.room_id = rm.id)
+	%s
+	%s
+	limit %d offset %d
+	`, where, orderBy, q.PageSize, q.Offset())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return page, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err = rows.Scan(
+			&i.ID,
+			&i.ConfirmationCode,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.Status,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+
+		if err != nil {
+			return page, err
+		}
+		page.Reservations = append(page.Reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return page, err
+	}
+
+	return page, nil
+}
+
+// SearchReservations finds reservations by reservation ID, confirmation code or part of the guest's name, email or phone, best matches first
+func (m *sqliteDBRepo) SearchReservations(ctx context.Context, term string, limit int) ([]models.Reservation, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	term = strings.TrimSpace(term)
+	if term == "" {
+		return reservations, nil
+	}
+	id, _ := strconv.Atoi(term)
+	if limit < 1 {
+		limit = defaultSearchLimit
+	}
+
+	// ?1 term, ?2 reservation ID, ?3 contains pattern, ?4 prefix pattern, ?5 limit, ?6 confirmation code
+	query := `
+	select r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone,
+	r.start_date, r.end_date, r.status, r.room_id, r.created_at, r.updated_at,
+	rm.id, rm.room_name
+	from reservations r
+	left join rooms rm on (r.room_id = rm.id)
+	where r.id = ?2 or r.confirmation_code = ?6
+	or (r.first_name || ' ' || r.last_name) like ?3 escape '\'
+	or r.email like ?3 escape '\'
+	or r.phone like ?3 escape '\'
+	order by
+		case
+			when r.id = ?2 or r.confirmation_code = ?6 then 0
+			when lower(r.email) = lower(?1) then 1
+			when lower(r.first_name || ' ' || r.last_name) = lower(?1)
+				or lower(r.first_name) = lower(?1) or lower(r.last_name) = lower(?1) then 2
+			when r.first_name like ?4 escape '\' or r.last_name like ?4 escape '\'
+				or r.email like ?4 escape '\' or r.phone like ?4 escape '\' then 3
+			else 4
+		end,
+		r.start_date desc, r.id desc
+	limit ?5
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, term, id, containsPattern(term), prefixPattern(term), limit, models.NormalizeConfirmationCode(term))
+	if err != nil {
+		return reservations, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err = rows.Scan(
+			&i.ID,
+			&i.ConfirmationCode,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.Status,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// GetReservationByID returns a reservation by ID
+func (m *sqliteDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	return m.getReservation(ctx, "r.id", id)
+}
+
+// GetReservationByCode returns a reservation by its confirmation code, however the guest typed it
+func (m *sqliteDBRepo) GetReservationByCode(ctx context.Context, code string) (models.Reservation, error) {
+	return m.getReservation(ctx, "r.confirmation_code", models.NormalizeConfirmationCode(code))
+}
+
+// getReservation returns the reservation whose column equals value
+func (m *sqliteDBRepo) getReservation(ctx context.Context, column string, value interface{}) (models.Reservation, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var res models.Reservation
+
+	query := `
+	select r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
+	r.created_at, r.updated_at, r.status, r.cancelled_at, coalesce(r.cancelled_by, 0), r.cancellation_reason,
+	r.requested_start_date, r.requested_end_date, r.source, r.total_price, r.promo_code, r.discount, r.guests,
+	r.cancellation_policy, r.cancellation_free_days, r.cancellation_penalty, r.cancellation_fee,
+	rm.id, rm.room_name
+	from reservations r
+	left join rooms rm on (r.room_id = rm.id)
+	where ` + column + ` = ?
+	`
+
+	var cancelledAt, requestedStart, requestedEnd sql.NullTime
+
+	row := m.DB.QueryRowContext(ctx, query, value)
+	err := row.Scan(
+		&res.ID,
+		&res.ConfirmationCode,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Status,
+		&cancelledAt,
+		&res.CancelledBy,
+		&res.CancellationReason,
+		&requestedStart,
+		&requestedEnd,
+		&res.Source,
+		&res.TotalPrice,
+		&res.PromoCode,
+		&res.Discount,
+		&res.Guests,
+		&res.CancellationPolicy.Name,
+		&res.CancellationPolicy.FreeDays,
+		&res.CancellationPolicy.Penalty,
+		&res.CancellationFee,
+		&res.Room.ID,
+		&res.Room.RoomName,
+	)
+
+	if err != nil {
+		return res, err
+	}
+
+	res.CancelledAt = cancelledAt.Time
+	res.RequestedStartDate = requestedStart.Time
+	res.RequestedEndDate = requestedEnd.Time
+
+	res.Charges, err = loadReservationCharges(ctx, m.DB, sqliteDialect, res.ID)
+	return res, err
+}
+
+// UpdateReservation updates reservation in database
+func (m *sqliteDBRepo) UpdateReservation(ctx context.Context, res models.Reservation) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `update reservations set
+	first_name = ?, last_name = ?, email = ?, phone = ?, updated_at = ?
+	where id = ?
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, res.FirstName, res.LastName, res.Email, res.Phone, time.Now(), res.ID)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RequestDateChange records the dates a guest would like to move their reservation to
+func (m *sqliteDBRepo) RequestDateChange(ctx context.Context, id int, start, end time.Time) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `update reservations set requested_start_date = ?, requested_end_date = ?, updated_at = ?
+	where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, query, sqliteDate(start), sqliteDate(end), time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ChangeReservationStay moves a reservation to new dates and room, together with its room restriction,
+// and stores total and charges as its new price.
+// Availability is checked again without the reservation's own restriction, so a stay can be shortened,
+// extended or moved onto dates it partly covers already. Any dates the guest asked for are cleared.
+// It returns repository.ErrRoomNotAvailable if the room is taken and repository.ErrReservationClosed
+// if the reservation is in a final status.
+func (m *sqliteDBRepo) ChangeReservationStay(ctx context.Context, id, roomID int, start, end time.Time, total models.Money, charges []models.ReservationCharge) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status models.ReservationStatus
+	err = tx.QueryRowContext(ctx, `select status from reservations where id = ?`, id).Scan(&status)
+	if err != nil {
+		return err
+	}
+	if status.Final() {
+		return repository.ErrReservationClosed
+	}
+
+	var numRows int
+	query := `select count(id)
+			  from room_restrictions
+			  where
+			  room_id = ? and
+			  	? < end_date and ? > start_date and
+			  	(reservation_id is null or reservation_id <> ?);`
+
+	err = tx.QueryRowContext(ctx, query, roomID, sqliteDate(start), sqliteDate(end), id).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomNotAvailable
+	}
+
+	stmt := `update reservations set start_date = ?, end_date = ?, room_id = ?, total_price = ?,
+			requested_start_date = null, requested_end_date = null, updated_at = ?
+			where id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt, sqliteDate(start), sqliteDate(end), roomID, total, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update room_restrictions set start_date = ?, end_date = ?, room_id = ?, updated_at = ?
+			where reservation_id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt, sqliteDate(start), sqliteDate(end), roomID, time.Now(), id)
+	if isSQLiteOverlapError(err) {
+		return repository.ErrRoomNotAvailable
+	}
+	if err != nil {
+		return err
+	}
+
+	err = saveReservationCharges(ctx, tx, sqliteDialect, id, charges)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteReservation deletes a reservation by ID
+func (m *sqliteDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return deleteReservation(ctx, m.DB, sqliteDialect, id)
+}
+
+// changeStatus moves a reservation to status inside tx and records the change in its history.
+// It returns repository.ErrInvalidStatusTransition if the current status cannot move to status.
+func (m *sqliteDBRepo) changeStatus(ctx context.Context, tx *sql.Tx, id int, status models.ReservationStatus, userID int) error {
+	var current models.ReservationStatus
+	err := tx.QueryRowContext(ctx, `select status from reservations where id = ?`, id).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	if !current.CanTransitionTo(status) {
+		return repository.ErrInvalidStatusTransition
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set status = ?, updated_at = ? where id = ?`, string(status), time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into reservation_status_history (reservation_id, from_status, to_status, user_id, created_at)
+			values (?, ?, ?, ?, ?)`
+
+	_, err = tx.ExecContext(ctx, stmt, id, string(current), string(status), nullableID(userID), time.Now())
+	return err
+}
+
+// UpdateReservationStatus moves a reservation to status and records the change in its history.
+// It returns repository.ErrInvalidStatusTransition if the current status cannot move to status.
+// Reservations are cancelled with CancelReservation only, which frees the room and records why and for what fee,
+// so status cancelled returns repository.ErrInvalidStatusTransition here.
+func (m *sqliteDBRepo) UpdateReservationStatus(ctx context.Context, id int, status models.ReservationStatus, userID int) error {
+	if status == models.StatusCancelled {
+		return repository.ErrInvalidStatusTransition
+	}
+
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = m.changeStatus(ctx, tx, id, status, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelReservation cancels a reservation, records who cancelled it, why and the fee charged for it,
+// and deletes its room restriction so the dates can be booked again. A reservation that was never confirmed
+// or paid for gives back the use of its promo code. userID is the admin who cancelled, 0 when the guest did.
+func (m *sqliteDBRepo) CancelReservation(ctx context.Context, id int, userID int, reason string, fee models.Money) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = returnPromoCode(ctx, tx, sqliteDialect, id)
+	if err != nil {
+		return err
+	}
+
+	err = m.changeStatus(ctx, tx, id, models.StatusCancelled, userID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update reservations set cancelled_at = ?, cancelled_by = ?, cancellation_reason = ?, cancellation_fee = ?
+			where id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), nullableID(userID), reason, fee, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetReservationStatusHistory returns the status changes of a reservation, oldest first
+func (m *sqliteDBRepo) GetReservationStatusHistory(ctx context.Context, id int) ([]models.ReservationStatusChange, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var changes []models.ReservationStatusChange
+
+	query := `select id, reservation_id, from_status, to_status, coalesce(user_id, 0), created_at
+	from reservation_status_history where reservation_id = ?
+	order by created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return changes, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.ReservationStatusChange
+		err = rows.Scan(
+			&c.ID,
+			&c.ReservationID,
+			&c.FromStatus,
+			&c.ToStatus,
+			&c.UserID,
+			&c.CreatedAt,
+		)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return changes, err
+	}
+
+	return changes, nil
+}
+
+// AllRooms returns all rooms, active or not, in the order they are listed in
+func (m *sqliteDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listRooms(ctx, m.DB, sqliteDialect, `select `+roomColumns+` from rooms order by sort_order, id`)
+}
+
+// ActiveRooms returns the rooms guests can see and book, in the order they are listed in
+func (m *sqliteDBRepo) ActiveRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listRooms(ctx, m.DB, sqliteDialect, `select `+roomColumns+` from rooms where active order by sort_order, id`)
+}
+
+// slugTaken reports whether a room other than id already uses slug
+func (m *sqliteDBRepo) slugTaken(ctx context.Context, tx *sql.Tx, slug string, id int) (bool, error) {
+	var numRows int
+	err := tx.QueryRowContext(ctx, `select count(id) from rooms where slug = ? and id <> ?`, slug, id).Scan(&numRows)
+	return numRows > 0, err
+}
+
+// InsertRoom adds a room with its amenities at the end of the list and returns its ID.
+// It returns repository.ErrDuplicateSlug if another room uses the same slug.
+func (m *sqliteDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	taken, err := m.slugTaken(ctx, tx, room.Slug, 0)
+	if err != nil {
+		return 0, err
+	}
+	if taken {
+		return 0, repository.ErrDuplicateSlug
+	}
+
+	var sortOrder int
+	err = tx.QueryRowContext(ctx, `select coalesce(max(sort_order), 0) + 1 from rooms`).Scan(&sortOrder)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into rooms (room_name, slug, active, sort_order, max_occupancy, bed_configuration, description,
+			nightly_rate, cancellation_policy_id, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := tx.ExecContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Active,
+		sortOrder,
+		room.MaxOccupancy,
+		room.BedConfiguration,
+		room.Description,
+		room.NightlyRate,
+		nullableID(room.CancellationPolicyID),
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	err = saveAmenities(ctx, tx, sqliteDialect, int(newID), room.Amenities)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
+
+// UpdateRoom updates the name, slug, details and amenities of a room. Its photos are changed separately.
+// It returns repository.ErrDuplicateSlug if another room uses the same slug.
+func (m *sqliteDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	taken, err := m.slugTaken(ctx, tx, room.Slug, room.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return repository.ErrDuplicateSlug
+	}
+
+	stmt := `update rooms set room_name = ?, slug = ?, max_occupancy = ?, bed_configuration = ?,
+			description = ?, nightly_rate = ?, cancellation_policy_id = ?, updated_at = ?
+			where id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.MaxOccupancy,
+		room.BedConfiguration,
+		room.Description,
+		room.NightlyRate,
+		nullableID(room.CancellationPolicyID),
+		time.Now(),
+		room.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = saveAmenities(ctx, tx, sqliteDialect, room.ID, room.Amenities)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetRoomActive shows a room to guests again, or hides it so it can no longer be booked.
+// Existing reservations for the room are kept.
+func (m *sqliteDBRepo) SetRoomActive(ctx context.Context, id int, active bool) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update rooms set active = ?, updated_at = ? where id = ?`, active, time.Now(), id)
+	return err
+}
+
+// ReorderRooms lists the rooms in the order of ids. Rooms missing from ids keep their position number.
+func (m *sqliteDBRepo) ReorderRooms(ctx context.Context, ids []int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, id := range ids {
+		_, err = tx.ExecContext(ctx, `update rooms set sort_order = ?, updated_at = ? where id = ?`, i+1, time.Now(), id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AddRoomPhoto adds a photo at the end of a room's gallery and returns its ID
+func (m *sqliteDBRepo) AddRoomPhoto(ctx context.Context, photo models.RoomPhoto) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var sortOrder int
+	err = tx.QueryRowContext(ctx, `select coalesce(max(sort_order), 0) + 1 from room_photos where room_id = ?`, photo.RoomID).Scan(&sortOrder)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into room_photos (room_id, path, caption, sort_order, original_path, thumbnail_path,
+			created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := tx.ExecContext(ctx, stmt,
+		photo.RoomID,
+		photo.Path,
+		photo.Caption,
+		sortOrder,
+		photo.OriginalPath,
+		photo.ThumbnailPath,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
+
+// DeleteRoomPhoto removes a photo from a room's gallery. It returns sql.ErrNoRows if the room has no such photo.
+func (m *sqliteDBRepo) DeleteRoomPhoto(ctx context.Context, roomID, photoID int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from room_photos where id = ? and room_id = ?`, photoID, roomID)
+	if err != nil {
+		return err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ReorderRoomPhotos puts a room's gallery in the order of ids. Photos missing from ids keep their position number.
+func (m *sqliteDBRepo) ReorderRoomPhotos(ctx context.Context, roomID int, ids []int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update room_photos set sort_order = ?, updated_at = ? where id = ? and room_id = ?`
+	for i, id := range ids {
+		_, err = tx.ExecContext(ctx, stmt, i+1, time.Now(), id, roomID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AllRateRules returns every rate rule, the ones that win over others first
+func (m *sqliteDBRepo) AllRateRules(ctx context.Context) ([]models.RateRule, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listRateRules(ctx, m.DB, `select `+rateRuleColumns+`
+		from rate_rules r left join rooms rm on (r.room_id = rm.id)
+		order by r.priority desc, r.name, r.id`)
+}
+
+// RateRulesForStay returns the rate rules, for any room, that may cover a night from start to end
+func (m *sqliteDBRepo) RateRulesForStay(ctx context.Context, start, end time.Time) ([]models.RateRule, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listRateRules(ctx, m.DB, `select `+rateRuleColumns+`
+		from rate_rules r left join rooms rm on (r.room_id = rm.id)
+		where (r.start_date is null or r.start_date < ?) and (r.end_date is null or r.end_date >= ?)
+		order by r.id`, sqliteDate(end), sqliteDate(start))
+}
+
+// GetRateRuleByID gets a rate rule by ID
+func (m *sqliteDBRepo) GetRateRuleByID(ctx context.Context, id int) (models.RateRule, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return scanRateRule(m.DB.QueryRowContext(ctx, `select `+rateRuleColumns+`
+		from rate_rules r left join rooms rm on (r.room_id = rm.id)
+		where r.id = ?`, id))
+}
+
+// InsertRateRule adds a rate rule and returns its ID
+func (m *sqliteDBRepo) InsertRateRule(ctx context.Context, rule models.RateRule) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `insert into rate_rules (name, room_id, start_date, end_date, weekdays, nightly_rate, priority,
+			cancellation_policy_id, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		rule.Name,
+		nullableID(rule.RoomID),
+		nullableDate(sqliteDialect, rule.StartDate),
+		nullableDate(sqliteDialect, rule.EndDate),
+		rule.Weekdays,
+		rule.NightlyRate,
+		rule.Priority,
+		nullableID(rule.CancellationPolicyID),
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
+
+// UpdateRateRule saves every field of a rate rule
+func (m *sqliteDBRepo) UpdateRateRule(ctx context.Context, rule models.RateRule) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `update rate_rules set name = ?, room_id = ?, start_date = ?, end_date = ?, weekdays = ?,
+			nightly_rate = ?, priority = ?, cancellation_policy_id = ?, updated_at = ?
+			where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		rule.Name,
+		nullableID(rule.RoomID),
+		nullableDate(sqliteDialect, rule.StartDate),
+		nullableDate(sqliteDialect, rule.EndDate),
+		rule.Weekdays,
+		rule.NightlyRate,
+		rule.Priority,
+		nullableID(rule.CancellationPolicyID),
+		time.Now(),
+		rule.ID,
+	)
+	return err
+}
+
+// DeleteRateRule deletes a rate rule. It returns sql.ErrNoRows if there is no such rule.
+func (m *sqliteDBRepo) DeleteRateRule(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from rate_rules where id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// promoCodeTaken reports whether a promo code other than id already uses code
+func (m *sqliteDBRepo) promoCodeTaken(ctx context.Context, tx *sql.Tx, code string, id int) (bool, error) {
+	var numRows int
+	err := tx.QueryRowContext(ctx, `select count(id) from promo_codes where code = ? and id <> ?`, code, id).Scan(&numRows)
+	return numRows > 0, err
+}
+
+// AllPromoCodes returns every promo code in alphabetical order
+func (m *sqliteDBRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listPromoCodes(ctx, m.DB, sqliteDialect, `select `+promoCodeColumns+` from promo_codes order by code`)
+}
+
+// GetPromoCodeByID gets a promo code by ID
+func (m *sqliteDBRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return getPromoCode(ctx, m.DB, sqliteDialect, `select `+promoCodeColumns+` from promo_codes where id = ?`, id)
+}
+
+// GetPromoCodeByCode gets a promo code by the code guests enter, in any case
+func (m *sqliteDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return getPromoCode(ctx, m.DB, sqliteDialect, `select `+promoCodeColumns+` from promo_codes where code = ?`,
+		models.NormalizePromoCode(code))
+}
+
+// InsertPromoCode adds a promo code with the rooms it is limited to and returns its ID.
+// It returns repository.ErrDuplicatePromoCode if another promo code uses the same code.
+func (m *sqliteDBRepo) InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	code := models.NormalizePromoCode(p.Code)
+	taken, err := m.promoCodeTaken(ctx, tx, code, 0)
+	if err != nil {
+		return 0, err
+	}
+	if taken {
+		return 0, repository.ErrDuplicatePromoCode
+	}
+
+	stmt := `insert into promo_codes (code, description, kind, percent_off, amount_off, valid_from, valid_until,
+			max_uses, min_nights, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := tx.ExecContext(ctx, stmt,
+		code,
+		p.Description,
+		string(p.Kind),
+		p.PercentOff,
+		p.AmountOff,
+		nullableDate(sqliteDialect, p.ValidFrom),
+		nullableDate(sqliteDialect, p.ValidUntil),
+		p.MaxUses,
+		p.MinNights,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	err = savePromoCodeRooms(ctx, tx, sqliteDialect, int(newID), p.RoomIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
+
+// UpdatePromoCode saves every field of a promo code but its uses, and the rooms it is limited to.
+// It returns repository.ErrDuplicatePromoCode if another promo code uses the same code.
+func (m *sqliteDBRepo) UpdatePromoCode(ctx context.Context, p models.PromoCode) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	code := models.NormalizePromoCode(p.Code)
+	taken, err := m.promoCodeTaken(ctx, tx, code, p.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return repository.ErrDuplicatePromoCode
+	}
+
+	stmt := `update promo_codes set code = ?, description = ?, kind = ?, percent_off = ?, amount_off = ?,
+			valid_from = ?, valid_until = ?, max_uses = ?, min_nights = ?, updated_at = ?
+			where id = ?`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		code,
+		p.Description,
+		string(p.Kind),
+		p.PercentOff,
+		p.AmountOff,
+		nullableDate(sqliteDialect, p.ValidFrom),
+		nullableDate(sqliteDialect, p.ValidUntil),
+		p.MaxUses,
+		p.MinNights,
+		time.Now(),
+		p.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = savePromoCodeRooms(ctx, tx, sqliteDialect, p.ID, p.RoomIDs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeletePromoCode deletes a promo code. Reservations booked with it keep its code and discount.
+// It returns sql.ErrNoRows if there is no such promo code.
+func (m *sqliteDBRepo) DeletePromoCode(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from promo_codes where id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// AllCharges returns every tax and fee, active or not, in the order they are added to stays
+func (m *sqliteDBRepo) AllCharges(ctx context.Context) ([]models.Charge, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listCharges(ctx, m.DB, `select `+chargeColumns+` from charges order by id`)
+}
+
+// ActiveCharges returns the taxes and fees added to new reservations, in the order they are added
+func (m *sqliteDBRepo) ActiveCharges(ctx context.Context) ([]models.Charge, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listCharges(ctx, m.DB, `select `+chargeColumns+` from charges where active order by id`)
+}
+
+// GetChargeByID gets a tax or fee by ID
+func (m *sqliteDBRepo) GetChargeByID(ctx context.Context, id int) (models.Charge, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return scanCharge(m.DB.QueryRowContext(ctx, `select `+chargeColumns+` from charges where id = ?`, id))
+}
+
+// InsertCharge adds a tax or fee and returns its ID
+func (m *sqliteDBRepo) InsertCharge(ctx context.Context, c models.Charge) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `insert into charges (name, method, rate, amount, per, active, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		c.Name,
+		string(c.Method),
+		c.Rate,
+		c.Amount,
+		string(c.Per),
+		c.Active,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
+
+// UpdateCharge saves every field of a tax or fee. Reservations already made keep their charges.
+func (m *sqliteDBRepo) UpdateCharge(ctx context.Context, c models.Charge) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `update charges set name = ?, method = ?, rate = ?, amount = ?, per = ?, active = ?, updated_at = ?
+			where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		c.Name,
+		string(c.Method),
+		c.Rate,
+		c.Amount,
+		string(c.Per),
+		c.Active,
+		time.Now(),
+		c.ID,
+	)
+	return err
+}
+
+// DeleteCharge deletes a tax or fee. Reservations already made keep their charges.
+// It returns sql.ErrNoRows if there is no such charge.
+func (m *sqliteDBRepo) DeleteCharge(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from charges where id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// AllCancellationPolicies returns every cancellation policy, by name
+func (m *sqliteDBRepo) AllCancellationPolicies(ctx context.Context) ([]models.CancellationPolicy, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listCancellationPolicies(ctx, m.DB, `select `+cancellationPolicyColumns+` from cancellation_policies order by name, id`)
+}
+
+// GetCancellationPolicyByID gets a cancellation policy by ID
+func (m *sqliteDBRepo) GetCancellationPolicyByID(ctx context.Context, id int) (models.CancellationPolicy, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return scanCancellationPolicy(m.DB.QueryRowContext(ctx, `select `+cancellationPolicyColumns+` from cancellation_policies where id = ?`, id))
+}
+
+// InsertCancellationPolicy adds a cancellation policy and returns its ID
+func (m *sqliteDBRepo) InsertCancellationPolicy(ctx context.Context, p models.CancellationPolicy) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `insert into cancellation_policies (name, free_days, penalty, created_at, updated_at)
+			values (?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		p.Name,
+		p.FreeDays,
+		p.Penalty,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
+
+// UpdateCancellationPolicy saves every field of a cancellation policy. Reservations already made keep their terms.
+func (m *sqliteDBRepo) UpdateCancellationPolicy(ctx context.Context, p models.CancellationPolicy) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `update cancellation_policies set name = ?, free_days = ?, penalty = ?, updated_at = ?
+			where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		p.Name,
+		p.FreeDays,
+		p.Penalty,
+		time.Now(),
+		p.ID,
+	)
+	return err
+}
+
+// DeleteCancellationPolicy deletes a cancellation policy. Rooms and rate rules using it go back to free
+// cancellation and to their room's policy, and reservations already made keep their terms.
+// It returns sql.ErrNoRows if there is no such policy.
+func (m *sqliteDBRepo) DeleteCancellationPolicy(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update rooms set cancellation_policy_id = null where cancellation_policy_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update rate_rules set cancellation_policy_id = null where cancellation_policy_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `delete from cancellation_policies where id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+// InsertPayment records a payment taken for a reservation and returns its ID
+func (m *sqliteDBRepo) InsertPayment(ctx context.Context, p models.Payment) (int, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `insert into payments (reservation_id, provider, reference, status, amount, refunded, card_last4,
+			failure_reason, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		p.ReservationID,
+		p.Provider,
+		p.Reference,
+		string(p.Status),
+		p.Amount,
+		p.Refunded,
+		p.CardLast4,
+		p.FailureReason,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
+
+// UpdatePayment saves the status, amounts and failure reason of a payment
+func (m *sqliteDBRepo) UpdatePayment(ctx context.Context, p models.Payment) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	stmt := `update payments set status = ?, amount = ?, refunded = ?, failure_reason = ?, updated_at = ?
+			where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		string(p.Status),
+		p.Amount,
+		p.Refunded,
+		p.FailureReason,
+		time.Now(),
+		p.ID,
+	)
+	return err
+}
+
+// GetPaymentByReference gets a payment by the reference its provider gave it
+func (m *sqliteDBRepo) GetPaymentByReference(ctx context.Context, provider, reference string) (models.Payment, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return scanPayment(m.DB.QueryRowContext(ctx, `select `+paymentColumns+` from payments
+		where provider = ? and reference = ?`, provider, reference))
+}
+
+// PaymentsForReservation returns the payments taken for a reservation, oldest first
+func (m *sqliteDBRepo) PaymentsForReservation(ctx context.Context, reservationID int) ([]models.Payment, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listPayments(ctx, m.DB, `select `+paymentColumns+` from payments
+		where reservation_id = ? order by id`, reservationID)
+}
+
+// PendingPayments returns the payments still waiting for the guest to authenticate them, oldest first
+func (m *sqliteDBRepo) PendingPayments(ctx context.Context) ([]models.Payment, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listPayments(ctx, m.DB, `select `+paymentColumns+` from payments
+		where status = ? order by id`, string(models.PaymentPending))
+}
+
+// InsertInvoice issues an invoice for a reservation under the next invoice number and returns it with
+// its ID and number. It returns repository.ErrInvoiceExists if the reservation already has an invoice.
+func (m *sqliteDBRepo) InsertInvoice(ctx context.Context, inv models.Invoice) (models.Invoice, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return inv, err
+	}
+	defer tx.Rollback()
+
+	inv.Number, err = invoiceNumber(ctx, tx, sqliteDialect, inv)
+	if err != nil {
+		return inv, err
+	}
+	inv.CreatedAt = time.Now()
+	inv.UpdatedAt = time.Now()
+
+	stmt := `insert into invoices (number, reservation_id, confirmation_code, billed_to, email, total, issued_at,
+			created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := tx.ExecContext(ctx, stmt,
+		inv.Number,
+		nullableID(inv.ReservationID),
+		inv.ConfirmationCode,
+		inv.BilledTo,
+		inv.Email,
+		inv.Total,
+		inv.IssuedAt,
+		inv.CreatedAt,
+		inv.UpdatedAt,
+	)
+	if err != nil {
+		return inv, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return inv, err
+	}
+	inv.ID = int(newID)
+
+	err = saveInvoiceLines(ctx, tx, sqliteDialect, inv.ID, inv.Lines)
+	if err != nil {
+		return inv, err
+	}
+
+	return inv, tx.Commit()
+}
+
+// GetInvoiceByID gets an invoice with its lines
+func (m *sqliteDBRepo) GetInvoiceByID(ctx context.Context, id int) (models.Invoice, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return getInvoice(ctx, m.DB, sqliteDialect, `select `+invoiceColumns+` from invoices where id = ?`, id)
+}
+
+// GetInvoiceForReservation gets the invoice issued for a reservation with its lines
+func (m *sqliteDBRepo) GetInvoiceForReservation(ctx context.Context, reservationID int) (models.Invoice, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return getInvoice(ctx, m.DB, sqliteDialect, `select `+invoiceColumns+` from invoices where reservation_id = ?`, reservationID)
+}
+
+// AllInvoices returns every invoice without its lines, the last issued first
+func (m *sqliteDBRepo) AllInvoices(ctx context.Context) ([]models.Invoice, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	return listInvoices(ctx, m.DB, `select `+invoiceColumns+` from invoices order by number desc`)
+}
+
+// GetRestrictionsForRoomByDate returns all restrictions for a room by date range
+func (m *sqliteDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
+	from room_restrictions where ? < end_date and ? >= start_date
+	and room_id = ?`
+
+	rows, err := m.DB.QueryContext(ctx, query, sqliteDate(start), sqliteDate(end), roomID)
+
+	if err != nil {
+		return restrictions, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var restriction models.RoomRestriction
+		err := rows.Scan(
+			&restriction.ID,
+			&restriction.ReservationID,
+			&restriction.RestrictionID,
+			&restriction.RoomID,
+			&restriction.StartDate,
+			&restriction.EndDate,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, restriction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// InsertBlockForRoom inserts a room restriction
+func (m *sqliteDBRepo) InsertBlockForRoom(ctx context.Context, id int, date time.Time) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `insert into room_restrictions
+	(start_date, end_date, room_id, restriction_id, created_at, updated_at)
+	values (?, ?, ?, ?, ?, ?)`
+
+	_, err := m.DB.ExecContext(ctx, query, sqliteDate(date), sqliteDate(date.AddDate(0, 0, 1)), id, 2, time.Now(), time.Now())
+	if isSQLiteOverlapError(err) {
+		return repository.ErrRoomNotAvailable
+	}
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteBlockByID deletes a room restriction by ID
+func (m *sqliteDBRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx, m.App)
+	defer cancel()
+
+	query := `delete from room_restrictions where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
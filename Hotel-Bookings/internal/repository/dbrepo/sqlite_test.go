@@ -0,0 +1,29 @@
+//go:build sqlite
+
+package dbrepo
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/config"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/driver"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/repository"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/repository/repositorytest"
+)
+
+func TestSQLiteRepo(t *testing.T) {
+	repositorytest.RunConformanceTests(t, func(t *testing.T) repository.DatabaseRepo {
+		db, err := driver.ConnectSQLite(filepath.Join(t.TempDir(), "bookings.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.SQL.Close() })
+
+		repo, err := NewSQLiteRepo(db.SQL, &config.AppConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	})
+}
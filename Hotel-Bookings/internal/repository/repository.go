@@ -1,30 +1,105 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
 )
 
+// ErrRoomNotAvailable is returned when a booking overlaps an existing restriction for the same room
+var ErrRoomNotAvailable = errors.New("room is not available for the selected dates")
+
+// ErrInvalidStatusTransition is returned when a reservation cannot move from its current status to the requested one
+var ErrInvalidStatusTransition = errors.New("reservation cannot move to that status")
+
+// ErrDuplicateSlug is returned when a room is given a slug another room already uses
+var ErrDuplicateSlug = errors.New("another room already uses that slug")
+
+// ErrReservationClosed is returned when the stay of a checked out, cancelled or no-show reservation is changed
+var ErrReservationClosed = errors.New("reservation can no longer be changed")
+
+// ErrDuplicatePromoCode is returned when a promo code is given a code another promo code already uses
+var ErrDuplicatePromoCode = errors.New("another promo code already uses that code")
+
+// ErrPromoCodeUnavailable is returned when a reservation is booked with a promo code that no longer exists
+// or has been used as often as it allows
+var ErrPromoCodeUnavailable = errors.New("promo code can no longer be used")
+
+// ErrReservationHasPayments is returned when a reservation with payments is deleted, since deleting it
+// would lose the record of the money taken
+var ErrReservationHasPayments = errors.New("reservation has payments")
+
+// ErrInvoiceExists is returned when an invoice is issued for a reservation that already has one
+var ErrInvoiceExists = errors.New("reservation already has an invoice")
+
 type DatabaseRepo interface {
-	AllUser() bool
-
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomByID(id int) (models.Room, error)
-	GetUserByID(id int) (models.User, error)
-	UpdateUser(user models.User) error
-	Authenticate(email, password string) (int, string, error)
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
-	GetReservationByID(id int) (models.Reservation, error)
-	UpdateReservation(res models.Reservation) error
-	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
-	AllRooms() ([]models.Room, error)
-	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(id int, date time.Time) error
-	DeleteBlockByID(id int) error
+	AllUser(ctx context.Context) bool
+
+	InsertReservation(ctx context.Context, res models.Reservation) (int, error)
+	InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error
+	InsertReservationWithRestriction(ctx context.Context, res models.Reservation) (int, error)
+	SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error)
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	UpdateUser(ctx context.Context, user models.User) error
+	Authenticate(ctx context.Context, email, password string) (int, string, error)
+	ListReservations(ctx context.Context, q models.ReservationQuery) (models.ReservationPage, error)
+	SearchReservations(ctx context.Context, term string, limit int) ([]models.Reservation, error)
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	GetReservationByCode(ctx context.Context, code string) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, res models.Reservation) error
+	RequestDateChange(ctx context.Context, id int, start, end time.Time) error
+	ChangeReservationStay(ctx context.Context, id, roomID int, start, end time.Time, total models.Money, charges []models.ReservationCharge) error
+	DeleteReservation(ctx context.Context, id int) error
+	UpdateReservationStatus(ctx context.Context, id int, status models.ReservationStatus, userID int) error
+	CancelReservation(ctx context.Context, id int, userID int, reason string, fee models.Money) error
+	GetReservationStatusHistory(ctx context.Context, id int) ([]models.ReservationStatusChange, error)
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	ActiveRooms(ctx context.Context) ([]models.Room, error)
+	GetRoomBySlug(ctx context.Context, slug string) (models.Room, error)
+	InsertRoom(ctx context.Context, room models.Room) (int, error)
+	UpdateRoom(ctx context.Context, room models.Room) error
+	SetRoomActive(ctx context.Context, id int, active bool) error
+	ReorderRooms(ctx context.Context, ids []int) error
+	AddRoomPhoto(ctx context.Context, photo models.RoomPhoto) (int, error)
+	DeleteRoomPhoto(ctx context.Context, roomID, photoID int) error
+	ReorderRoomPhotos(ctx context.Context, roomID int, ids []int) error
+	AllRateRules(ctx context.Context) ([]models.RateRule, error)
+	RateRulesForStay(ctx context.Context, start, end time.Time) ([]models.RateRule, error)
+	GetRateRuleByID(ctx context.Context, id int) (models.RateRule, error)
+	InsertRateRule(ctx context.Context, rule models.RateRule) (int, error)
+	UpdateRateRule(ctx context.Context, rule models.RateRule) error
+	DeleteRateRule(ctx context.Context, id int) error
+	AllPromoCodes(ctx context.Context) ([]models.PromoCode, error)
+	GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error)
+	GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error)
+	InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error)
+	UpdatePromoCode(ctx context.Context, p models.PromoCode) error
+	DeletePromoCode(ctx context.Context, id int) error
+	AllCharges(ctx context.Context) ([]models.Charge, error)
+	ActiveCharges(ctx context.Context) ([]models.Charge, error)
+	GetChargeByID(ctx context.Context, id int) (models.Charge, error)
+	InsertCharge(ctx context.Context, c models.Charge) (int, error)
+	UpdateCharge(ctx context.Context, c models.Charge) error
+	DeleteCharge(ctx context.Context, id int) error
+	AllCancellationPolicies(ctx context.Context) ([]models.CancellationPolicy, error)
+	GetCancellationPolicyByID(ctx context.Context, id int) (models.CancellationPolicy, error)
+	InsertCancellationPolicy(ctx context.Context, p models.CancellationPolicy) (int, error)
+	UpdateCancellationPolicy(ctx context.Context, p models.CancellationPolicy) error
+	DeleteCancellationPolicy(ctx context.Context, id int) error
+	InsertPayment(ctx context.Context, p models.Payment) (int, error)
+	UpdatePayment(ctx context.Context, p models.Payment) error
+	GetPaymentByReference(ctx context.Context, provider, reference string) (models.Payment, error)
+	PaymentsForReservation(ctx context.Context, reservationID int) ([]models.Payment, error)
+	PendingPayments(ctx context.Context) ([]models.Payment, error)
+	InsertInvoice(ctx context.Context, inv models.Invoice) (models.Invoice, error)
+	GetInvoiceByID(ctx context.Context, id int) (models.Invoice, error)
+	GetInvoiceForReservation(ctx context.Context, reservationID int) (models.Invoice, error)
+	AllInvoices(ctx context.Context) ([]models.Invoice, error)
+	GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlockForRoom(ctx context.Context, id int, date time.Time) error
+	DeleteBlockByID(ctx context.Context, id int) error
 }
@@ -0,0 +1,1923 @@
+// Package repositorytest holds the tests every repository.DatabaseRepo implementation must pass
+package repositorytest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/models"
+	"github.com/doantaydo/Learning-GO_Web-Application/Hotel-Bookings/internal/repository"
+)
+
+// RunConformanceTests checks that a repository.DatabaseRepo implementation behaves like every other one.
+// newRepo must return a repository holding only the seed data from the migrations:
+// rooms 1 and 2, the reservation (1) and owner block (2) restrictions,
+// and the admin@admin.com user with password "password".
+func RunConformanceTests(t *testing.T, newRepo func(t *testing.T) repository.DatabaseRepo) {
+	t.Run("AvailabilityOverlap", func(t *testing.T) { testAvailabilityOverlap(t, newRepo(t)) })
+	t.Run("SearchAvailabilityForAllRooms", func(t *testing.T) { testSearchAvailabilityForAllRooms(t, newRepo(t)) })
+	t.Run("InsertReservationWithRestriction", func(t *testing.T) { testInsertReservationWithRestriction(t, newRepo(t)) })
+	t.Run("Blocks", func(t *testing.T) { testBlocks(t, newRepo(t)) })
+	t.Run("Status", func(t *testing.T) { testStatus(t, newRepo(t)) })
+	t.Run("CancelReservation", func(t *testing.T) { testCancelReservation(t, newRepo(t)) })
+	t.Run("ListReservations", func(t *testing.T) { testListReservations(t, newRepo(t)) })
+	t.Run("SearchReservations", func(t *testing.T) { testSearchReservations(t, newRepo(t)) })
+	t.Run("ConfirmationCode", func(t *testing.T) { testConfirmationCode(t, newRepo(t)) })
+	t.Run("Source", func(t *testing.T) { testSource(t, newRepo(t)) })
+	t.Run("Authenticate", func(t *testing.T) { testAuthenticate(t, newRepo(t)) })
+	t.Run("UpdateReservation", func(t *testing.T) { testUpdateReservation(t, newRepo(t)) })
+	t.Run("RequestDateChange", func(t *testing.T) { testRequestDateChange(t, newRepo(t)) })
+	t.Run("ChangeReservationStay", func(t *testing.T) { testChangeReservationStay(t, newRepo(t)) })
+	t.Run("UpdateUser", func(t *testing.T) { testUpdateUser(t, newRepo(t)) })
+	t.Run("Rooms", func(t *testing.T) { testRooms(t, newRepo(t)) })
+	t.Run("RoomDetails", func(t *testing.T) { testRoomDetails(t, newRepo(t)) })
+	t.Run("Prices", func(t *testing.T) { testPrices(t, newRepo(t)) })
+	t.Run("RateRules", func(t *testing.T) { testRateRules(t, newRepo(t)) })
+	t.Run("PromoCodes", func(t *testing.T) { testPromoCodes(t, newRepo(t)) })
+	t.Run("Charges", func(t *testing.T) { testCharges(t, newRepo(t)) })
+	t.Run("Payments", func(t *testing.T) { testPayments(t, newRepo(t)) })
+	t.Run("CancellationPolicies", func(t *testing.T) { testCancellationPolicies(t, newRepo(t)) })
+	t.Run("Invoices", func(t *testing.T) { testInvoices(t, newRepo(t)) })
+	t.Run("DeleteReservation", func(t *testing.T) { testDeleteReservation(t, newRepo(t)) })
+}
+
+// conformanceDate parses a yyyy-mm-dd date
+func conformanceDate(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+// sameDay reports whether a and b fall on the same calendar date, whatever their location
+func sameDay(a, b time.Time) bool {
+	return a.Format("2006-01-02") == b.Format("2006-01-02")
+}
+
+// book stores a reservation with its room restriction and fails the test if it cannot
+func book(t *testing.T, repo repository.DatabaseRepo, roomID int, start, end string) int {
+	t.Helper()
+
+	id, err := repo.InsertReservationWithRestriction(context.Background(), models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		Phone:     "555-555-5555",
+		StartDate: conformanceDate(start),
+		EndDate:   conformanceDate(end),
+		RoomID:    roomID,
+	})
+	if err != nil {
+		t.Fatalf("cannot book room %d from %s to %s: %v", roomID, start, end, err)
+	}
+	return id
+}
+
+func testAvailabilityOverlap(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	book(t, repo, 1, "2050-01-10", "2050-01-15")
+
+	var tests = []struct {
+		name      string
+		start     string
+		end       string
+		available bool
+	}{
+		{"check-in on checkout day", "2050-01-15", "2050-01-17", true},
+		{"checkout on check-in day", "2050-01-05", "2050-01-10", true},
+		{"well before", "2050-01-01", "2050-01-03", true},
+		{"well after", "2050-01-20", "2050-01-22", true},
+		{"same dates", "2050-01-10", "2050-01-15", false},
+		{"inside", "2050-01-11", "2050-01-12", false},
+		{"covering", "2050-01-09", "2050-01-16", false},
+		{"overlapping check-in", "2050-01-08", "2050-01-11", false},
+		{"overlapping checkout", "2050-01-14", "2050-01-16", false},
+		{"first night only", "2050-01-10", "2050-01-11", false},
+		{"last night only", "2050-01-14", "2050-01-15", false},
+	}
+
+	for _, e := range tests {
+		available, err := repo.SearchAvailabilityByDatesByRoomID(ctx, conformanceDate(e.start), conformanceDate(e.end), 1)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", e.name, err)
+			continue
+		}
+		if available != e.available {
+			t.Errorf("%s: room 1 from %s to %s: got available %v, wanted %v", e.name, e.start, e.end, available, e.available)
+		}
+	}
+
+	// a booking on room 1 does not affect room 2
+	available, err := repo.SearchAvailabilityByDatesByRoomID(ctx, conformanceDate("2050-01-10"), conformanceDate("2050-01-15"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !available {
+		t.Error("room 2 shows unavailable because of a booking on room 1")
+	}
+}
+
+func testSearchAvailabilityForAllRooms(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	rooms, err := repo.SearchAvailabilityForAllRooms(ctx, conformanceDate("2050-02-01"), conformanceDate("2050-02-05"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 2 {
+		t.Fatalf("got %d available rooms with no bookings, wanted 2", len(rooms))
+	}
+	for _, room := range rooms {
+		if room.ID == 0 || room.RoomName == "" {
+			t.Errorf("available room is missing its ID or name: %+v", room)
+		}
+	}
+
+	book(t, repo, 2, "2050-02-03", "2050-02-04")
+
+	rooms, err = repo.SearchAvailabilityForAllRooms(ctx, conformanceDate("2050-02-01"), conformanceDate("2050-02-05"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].ID != 1 {
+		t.Errorf("got %+v, wanted only room 1", rooms)
+	}
+
+	// checking in on the booking's checkout day is allowed
+	rooms, err = repo.SearchAvailabilityForAllRooms(ctx, conformanceDate("2050-02-04"), conformanceDate("2050-02-06"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 2 {
+		t.Errorf("got %d available rooms from the checkout day, wanted 2", len(rooms))
+	}
+}
+
+func testInsertReservationWithRestriction(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := book(t, repo, 1, "2050-03-10", "2050-03-12")
+
+	res, err := repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != id || res.FirstName != "John" || res.LastName != "Smith" || res.Email != "john@smith.com" || res.Phone != "555-555-5555" {
+		t.Errorf("stored reservation does not match: %+v", res)
+	}
+	if !sameDay(res.StartDate, conformanceDate("2050-03-10")) || !sameDay(res.EndDate, conformanceDate("2050-03-12")) {
+		t.Errorf("stored dates do not match: got %s to %s", res.StartDate, res.EndDate)
+	}
+	if res.RoomID != 1 || res.Room.ID != 1 || res.Room.RoomName == "" {
+		t.Errorf("stored room does not match: %+v", res.Room)
+	}
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, 1, conformanceDate("2050-03-01"), conformanceDate("2050-03-31"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 1 {
+		t.Fatalf("got %d room restrictions, wanted 1", len(restrictions))
+	}
+	if restrictions[0].ReservationID != id || restrictions[0].RestrictionID != 1 {
+		t.Errorf("room restriction does not point to the reservation: %+v", restrictions[0])
+	}
+
+	// an overlapping booking is rejected and leaves nothing behind
+	_, err = repo.InsertReservationWithRestriction(ctx, models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@doe.com",
+		StartDate: conformanceDate("2050-03-11"),
+		EndDate:   conformanceDate("2050-03-13"),
+		RoomID:    1,
+	})
+	if !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("overlapping booking: got error %v, wanted %v", err, repository.ErrRoomNotAvailable)
+	}
+
+	all, err := repo.ListReservations(ctx, models.ReservationQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all.Total != 1 {
+		t.Errorf("got %d reservations after a rejected booking, wanted 1", all.Total)
+	}
+
+	// back to back bookings are fine
+	book(t, repo, 1, "2050-03-12", "2050-03-14")
+	book(t, repo, 1, "2050-03-08", "2050-03-10")
+
+	// a room that does not exist cannot be booked
+	_, err = repo.InsertReservationWithRestriction(ctx, models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@doe.com",
+		StartDate: conformanceDate("2050-03-11"),
+		EndDate:   conformanceDate("2050-03-13"),
+		RoomID:    1000,
+	})
+	if err == nil {
+		t.Error("booking a room that does not exist did not return an error")
+	}
+}
+
+func testBlocks(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	err := repo.InsertBlockForRoom(ctx, 2, conformanceDate("2050-04-10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, 2, conformanceDate("2050-04-01"), conformanceDate("2050-04-30"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 1 {
+		t.Fatalf("got %d room restrictions after inserting a block, wanted 1", len(restrictions))
+	}
+	block := restrictions[0]
+	if block.RestrictionID != 2 || block.ReservationID != 0 || block.RoomID != 2 {
+		t.Errorf("block is not an owner block without a reservation: %+v", block)
+	}
+	if !sameDay(block.StartDate, conformanceDate("2050-04-10")) || !sameDay(block.EndDate, conformanceDate("2050-04-11")) {
+		t.Errorf("block should cover one night: got %s to %s", block.StartDate, block.EndDate)
+	}
+
+	// the block covers the night of the 10th only
+	available, _ := repo.SearchAvailabilityByDatesByRoomID(ctx, conformanceDate("2050-04-10"), conformanceDate("2050-04-11"), 2)
+	if available {
+		t.Error("blocked night shows available")
+	}
+	available, _ = repo.SearchAvailabilityByDatesByRoomID(ctx, conformanceDate("2050-04-11"), conformanceDate("2050-04-12"), 2)
+	if !available {
+		t.Error("night after the block shows unavailable")
+	}
+
+	// blocks cannot overlap other restrictions
+	err = repo.InsertBlockForRoom(ctx, 2, conformanceDate("2050-04-10"))
+	if !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("second block on the same night: got error %v, wanted %v", err, repository.ErrRoomNotAvailable)
+	}
+	book(t, repo, 2, "2050-04-20", "2050-04-23")
+	err = repo.InsertBlockForRoom(ctx, 2, conformanceDate("2050-04-21"))
+	if !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("block over a reservation: got error %v, wanted %v", err, repository.ErrRoomNotAvailable)
+	}
+	err = repo.InsertBlockForRoom(ctx, 2, conformanceDate("2050-04-23"))
+	if err != nil {
+		t.Errorf("block on the checkout day: unexpected error %v", err)
+	}
+
+	// the calendar asks for restrictions by an inclusive range of days
+	restrictions, err = repo.GetRestrictionsForRoomByDate(ctx, 2, conformanceDate("2050-04-11"), conformanceDate("2050-04-19"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 0 {
+		t.Errorf("got %d restrictions in an empty range, wanted 0", len(restrictions))
+	}
+	restrictions, err = repo.GetRestrictionsForRoomByDate(ctx, 2, conformanceDate("2050-04-10"), conformanceDate("2050-04-10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 1 {
+		t.Errorf("got %d restrictions on the blocked day, wanted 1", len(restrictions))
+	}
+
+	err = repo.DeleteBlockByID(ctx, block.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	available, _ = repo.SearchAvailabilityByDatesByRoomID(ctx, conformanceDate("2050-04-10"), conformanceDate("2050-04-11"), 2)
+	if !available {
+		t.Error("night shows unavailable after its block was deleted")
+	}
+}
+
+func testStatus(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	first := book(t, repo, 1, "2050-05-01", "2050-05-03")
+	second := book(t, repo, 2, "2050-05-01", "2050-05-03")
+
+	newOnly := models.ReservationQuery{Status: models.StatusNew}
+
+	newReservations, err := repo.ListReservations(ctx, newOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newReservations.Total != 2 {
+		t.Fatalf("got %d new reservations, wanted 2", newReservations.Total)
+	}
+
+	err = repo.UpdateReservationStatus(ctx, first, models.StatusConfirmed, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationByID(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != models.StatusConfirmed {
+		t.Errorf("got status %q, wanted %q", res.Status, models.StatusConfirmed)
+	}
+
+	newReservations, err = repo.ListReservations(ctx, newOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newReservations.Total != 1 || newReservations.Reservations[0].ID != second {
+		t.Errorf("got new reservations %+v, wanted only reservation %d", newReservations.Reservations, second)
+	}
+
+	confirmed, err := repo.ListReservations(ctx, models.ReservationQuery{Status: models.StatusConfirmed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if confirmed.Total != 1 || confirmed.Reservations[0].ID != first {
+		t.Errorf("got confirmed reservations %+v, wanted only reservation %d", confirmed.Reservations, first)
+	}
+
+	all, err := repo.ListReservations(ctx, models.ReservationQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all.Total != 2 {
+		t.Errorf("got %d reservations, wanted reservations in every status together", all.Total)
+	}
+
+	// a new reservation cannot be checked out, and a failed transition leaves no trace
+	err = repo.UpdateReservationStatus(ctx, second, models.StatusCheckedOut, 1)
+	if !errors.Is(err, repository.ErrInvalidStatusTransition) {
+		t.Errorf("new to checked out: got error %v, wanted %v", err, repository.ErrInvalidStatusTransition)
+	}
+	res, _ = repo.GetReservationByID(ctx, second)
+	if res.Status != models.StatusNew {
+		t.Errorf("got status %q after a rejected transition, wanted %q", res.Status, models.StatusNew)
+	}
+
+	// a change made without an admin is recorded with user 0
+	for _, status := range []models.ReservationStatus{models.StatusCheckedIn, models.StatusCheckedOut} {
+		err = repo.UpdateReservationStatus(ctx, first, status, 0)
+		if err != nil {
+			t.Fatalf("moving to %s: %v", status, err)
+		}
+	}
+
+	// checked out is final
+	err = repo.UpdateReservationStatus(ctx, first, models.StatusCancelled, 1)
+	if !errors.Is(err, repository.ErrInvalidStatusTransition) {
+		t.Errorf("checked out to cancelled: got error %v, wanted %v", err, repository.ErrInvalidStatusTransition)
+	}
+
+	history, err := repo.GetReservationStatusHistory(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wanted := []models.ReservationStatusChange{
+		{FromStatus: models.StatusNew, ToStatus: models.StatusConfirmed, UserID: 1},
+		{FromStatus: models.StatusConfirmed, ToStatus: models.StatusCheckedIn},
+		{FromStatus: models.StatusCheckedIn, ToStatus: models.StatusCheckedOut},
+	}
+	if len(history) != len(wanted) {
+		t.Fatalf("got %d status changes, wanted %d", len(history), len(wanted))
+	}
+	for i, c := range history {
+		if c.ReservationID != first || c.FromStatus != wanted[i].FromStatus || c.ToStatus != wanted[i].ToStatus || c.UserID != wanted[i].UserID {
+			t.Errorf("change %d: got %+v, wanted %+v", i, c, wanted[i])
+		}
+		if c.CreatedAt.IsZero() {
+			t.Errorf("change %d has no time", i)
+		}
+	}
+
+	history, err = repo.GetReservationStatusHistory(ctx, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Errorf("got %d status changes for an unchanged reservation, wanted none", len(history))
+	}
+
+	err = repo.UpdateReservationStatus(ctx, 999, models.StatusConfirmed, 1)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing reservation: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+}
+
+func testCancelReservation(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	guest := book(t, repo, 1, "2050-06-01", "2050-06-04")
+
+	err := repo.CancelReservation(ctx, guest, 0, "Change of plans", 2500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationByID(ctx, guest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != models.StatusCancelled {
+		t.Errorf("got status %q, wanted %q", res.Status, models.StatusCancelled)
+	}
+	if res.CancelledAt.IsZero() || res.CancelledBy != 0 || res.CancellationReason != "Change of plans" {
+		t.Errorf("got cancelled at %v by %d because %q, wanted a guest cancellation because \"Change of plans\"", res.CancelledAt, res.CancelledBy, res.CancellationReason)
+	}
+	if res.CancellationFee != 2500 {
+		t.Errorf("got a cancellation fee of %d, wanted 2500", res.CancellationFee)
+	}
+
+	// the dates are free again, so the room can be booked for them
+	available, err := repo.SearchAvailabilityByDatesByRoomID(ctx, conformanceDate("2050-06-01"), conformanceDate("2050-06-04"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !available {
+		t.Error("room shows unavailable after its reservation was cancelled")
+	}
+	rebooked := book(t, repo, 1, "2050-06-01", "2050-06-04")
+
+	err = repo.CancelReservation(ctx, guest, 0, "", 0)
+	if !errors.Is(err, repository.ErrInvalidStatusTransition) {
+		t.Errorf("cancelling twice: got error %v, wanted %v", err, repository.ErrInvalidStatusTransition)
+	}
+
+	// a status change cannot cancel, so cancelling always records why and frees the room
+	err = repo.UpdateReservationStatus(ctx, rebooked, models.StatusConfirmed, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.UpdateReservationStatus(ctx, rebooked, models.StatusCancelled, 1)
+	if !errors.Is(err, repository.ErrInvalidStatusTransition) {
+		t.Errorf("cancelling through a status change: got error %v, wanted %v", err, repository.ErrInvalidStatusTransition)
+	}
+	if res, _ = repo.GetReservationByID(ctx, rebooked); res.Status != models.StatusConfirmed {
+		t.Errorf("got status %q after cancelling through a status change, wanted %q", res.Status, models.StatusConfirmed)
+	}
+	err = repo.CancelReservation(ctx, rebooked, 1, "Guest called", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, _ = repo.GetReservationByID(ctx, rebooked)
+	if res.Status != models.StatusCancelled || res.CancelledBy != 1 {
+		t.Errorf("got status %q cancelled by %d, wanted %q by 1", res.Status, res.CancelledBy, models.StatusCancelled)
+	}
+	available, _ = repo.SearchAvailabilityByDatesByRoomID(ctx, conformanceDate("2050-06-01"), conformanceDate("2050-06-04"), 1)
+	if !available {
+		t.Error("room shows unavailable after an admin cancelled its reservation")
+	}
+
+	history, err := repo.GetReservationStatusHistory(ctx, rebooked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[1].ToStatus != models.StatusCancelled || history[1].UserID != 1 {
+		t.Errorf("got status history %+v, wanted confirmation then cancellation by user 1", history)
+	}
+
+	// a guest who has checked in can no longer cancel, and keeps the room
+	stay := book(t, repo, 2, "2050-06-01", "2050-06-04")
+	for _, status := range []models.ReservationStatus{models.StatusConfirmed, models.StatusCheckedIn} {
+		if err = repo.UpdateReservationStatus(ctx, stay, status, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = repo.CancelReservation(ctx, stay, 0, "", 0)
+	if !errors.Is(err, repository.ErrInvalidStatusTransition) {
+		t.Errorf("cancelling a checked in reservation: got error %v, wanted %v", err, repository.ErrInvalidStatusTransition)
+	}
+	available, _ = repo.SearchAvailabilityByDatesByRoomID(ctx, conformanceDate("2050-06-01"), conformanceDate("2050-06-04"), 2)
+	if available {
+		t.Error("room shows available after a rejected cancellation")
+	}
+
+	err = repo.CancelReservation(ctx, 999, 0, "", 0)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing reservation: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+}
+
+func testListReservations(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	guests := []struct {
+		firstName string
+		lastName  string
+		email     string
+		roomID    int
+		start     string
+		end       string
+	}{
+		{"Anna", "Zimmer", "anna@example.com", 1, "2050-09-01", "2050-09-03"},
+		{"Bob", "Young", "bob@example.com", 2, "2050-09-02", "2050-09-04"},
+		{"Carl", "Xavier", "carl@example.org", 1, "2050-09-05", "2050-09-06"},
+		{"Dina", "Wolf", "dina_w@example.org", 2, "2050-09-10", "2050-09-12"},
+		{"Emma", "Vance", "emma@example.com", 1, "2050-09-20", "2050-09-25"},
+	}
+	ids := make(map[string]int)
+	for _, g := range guests {
+		id, err := repo.InsertReservationWithRestriction(ctx, models.Reservation{
+			FirstName: g.firstName,
+			LastName:  g.lastName,
+			Email:     g.email,
+			Phone:     "555-0100",
+			StartDate: conformanceDate(g.start),
+			EndDate:   conformanceDate(g.end),
+			RoomID:    g.roomID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[g.firstName] = id
+	}
+
+	names := func(page models.ReservationPage) []string {
+		var n []string
+		for _, res := range page.Reservations {
+			n = append(n, res.FirstName)
+		}
+		return n
+	}
+
+	var tests = []struct {
+		name  string
+		query models.ReservationQuery
+		total int
+		want  []string
+	}{
+		{"defaults sort by arrival", models.ReservationQuery{}, 5, []string{"Anna", "Bob", "Carl", "Dina", "Emma"}},
+		{"first page", models.ReservationQuery{PageSize: 2}, 5, []string{"Anna", "Bob"}},
+		{"last page", models.ReservationQuery{PageSize: 2, Page: 3}, 5, []string{"Emma"}},
+		{"past the last page", models.ReservationQuery{PageSize: 2, Page: 4}, 5, nil},
+		{"sort by last name", models.ReservationQuery{Sort: "last_name"}, 5, []string{"Emma", "Dina", "Carl", "Bob", "Anna"}},
+		{"sort descending", models.ReservationQuery{Sort: "end_date", Desc: true, PageSize: 2}, 5, []string{"Emma", "Dina"}},
+		{"unknown sort column", models.ReservationQuery{Sort: "password; drop table users"}, 5, []string{"Anna", "Bob", "Carl", "Dina", "Emma"}},
+		{"room", models.ReservationQuery{RoomID: 2}, 2, []string{"Bob", "Dina"}},
+		{"stays touching the range", models.ReservationQuery{From: conformanceDate("2050-09-04"), To: conformanceDate("2050-09-10")}, 3, []string{"Bob", "Carl", "Dina"}},
+		{"from only", models.ReservationQuery{From: conformanceDate("2050-09-12")}, 2, []string{"Dina", "Emma"}},
+		{"search by name", models.ReservationQuery{Search: "xav"}, 1, []string{"Carl"}},
+		{"search by email", models.ReservationQuery{Search: "example.org"}, 2, []string{"Carl", "Dina"}},
+		{"search wildcards are literal", models.ReservationQuery{Search: "_"}, 1, []string{"Dina"}},
+		{"search by phone", models.ReservationQuery{Search: "0100"}, 5, []string{"Anna", "Bob", "Carl", "Dina", "Emma"}},
+		{"combined filters", models.ReservationQuery{RoomID: 1, Search: "example.com"}, 2, []string{"Anna", "Emma"}},
+	}
+
+	for _, e := range tests {
+		page, err := repo.ListReservations(ctx, e.query)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", e.name, err)
+			continue
+		}
+		if page.Total != e.total {
+			t.Errorf("%s: got total %d, wanted %d", e.name, page.Total, e.total)
+		}
+		got := names(page)
+		if len(got) != len(e.want) {
+			t.Errorf("%s: got %v, wanted %v", e.name, got, e.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != e.want[i] {
+				t.Errorf("%s: got %v, wanted %v", e.name, got, e.want)
+				break
+			}
+		}
+	}
+
+	// listings carry the room and processed flag of each reservation
+	page, err := repo.ListReservations(ctx, models.ReservationQuery{Search: "Anna"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Reservations) != 1 || page.Reservations[0].ID != ids["Anna"] || page.Reservations[0].Room.RoomName == "" {
+		t.Errorf("listing is missing reservation details: %+v", page.Reservations)
+	}
+	if page.Query.Page != 1 || page.Query.PageSize != models.DefaultPageSize {
+		t.Errorf("listing did not return its normalized query: %+v", page.Query)
+	}
+}
+
+func testSearchReservations(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	guests := []models.Reservation{
+		{FirstName: "Anna", LastName: "Smith", Email: "anna@example.com", Phone: "555-0100", RoomID: 1, StartDate: conformanceDate("2050-10-01"), EndDate: conformanceDate("2050-10-02")},
+		{FirstName: "John", LastName: "Smithers", Email: "smith@example.com", Phone: "555-0200", RoomID: 2, StartDate: conformanceDate("2050-10-05"), EndDate: conformanceDate("2050-10-06")},
+		{FirstName: "Carl", LastName: "Blacksmith", Email: "carl@example.com", Phone: "555-0300", RoomID: 1, StartDate: conformanceDate("2050-10-10"), EndDate: conformanceDate("2050-10-11")},
+		{FirstName: "Dina", LastName: "Wolf", Email: "dina@example.org", Phone: "555-0400", RoomID: 2, StartDate: conformanceDate("2050-10-12"), EndDate: conformanceDate("2050-10-13")},
+	}
+	ids := make(map[string]int)
+	for _, g := range guests {
+		id, err := repo.InsertReservationWithRestriction(ctx, g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[g.FirstName] = id
+	}
+
+	var tests = []struct {
+		name  string
+		term  string
+		limit int
+		want  []string
+	}{
+		{"exact last name, then prefix, then anywhere", "smith", 0, []string{"Anna", "John", "Carl"}},
+		{"exact email", "smith@example.com", 0, []string{"John"}},
+		{"case insensitive", "ANNA", 0, []string{"Anna"}},
+		{"phone", "0300", 0, []string{"Carl"}},
+		{"full name", "dina wolf", 0, []string{"Dina"}},
+		{"limit", "example.com", 2, []string{"Carl", "John"}},
+		{"wildcards are literal", "%", 0, nil},
+		{"no match", "nobody", 0, nil},
+		{"blank", "  ", 0, nil},
+	}
+
+	for _, e := range tests {
+		results, err := repo.SearchReservations(ctx, e.term, e.limit)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", e.name, err)
+			continue
+		}
+
+		var got []string
+		for _, res := range results {
+			got = append(got, res.FirstName)
+		}
+		if strings.Join(got, ",") != strings.Join(e.want, ",") {
+			t.Errorf("%s: searching %q got %v, wanted %v", e.name, e.term, got, e.want)
+		}
+	}
+
+	// a reservation ID ranks first, whatever else matches
+	results, err := repo.SearchReservations(ctx, strconv.Itoa(ids["Dina"]), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 || results[0].ID != ids["Dina"] {
+		t.Errorf("searching reservation %d did not return it first: %+v", ids["Dina"], results)
+	}
+	if len(results) > 0 && results[0].Room.RoomName == "" {
+		t.Error("search results are missing the room")
+	}
+}
+
+func testConfirmationCode(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	first, _ := repo.GetReservationByID(ctx, book(t, repo, 1, "2050-08-01", "2050-08-03"))
+	second, _ := repo.GetReservationByID(ctx, book(t, repo, 2, "2050-08-01", "2050-08-03"))
+
+	for _, res := range []models.Reservation{first, second} {
+		if len(res.ConfirmationCode) != 9 || res.ConfirmationCode[4] != '-' || res.ConfirmationCode != strings.ToUpper(res.ConfirmationCode) {
+			t.Errorf("reservation %d: got confirmation code %q, wanted one like \"K7QM-4XPD\"", res.ID, res.ConfirmationCode)
+		}
+	}
+	if first.ConfirmationCode == second.ConfirmationCode {
+		t.Errorf("two reservations got the same confirmation code %q", first.ConfirmationCode)
+	}
+
+	// guests may type the code in lower case and without the dash
+	for _, code := range []string{first.ConfirmationCode, strings.ToLower(strings.ReplaceAll(first.ConfirmationCode, "-", ""))} {
+		res, err := repo.GetReservationByCode(ctx, code)
+		if err != nil {
+			t.Fatalf("code %q: %v", code, err)
+		}
+		if res.ID != first.ID || res.Room.RoomName == "" {
+			t.Errorf("code %q: got reservation %d in room %q, wanted reservation %d with its room", code, res.ID, res.Room.RoomName, first.ID)
+		}
+	}
+
+	_, err := repo.GetReservationByCode(ctx, "AAAA-AAAA")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unknown code: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+
+	found, err := repo.SearchReservations(ctx, strings.ToLower(second.ConfirmationCode), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) == 0 || found[0].ID != second.ID || found[0].ConfirmationCode != second.ConfirmationCode {
+		t.Errorf("searching by code: got %+v, wanted reservation %d first", found, second.ID)
+	}
+
+	page, err := repo.ListReservations(ctx, models.ReservationQuery{Search: second.ConfirmationCode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || page.Reservations[0].ID != second.ID {
+		t.Errorf("listing by code: got %+v, wanted only reservation %d", page.Reservations, second.ID)
+	}
+}
+
+func testSource(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	online := book(t, repo, 1, "2050-12-01", "2050-12-03")
+
+	phone, err := repo.InsertReservationWithRestriction(ctx, models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		StartDate: conformanceDate("2050-12-01"),
+		EndDate:   conformanceDate("2050-12-03"),
+		RoomID:    2,
+		Source:    models.SourcePhone,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		id       int
+		expected models.ReservationSource
+	}{
+		{"no source", online, models.SourceOnline},
+		{"phone", phone, models.SourcePhone},
+	}
+
+	for _, e := range tests {
+		res, err := repo.GetReservationByID(ctx, e.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Source != e.expected {
+			t.Errorf("%s: got source %q, wanted %q", e.name, res.Source, e.expected)
+		}
+	}
+}
+
+func testAuthenticate(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id, hash, err := repo.Authenticate(ctx, "admin@admin.com", "password")
+	if err != nil {
+		t.Fatalf("valid credentials: unexpected error %v", err)
+	}
+	if id == 0 || hash == "" {
+		t.Errorf("valid credentials: got id %d and hash %q", id, hash)
+	}
+
+	user, err := repo.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "admin@admin.com" || user.AccessLevel != 3 {
+		t.Errorf("authenticated user does not match: %+v", user)
+	}
+
+	id, _, err = repo.Authenticate(ctx, "admin@admin.com", "wrong")
+	if err == nil || id != 0 {
+		t.Errorf("wrong password: got id %d and error %v", id, err)
+	}
+
+	_, _, err = repo.Authenticate(ctx, "nobody@here.com", "password")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unknown email: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+}
+
+func testUpdateReservation(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := book(t, repo, 1, "2050-06-01", "2050-06-04")
+
+	err := repo.UpdateReservation(ctx, models.Reservation{
+		ID:        id,
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@doe.com",
+		Phone:     "123",
+		StartDate: conformanceDate("2050-07-01"),
+		EndDate:   conformanceDate("2050-07-02"),
+		RoomID:    2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.FirstName != "Jane" || res.LastName != "Doe" || res.Email != "jane@doe.com" || res.Phone != "123" {
+		t.Errorf("guest details were not updated: %+v", res)
+	}
+
+	// only the guest details change, never the stay
+	if res.RoomID != 1 || !sameDay(res.StartDate, conformanceDate("2050-06-01")) || !sameDay(res.EndDate, conformanceDate("2050-06-04")) {
+		t.Errorf("UpdateReservation changed the stay: room %d from %s to %s", res.RoomID, res.StartDate, res.EndDate)
+	}
+
+	// updating a reservation that does not exist is not an error
+	err = repo.UpdateReservation(ctx, models.Reservation{ID: id + 1000, FirstName: "Nobody", Email: "nobody@here.com"})
+	if err != nil {
+		t.Errorf("updating a missing reservation: unexpected error %v", err)
+	}
+
+	_, err = repo.GetReservationByID(ctx, id+1000)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing reservation: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+}
+
+func testRequestDateChange(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := book(t, repo, 1, "2050-09-01", "2050-09-03")
+
+	res, err := repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.RequestedStartDate.IsZero() || !res.RequestedEndDate.IsZero() {
+		t.Errorf("new reservation has requested dates %v to %v, wanted none", res.RequestedStartDate, res.RequestedEndDate)
+	}
+
+	err = repo.RequestDateChange(ctx, id, conformanceDate("2050-09-10"), conformanceDate("2050-09-12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameDay(res.RequestedStartDate, conformanceDate("2050-09-10")) || !sameDay(res.RequestedEndDate, conformanceDate("2050-09-12")) {
+		t.Errorf("got requested dates %v to %v, wanted 2050-09-10 to 2050-09-12", res.RequestedStartDate, res.RequestedEndDate)
+	}
+
+	// a request does not move the booking
+	if !sameDay(res.StartDate, conformanceDate("2050-09-01")) || !sameDay(res.EndDate, conformanceDate("2050-09-03")) {
+		t.Errorf("got dates %v to %v after a date change request, wanted them unchanged", res.StartDate, res.EndDate)
+	}
+	available, _ := repo.SearchAvailabilityByDatesByRoomID(ctx, conformanceDate("2050-09-01"), conformanceDate("2050-09-03"), 1)
+	if available {
+		t.Error("room shows available after a date change request")
+	}
+}
+
+func testChangeReservationStay(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := book(t, repo, 1, "2050-11-10", "2050-11-14")
+	book(t, repo, 1, "2050-11-20", "2050-11-22")
+	if err := repo.InsertBlockForRoom(ctx, 2, conformanceDate("2050-11-12")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RequestDateChange(ctx, id, conformanceDate("2050-11-08"), conformanceDate("2050-11-15")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stay's own restriction does not count against it
+	err := repo.ChangeReservationStay(ctx, id, 1, conformanceDate("2050-11-08"), conformanceDate("2050-11-15"), 70000, nil)
+	if err != nil {
+		t.Fatalf("extending a stay over its own dates: %v", err)
+	}
+
+	res, err := repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameDay(res.StartDate, conformanceDate("2050-11-08")) || !sameDay(res.EndDate, conformanceDate("2050-11-15")) {
+		t.Errorf("got dates %v to %v, wanted 2050-11-08 to 2050-11-15", res.StartDate, res.EndDate)
+	}
+	if !res.RequestedStartDate.IsZero() || !res.RequestedEndDate.IsZero() {
+		t.Errorf("requested dates %v to %v were not cleared", res.RequestedStartDate, res.RequestedEndDate)
+	}
+	if res.TotalPrice != 70000 {
+		t.Errorf("got total price %d, wanted 70000", res.TotalPrice)
+	}
+
+	restrictions, err := repo.GetRestrictionsForRoomByDate(ctx, 1, conformanceDate("2050-11-01"), conformanceDate("2050-11-30"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, rr := range restrictions {
+		if rr.ReservationID == id {
+			found = true
+			if !sameDay(rr.StartDate, conformanceDate("2050-11-08")) || !sameDay(rr.EndDate, conformanceDate("2050-11-15")) {
+				t.Errorf("got restriction %v to %v, wanted it moved with the reservation", rr.StartDate, rr.EndDate)
+			}
+		}
+	}
+	if !found {
+		t.Error("the reservation's restriction is missing after the change")
+	}
+
+	var tests = []struct {
+		name   string
+		roomID int
+		start  string
+		end    string
+	}{
+		{"overlaps another reservation", 1, "2050-11-14", "2050-11-21"},
+		{"overlaps an owner block", 2, "2050-11-11", "2050-11-13"},
+	}
+
+	for _, e := range tests {
+		err = repo.ChangeReservationStay(ctx, id, e.roomID, conformanceDate(e.start), conformanceDate(e.end), 0, nil)
+		if !errors.Is(err, repository.ErrRoomNotAvailable) {
+			t.Errorf("%s: got error %v, wanted %v", e.name, err, repository.ErrRoomNotAvailable)
+		}
+	}
+
+	res, _ = repo.GetReservationByID(ctx, id)
+	if res.RoomID != 1 || !sameDay(res.StartDate, conformanceDate("2050-11-08")) || res.TotalPrice != 70000 {
+		t.Errorf("a rejected change moved the reservation to room %d on %v for %d", res.RoomID, res.StartDate, res.TotalPrice)
+	}
+
+	// moving to another room frees the old one
+	err = repo.ChangeReservationStay(ctx, id, 2, conformanceDate("2050-11-01"), conformanceDate("2050-11-03"), 30000, nil)
+	if err != nil {
+		t.Fatalf("moving to another room: %v", err)
+	}
+	available, _ := repo.SearchAvailabilityByDatesByRoomID(ctx, conformanceDate("2050-11-08"), conformanceDate("2050-11-15"), 1)
+	if !available {
+		t.Error("old room is still unavailable after the reservation moved")
+	}
+	available, _ = repo.SearchAvailabilityByDatesByRoomID(ctx, conformanceDate("2050-11-01"), conformanceDate("2050-11-03"), 2)
+	if available {
+		t.Error("new room shows available after the reservation moved")
+	}
+
+	if err = repo.CancelReservation(ctx, id, 1, "", 0); err != nil {
+		t.Fatal(err)
+	}
+	err = repo.ChangeReservationStay(ctx, id, 2, conformanceDate("2050-11-01"), conformanceDate("2050-11-04"), 45000, nil)
+	if !errors.Is(err, repository.ErrReservationClosed) {
+		t.Errorf("cancelled reservation: got error %v, wanted %v", err, repository.ErrReservationClosed)
+	}
+}
+
+// roomIDs returns the IDs of rooms in order
+func roomIDs(rooms []models.Room) []int {
+	var ids []int
+	for _, room := range rooms {
+		ids = append(ids, room.ID)
+	}
+	return ids
+}
+
+func testRooms(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	rooms, err := repo.AllRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 2 || rooms[0].Slug != "generals-quarters" || rooms[1].Slug != "majors-suite" || !rooms[0].Active || !rooms[1].Active {
+		t.Fatalf("got seeded rooms %+v, wanted the active General's Quarters and Major's Suite in that order", rooms)
+	}
+
+	room, err := repo.GetRoomBySlug(ctx, "majors-suite")
+	if err != nil || room.ID != 2 {
+		t.Errorf("got room %d and error %v for majors-suite, wanted room 2", room.ID, err)
+	}
+	_, err = repo.GetRoomBySlug(ctx, "no-such-room")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unknown slug: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+
+	id, err := repo.InsertRoom(ctx, models.Room{RoomName: "Colonel's Cabin", Slug: "colonels-cabin", Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room, err = repo.GetRoomByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.RoomName != "Colonel's Cabin" || room.Slug != "colonels-cabin" || !room.Active || room.SortOrder <= rooms[1].SortOrder {
+		t.Errorf("got new room %+v, wanted an active Colonel's Cabin listed last", room)
+	}
+
+	_, err = repo.InsertRoom(ctx, models.Room{RoomName: "Another Suite", Slug: "majors-suite", Active: true})
+	if !errors.Is(err, repository.ErrDuplicateSlug) {
+		t.Errorf("insert with a used slug: got error %v, wanted %v", err, repository.ErrDuplicateSlug)
+	}
+	err = repo.UpdateRoom(ctx, models.Room{ID: id, RoomName: "Colonel's Cabin", Slug: "generals-quarters"})
+	if !errors.Is(err, repository.ErrDuplicateSlug) {
+		t.Errorf("update to a used slug: got error %v, wanted %v", err, repository.ErrDuplicateSlug)
+	}
+
+	// a room keeps its own slug when only the name changes
+	err = repo.UpdateRoom(ctx, models.Room{ID: id, RoomName: "Colonel's Lodge", Slug: "colonels-cabin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room, _ = repo.GetRoomByID(ctx, id)
+	if room.RoomName != "Colonel's Lodge" {
+		t.Errorf("got name %q after the update, wanted Colonel's Lodge", room.RoomName)
+	}
+
+	err = repo.SetRoomActive(ctx, id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := repo.ActiveRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := roomIDs(active); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("got active rooms %v, wanted [1 2]", got)
+	}
+	available, err := repo.SearchAvailabilityForAllRooms(ctx, conformanceDate("2050-12-20"), conformanceDate("2050-12-22"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, room := range available {
+		if room.ID == id {
+			t.Error("an inactive room is offered as available")
+		}
+	}
+
+	err = repo.ReorderRooms(ctx, []int{id, 2, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rooms, err = repo.AllRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := roomIDs(rooms); len(got) != 3 || got[0] != id || got[1] != 2 || got[2] != 1 {
+		t.Errorf("got rooms in order %v, wanted [%d 2 1]", got, id)
+	}
+	if rooms[0].Active {
+		t.Error("AllRooms lost the inactive flag")
+	}
+}
+
+func testRoomDetails(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	room, err := repo.GetRoomByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.MaxOccupancy != 2 || len(room.Photos) != 1 || room.Photos[0].Path != "/static/images/generals-quarters.png" {
+		t.Errorf("got seeded room %+v, wanted it to sleep 2 with its photo", room)
+	}
+
+	id, err := repo.InsertRoom(ctx, models.Room{
+		RoomName:         "Colonel's Cabin",
+		Slug:             "colonels-cabin",
+		Active:           true,
+		MaxOccupancy:     4,
+		BedConfiguration: "2 queen beds",
+		Description:      "A cabin by the lake",
+		Amenities:        []string{"Wi-Fi", "Fireplace", "Balcony"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room, err = repo.GetRoomBySlug(ctx, "colonels-cabin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.MaxOccupancy != 4 || room.BedConfiguration != "2 queen beds" || room.Description != "A cabin by the lake" {
+		t.Errorf("got details %d, %q, %q, wanted 4, 2 queen beds, A cabin by the lake", room.MaxOccupancy, room.BedConfiguration, room.Description)
+	}
+	if got := strings.Join(room.Amenities, ","); got != "Wi-Fi,Fireplace,Balcony" {
+		t.Errorf("got amenities %q, wanted them in the order given", got)
+	}
+
+	room.MaxOccupancy = 3
+	room.Amenities = []string{"Balcony", "Wi-Fi"}
+	err = repo.UpdateRoom(ctx, room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var photoIDs []int
+	for _, path := range []string{"/a.png", "/b.png", "/c.png"} {
+		photoID, err := repo.AddRoomPhoto(ctx, models.RoomPhoto{
+			RoomID:        id,
+			Path:          path,
+			Caption:       "Photo " + path,
+			OriginalPath:  path + ".original",
+			ThumbnailPath: path + ".thumb",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		photoIDs = append(photoIDs, photoID)
+	}
+
+	err = repo.ReorderRoomPhotos(ctx, id, []int{photoIDs[2], photoIDs[0], photoIDs[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.DeleteRoomPhoto(ctx, id, photoIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.DeleteRoomPhoto(ctx, 1, photoIDs[1]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("delete another room's photo: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+
+	rooms, err := repo.AllRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room = rooms[len(rooms)-1]
+	if room.ID != id || room.MaxOccupancy != 3 || strings.Join(room.Amenities, ",") != "Balcony,Wi-Fi" {
+		t.Errorf("got listed room %+v, wanted the updated Colonel's Cabin", room)
+	}
+	var paths []string
+	for _, photo := range room.Photos {
+		paths = append(paths, photo.Path)
+	}
+	if got := strings.Join(paths, ","); got != "/c.png,/b.png" {
+		t.Errorf("got photos %q, wanted /c.png,/b.png", got)
+	}
+	if p := room.Photos[0]; p.Caption != "Photo /c.png" || p.OriginalPath != "/c.png.original" || p.ThumbnailPath != "/c.png.thumb" {
+		t.Errorf("got photo %+v, wanted the caption, original and thumbnail of /c.png", p)
+	}
+
+	available, err := repo.SearchAvailabilityForAllRooms(ctx, conformanceDate("2050-12-20"), conformanceDate("2050-12-22"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range available {
+		if r.ID == id && (r.BedConfiguration != "2 queen beds" || len(r.Amenities) != 2 || len(r.Photos) != 2) {
+			t.Errorf("got available room %+v, wanted its details, amenities and photos", r)
+		}
+	}
+}
+
+func testUpdateUser(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id, _, err := repo.Authenticate(ctx, "admin@admin.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := repo.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// database backed repositories may be shared between runs, so put the admin back
+	t.Cleanup(func() { repo.UpdateUser(context.Background(), original) })
+
+	err = repo.UpdateUser(ctx, models.User{
+		ID:          id,
+		FirstName:   "New",
+		LastName:    "Name",
+		Email:       "new@admin.com",
+		Password:    "not a hash",
+		AccessLevel: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := repo.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.FirstName != "New" || user.LastName != "Name" || user.Email != "new@admin.com" || user.AccessLevel != 1 {
+		t.Errorf("user was not updated: %+v", user)
+	}
+
+	// the password is not part of an update
+	if user.Password != original.Password {
+		t.Error("UpdateUser changed the password")
+	}
+	if _, _, err = repo.Authenticate(ctx, "new@admin.com", "password"); err != nil {
+		t.Errorf("cannot log in with the new email: %v", err)
+	}
+
+	_, err = repo.GetUserByID(ctx, id+1000)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing user: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+}
+
+func testDeleteReservation(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := book(t, repo, 1, "2050-08-01", "2050-08-05")
+
+	err := repo.DeleteReservation(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.GetReservationByID(ctx, id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted reservation: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+
+	// the room restriction goes with the reservation
+	available, err := repo.SearchAvailabilityByDatesByRoomID(ctx, conformanceDate("2050-08-01"), conformanceDate("2050-08-05"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !available {
+		t.Error("room is still unavailable after its reservation was deleted")
+	}
+	book(t, repo, 1, "2050-08-01", "2050-08-05")
+}
+
+func testPrices(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	room, err := repo.GetRoomBySlug(ctx, "majors-suite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.NightlyRate != 15000 {
+		t.Errorf("got seeded nightly rate %d, wanted 15000", room.NightlyRate)
+	}
+
+	id, err := repo.InsertRoom(ctx, models.Room{RoomName: "Colonel's Cabin", Slug: "colonels-cabin", NightlyRate: 9950})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room, err = repo.GetRoomByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.NightlyRate != 9950 {
+		t.Errorf("got nightly rate %d, wanted 9950", room.NightlyRate)
+	}
+
+	resID, err := repo.InsertReservationWithRestriction(ctx, models.Reservation{
+		FirstName:  "John",
+		LastName:   "Smith",
+		Email:      "john@smith.com",
+		StartDate:  conformanceDate("2050-12-01"),
+		EndDate:    conformanceDate("2050-12-03"),
+		RoomID:     id,
+		TotalPrice: 19900,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new rate does not change what existing reservations cost
+	room.NightlyRate = 12000
+	if err = repo.UpdateRoom(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	room, _ = repo.GetRoomByID(ctx, id)
+	if room.NightlyRate != 12000 {
+		t.Errorf("got nightly rate %d after the update, wanted 12000", room.NightlyRate)
+	}
+
+	res, err := repo.GetReservationByID(ctx, resID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TotalPrice != 19900 {
+		t.Errorf("got total price %d, wanted the quoted 19900", res.TotalPrice)
+	}
+}
+
+func testRateRules(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	summer := models.RateRule{
+		Name:        "Summer",
+		NightlyRate: 18000,
+		Priority:    1,
+		StartDate:   conformanceDate("2050-07-01"),
+		EndDate:     conformanceDate("2050-08-31"),
+	}
+	weekends := models.RateRule{
+		Name:        "Weekends",
+		NightlyRate: 16000,
+		RoomID:      2,
+		Weekdays:    models.NewWeekdays(time.Friday, time.Saturday),
+	}
+	festival := models.RateRule{
+		Name:        "Festival",
+		NightlyRate: 30000,
+		Priority:    5,
+		StartDate:   conformanceDate("2050-09-10"),
+		EndDate:     conformanceDate("2050-09-12"),
+	}
+
+	var ids []int
+	for _, rule := range []models.RateRule{summer, weekends, festival} {
+		id, err := repo.InsertRateRule(ctx, rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	rule, err := repo.GetRateRuleByID(ctx, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Name != "Weekends" || rule.RoomID != 2 || rule.Room.RoomName != "Major's Suite" || rule.NightlyRate != 16000 {
+		t.Errorf("got rule %+v, wanted the weekend rule for Major's Suite", rule)
+	}
+	if !rule.StartDate.IsZero() || !rule.EndDate.IsZero() || !rule.Weekdays.Has(time.Saturday) || rule.Weekdays.Has(time.Sunday) {
+		t.Errorf("got dates %v to %v on %v, wanted open dates on Fri, Sat", rule.StartDate, rule.EndDate, rule.Weekdays)
+	}
+
+	rules, err := repo.AllRateRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range rules {
+		names = append(names, r.Name)
+	}
+	if got := strings.Join(names, ","); got != "Festival,Summer,Weekends" {
+		t.Errorf("got rules %s, wanted them by priority", got)
+	}
+
+	var tests = []struct {
+		name     string
+		start    string
+		end      string
+		expected string
+	}{
+		{"inside a range", "2050-07-10", "2050-07-12", "Summer,Weekends"},
+		{"ending on the first night of a range", "2050-06-28", "2050-07-02", "Summer,Weekends"},
+		{"departing as a range starts", "2050-06-28", "2050-07-01", "Weekends"},
+		{"arriving on the last night of a range", "2050-09-12", "2050-09-14", "Weekends,Festival"},
+		{"arriving after a range", "2050-09-13", "2050-09-14", "Weekends"},
+	}
+
+	for _, e := range tests {
+		rules, err := repo.RateRulesForStay(ctx, conformanceDate(e.start), conformanceDate(e.end))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, r := range rules {
+			names = append(names, r.Name)
+		}
+		if got := strings.Join(names, ","); got != e.expected {
+			t.Errorf("%s: got rules %s, wanted %s", e.name, got, e.expected)
+		}
+	}
+
+	rule.ID = ids[1]
+	rule.Name = "Long weekends"
+	rule.RoomID = 0
+	rule.StartDate = conformanceDate("2050-01-01")
+	rule.Weekdays = models.NewWeekdays(time.Friday, time.Saturday, time.Sunday)
+	if err = repo.UpdateRateRule(ctx, rule); err != nil {
+		t.Fatal(err)
+	}
+	rule, _ = repo.GetRateRuleByID(ctx, ids[1])
+	if rule.Name != "Long weekends" || rule.RoomID != 0 || !sameDay(rule.StartDate, conformanceDate("2050-01-01")) || !rule.Weekdays.Has(time.Sunday) {
+		t.Errorf("got rule %+v after the update", rule)
+	}
+
+	if err = repo.DeleteRateRule(ctx, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.DeleteRateRule(ctx, ids[0]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleting a missing rule: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+	if _, err = repo.GetRateRuleByID(ctx, ids[0]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("getting a deleted rule: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+}
+
+func testPromoCodes(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	summer := models.PromoCode{
+		Code:       " summer10 ",
+		Kind:       models.PromoPercent,
+		PercentOff: 10,
+		ValidFrom:  conformanceDate("2050-06-01"),
+		ValidUntil: conformanceDate("2050-08-31"),
+		MaxUses:    2,
+		MinNights:  2,
+		RoomIDs:    []int{2},
+	}
+	id, err := repo.InsertPromoCode(ctx, summer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.InsertPromoCode(ctx, models.PromoCode{Code: "WELCOME", Kind: models.PromoFixed, AmountOff: 2500})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = repo.InsertPromoCode(ctx, models.PromoCode{Code: "Summer10", Kind: models.PromoFixed}); !errors.Is(err, repository.ErrDuplicatePromoCode) {
+		t.Errorf("inserting a duplicate code: got error %v, wanted %v", err, repository.ErrDuplicatePromoCode)
+	}
+
+	p, err := repo.GetPromoCodeByCode(ctx, "summer10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != id || p.Code != "SUMMER10" || p.Kind != models.PromoPercent || p.PercentOff != 10 || p.MaxUses != 2 || p.MinNights != 2 {
+		t.Errorf("got promo code %+v, wanted SUMMER10", p)
+	}
+	if !sameDay(p.ValidFrom, summer.ValidFrom) || !sameDay(p.ValidUntil, summer.ValidUntil) || len(p.RoomIDs) != 1 || p.RoomIDs[0] != 2 {
+		t.Errorf("got validity %v to %v for rooms %v, wanted the summer for room 2", p.ValidFrom, p.ValidUntil, p.RoomIDs)
+	}
+
+	codes, err := repo.AllPromoCodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 2 || codes[0].Code != "SUMMER10" || codes[1].Code != "WELCOME" || codes[1].AmountOff != 2500 || len(codes[1].RoomIDs) != 0 {
+		t.Errorf("got promo codes %+v, wanted SUMMER10 and WELCOME", codes)
+	}
+
+	// booking with the code counts a use, until it has none left
+	var booked []int
+	for i := 0; i < 3; i++ {
+		resID, err := repo.InsertReservationWithRestriction(ctx, models.Reservation{
+			FirstName:  "John",
+			LastName:   "Smith",
+			Email:      "john@smith.com",
+			StartDate:  conformanceDate("2050-07-01").AddDate(0, 0, 3*i),
+			EndDate:    conformanceDate("2050-07-03").AddDate(0, 0, 3*i),
+			RoomID:     2,
+			TotalPrice: 27000,
+			PromoCode:  "SUMMER10",
+			Discount:   3000,
+		})
+		if i < 2 && err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 && !errors.Is(err, repository.ErrPromoCodeUnavailable) {
+			t.Errorf("booking with a used up code: got error %v, wanted %v", err, repository.ErrPromoCodeUnavailable)
+		}
+		booked = append(booked, resID)
+	}
+	if available, _ := repo.SearchAvailabilityByDatesByRoomID(ctx, conformanceDate("2050-07-07"), conformanceDate("2050-07-09"), 2); !available {
+		t.Error("the booking refused for its promo code still holds the room")
+	}
+
+	p, _ = repo.GetPromoCodeByID(ctx, id)
+	if p.Uses != 2 {
+		t.Errorf("got %d uses, wanted 2", p.Uses)
+	}
+
+	// a booking that is cancelled before it was confirmed or paid for gives its use back, others keep it
+	if err = repo.CancelReservation(ctx, booked[0], 0, "Change of plans", 0); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ = repo.GetPromoCodeByID(ctx, id); p.Uses != 1 {
+		t.Errorf("got %d uses after an unpaid booking was cancelled, wanted 1", p.Uses)
+	}
+	if err = repo.UpdateReservationStatus(ctx, booked[1], models.StatusConfirmed, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.CancelReservation(ctx, booked[1], 1, "Guest called", 0); err != nil {
+		t.Fatal(err)
+	}
+	paid, err := repo.InsertReservationWithRestriction(ctx, models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		StartDate: conformanceDate("2050-07-07"),
+		EndDate:   conformanceDate("2050-07-09"),
+		RoomID:    2,
+		PromoCode: "SUMMER10",
+		Discount:  3000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.InsertPayment(ctx, models.Payment{ReservationID: paid, Provider: "fake", Reference: "fake_promo",
+		Status: models.PaymentCaptured, Amount: 27000}); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.CancelReservation(ctx, paid, 0, "Change of plans", 0); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ = repo.GetPromoCodeByID(ctx, id); p.Uses != 2 {
+		t.Errorf("got %d uses after confirmed and paid bookings were cancelled, wanted 2", p.Uses)
+	}
+
+	page, err := repo.ListReservations(ctx, models.ReservationQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := repo.GetReservationByID(ctx, page.Reservations[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PromoCode != "SUMMER10" || res.Discount != 3000 || res.TotalPrice != 27000 {
+		t.Errorf("got code %q with a discount of %d off %d, wanted SUMMER10 with 3000 off 27000", res.PromoCode, res.Discount, res.TotalPrice)
+	}
+
+	if _, err = repo.InsertReservationWithRestriction(ctx, models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Smith",
+		StartDate: conformanceDate("2050-10-01"),
+		EndDate:   conformanceDate("2050-10-02"),
+		RoomID:    1,
+		PromoCode: "NOSUCHCODE",
+	}); !errors.Is(err, repository.ErrPromoCodeUnavailable) {
+		t.Errorf("booking with a missing code: got error %v, wanted %v", err, repository.ErrPromoCodeUnavailable)
+	}
+
+	p.Code = "summer15"
+	p.PercentOff = 15
+	p.ValidFrom = time.Time{}
+	p.RoomIDs = []int{1, 2}
+	p.Uses = 0
+	if err = repo.UpdatePromoCode(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	p, _ = repo.GetPromoCodeByID(ctx, id)
+	if p.Code != "SUMMER15" || p.PercentOff != 15 || !p.ValidFrom.IsZero() || len(p.RoomIDs) != 2 || p.Uses != 2 {
+		t.Errorf("got promo code %+v after the update, wanted SUMMER15 for both rooms with its uses kept", p)
+	}
+
+	p.Code = "WELCOME"
+	if err = repo.UpdatePromoCode(ctx, p); !errors.Is(err, repository.ErrDuplicatePromoCode) {
+		t.Errorf("updating to a duplicate code: got error %v, wanted %v", err, repository.ErrDuplicatePromoCode)
+	}
+
+	if err = repo.DeletePromoCode(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.DeletePromoCode(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleting a missing promo code: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+	if _, err = repo.GetPromoCodeByCode(ctx, "SUMMER15"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("getting a deleted promo code: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+	if res, _ = repo.GetReservationByID(ctx, res.ID); res.PromoCode != "SUMMER10" {
+		t.Errorf("got code %q after the promo code was deleted, wanted SUMMER10 kept", res.PromoCode)
+	}
+}
+
+func testCharges(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	vat := models.Charge{Name: "VAT", Method: models.ChargePercent, Rate: 750, Per: models.PerStay, Active: true}
+	cityTax := models.Charge{Name: "City tax", Method: models.ChargeFlat, Amount: 250, Per: models.PerGuestNight, Active: true}
+	oldFee := models.Charge{Name: "Old fee", Method: models.ChargeFlat, Amount: 1000, Per: models.PerStay}
+
+	var ids []int
+	for _, c := range []models.Charge{vat, cityTax, oldFee} {
+		id, err := repo.InsertCharge(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	c, err := repo.GetChargeByID(ctx, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "City tax" || c.Method != models.ChargeFlat || c.Amount != 250 || c.Per != models.PerGuestNight || !c.Active {
+		t.Errorf("got charge %+v, wanted the city tax", c)
+	}
+
+	all, err := repo.AllCharges(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := repo.ActiveCharges(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || len(active) != 2 || active[0].Name != "VAT" || active[0].Rate != 750 || active[1].Name != "City tax" {
+		t.Errorf("got %d charges with %d active, wanted 3 with VAT and the city tax active", len(all), len(active))
+	}
+
+	id, err := repo.InsertReservationWithRestriction(ctx, models.Reservation{
+		FirstName:  "John",
+		LastName:   "Smith",
+		Email:      "john@smith.com",
+		StartDate:  conformanceDate("2050-12-01"),
+		EndDate:    conformanceDate("2050-12-03"),
+		RoomID:     1,
+		Guests:     2,
+		TotalPrice: 22500,
+		Charges: []models.ReservationCharge{
+			{Name: "VAT", Amount: 1500},
+			{Name: "City tax", Amount: 1000},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Guests != 2 || len(res.Charges) != 2 || res.Charges[0].Name != "VAT" || res.Charges[1].Amount != 1000 || res.ChargesTotal() != 2500 {
+		t.Errorf("got %d guests and charges %+v, wanted 2 guests with VAT and the city tax", res.Guests, res.Charges)
+	}
+
+	err = repo.ChangeReservationStay(ctx, id, 1, conformanceDate("2050-12-01"), conformanceDate("2050-12-04"), 33750,
+		[]models.ReservationCharge{{Name: "VAT", Amount: 2250}, {Name: "City tax", Amount: 1500}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, _ = repo.GetReservationByID(ctx, id)
+	if res.TotalPrice != 33750 || len(res.Charges) != 2 || res.Charges[0].Amount != 2250 || res.Charges[1].Amount != 1500 {
+		t.Errorf("got a total of %d with charges %+v after the change, wanted 33750 with the charges replaced", res.TotalPrice, res.Charges)
+	}
+
+	// reservations made without a number of guests are for one
+	other := book(t, repo, 2, "2050-12-01", "2050-12-02")
+	res, _ = repo.GetReservationByID(ctx, other)
+	if res.Guests != 1 || len(res.Charges) != 0 {
+		t.Errorf("got %d guests and %d charges, wanted 1 guest and no charges", res.Guests, len(res.Charges))
+	}
+
+	c.Name = "Tourist tax"
+	c.Amount = 300
+	c.Active = false
+	if err = repo.UpdateCharge(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	c, _ = repo.GetChargeByID(ctx, ids[1])
+	if c.Name != "Tourist tax" || c.Amount != 300 || c.Active {
+		t.Errorf("got charge %+v after the update", c)
+	}
+
+	if err = repo.DeleteCharge(ctx, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.DeleteCharge(ctx, ids[0]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleting a missing charge: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+	if _, err = repo.GetChargeByID(ctx, ids[0]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("getting a deleted charge: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+	if res, _ = repo.GetReservationByID(ctx, id); len(res.Charges) != 2 || res.Charges[0].Name != "VAT" {
+		t.Errorf("got charges %+v after the VAT was deleted, wanted the reservation to keep them", res.Charges)
+	}
+
+	if err = repo.DeleteReservation(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testPayments(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id := book(t, repo, 1, "2050-12-01", "2050-12-03")
+	other := book(t, repo, 2, "2050-12-01", "2050-12-03")
+
+	declined := models.Payment{ReservationID: id, Provider: "fake", Reference: "fake_1", Status: models.PaymentDeclined,
+		Amount: 20000, CardLast4: "0002", FailureReason: "Your card was declined"}
+	authorized := models.Payment{ReservationID: id, Provider: "fake", Reference: "fake_2", Status: models.PaymentAuthorized,
+		Amount: 20000, CardLast4: "4242"}
+
+	var ids []int
+	for _, p := range []models.Payment{declined, authorized} {
+		paymentID, err := repo.InsertPayment(ctx, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, paymentID)
+	}
+	if _, err := repo.InsertPayment(ctx, models.Payment{ReservationID: other, Provider: "fake", Reference: "fake_3",
+		Status: models.PaymentPending, Amount: 30000}); err != nil {
+		t.Fatal(err)
+	}
+
+	payments, err := repo.PaymentsForReservation(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 2 || payments[0].ID != ids[0] || payments[1].ID != ids[1] {
+		t.Fatalf("got payments %+v, wanted the declined and the authorized payment", payments)
+	}
+	if p := payments[0]; p.Status != models.PaymentDeclined || p.FailureReason != "Your card was declined" || p.CardLast4 != "0002" ||
+		p.Amount != 20000 || p.CreatedAt.IsZero() {
+		t.Errorf("got payment %+v, wanted the declined payment", p)
+	}
+
+	pending, err := repo.PendingPayments(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Reference != "fake_3" {
+		t.Errorf("got pending payments %+v, wanted only the pending one", pending)
+	}
+
+	p, err := repo.GetPaymentByReference(ctx, "fake", "fake_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != ids[1] || p.ReservationID != id || p.Status != models.PaymentAuthorized {
+		t.Errorf("got payment %+v, wanted the authorized payment", p)
+	}
+	if _, err = repo.GetPaymentByReference(ctx, "other", "fake_2"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("getting a payment of another provider: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+
+	p.Status = models.PaymentCaptured
+	p.Amount = 18000
+	p.Refunded = 5000
+	if err = repo.UpdatePayment(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	p, _ = repo.GetPaymentByReference(ctx, "fake", "fake_2")
+	if p.Status != models.PaymentCaptured || p.Amount != 18000 || p.Refunded != 5000 || p.CardLast4 != "4242" {
+		t.Errorf("got payment %+v after the update, wanted $180.00 captured with $50.00 refunded", p)
+	}
+
+	// reservations with payments are kept, so the record of the money is too
+	if err = repo.DeleteReservation(ctx, id); !errors.Is(err, repository.ErrReservationHasPayments) {
+		t.Errorf("deleting a reservation with payments: got error %v, wanted %v", err, repository.ErrReservationHasPayments)
+	}
+	if _, err = repo.GetReservationByID(ctx, id); err != nil {
+		t.Errorf("getting the reservation after a refused delete: %v", err)
+	}
+	if payments, _ = repo.PaymentsForReservation(ctx, id); len(payments) != 2 {
+		t.Errorf("got %d payments after a refused delete, wanted 2", len(payments))
+	}
+}
+
+func testCancellationPolicies(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	flexible := models.CancellationPolicy{Name: "Flexible", FreeDays: 7, Penalty: 5000}
+	nonRefundable := models.CancellationPolicy{Name: "Non-refundable", Penalty: models.FullPenalty}
+
+	var ids []int
+	for _, p := range []models.CancellationPolicy{nonRefundable, flexible} {
+		id, err := repo.InsertCancellationPolicy(ctx, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	p, err := repo.GetCancellationPolicyByID(ctx, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Flexible" || p.FreeDays != 7 || p.Penalty != 5000 {
+		t.Errorf("got policy %+v, wanted the flexible one", p)
+	}
+
+	all, err := repo.AllCancellationPolicies(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Name != "Flexible" || all[1].Name != "Non-refundable" {
+		t.Errorf("got policies %+v, wanted both by name", all)
+	}
+
+	// rooms and rate rules point at the policy their stays are booked under
+	room, _ := repo.GetRoomByID(ctx, 1)
+	room.CancellationPolicyID = ids[1]
+	if err = repo.UpdateRoom(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	ruleID, err := repo.InsertRateRule(ctx, models.RateRule{Name: "Saver", NightlyRate: 8000, CancellationPolicyID: ids[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room, _ = repo.GetRoomByID(ctx, 1)
+	rule, _ := repo.GetRateRuleByID(ctx, ruleID)
+	if room.CancellationPolicyID != ids[1] || rule.CancellationPolicyID != ids[0] {
+		t.Errorf("got room policy %d and rule policy %d, wanted %d and %d", room.CancellationPolicyID, rule.CancellationPolicyID, ids[1], ids[0])
+	}
+
+	// reservations keep the terms they were booked under
+	id, err := repo.InsertReservationWithRestriction(ctx, models.Reservation{
+		FirstName:          "John",
+		LastName:           "Smith",
+		Email:              "john@smith.com",
+		StartDate:          conformanceDate("2050-12-10"),
+		EndDate:            conformanceDate("2050-12-12"),
+		RoomID:             1,
+		TotalPrice:         20000,
+		CancellationPolicy: p,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.FreeDays = 14
+	p.Penalty = 2500
+	if err = repo.UpdateCancellationPolicy(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	updated, _ := repo.GetCancellationPolicyByID(ctx, p.ID)
+	if updated.FreeDays != 14 || updated.Penalty != 2500 {
+		t.Errorf("got policy %+v after the update, wanted 14 free days and a 25%% penalty", updated)
+	}
+
+	res, err := repo.GetReservationByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	terms := res.CancellationPolicy
+	if terms.Name != "Flexible" || terms.FreeDays != 7 || terms.Penalty != 5000 || res.CancellationFee != 0 {
+		t.Errorf("got terms %+v and fee %d, wanted the flexible terms it was booked under and no fee", terms, res.CancellationFee)
+	}
+
+	// deleting a policy puts rooms back to free cancellation and rate rules back to their room's policy
+	if err = repo.DeleteCancellationPolicy(ctx, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.DeleteCancellationPolicy(ctx, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	room, _ = repo.GetRoomByID(ctx, 1)
+	rule, _ = repo.GetRateRuleByID(ctx, ruleID)
+	if room.CancellationPolicyID != 0 || rule.CancellationPolicyID != 0 {
+		t.Errorf("got room policy %d and rule policy %d after deleting the policies, wanted none", room.CancellationPolicyID, rule.CancellationPolicyID)
+	}
+	res, _ = repo.GetReservationByID(ctx, id)
+	if res.CancellationPolicy.Name != "Flexible" {
+		t.Errorf("got terms %+v after deleting the policy, wanted the flexible terms kept", res.CancellationPolicy)
+	}
+
+	if _, err = repo.GetCancellationPolicyByID(ctx, ids[1]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted policy: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+	if err = repo.DeleteCancellationPolicy(ctx, ids[1]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleting twice: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+}
+
+func testInvoices(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id := book(t, repo, 1, "2050-12-01", "2050-12-03")
+	other := book(t, repo, 2, "2050-12-01", "2050-12-03")
+	issued, _ := time.Parse("2006-01-02", "2050-11-01")
+
+	first, err := repo.InsertInvoice(ctx, models.Invoice{
+		ReservationID: id, ConfirmationCode: "ABCD-EFGH", BilledTo: "John Smith", Email: "john@smith.com",
+		Total: 19000, IssuedAt: issued,
+		Lines: []models.InvoiceLine{{Description: "General's Quarters", Amount: 20000}, {Description: "Promo code WELCOME", Amount: -1000}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := repo.InsertInvoice(ctx, models.Invoice{
+		ReservationID: other, BilledTo: "Jane Smith", Total: 15000, IssuedAt: issued,
+		Lines: []models.InvoiceLine{{Description: "Major's Suite", Amount: 15000}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Number != 1 || second.Number != 2 || first.ID == 0 || second.ID == first.ID {
+		t.Fatalf("got invoices numbered %d and %d, wanted 1 and 2", first.Number, second.Number)
+	}
+
+	// a reservation has one invoice only, and a refused invoice uses up no number
+	if _, err = repo.InsertInvoice(ctx, models.Invoice{ReservationID: id, BilledTo: "John Smith", Total: 1, IssuedAt: issued}); !errors.Is(err, repository.ErrInvoiceExists) {
+		t.Errorf("invoicing a reservation twice: got error %v, wanted %v", err, repository.ErrInvoiceExists)
+	}
+
+	inv, err := repo.GetInvoiceForReservation(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.ID != first.ID || inv.Number != 1 || inv.ConfirmationCode != "ABCD-EFGH" || inv.BilledTo != "John Smith" ||
+		inv.Email != "john@smith.com" || inv.Total != 19000 || inv.IssuedAt.Format("2006-01-02") != "2050-11-01" || inv.CreatedAt.IsZero() {
+		t.Errorf("got invoice %+v, wanted the first invoice", inv)
+	}
+	if len(inv.Lines) != 2 || inv.Lines[0].Description != "General's Quarters" || inv.Lines[1].Amount != -1000 {
+		t.Errorf("got lines %+v, wanted the room and the promo code in order", inv.Lines)
+	}
+	if _, err = repo.GetInvoiceForReservation(ctx, 999); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("getting the invoice of a reservation without one: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+
+	invoices, err := repo.AllInvoices(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invoices) != 2 || invoices[0].Number != 2 || invoices[1].Number != 1 {
+		t.Errorf("got invoices %+v, wanted the second then the first", invoices)
+	}
+
+	// invoices are kept for the books when their reservation is deleted
+	if err = repo.DeleteReservation(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	inv, err = repo.GetInvoiceByID(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.ReservationID != 0 || inv.Number != 1 || len(inv.Lines) != 2 {
+		t.Errorf("got invoice %+v after deleting its reservation, wanted it kept without the reservation", inv)
+	}
+	if _, err = repo.GetInvoiceByID(ctx, 999); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("getting a missing invoice: got error %v, wanted %v", err, sql.ErrNoRows)
+	}
+
+	// numbers carry on after the refused invoice and the deleted reservation
+	third, err := repo.InsertInvoice(ctx, models.Invoice{
+		ReservationID: book(t, repo, 1, "2050-12-05", "2050-12-06"), BilledTo: "John Smith", Total: 10000, IssuedAt: issued,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Number != 3 {
+		t.Errorf("got number %d for the next invoice, wanted 3", third.Number)
+	}
+}
@@ -0,0 +1,10 @@
+CREATE TABLE public.users (
+    id serial PRIMARY KEY,
+    first_name character varying(255) DEFAULT '' NOT NULL,
+    last_name character varying(255) DEFAULT '' NOT NULL,
+    email character varying(255) NOT NULL,
+    password character varying(60) NOT NULL,
+    access_level integer DEFAULT 1 NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
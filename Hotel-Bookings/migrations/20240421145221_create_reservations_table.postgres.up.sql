@@ -0,0 +1,12 @@
+CREATE TABLE public.reservations (
+    id serial PRIMARY KEY,
+    first_name character varying(255) DEFAULT '' NOT NULL,
+    last_name character varying(255) DEFAULT '' NOT NULL,
+    email character varying(255) NOT NULL,
+    phone character varying(255) DEFAULT '' NOT NULL,
+    start_date date NOT NULL,
+    end_date date NOT NULL,
+    room_id integer NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
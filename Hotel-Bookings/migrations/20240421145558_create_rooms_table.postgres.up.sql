@@ -0,0 +1,6 @@
+CREATE TABLE public.rooms (
+    id serial PRIMARY KEY,
+    room_name character varying(255) DEFAULT '' NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
@@ -0,0 +1 @@
+DROP TABLE public.room_restrictions;
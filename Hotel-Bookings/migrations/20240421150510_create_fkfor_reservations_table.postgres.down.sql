@@ -0,0 +1 @@
+ALTER TABLE public.reservations DROP CONSTRAINT reservations_rooms_id_fk;
@@ -0,0 +1,3 @@
+ALTER TABLE public.reservations
+    ADD CONSTRAINT reservations_rooms_id_fk FOREIGN KEY (room_id)
+    REFERENCES public.rooms (id) ON UPDATE CASCADE ON DELETE CASCADE;
@@ -0,0 +1,7 @@
+ALTER TABLE public.room_restrictions
+    ADD CONSTRAINT room_restrictions_rooms_id_fk FOREIGN KEY (room_id)
+    REFERENCES public.rooms (id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+ALTER TABLE public.room_restrictions
+    ADD CONSTRAINT room_restrictions_restrictions_id_fk FOREIGN KEY (restriction_id)
+    REFERENCES public.restrictions (id) ON UPDATE CASCADE ON DELETE CASCADE;
@@ -0,0 +1 @@
+CREATE UNIQUE INDEX users_email_idx ON public.users (email);
@@ -0,0 +1,3 @@
+DROP INDEX public.room_restrictions_reservation_id_idx;
+DROP INDEX public.room_restrictions_room_id_idx;
+DROP INDEX public.room_restrictions_start_date_end_date_idx;
@@ -0,0 +1,3 @@
+ALTER TABLE public.room_restrictions DROP CONSTRAINT room_restrictions_reservations_id_fk;
+DROP INDEX public.reservations_email_idx;
+DROP INDEX public.reservations_last_name_idx;
@@ -0,0 +1,6 @@
+ALTER TABLE public.room_restrictions
+    ADD CONSTRAINT room_restrictions_reservations_id_fk FOREIGN KEY (reservation_id)
+    REFERENCES public.reservations (id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+CREATE INDEX reservations_email_idx ON public.reservations (email);
+CREATE INDEX reservations_last_name_idx ON public.reservations (last_name);
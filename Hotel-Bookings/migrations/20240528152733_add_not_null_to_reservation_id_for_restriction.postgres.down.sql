@@ -0,0 +1 @@
+-- left nullable: owner blocks already stored without a reservation would break NOT NULL
@@ -0,0 +1 @@
+ALTER TABLE public.reservations DROP COLUMN processed;
@@ -0,0 +1 @@
+ALTER TABLE public.reservations ADD COLUMN processed integer DEFAULT 0 NOT NULL;
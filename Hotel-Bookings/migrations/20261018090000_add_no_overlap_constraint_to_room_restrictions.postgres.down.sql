@@ -0,0 +1 @@
+ALTER TABLE public.room_restrictions DROP CONSTRAINT IF EXISTS room_restrictions_no_overlap;
@@ -0,0 +1,24 @@
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+-- the constraint cannot be added while restrictions overlap, so name them first: cancel or move the
+-- reservations behind them, or delete the extra blocks, then run the migration again
+DO $$
+DECLARE
+    conflicts text;
+BEGIN
+    SELECT string_agg(format('%s and %s (room %s)', a.id, b.id, a.room_id), ', ' ORDER BY a.id, b.id)
+    INTO conflicts
+    FROM public.room_restrictions a
+    JOIN public.room_restrictions b
+        ON b.room_id = a.room_id AND b.id > a.id
+        AND daterange(b.start_date, b.end_date) && daterange(a.start_date, a.end_date);
+
+    IF conflicts IS NOT NULL THEN
+        RAISE EXCEPTION 'room restrictions overlap: %', conflicts
+            USING HINT = 'Cancel or move the reservations behind them, or delete the extra blocks, and migrate again.';
+    END IF;
+END $$;
+
+ALTER TABLE public.room_restrictions
+    ADD CONSTRAINT room_restrictions_no_overlap
+    EXCLUDE USING gist (room_id WITH =, daterange(start_date, end_date) WITH &&);
@@ -0,0 +1,2 @@
+DROP INDEX public.reservations_room_id_idx;
+DROP INDEX public.reservations_start_date_idx;
@@ -0,0 +1,3 @@
+-- reservation listings sort by arrival and filter by room
+CREATE INDEX reservations_start_date_idx ON public.reservations (start_date);
+CREATE INDEX reservations_room_id_idx ON public.reservations (room_id);
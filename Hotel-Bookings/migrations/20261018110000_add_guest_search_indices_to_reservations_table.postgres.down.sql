@@ -0,0 +1,3 @@
+DROP INDEX public.reservations_phone_trgm_idx;
+DROP INDEX public.reservations_email_trgm_idx;
+DROP INDEX public.reservations_guest_name_trgm_idx;
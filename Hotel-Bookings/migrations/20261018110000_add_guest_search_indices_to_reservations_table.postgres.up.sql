@@ -0,0 +1,7 @@
+-- trigram indexes serve the ilike '%...%' and fuzzy name matching of the admin guest search
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX reservations_guest_name_trgm_idx ON public.reservations
+    USING gin ((first_name || ' ' || last_name) gin_trgm_ops);
+CREATE INDEX reservations_email_trgm_idx ON public.reservations USING gin (email gin_trgm_ops);
+CREATE INDEX reservations_phone_trgm_idx ON public.reservations USING gin (phone gin_trgm_ops);
@@ -0,0 +1,7 @@
+DROP TABLE public.reservation_status_history;
+
+ALTER TABLE public.reservations ADD COLUMN processed integer DEFAULT 0 NOT NULL;
+UPDATE public.reservations SET processed = 1 WHERE status <> 'new';
+DROP INDEX public.reservations_status_idx;
+ALTER TABLE public.reservations DROP CONSTRAINT reservations_status_check;
+ALTER TABLE public.reservations DROP COLUMN status;
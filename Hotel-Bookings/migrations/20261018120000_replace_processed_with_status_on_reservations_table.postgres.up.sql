@@ -0,0 +1,18 @@
+-- reservations move through new, confirmed, checked_in, checked_out, cancelled and no_show;
+-- processed reservations were the confirmed ones
+ALTER TABLE public.reservations ADD COLUMN status character varying(20) DEFAULT 'new' NOT NULL;
+UPDATE public.reservations SET status = 'confirmed' WHERE processed = 1;
+ALTER TABLE public.reservations DROP COLUMN processed;
+ALTER TABLE public.reservations ADD CONSTRAINT reservations_status_check
+    CHECK (status IN ('new', 'confirmed', 'checked_in', 'checked_out', 'cancelled', 'no_show'));
+CREATE INDEX reservations_status_idx ON public.reservations (status);
+
+CREATE TABLE public.reservation_status_history (
+    id serial PRIMARY KEY,
+    reservation_id integer NOT NULL REFERENCES public.reservations (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    from_status character varying(20) NOT NULL,
+    to_status character varying(20) NOT NULL,
+    user_id integer REFERENCES public.users (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    created_at timestamp without time zone NOT NULL
+);
+CREATE INDEX reservation_status_history_reservation_id_idx ON public.reservation_status_history (reservation_id);
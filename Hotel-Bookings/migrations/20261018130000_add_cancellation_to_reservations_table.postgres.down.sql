@@ -0,0 +1,4 @@
+-- the room restrictions of cancelled reservations are not restored
+ALTER TABLE public.reservations DROP COLUMN cancellation_reason;
+ALTER TABLE public.reservations DROP COLUMN cancelled_by;
+ALTER TABLE public.reservations DROP COLUMN cancelled_at;
@@ -0,0 +1,7 @@
+ALTER TABLE public.reservations ADD COLUMN cancelled_at timestamp without time zone;
+ALTER TABLE public.reservations ADD COLUMN cancelled_by integer REFERENCES public.users (id) ON DELETE SET NULL ON UPDATE CASCADE;
+ALTER TABLE public.reservations ADD COLUMN cancellation_reason text DEFAULT '' NOT NULL;
+
+-- cancelled reservations no longer hold their room
+DELETE FROM public.room_restrictions
+WHERE reservation_id IN (SELECT id FROM public.reservations WHERE status = 'cancelled');
@@ -0,0 +1,2 @@
+DROP INDEX public.reservations_confirmation_code_idx;
+ALTER TABLE public.reservations DROP COLUMN confirmation_code;
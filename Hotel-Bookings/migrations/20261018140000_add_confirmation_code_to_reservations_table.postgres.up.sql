@@ -0,0 +1,28 @@
+ALTER TABLE public.reservations ADD COLUMN confirmation_code character varying(20);
+
+-- new reservations get their code from the application; existing ones get a random one here,
+-- from the same alphabet and in the same XXXX-XXXX form, drawn again if another reservation has it
+DO $$
+DECLARE
+    alphabet CONSTANT text := 'ABCDEFGHJKLMNPQRSTUVWXYZ23456789';
+    res_id integer;
+    code text;
+BEGIN
+    FOR res_id IN SELECT id FROM public.reservations LOOP
+        LOOP
+            code := '';
+            FOR i IN 1..8 LOOP
+                code := code || substr(alphabet, 1 + floor(random() * 32)::integer, 1);
+                IF i = 4 THEN
+                    code := code || '-';
+                END IF;
+            END LOOP;
+            EXIT WHEN NOT EXISTS (SELECT 1 FROM public.reservations WHERE confirmation_code = code);
+        END LOOP;
+
+        UPDATE public.reservations SET confirmation_code = code WHERE id = res_id;
+    END LOOP;
+END $$;
+
+ALTER TABLE public.reservations ALTER COLUMN confirmation_code SET NOT NULL;
+CREATE UNIQUE INDEX reservations_confirmation_code_idx ON public.reservations (confirmation_code);
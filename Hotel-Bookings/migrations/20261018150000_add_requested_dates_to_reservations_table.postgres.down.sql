@@ -0,0 +1,2 @@
+ALTER TABLE public.reservations DROP COLUMN requested_end_date;
+ALTER TABLE public.reservations DROP COLUMN requested_start_date;
@@ -0,0 +1,3 @@
+-- the dates a guest asked to move to from the manage my booking page, until an admin deals with it
+ALTER TABLE public.reservations ADD COLUMN requested_start_date date;
+ALTER TABLE public.reservations ADD COLUMN requested_end_date date;
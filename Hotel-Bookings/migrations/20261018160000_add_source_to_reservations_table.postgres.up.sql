@@ -0,0 +1,2 @@
+-- how the reservation was made: online, or recorded by an admin as a phone or walk-in booking
+ALTER TABLE public.reservations ADD COLUMN source varchar(20) NOT NULL DEFAULT 'online';
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS rooms_slug_idx;
+ALTER TABLE public.rooms DROP COLUMN sort_order;
+ALTER TABLE public.rooms DROP COLUMN active;
+ALTER TABLE public.rooms DROP COLUMN slug;
@@ -0,0 +1,13 @@
+-- rooms are managed from the admin area: the slug names the public page,
+-- inactive rooms are hidden from guests and sort_order sets the order rooms are listed in
+ALTER TABLE public.rooms ADD COLUMN slug varchar(255) NOT NULL DEFAULT '';
+ALTER TABLE public.rooms ADD COLUMN active boolean NOT NULL DEFAULT true;
+ALTER TABLE public.rooms ADD COLUMN sort_order integer NOT NULL DEFAULT 0;
+
+-- keep the addresses of the seeded rooms' pages
+UPDATE public.rooms SET slug = 'generals-quarters' WHERE room_name = 'General''s Quarters';
+UPDATE public.rooms SET slug = 'majors-suite' WHERE room_name = 'Major''s Suite';
+UPDATE public.rooms SET slug = 'room-' || id WHERE slug = '';
+UPDATE public.rooms SET sort_order = id;
+
+CREATE UNIQUE INDEX rooms_slug_idx ON public.rooms (slug);
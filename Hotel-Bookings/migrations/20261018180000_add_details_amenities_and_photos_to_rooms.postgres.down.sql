@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS public.room_photos;
+DROP TABLE IF EXISTS public.room_amenities;
+ALTER TABLE public.rooms DROP COLUMN description;
+ALTER TABLE public.rooms DROP COLUMN bed_configuration;
+ALTER TABLE public.rooms DROP COLUMN max_occupancy;
@@ -0,0 +1,31 @@
+-- what guests see about a room: how many it sleeps, its beds and a description,
+-- with its amenities and photo gallery kept in their own tables, both in display order
+ALTER TABLE public.rooms ADD COLUMN max_occupancy integer NOT NULL DEFAULT 2;
+ALTER TABLE public.rooms ADD COLUMN bed_configuration character varying(255) NOT NULL DEFAULT '';
+ALTER TABLE public.rooms ADD COLUMN description text NOT NULL DEFAULT '';
+
+CREATE TABLE public.room_amenities (
+    id serial PRIMARY KEY,
+    room_id integer NOT NULL REFERENCES public.rooms (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    name character varying(255) NOT NULL,
+    sort_order integer NOT NULL DEFAULT 0
+);
+CREATE INDEX room_amenities_room_id_idx ON public.room_amenities (room_id);
+
+CREATE TABLE public.room_photos (
+    id serial PRIMARY KEY,
+    room_id integer NOT NULL REFERENCES public.rooms (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    path character varying(255) NOT NULL,
+    caption character varying(255) NOT NULL DEFAULT '',
+    sort_order integer NOT NULL DEFAULT 0,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+CREATE INDEX room_photos_room_id_idx ON public.room_photos (room_id);
+
+-- the seeded rooms keep the text and photo their pages have always shown
+UPDATE public.rooms SET description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.'
+WHERE slug IN ('generals-quarters', 'majors-suite');
+INSERT INTO public.room_photos (room_id, path, sort_order, created_at, updated_at)
+SELECT id, '/static/images/' || slug || '.png', 1, now(), now()
+FROM public.rooms WHERE slug IN ('generals-quarters', 'majors-suite');
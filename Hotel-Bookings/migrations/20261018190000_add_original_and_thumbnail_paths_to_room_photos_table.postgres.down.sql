@@ -0,0 +1,2 @@
+ALTER TABLE public.room_photos DROP COLUMN thumbnail_path;
+ALTER TABLE public.room_photos DROP COLUMN original_path;
@@ -0,0 +1,3 @@
+-- uploaded photos keep the file as uploaded and a thumbnail next to the web-optimized copy in path
+ALTER TABLE public.room_photos ADD COLUMN original_path character varying(255) NOT NULL DEFAULT '';
+ALTER TABLE public.room_photos ADD COLUMN thumbnail_path character varying(255) NOT NULL DEFAULT '';
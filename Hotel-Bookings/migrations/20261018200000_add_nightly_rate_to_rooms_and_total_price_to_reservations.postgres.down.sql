@@ -0,0 +1,2 @@
+ALTER TABLE public.reservations DROP COLUMN total_price;
+ALTER TABLE public.rooms DROP COLUMN nightly_rate;
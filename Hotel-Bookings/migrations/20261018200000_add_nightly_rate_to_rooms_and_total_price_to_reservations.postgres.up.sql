@@ -0,0 +1,6 @@
+-- amounts are stored in cents
+ALTER TABLE public.rooms ADD COLUMN nightly_rate bigint NOT NULL DEFAULT 0;
+ALTER TABLE public.reservations ADD COLUMN total_price bigint NOT NULL DEFAULT 0;
+
+UPDATE public.rooms SET nightly_rate = 10000 WHERE slug = 'generals-quarters';
+UPDATE public.rooms SET nightly_rate = 15000 WHERE slug = 'majors-suite';
@@ -0,0 +1 @@
+DROP TABLE public.rate_rules;
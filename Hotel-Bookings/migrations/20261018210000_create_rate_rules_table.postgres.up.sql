@@ -0,0 +1,16 @@
+-- rate rules override a room's nightly rate on the nights they cover. A null room_id applies the rule
+-- to every room, null dates leave the range open, and weekdays is a bit set of the days of the week
+-- covered, bit 0 being Sunday and 0 meaning every day
+CREATE TABLE public.rate_rules (
+    id serial PRIMARY KEY,
+    name character varying(255) NOT NULL,
+    room_id integer REFERENCES public.rooms (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    start_date date,
+    end_date date,
+    weekdays integer NOT NULL DEFAULT 0,
+    nightly_rate bigint NOT NULL,
+    priority integer NOT NULL DEFAULT 0,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+CREATE INDEX rate_rules_start_date_end_date_idx ON public.rate_rules (start_date, end_date);
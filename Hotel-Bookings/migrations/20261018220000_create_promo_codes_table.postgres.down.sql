@@ -0,0 +1,4 @@
+ALTER TABLE public.reservations DROP COLUMN discount;
+ALTER TABLE public.reservations DROP COLUMN promo_code;
+DROP TABLE public.promo_code_rooms;
+DROP TABLE public.promo_codes;
@@ -0,0 +1,28 @@
+-- promo codes take a percentage or a fixed amount off a stay. Null dates leave the validity window open,
+-- max_uses 0 means no limit, and a code without rows in promo_code_rooms applies to every room
+CREATE TABLE public.promo_codes (
+    id serial PRIMARY KEY,
+    code character varying(50) NOT NULL,
+    description character varying(255) NOT NULL DEFAULT '',
+    kind character varying(20) NOT NULL,
+    percent_off integer NOT NULL DEFAULT 0,
+    amount_off bigint NOT NULL DEFAULT 0,
+    valid_from date,
+    valid_until date,
+    max_uses integer NOT NULL DEFAULT 0,
+    uses integer NOT NULL DEFAULT 0,
+    min_nights integer NOT NULL DEFAULT 0,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+CREATE UNIQUE INDEX promo_codes_code_idx ON public.promo_codes (code);
+
+CREATE TABLE public.promo_code_rooms (
+    promo_code_id integer NOT NULL REFERENCES public.promo_codes (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    room_id integer NOT NULL REFERENCES public.rooms (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    PRIMARY KEY (promo_code_id, room_id)
+);
+
+-- the code a reservation was booked with and what it took off the total price
+ALTER TABLE public.reservations ADD COLUMN promo_code character varying(50) NOT NULL DEFAULT '';
+ALTER TABLE public.reservations ADD COLUMN discount bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+ALTER TABLE public.reservations DROP COLUMN guests;
+DROP TABLE public.reservation_charges;
+DROP TABLE public.charges;
@@ -0,0 +1,25 @@
+-- taxes and fees added to the price of stays. Percentage charges keep their rate in hundredths of a percent
+-- and flat charges their amount in cents, charged per stay, per night or per guest per night
+CREATE TABLE public.charges (
+    id serial PRIMARY KEY,
+    name character varying(255) NOT NULL,
+    method character varying(20) NOT NULL,
+    rate integer NOT NULL DEFAULT 0,
+    amount bigint NOT NULL DEFAULT 0,
+    per character varying(20) NOT NULL DEFAULT 'stay',
+    active boolean NOT NULL DEFAULT true,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+-- the taxes and fees as they were charged on each reservation, so later changes to the charges do not alter them
+CREATE TABLE public.reservation_charges (
+    id serial PRIMARY KEY,
+    reservation_id integer NOT NULL REFERENCES public.reservations (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    name character varying(255) NOT NULL,
+    amount bigint NOT NULL,
+    sort_order integer NOT NULL DEFAULT 0
+);
+CREATE INDEX reservation_charges_reservation_id_idx ON public.reservation_charges (reservation_id);
+
+ALTER TABLE public.reservations ADD COLUMN guests integer NOT NULL DEFAULT 1;
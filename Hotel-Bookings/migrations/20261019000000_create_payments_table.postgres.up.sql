@@ -0,0 +1,18 @@
+-- card payments taken for reservations through a payment provider, identified there by reference.
+-- Amounts are in cents: what is held on the card or was captured, and how much of it was refunded.
+-- Reservations with payments cannot be deleted, so the record of the money is kept
+CREATE TABLE public.payments (
+    id serial PRIMARY KEY,
+    reservation_id integer NOT NULL REFERENCES public.reservations (id) ON DELETE RESTRICT ON UPDATE CASCADE,
+    provider character varying(50) NOT NULL,
+    reference character varying(255) NOT NULL,
+    status character varying(20) NOT NULL,
+    amount bigint NOT NULL,
+    refunded bigint NOT NULL DEFAULT 0,
+    card_last4 character varying(4) NOT NULL DEFAULT '',
+    failure_reason character varying(255) NOT NULL DEFAULT '',
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+CREATE UNIQUE INDEX payments_provider_reference_idx ON public.payments (provider, reference);
+CREATE INDEX payments_reservation_id_idx ON public.payments (reservation_id);
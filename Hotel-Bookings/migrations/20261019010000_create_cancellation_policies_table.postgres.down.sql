@@ -0,0 +1,7 @@
+ALTER TABLE public.reservations DROP COLUMN cancellation_fee;
+ALTER TABLE public.reservations DROP COLUMN cancellation_penalty;
+ALTER TABLE public.reservations DROP COLUMN cancellation_free_days;
+ALTER TABLE public.reservations DROP COLUMN cancellation_policy;
+ALTER TABLE public.rate_rules DROP COLUMN cancellation_policy_id;
+ALTER TABLE public.rooms DROP COLUMN cancellation_policy_id;
+DROP TABLE public.cancellation_policies;
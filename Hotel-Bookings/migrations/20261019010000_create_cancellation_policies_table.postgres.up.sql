@@ -0,0 +1,23 @@
+-- cancellation policies charge penalty, in hundredths of a percent of the total price, to guests who cancel
+-- later than free_days days before arrival. A free_days of 0 never lets guests cancel for free
+CREATE TABLE public.cancellation_policies (
+    id serial PRIMARY KEY,
+    name character varying(255) NOT NULL,
+    free_days integer NOT NULL DEFAULT 0,
+    penalty integer NOT NULL DEFAULT 0,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+-- stays are booked under the policy of the rate rule they start on, or else of their room, or else free
+ALTER TABLE public.rooms ADD COLUMN cancellation_policy_id integer
+    REFERENCES public.cancellation_policies (id) ON DELETE SET NULL ON UPDATE CASCADE;
+ALTER TABLE public.rate_rules ADD COLUMN cancellation_policy_id integer
+    REFERENCES public.cancellation_policies (id) ON DELETE SET NULL ON UPDATE CASCADE;
+
+-- the terms each reservation was booked under, so later changes to the policies do not alter them,
+-- and the fee charged when it was cancelled, in cents
+ALTER TABLE public.reservations ADD COLUMN cancellation_policy character varying(255) NOT NULL DEFAULT '';
+ALTER TABLE public.reservations ADD COLUMN cancellation_free_days integer NOT NULL DEFAULT 0;
+ALTER TABLE public.reservations ADD COLUMN cancellation_penalty integer NOT NULL DEFAULT 0;
+ALTER TABLE public.reservations ADD COLUMN cancellation_fee bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DROP TABLE public.invoice_lines;
+DROP TABLE public.invoices;
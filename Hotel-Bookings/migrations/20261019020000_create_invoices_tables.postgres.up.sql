@@ -0,0 +1,25 @@
+-- invoices issued for reservations, numbered from 1 without gaps. Who is billed and what for are copied from
+-- the reservation, which can be deleted later without losing the invoice. Amounts are in cents
+CREATE TABLE public.invoices (
+    id serial PRIMARY KEY,
+    number integer NOT NULL,
+    reservation_id integer REFERENCES public.reservations (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    confirmation_code character varying(20) NOT NULL DEFAULT '',
+    billed_to character varying(255) NOT NULL,
+    email character varying(255) NOT NULL DEFAULT '',
+    total bigint NOT NULL,
+    issued_at timestamp without time zone NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+CREATE UNIQUE INDEX invoices_number_idx ON public.invoices (number);
+CREATE UNIQUE INDEX invoices_reservation_id_idx ON public.invoices (reservation_id);
+
+CREATE TABLE public.invoice_lines (
+    id serial PRIMARY KEY,
+    invoice_id integer NOT NULL REFERENCES public.invoices (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    description character varying(255) NOT NULL,
+    amount bigint NOT NULL,
+    sort_order integer NOT NULL DEFAULT 0
+);
+CREATE INDEX invoice_lines_invoice_id_idx ON public.invoice_lines (invoice_id);
@@ -0,0 +1,9 @@
+// Package migrations embeds the Postgres migrations so the bookings binary can apply them itself
+package migrations
+
+import "embed"
+
+// Files holds every <version>_<name>.postgres.up.sql and .down.sql migration
+//
+//go:embed *.postgres.up.sql *.postgres.down.sql
+var Files embed.FS